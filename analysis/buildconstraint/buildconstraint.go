@@ -0,0 +1,113 @@
+// Package buildconstraint defines Analyzers that check the build constraints
+// of Go source files using buildutil.CheckConstraints. They can be run with
+// "go vet -vettool" (see the buildconstraint command), golangci-lint or any
+// other driver of golang.org/x/tools/go/analysis.
+//
+// The files excluded from the package by its build configuration are also
+// checked since those are the files that have dead or conflicting build
+// constraints.
+package buildconstraint
+
+import (
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charlievieth/buildutil"
+	"golang.org/x/tools/go/analysis"
+)
+
+// FilenameConflict reports files whose build constraint excludes every
+// platform allowed by the GOOS/GOARCH suffix of their name.
+var FilenameConflict = newAnalyzer("filenameconflict", buildutil.CheckFilenameConflict,
+	"check for build constraints that conflict with the file name\n\n"+
+		"A file named x_linux.go with the build constraint \"//go:build windows\" is\n"+
+		"never built.")
+
+// StalePlusBuild reports "// +build" lines that do not match the
+// "//go:build" line of the file or that have no "//go:build" line.
+var StalePlusBuild = newAnalyzer("staleplusbuild", buildutil.CheckStalePlusBuild,
+	"check for +build lines that do not match the //go:build line\n\n"+
+		"Files with +build lines must also have a //go:build line with the same\n"+
+		"condition. Running gofmt adds or updates the //go:build line.")
+
+// UnknownTag reports build tags that are probably misspellings of a GOOS,
+// GOARCH or other known tag and malformed Go release tags.
+var UnknownTag = newAnalyzer("unknowntag", buildutil.CheckUnknownTag,
+	"check for misspelled build tags\n\n"+
+		"A build tag that is similar to a known tag, such as \"linx\", is probably a\n"+
+		"mistake since the go command treats it as a user defined tag.")
+
+// DeadFile reports files whose build constraint cannot be satisfied by any
+// GOOS and GOARCH.
+var DeadFile = newAnalyzer("deadfile", buildutil.CheckDeadFile,
+	"check for build constraints that are never satisfied\n\n"+
+		"A file with the build constraint \"//go:build linux && windows\" is never\n"+
+		"built.")
+
+// Analyzers are all of the Analyzers defined by this package.
+var Analyzers = []*analysis.Analyzer{
+	FilenameConflict,
+	StalePlusBuild,
+	UnknownTag,
+	DeadFile,
+}
+
+func newAnalyzer(name string, check buildutil.ConstraintCheck, doc string) *analysis.Analyzer {
+	return &analysis.Analyzer{
+		Name: name,
+		Doc:  doc,
+		Run: func(pass *analysis.Pass) (interface{}, error) {
+			return nil, run(pass, check)
+		},
+	}
+}
+
+func run(pass *analysis.Pass, check buildutil.ConstraintCheck) error {
+	for _, f := range pass.Files {
+		tf := pass.Fset.File(f.Pos())
+		if tf == nil {
+			continue
+		}
+		if err := checkFile(pass, check, tf.Name(), tf); err != nil {
+			return err
+		}
+	}
+	for _, name := range pass.IgnoredFiles {
+		if strings.HasSuffix(name, ".go") {
+			if err := checkFile(pass, check, name, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkFile reports the issues found by check in the file name. If tf is
+// nil, the file is added to the FileSet of the pass.
+func checkFile(pass *analysis.Pass, check buildutil.ConstraintCheck, name string, tf *token.File) error {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	issues, err := buildutil.CheckConstraints(filepath.Base(name), content)
+	if err != nil {
+		return nil // invalid build constraints are reported by the buildtag Analyzer
+	}
+	for _, issue := range issues {
+		if issue.Check != check {
+			continue
+		}
+		if tf == nil {
+			tf = pass.Fset.AddFile(name, -1, len(content))
+			tf.SetLinesForContent(content)
+		}
+		offset := issue.Offset
+		if offset > tf.Size() {
+			offset = 0 // file changed since it was parsed
+		}
+		pass.Reportf(tf.Pos(offset), "%s", issue.Message)
+	}
+	return nil
+}
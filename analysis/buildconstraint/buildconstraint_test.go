@@ -0,0 +1,78 @@
+package buildconstraint
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/charlievieth/buildutil/buildutiltest"
+	"golang.org/x/tools/go/analysis"
+)
+
+func TestAnalyzers(t *testing.T) {
+	dir := t.TempDir()
+	buildutiltest.WriteFiles(t, dir, map[string]string{
+		"p.go":              "package p\n",
+		"stale.go":          "//go:build linux\n// +build darwin\n\npackage p\n",
+		"tag.go":            "// Copyright\n\n//go:build linx\n\npackage p\n",
+		"dead.go":           "//go:build linux && windows\n\npackage p\n",
+		"conflict_linux.go": "//go:build windows\n\npackage p\n",
+		"invalid.go":        "//go:build (\n\npackage p\n",
+		"other.s":           "// +build ignore\n",
+	})
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range []string{"p.go", "stale.go"} {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	var ignored []string
+	for _, name := range []string{"tag.go", "dead.go", "conflict_linux.go", "invalid.go", "other.s"} {
+		ignored = append(ignored, filepath.Join(dir, name))
+	}
+
+	var got []string
+	for _, a := range Analyzers {
+		pass := &analysis.Pass{
+			Analyzer:     a,
+			Fset:         fset,
+			Files:        files,
+			IgnoredFiles: ignored,
+			Report: func(d analysis.Diagnostic) {
+				pos := fset.Position(d.Pos)
+				got = append(got, a.Name+": "+filepath.Base(pos.Filename)+":"+
+					strconv.Itoa(pos.Line))
+			},
+		}
+		if _, err := a.Run(pass); err != nil {
+			t.Fatalf("%s: %v", a.Name, err)
+		}
+	}
+	sort.Strings(got)
+	want := []string{
+		"deadfile: dead.go:1",
+		"filenameconflict: conflict_linux.go:1",
+		"staleplusbuild: stale.go:2",
+		"unknowntag: tag.go:3",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diagnostics = %q; want: %q", got, want)
+	}
+}
+
+func TestAnalyzersValid(t *testing.T) {
+	for _, a := range Analyzers {
+		if err := analysis.Validate([]*analysis.Analyzer{a}); err != nil {
+			t.Error(err)
+		}
+	}
+}
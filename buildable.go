@@ -0,0 +1,434 @@
+package buildutil
+
+import (
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An ExcludeReason describes why a file is excluded from a build.
+type ExcludeReason int
+
+const (
+	// ExcludeIgnored means the file name begins with "_" or ".".
+	ExcludeIgnored ExcludeReason = iota + 1
+
+	// ExcludeFilename means the $GOOS and/or $GOARCH suffix of the file name
+	// does not match the Context.
+	ExcludeFilename
+
+	// ExcludeConstraint means the file's build constraints are not satisfied.
+	ExcludeConstraint
+
+	// ExcludeCgo means the Go file imports "C" and cgo is not enabled.
+	ExcludeCgo
+
+	// ExcludeError means the file could not be read or its header could
+	// not be parsed.
+	ExcludeError
+)
+
+var excludeReasonNames = [...]string{
+	ExcludeIgnored:    "ignored",
+	ExcludeFilename:   "filename",
+	ExcludeConstraint: "constraint",
+	ExcludeCgo:        "cgo",
+	ExcludeError:      "error",
+}
+
+func (r ExcludeReason) String() string {
+	if 0 < r && int(r) < len(excludeReasonNames) {
+		return excludeReasonNames[r]
+	}
+	return "ExcludeReason(" + strconv.Itoa(int(r)) + ")"
+}
+
+// An ExcludedFile is a source file excluded from a build.
+type ExcludedFile struct {
+	Name   string        // base name of the file
+	Reason ExcludeReason // why the file was excluded
+	Err    error         // error reading the file (Reason == ExcludeError)
+}
+
+// A BuildableFileSet is the classification of the source files in a
+// directory for a build.Context.
+type BuildableFileSet struct {
+	Dir        string         // directory containing the files
+	GoFiles    []string       // included .go files (including _test.go files)
+	OtherFiles []string       // included non-Go source files (.c, .s, .syso, etc.)
+	Excluded   []ExcludedFile // excluded source files
+	AllTags    []string       // sorted list of all tags consulted
+}
+
+// sourceFileExts are the non-Go file extensions that are part of a build.
+var sourceFileExts = map[string]bool{
+	".c":       true,
+	".cc":      true,
+	".cpp":     true,
+	".cxx":     true,
+	".m":       true,
+	".h":       true,
+	".hh":      true,
+	".hpp":     true,
+	".hxx":     true,
+	".f":       true,
+	".F":       true,
+	".for":     true,
+	".f90":     true,
+	".s":       true,
+	".S":       true,
+	".sx":      true,
+	".swig":    true,
+	".swigcxx": true,
+	".syso":    true,
+}
+
+// BuildableFiles classifies the source files in directory dir for the
+// build.Context ctxt. It is like build.ImportDir, but does not require dir
+// to be in a GOPATH or module, and it records why files are excluded.
+// Files that are not Go or other source files, and subdirectories, are
+// ignored. The Context's ReadDir and OpenFile functions, if set, are used.
+//
+// An error is only returned if dir cannot be read. Errors reading
+// individual files are reported as ExcludeError.
+func BuildableFiles(ctxt *build.Context, dir string) (*BuildableFileSet, error) {
+	set, _, err := scanDir(ctxt, dir, false)
+	return set, err
+}
+
+// ScanDir is like BuildableFiles, but also returns the build tags of all of
+// the files in dir, as returned by DirBuildTags, in the same pass. This saves
+// callers that need both from reading each file twice. Unlike DirBuildTags,
+// files with invalid build constraints are reported as ExcludeError and do
+// not cause an error to be returned.
+func ScanDir(ctxt *build.Context, dir string) (*BuildableFileSet, map[string]bool, error) {
+	return scanDir(ctxt, dir, true)
+}
+
+func scanDir(ctxt *build.Context, dir string, wantTags bool) (*BuildableFileSet, map[string]bool, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	fis, err := readSourceDir(ctxt, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var tags map[string]bool
+	if wantTags {
+		tags = make(map[string]bool)
+	}
+	set := &BuildableFileSet{Dir: dir}
+	allTags := make(map[string]bool)
+	ev := newTagEvaluator(ctxt)
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		}
+		name := fi.Name()
+		ext := filepath.Ext(name)
+		isGo := ext == ".go"
+		if !isGo && !sourceFileExts[ext] {
+			continue
+		}
+		reason, header, err := classifyFile(ev, dir, name, isGo, allTags)
+		if tags != nil && reason != ExcludeIgnored {
+			goodOSArchFile(ctxt, name, tags)
+			if header == nil && reason == ExcludeFilename && ext != ".syso" {
+				// The file was excluded by its name before being read
+				header, _ = readFileHeader(ctxt, dir, name, isGo)
+			}
+			if header != nil {
+				shouldBuild(ctxt, header, tags)
+				if isGo && importsC(name, header) {
+					tags["cgo"] = true
+				}
+			}
+		}
+		if reason != 0 {
+			set.Excluded = append(set.Excluded, ExcludedFile{
+				Name:   name,
+				Reason: reason,
+				Err:    err,
+			})
+			continue
+		}
+		if isGo {
+			set.GoFiles = append(set.GoFiles, name)
+		} else {
+			set.OtherFiles = append(set.OtherFiles, name)
+		}
+	}
+	if len(allTags) != 0 {
+		set.AllTags = make([]string, 0, len(allTags))
+		for tag := range allTags {
+			set.AllTags = append(set.AllTags, tag)
+		}
+		sort.Strings(set.AllTags)
+	}
+	return set, tags, nil
+}
+
+// DirBuildTags returns all of the build tags consulted by the source files
+// in directory dir. Unlike the AllTags of BuildableFiles, the tags of every
+// file are included regardless of whether the file matches ctxt, which makes
+// it suitable for constructing a "-tags" list that tests all of the files in
+// a package. Tags come from both file names (e.g. "linux" and "amd64" for
+// "x_linux_amd64.go") and build constraints, and the "cgo" tag is added if
+// a Go file imports "C". Files with names beginning with "_" or "." are
+// ignored, as are subdirectories.
+//
+// An error is returned if dir or one of its files cannot be read, or if a
+// file has an invalid build constraint.
+func DirBuildTags(ctxt *build.Context, dir string) (map[string]bool, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	fis, err := readSourceDir(ctxt, dir)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]bool)
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		}
+		name := fi.Name()
+		if strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		ext := filepath.Ext(name)
+		isGo := ext == ".go"
+		if !isGo && !sourceFileExts[ext] {
+			continue
+		}
+		goodOSArchFile(ctxt, name, tags)
+		if ext == ".syso" {
+			continue
+		}
+		if err := fileBuildTags(ctxt, dir, name, isGo, tags); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+func fileBuildTags(ctxt *build.Context, dir, name string, isGo bool, tags map[string]bool) error {
+	header, err := readFileHeader(ctxt, dir, name, isGo)
+	if err != nil {
+		return err
+	}
+	if _, _, err := shouldBuild(ctxt, header, tags); err != nil {
+		return fmt.Errorf("%s: %w", joinPath(ctxt, dir, name), err)
+	}
+	if isGo && importsC(name, header) {
+		tags["cgo"] = true
+	}
+	return nil
+}
+
+// importsC reports if the Go file header imports "C".
+func importsC(name string, header []byte) bool {
+	f, err := parser.ParseFile(token.NewFileSet(), name, header, parser.ImportsOnly)
+	if err != nil {
+		return false
+	}
+	for _, spec := range f.Imports {
+		if path, _ := strconv.Unquote(spec.Path.Value); path == "C" {
+			return true
+		}
+	}
+	return false
+}
+
+// A FileFlip is a source file whose inclusion in a build changes between
+// two Contexts.
+type FileFlip struct {
+	Name      string        // base name of the file
+	Included  bool          // file is included by the new Context, but not the old
+	OldReason ExcludeReason // why the old Context excludes the file (0 if included)
+	NewReason ExcludeReason // why the new Context excludes the file (0 if included)
+}
+
+// FlippedFiles reports which source files in directory dir change inclusion
+// status when the build.Context oldCtxt is replaced by newCtxt (e.g. when a
+// build tag is added or the GOOS is changed), which allows editors to preview
+// the impact of a settings change before applying it. Each file is read at
+// most once. The files are classified the same as BuildableFiles, files that
+// cannot be read are not reported, and the ReadDir and OpenFile functions of
+// oldCtxt are used.
+func FlippedFiles(oldCtxt, newCtxt *build.Context, dir string) ([]FileFlip, error) {
+	if oldCtxt == nil {
+		oldCtxt = &build.Default
+	}
+	if newCtxt == nil {
+		newCtxt = &build.Default
+	}
+	fis, err := readSourceDir(oldCtxt, dir)
+	if err != nil {
+		return nil, err
+	}
+	oldEv := newTagEvaluator(oldCtxt)
+	newEv := newTagEvaluator(newCtxt)
+	var flips []FileFlip
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		}
+		name := fi.Name()
+		ext := filepath.Ext(name)
+		isGo := ext == ".go"
+		if !isGo && !sourceFileExts[ext] {
+			continue
+		}
+		if strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+			continue // ignored by all Contexts
+		}
+		oldOK := goodOSArchFile(oldCtxt, name, nil)
+		newOK := goodOSArchFile(newCtxt, name, nil)
+		var oldReason, newReason ExcludeReason
+		if !oldOK {
+			oldReason = ExcludeFilename
+		}
+		if !newOK {
+			newReason = ExcludeFilename
+		}
+		if (oldOK || newOK) && ext != ".syso" {
+			header, err := readFileHeader(oldCtxt, dir, name, isGo)
+			if err != nil {
+				continue
+			}
+			if oldOK {
+				oldReason, _ = classifyHeader(oldEv, name, header, isGo, nil)
+			}
+			if newOK {
+				newReason, _ = classifyHeader(newEv, name, header, isGo, nil)
+			}
+		}
+		if (oldReason == 0) != (newReason == 0) {
+			flips = append(flips, FileFlip{
+				Name:      name,
+				Included:  newReason == 0,
+				OldReason: oldReason,
+				NewReason: newReason,
+			})
+		}
+	}
+	return flips, nil
+}
+
+// CgoExcludedFiles returns the names of the source files in directory dir
+// that are excluded by ctxt, but would be included if the CgoEnabled field of
+// ctxt was flipped. When cgo is disabled these are the files that import "C"
+// or require the "cgo" build tag, and when it is enabled they are the files
+// that require "!cgo". This allows editors to offer to toggle cgo for the
+// files of a view. The files are classified the same as FlippedFiles.
+func CgoExcludedFiles(ctxt *build.Context, dir string) ([]string, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	flipped := *ctxt
+	flipped.CgoEnabled = !ctxt.CgoEnabled
+	flips, err := FlippedFiles(ctxt, &flipped, dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range flips {
+		if f.Included {
+			names = append(names, f.Name)
+		}
+	}
+	return names, nil
+}
+
+// readFileHeader returns the header of the file, which is the leading
+// comments of non-Go files and everything through the imports of Go files.
+func readFileHeader(ctxt *build.Context, dir, name string, isGo bool) ([]byte, error) {
+	rc, err := openReaderDirName(ctxt, dir, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	if isGo {
+		info := fileInfo{name: name}
+		err := readGoInfo(rc, &info)
+		return info.header, err
+	}
+	return readComments(rc)
+}
+
+// readSourceDir returns the FileInfos of directory dir sorted by name.
+func readSourceDir(ctxt *build.Context, dir string) ([]fs.FileInfo, error) {
+	if ctxt.ReadDir == nil {
+		return ioutil.ReadDir(dir) // sorted
+	}
+	fis, err := ctxt.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	// The ReadDir function may return a cached slice so sort a copy.
+	fis = append([]fs.FileInfo(nil), fis...)
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
+	return fis, nil
+}
+
+// classifyFile returns the reason the file is excluded, or zero if the file
+// is included, and the header of the file if it was read.
+func classifyFile(ev *tagEvaluator, dir, name string, isGo bool, allTags map[string]bool) (ExcludeReason, []byte, error) {
+	ctxt := ev.ctxt
+	if strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+		return ExcludeIgnored, nil, nil
+	}
+	if !goodOSArchFile(ctxt, name, allTags) {
+		return ExcludeFilename, nil, nil
+	}
+	if strings.HasSuffix(name, ".syso") {
+		return 0, nil, nil // binary file with no build constraints
+	}
+	header, err := readFileHeader(ctxt, dir, name, isGo)
+	if err != nil {
+		return ExcludeError, nil, err
+	}
+	reason, err := classifyHeader(ev, name, header, isGo, allTags)
+	return reason, header, err
+}
+
+// classifyHeader is like classifyFile, but uses the header of the file,
+// which must have a good OS/Arch for the Context of ev.
+func classifyHeader(ev *tagEvaluator, name string, header []byte, isGo bool, allTags map[string]bool) (ExcludeReason, error) {
+	ok, err := ev.shouldBuild(header, allTags)
+	if err != nil {
+		return ExcludeError, err
+	}
+	if !ok {
+		return ExcludeConstraint, nil
+	}
+	if isGo {
+		f, err := parser.ParseFile(token.NewFileSet(), name, header, parser.ImportsOnly)
+		if err != nil {
+			return ExcludeError, err
+		}
+		for _, spec := range f.Imports {
+			if path, _ := strconv.Unquote(spec.Path.Value); path == "C" {
+				if allTags != nil {
+					allTags["cgo"] = true
+				}
+				if !ev.ctxt.CgoEnabled {
+					return ExcludeCgo, nil
+				}
+				break
+			}
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,340 @@
+package buildutil
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/charlievieth/buildutil/buildutiltest"
+)
+
+func TestBuildableFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.go":         "package main\n",
+		"main_test.go":    "package main\n",
+		"_ignored.go":     "package main\n",
+		".hidden.go":      "package main\n",
+		"sys_windows.go":  "package main\n",
+		"sys_linux.go":    "package main\n",
+		"tag.go":          "//go:build tag1\n\npackage main\n",
+		"notag.go":        "//go:build !linux\n\npackage main\n",
+		"cgo.go":          "package main\n\nimport \"C\"\n",
+		"invalid.go":      "//go:build (\n\npackage main\n",
+		"asm_amd64.s":     "#include \"textflag.h\"\n",
+		"asm_arm64.s":     "#include \"textflag.h\"\n",
+		"file.c":          "//go:build ignore\n\nint x;\n",
+		"README.md":       "# readme\n",
+		"sub/sub.go":      "package sub\n",
+		"rsrc_linux.syso": "",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "amd64"
+	ctxt.CgoEnabled = false
+	ctxt.BuildTags = nil
+
+	set, err := BuildableFiles(&ctxt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantGo := []string{"main.go", "main_test.go", "sys_linux.go"}
+	if !reflect.DeepEqual(set.GoFiles, wantGo) {
+		t.Errorf("GoFiles = %q; want: %q", set.GoFiles, wantGo)
+	}
+	wantOther := []string{"asm_amd64.s", "rsrc_linux.syso"}
+	if !reflect.DeepEqual(set.OtherFiles, wantOther) {
+		t.Errorf("OtherFiles = %q; want: %q", set.OtherFiles, wantOther)
+	}
+	wantExcluded := map[string]ExcludeReason{
+		".hidden.go":     ExcludeIgnored,
+		"_ignored.go":    ExcludeIgnored,
+		"asm_arm64.s":    ExcludeFilename,
+		"cgo.go":         ExcludeCgo,
+		"file.c":         ExcludeConstraint,
+		"invalid.go":     ExcludeError,
+		"notag.go":       ExcludeConstraint,
+		"sys_windows.go": ExcludeFilename,
+		"tag.go":         ExcludeConstraint,
+	}
+	gotExcluded := make(map[string]ExcludeReason)
+	for _, f := range set.Excluded {
+		gotExcluded[f.Name] = f.Reason
+		if (f.Reason == ExcludeError) != (f.Err != nil) {
+			t.Errorf("%s: Reason: %s Err: %v", f.Name, f.Reason, f.Err)
+		}
+	}
+	if !reflect.DeepEqual(gotExcluded, wantExcluded) {
+		t.Errorf("Excluded = %v; want: %v", gotExcluded, wantExcluded)
+	}
+	for _, tag := range []string{"amd64", "arm64", "cgo", "ignore", "linux", "tag1", "windows"} {
+		found := false
+		for _, s := range set.AllTags {
+			if s == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("AllTags = %q: missing: %q", set.AllTags, tag)
+		}
+	}
+
+	// Enabling cgo and adding tag1 should include the files
+	ctxt.CgoEnabled = true
+	ctxt.BuildTags = []string{"tag1"}
+	set, err = BuildableFiles(&ctxt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantGo = []string{"cgo.go", "main.go", "main_test.go", "sys_linux.go", "tag.go"}
+	if !reflect.DeepEqual(set.GoFiles, wantGo) {
+		t.Errorf("GoFiles = %q; want: %q", set.GoFiles, wantGo)
+	}
+
+	if _, err := BuildableFiles(&ctxt, filepath.Join(dir, "missing")); err == nil {
+		t.Error("BuildableFiles: expected error for missing directory")
+	}
+}
+
+func TestExcludeReasonString(t *testing.T) {
+	for r := ExcludeIgnored; r <= ExcludeError; r++ {
+		if s := r.String(); s == "" || s[0] == 'E' {
+			t.Errorf("ExcludeReason(%d).String() = %q", int(r), s)
+		}
+	}
+	if s := ExcludeReason(0).String(); s != "ExcludeReason(0)" {
+		t.Errorf("ExcludeReason(0).String() = %q; want: %q", s, "ExcludeReason(0)")
+	}
+}
+
+func TestDirBuildTags(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.go":          "package main\n",
+		"_ignored.go":      "//go:build ignored\n\npackage main\n",
+		"sys_windows.go":   "package main\n",
+		"sys_linux_arm.go": "package main\n",
+		"tag.go":           "//go:build tag1 && !tag2\n\npackage main\n",
+		"cgo.go":           "//go:build darwin\n\npackage main\n\nimport \"C\"\n",
+		"file.c":           "//go:build ignore\n\nint x;\n",
+		"README.md":        "//go:build readme\n",
+		"sub/sub.go":       "//go:build sub\n\npackage sub\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "amd64"
+	ctxt.CgoEnabled = false
+
+	tags, err := DirBuildTags(&ctxt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{
+		"arm":     true,
+		"cgo":     true,
+		"darwin":  true,
+		"ignore":  true,
+		"linux":   true,
+		"tag1":    true,
+		"tag2":    true,
+		"windows": true,
+	}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("DirBuildTags() = %v; want: %v", tags, want)
+	}
+
+	// Invalid constraints are an error
+	if err := os.WriteFile(filepath.Join(dir, "invalid.go"), []byte("//go:build (\n\npackage main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DirBuildTags(&ctxt, dir); err == nil {
+		t.Error("DirBuildTags: expected error for invalid build constraint")
+	}
+	if _, err := DirBuildTags(&ctxt, filepath.Join(dir, "missing")); err == nil {
+		t.Error("DirBuildTags: expected error for missing directory")
+	}
+}
+
+func TestScanDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.go":          "package main\n",
+		"_ignored.go":      "//go:build ignored\n\npackage main\n",
+		"sys_windows.go":   "//go:build winonly\n\npackage main\n",
+		"sys_linux_arm.go": "package main\n",
+		"tag.go":           "//go:build tag1 && !tag2\n\npackage main\n",
+		"cgo.go":           "//go:build darwin\n\npackage main\n\nimport \"C\"\n",
+		"asm_arm64.s":      "//go:build asmtag\n\n#include \"textflag.h\"\n",
+		"invalid.go":       "//go:build (\n\npackage main\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "amd64"
+	ctxt.CgoEnabled = false
+	ctxt.BuildTags = nil
+
+	set, tags, err := ScanDir(&ctxt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := BuildableFiles(&ctxt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(set, want) {
+		t.Errorf("ScanDir() = %+v; want: %+v", set, want)
+	}
+	wantTags := map[string]bool{
+		"arm":     true,
+		"arm64":   true,
+		"asmtag":  true,
+		"cgo":     true,
+		"darwin":  true,
+		"linux":   true,
+		"tag1":    true,
+		"tag2":    true,
+		"windows": true,
+		"winonly": true,
+	}
+	if !reflect.DeepEqual(tags, wantTags) {
+		t.Errorf("ScanDir() tags = %v; want: %v", tags, wantTags)
+	}
+}
+
+func TestFlippedFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.go":         "package main\n",
+		"_ignored.go":     "//go:build tag1\n\npackage main\n",
+		"sys_linux.go":    "package main\n",
+		"sys_darwin.go":   "package main\n",
+		"tag.go":          "//go:build tag1\n\npackage main\n",
+		"notag.go":        "//go:build !tag1\n\npackage main\n",
+		"cgo.go":          "//go:build darwin\n\npackage main\n\nimport \"C\"\n",
+		"asm_darwin.s":    "#include \"textflag.h\"\n",
+		"rsrc_linux.syso": "",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := build.Default
+	old.GOOS = "linux"
+	old.GOARCH = "amd64"
+	old.CgoEnabled = false
+	old.BuildTags = nil
+
+	// Add a build tag
+	tagged := old
+	tagged.BuildTags = []string{"tag1"}
+	flips, err := FlippedFiles(&old, &tagged, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FileFlip{
+		{Name: "notag.go", Included: false, NewReason: ExcludeConstraint},
+		{Name: "tag.go", Included: true, OldReason: ExcludeConstraint},
+	}
+	if !reflect.DeepEqual(flips, want) {
+		t.Errorf("FlippedFiles(+tag1) = %+v; want: %+v", flips, want)
+	}
+
+	// Switch GOOS
+	darwin := old
+	darwin.GOOS = "darwin"
+	flips, err = FlippedFiles(&old, &darwin, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []FileFlip{
+		{Name: "asm_darwin.s", Included: true, OldReason: ExcludeFilename},
+		{Name: "rsrc_linux.syso", Included: false, NewReason: ExcludeFilename},
+		{Name: "sys_darwin.go", Included: true, OldReason: ExcludeFilename},
+		{Name: "sys_linux.go", Included: false, NewReason: ExcludeFilename},
+	}
+	if !reflect.DeepEqual(flips, want) {
+		t.Errorf("FlippedFiles(GOOS=darwin) = %+v; want: %+v", flips, want)
+	}
+
+	// Enabling cgo includes cgo.go
+	darwinCgo := darwin
+	darwinCgo.CgoEnabled = true
+	flips, err = FlippedFiles(&darwin, &darwinCgo, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []FileFlip{{Name: "cgo.go", Included: true, OldReason: ExcludeCgo}}
+	if !reflect.DeepEqual(flips, want) {
+		t.Errorf("FlippedFiles(cgo) = %+v; want: %+v", flips, want)
+	}
+
+	if flips, err := FlippedFiles(&old, &old, dir); err != nil || len(flips) != 0 {
+		t.Errorf("FlippedFiles(old, old) = %+v, %v; want: [], nil", flips, err)
+	}
+}
+
+func TestCgoExcludedFiles(t *testing.T) {
+	dir := t.TempDir()
+	buildutiltest.WriteFiles(t, dir, map[string]string{
+		"main.go":       "package main\n",
+		"cgo.go":        "package main\n\nimport \"C\"\n",
+		"tag.go":        "//go:build cgo\n\npackage main\n",
+		"nocgo.go":      "//go:build !cgo\n\npackage main\n",
+		"cgo_darwin.go": "package main\n\nimport \"C\"\n",
+		"hdr.h":         "//go:build cgo\n\nint x;\n",
+	})
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "amd64"
+	ctxt.CgoEnabled = false
+
+	names, err := CgoExcludedFiles(&ctxt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"cgo.go", "hdr.h", "tag.go"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("CgoExcludedFiles(CgoEnabled=false) = %q; want: %q", names, want)
+	}
+
+	ctxt.CgoEnabled = true
+	names, err = CgoExcludedFiles(&ctxt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"nocgo.go"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("CgoExcludedFiles(CgoEnabled=true) = %q; want: %q", names, want)
+	}
+}
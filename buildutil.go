@@ -15,7 +15,11 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+
+	"github.com/charlievieth/buildutil/internal/fsys"
+	"github.com/charlievieth/buildutil/internal/util"
 )
 
 // BuildTags adds and build tags found in name or content to allTags.
@@ -51,38 +55,46 @@ func ShouldBuild(ctxt *build.Context, content []byte, allTags map[string]bool) b
 }
 
 func Include(ctxt *build.Context, path string) bool {
+	return IncludeSrc(ctxt, path, nil)
+}
+
+// IncludeSrc is like Include, but if src != nil it is used as the source of
+// the file at path instead of reading it (src may be a string, []byte, or
+// io.Reader). This is useful for evaluating unsaved files (overlays).
+func IncludeSrc(ctxt *build.Context, path string, src interface{}) bool {
+	ok, err := includeSrc(ctxt, path, src)
+	return ok && err == nil
+}
+
+// includeSrc is like IncludeSrc, but returns the error reading the file, if
+// any, which is errSyntax if its package clause is malformed.
+func includeSrc(ctxt *build.Context, path string, src interface{}) (bool, error) {
 	if !goodOSArchFile(ctxt, filepath.Base(path), nil) {
-		return false
-	}
-	var f io.ReadCloser
-	var err error
-	if fn := ctxt.OpenFile; fn != nil {
-		f, err = fn(path)
-	} else {
-		f, err = os.Open(path)
+		return false, nil
 	}
+	f, err := openReader(ctxt, path, src)
 	if err != nil {
-		return false
+		return false, err
 	}
 	data, err := readImportsFast(f)
 	f.Close()
 	if err != nil {
-		return false
+		return false, err
 	}
-	return shouldBuildOnly(ctxt, data, nil)
+	return shouldBuildOnly(ctxt, data, nil), nil
 }
 
 func IncludeTags(ctxt *build.Context, path string, tags map[string]bool) (bool, error) {
+	return IncludeTagsSrc(ctxt, path, nil, tags)
+}
+
+// IncludeTagsSrc is like IncludeTags, but if src != nil it is used as the
+// source of the file at path instead of reading it.
+func IncludeTagsSrc(ctxt *build.Context, path string, src interface{}, tags map[string]bool) (bool, error) {
 	if !goodOSArchFile(ctxt, filepath.Base(path), tags) {
 		return false, nil
 	}
-	var f io.ReadCloser
-	var err error
-	if fn := ctxt.OpenFile; fn != nil {
-		f, err = fn(path)
-	} else {
-		f, err = os.Open(path)
-	}
+	f, err := openReader(ctxt, path, src)
 	if err != nil {
 		return false, err
 	}
@@ -94,18 +106,70 @@ func IncludeTags(ctxt *build.Context, path string, tags map[string]bool) (bool,
 	return shouldBuildOnly(ctxt, data, tags), nil
 }
 
+// IncludeTagsMulti reports whether the file at path would be included in a
+// build for each of the ctxts. The file is read and its build constraints
+// are parsed only once, which makes it cheaper than calling Include for each
+// Context (e.g. an editor showing the status of a file for both linux and
+// darwin). The returned slice has the same length as ctxts and the result
+// for ctxts[i] is at index i.
+//
+// The file is opened using the first Context. A nil Context is the same as
+// build.Default. If ctxts is empty, nil is returned. An error is only
+// returned if the file cannot be read.
+func IncludeTagsMulti(ctxts []*build.Context, path string) ([]bool, error) {
+	if len(ctxts) == 0 {
+		return nil, nil
+	}
+	first := ctxts[0]
+	if first == nil {
+		first = &build.Default
+	}
+	f, err := openReader(first, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readImportsFast(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	// Invalid "// +build" lines are ignored by shouldBuild, so use it
+	// if the constraint cannot be parsed.
+	expr, perr := parseBuildConstraint(data)
+
+	name := filepath.Base(path)
+	include := make([]bool, len(ctxts))
+	for i, ctxt := range ctxts {
+		if ctxt == nil {
+			ctxt = &build.Default
+		}
+		if !goodOSArchFile(ctxt, name, nil) {
+			continue
+		}
+		switch {
+		case perr != nil:
+			include[i] = shouldBuildOnly(ctxt, data, nil)
+		case expr == nil:
+			include[i] = true
+		default:
+			include[i] = eval(ctxt, expr, nil)
+		}
+	}
+	return include, nil
+}
+
 // TODO (CEV): rename
 func ShortImport(ctxt *build.Context, path string) (string, bool) {
+	return ShortImportSrc(ctxt, path, nil)
+}
+
+// ShortImportSrc is like ShortImport, but if src != nil it is used as the
+// source of the file at path instead of reading it.
+func ShortImportSrc(ctxt *build.Context, path string, src interface{}) (string, bool) {
 	if !goodOSArchFile(ctxt, filepath.Base(path), nil) {
 		return "", false
 	}
-	var f io.ReadCloser
-	var err error
-	if fn := ctxt.OpenFile; fn != nil {
-		f, err = fn(path)
-	} else {
-		f, err = os.Open(path)
-	}
+	f, err := openReader(ctxt, path, src)
 	if err != nil {
 		return "", false
 	}
@@ -197,6 +261,45 @@ func MatchFile(ctxt *build.Context, dir, name string, src interface{}) (pkgName
 	return
 }
 
+// MatchFileAssumeTags is like MatchFile, but assumes that all user defined
+// build tags are satisfied. That is, any tag that is not a GOOS, GOARCH,
+// compiler, cgo, Go release, tool or GOEXPERIMENT tag is considered true.
+//
+// This is useful for tools, such as documentation generators, that want to
+// include files regardless of custom build tags, but still exclude files
+// that do not match the Context's OS and Arch.
+func MatchFileAssumeTags(ctxt *build.Context, dir, name string, src interface{}) (pkgName string, match bool, err error) {
+	rc, err := openReaderDirName(ctxt, dir, name, src)
+	if err != nil {
+		return
+	}
+	data, err := readImportsFast(rc)
+	rc.Close()
+	if err != nil {
+		return "", false, err
+	}
+	pkgName, err = readPackageName(data)
+	if err != nil {
+		return "", false, err
+	}
+	if !GoodOSArchFile(ctxt, name, nil) {
+		return pkgName, false, nil
+	}
+	match, _, err = shouldBuildMatch(data, func(tag string) bool {
+		return matchTagAssume(ctxt, tag, nil)
+	})
+	return
+}
+
+// ShouldBuildAssumeTags is like ShouldBuild, but assumes that all user
+// defined build tags are satisfied (see MatchFileAssumeTags).
+func ShouldBuildAssumeTags(ctxt *build.Context, content []byte, allTags map[string]bool) bool {
+	ok, _, _ := shouldBuildMatch(content, func(tag string) bool {
+		return matchTagAssume(ctxt, tag, allTags)
+	})
+	return ok
+}
+
 var emptyConstraint Constraint
 
 // A Constraint stores the build constraints of a Go source file and can be
@@ -233,6 +336,14 @@ func (c *Constraint) Eval(ctxt *build.Context) bool {
 	return c.Empty() || eval(ctxt, c.expr, nil)
 }
 
+// EvalAssumeTags is like Eval, but assumes that all user defined build tags
+// are satisfied (see MatchFileAssumeTags).
+func (c *Constraint) EvalAssumeTags(ctxt *build.Context) bool {
+	return c.Empty() || c.expr.Eval(func(tag string) bool {
+		return matchTagAssume(ctxt, tag, nil)
+	})
+}
+
 // ParseConstraint parses the build constraints of a Go source file, if any.
 // The returned Constraint can be used to check if the file matches a
 // build.Context.
@@ -253,6 +364,23 @@ func ParseConstraint(ctxt *build.Context, filename string, src interface{}) (*Co
 	return &Constraint{expr: expr}, nil
 }
 
+// ConstraintMatchesContext reports whether the file filename, whose build
+// constraints c were previously parsed with ParseConstraint, matches ctxt.
+// It is the same as MatchFile, but does not read or parse the file, which
+// allows callers that cache the Constraints of files (e.g. editors) to skip
+// re-parsing their headers. The GOOS/GOARCH suffix of filename must also
+// match ctxt. A nil Constraint matches any Context.
+func ConstraintMatchesContext(ctxt *build.Context, filename string, c *Constraint) bool {
+	return goodOSArchFile(ctxt, filepath.Base(filename), nil) && c.Eval(ctxt)
+}
+
+// ConstraintMatchesContextAssumeTags is like ConstraintMatchesContext, but
+// assumes that all user defined build tags are satisfied (see
+// MatchFileAssumeTags).
+func ConstraintMatchesContextAssumeTags(ctxt *build.Context, filename string, c *Constraint) bool {
+	return goodOSArchFile(ctxt, filepath.Base(filename), nil) && c.EvalAssumeTags(ctxt)
+}
+
 func openReaderDirName(ctxt *build.Context, dir, name string, src interface{}) (io.ReadCloser, error) {
 	if src != nil {
 		switch s := src.(type) {
@@ -330,6 +458,14 @@ var binaryOnlyComment = []byte("//go:binary-only-package")
 // shouldBuild reports whether the file should be built
 // and whether a //go:binary-only-package comment was found.
 func shouldBuild(ctxt *build.Context, content []byte, allTags map[string]bool) (shouldBuild, binaryOnly bool, err error) {
+	return shouldBuildMatch(content, func(tag string) bool {
+		return matchTag(ctxt, tag, allTags)
+	})
+}
+
+// shouldBuildMatch is like shouldBuild, but uses match to evaluate
+// build tags.
+func shouldBuildMatch(content []byte, match func(tag string) bool) (shouldBuild, binaryOnly bool, err error) {
 	// Identify leading run of // comments and blank lines,
 	// which must be followed by a blank line.
 	// Also identify any //go:build comments.
@@ -342,11 +478,11 @@ func shouldBuild(ctxt *build.Context, content []byte, allTags map[string]bool) (
 	// Otherwise fall back to +build processing.
 	switch {
 	case goBuild != nil:
-		x, err := constraint.Parse(string(goBuild))
+		x, err := parseConstraint(string(goBuild))
 		if err != nil {
 			return false, false, fmt.Errorf("parsing //go:build line: %v", err)
 		}
-		shouldBuild = eval(ctxt, x, allTags)
+		shouldBuild = x.Eval(match)
 
 	default:
 		shouldBuild = true
@@ -366,8 +502,8 @@ func shouldBuild(ctxt *build.Context, content []byte, allTags map[string]bool) (
 			if !constraint.IsPlusBuild(text) {
 				continue
 			}
-			if x, err := constraint.Parse(text); err == nil {
-				if !eval(ctxt, x, allTags) {
+			if x, err := parseConstraint(text); err == nil {
+				if !x.Eval(match) {
 					shouldBuild = false
 				}
 			}
@@ -390,7 +526,7 @@ func parseBuildConstraint(content []byte) (constraint.Expr, error) {
 	// If //go:build line is present, it controls.
 	// Otherwise fall back to +build processing.
 	if goBuild != nil {
-		x, err := constraint.Parse(string(goBuild))
+		x, err := parseConstraint(string(goBuild))
 		if err != nil {
 			return nil, fmt.Errorf("parsing //go:build line: %w", err)
 		}
@@ -415,7 +551,7 @@ func parseBuildConstraint(content []byte) (constraint.Expr, error) {
 		if !constraint.IsPlusBuild(text) {
 			continue
 		}
-		y, err := constraint.Parse(text)
+		y, err := parseConstraint(text)
 		if err != nil {
 			return nil, err
 		}
@@ -430,6 +566,25 @@ func parseBuildConstraint(content []byte) (constraint.Expr, error) {
 	return x, nil
 }
 
+// maxConstraintDepth is the maximum nesting depth of a build constraint.
+// Older versions of go/build/constraint parse deeply nested expressions with
+// unbounded recursion, which exhausts the stack (a fatal error that cannot be
+// recovered from) on malicious input.
+const maxConstraintDepth = 1000
+
+var errConstraintDepth = errors.New("build constraint exceeds max nesting depth")
+
+// parseConstraint is like constraint.Parse, but rejects expressions that
+// are nested deeper than maxConstraintDepth.
+func parseConstraint(line string) (constraint.Expr, error) {
+	// Each '(' and '!' is a level of recursion so this over estimates the
+	// depth of flat expressions with many negations, which is fine.
+	if strings.Count(line, "(")+strings.Count(line, "!") > maxConstraintDepth {
+		return nil, errConstraintDepth
+	}
+	return constraint.Parse(line)
+}
+
 // TODO: move to minimize diff with go/build.go
 func shouldBuildOnly(ctxt *build.Context, content []byte, allTags map[string]bool) bool {
 	ok, _, _ := shouldBuild(ctxt, content, allTags)
@@ -437,6 +592,51 @@ func shouldBuildOnly(ctxt *build.Context, content []byte, allTags map[string]boo
 }
 
 func parseFileHeader(content []byte) (trimmed, goBuild []byte, sawBinaryOnly bool, err error) {
+	h, sawBinaryOnly, err := scanFileHeader(content)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if h.GoBuild.Valid() {
+		goBuild = bytes.TrimSpace(content[h.GoBuild.Start:h.GoBuild.End])
+	}
+	return content[:h.End], goBuild, sawBinaryOnly, nil
+}
+
+// A HeaderRange is the byte range content[Start:End] of a line, or run of
+// lines, in the header of a file. The range includes the trailing newline, if
+// any, so that removing it removes the line. A missing element has a Start
+// and End of -1.
+type HeaderRange struct {
+	Start, End int
+}
+
+// Valid reports if the range is present.
+func (r HeaderRange) Valid() bool { return r.Start >= 0 }
+
+// HeaderOffsets are the byte offsets of the build constraints in the header
+// of a Go source file (the leading run of comments and blank lines). They
+// allow tools that rewrite build constraints to edit a file in place.
+type HeaderOffsets struct {
+	GoBuild   HeaderRange // the "//go:build" line
+	PlusBuild HeaderRange // from the first to the last "// +build" line
+	End       int         // end of the header: build constraints must appear before End
+}
+
+// ParseHeaderOffsets returns the offsets of the build constraints in content,
+// which is the content, or header (see ReadImportsFast), of a Go source file.
+// Only "// +build" lines that go/build would consider, which are those
+// followed by a blank line, are included in PlusBuild. An error is returned
+// if the file has multiple "//go:build" lines.
+func ParseHeaderOffsets(content []byte) (HeaderOffsets, error) {
+	h, _, err := scanFileHeader(content)
+	return h, err
+}
+
+// scanFileHeader is the implementation of parseFileHeader and
+// ParseHeaderOffsets.
+func scanFileHeader(content []byte) (h HeaderOffsets, sawBinaryOnly bool, err error) {
+	h.GoBuild = HeaderRange{-1, -1}
+	h.PlusBuild = HeaderRange{-1, -1}
 	end := 0
 	p := content
 	ended := false       // found non-blank, non-// line, so stopped accepting // +build lines
@@ -444,12 +644,14 @@ func parseFileHeader(content []byte) (trimmed, goBuild []byte, sawBinaryOnly boo
 
 Lines:
 	for len(p) > 0 {
+		lineStart := len(content) - len(p)
 		line := p
 		if i := bytes.IndexByte(line, '\n'); i >= 0 {
 			line, p = line[:i], p[i+1:]
 		} else {
 			p = p[len(p):]
 		}
+		lineRange := HeaderRange{lineStart, len(content) - len(p)}
 		line = bytes.TrimSpace(line)
 		if len(line) == 0 && !ended { // Blank line
 			// Remember position of most recent blank line.
@@ -468,14 +670,21 @@ Lines:
 		}
 
 		if !inSlashStar && isGoBuildComment(line) {
-			if goBuild != nil {
-				return nil, nil, false, errMultipleGoBuild
+			if h.GoBuild.Valid() {
+				return HeaderOffsets{GoBuild: HeaderRange{-1, -1}, PlusBuild: HeaderRange{-1, -1}}, false, errMultipleGoBuild
 			}
-			goBuild = line
+			h.GoBuild = lineRange
 		}
 		if !inSlashStar && bytes.Equal(line, binaryOnlyComment) {
 			sawBinaryOnly = true
 		}
+		// Lines after the last blank line are removed below.
+		if !ended && bytes.Contains(line, bPlusBuild) && constraint.IsPlusBuild(string(line)) {
+			if !h.PlusBuild.Valid() {
+				h.PlusBuild.Start = lineRange.Start
+			}
+			h.PlusBuild.End = lineRange.End
+		}
 
 	Comments:
 		for len(line) > 0 {
@@ -500,7 +709,38 @@ Lines:
 		}
 	}
 
-	return content[:end], goBuild, sawBinaryOnly, nil
+	h.End = end
+	if h.PlusBuild.Valid() && h.PlusBuild.End > end {
+		if h.PlusBuild.Start < end {
+			h.PlusBuild = trimPlusBuild(content, h.PlusBuild, end)
+		} else {
+			h.PlusBuild = HeaderRange{-1, -1}
+		}
+	}
+	return h, sawBinaryOnly, nil
+}
+
+// trimPlusBuild returns the range of the "// +build" lines of r that
+// end before end.
+func trimPlusBuild(content []byte, r HeaderRange, end int) HeaderRange {
+	t := HeaderRange{-1, -1}
+	p := content[r.Start:end]
+	for len(p) > 0 {
+		lineStart := end - len(p)
+		line := p
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line, p = line[:i], p[i+1:]
+		} else {
+			p = p[len(p):]
+		}
+		if constraint.IsPlusBuild(string(bytes.TrimSpace(line))) {
+			if !t.Valid() {
+				t.Start = lineStart
+			}
+			t.End = end - len(p)
+		}
+	}
+	return t
 }
 
 func eval(ctxt *build.Context, x constraint.Expr, allTags map[string]bool) bool {
@@ -526,6 +766,12 @@ var compatibleOSes = map[string][]string{
 //
 // It records all consulted tags in allTags.
 func matchTag(ctxt *build.Context, name string, allTags map[string]bool) bool {
+	return matchTagRules(ctxt, name, allTags, matchUnixAndBoringCrypto)
+}
+
+// matchTagRules is like matchTag, but go119 controls whether the "unix" and
+// "boringcrypto" tags, which were added with go1.19, are matched.
+func matchTagRules(ctxt *build.Context, name string, allTags map[string]bool, go119 bool) bool {
 	if allTags != nil {
 		allTags[name] = true
 	}
@@ -546,7 +792,7 @@ func matchTag(ctxt *build.Context, name string, allTags map[string]bool) bool {
 	if ctxt.GOOS == "ios" && name == "darwin" {
 		return true
 	}
-	if matchUnixAndBoringCrypto {
+	if go119 {
 		if name == "unix" && unixOS[ctxt.GOOS] {
 			return true
 		}
@@ -556,23 +802,18 @@ func matchTag(ctxt *build.Context, name string, allTags map[string]bool) bool {
 	}
 
 	// other tags
-	for _, tag := range ctxt.BuildTags {
-		if tag == name {
-			return true
-		}
-	}
-	for _, tag := range ctxt.ToolTags {
-		if tag == name {
-			return true
-		}
-	}
-	for _, tag := range ctxt.ReleaseTags {
-		if tag == name {
-			return true
-		}
-	}
+	return util.StringsContains(ctxt.BuildTags, name) ||
+		util.StringsContains(ctxt.ToolTags, name) ||
+		util.StringsContains(ctxt.ReleaseTags, name)
+}
 
-	return false
+// matchTagAssume is like matchTag, but reports true for any tag that is not
+// an internal (platform, compiler, toolchain or release) tag.
+func matchTagAssume(ctxt *build.Context, name string, allTags map[string]bool) bool {
+	if matchTag(ctxt, name, allTags) {
+		return true
+	}
+	return !isInternalTag(ctxt, name)
 }
 
 func inTestdata(sub string) bool {
@@ -582,13 +823,24 @@ func inTestdata(sub string) bool {
 
 // return ctxt.Import(".", dir, mode)
 func ImportPath(ctxt *build.Context, dir string) (string, error) {
+	importPath, _, err := ImportPathConflict(ctxt, dir)
+	return importPath, err
+}
+
+// ImportPathConflict is like ImportPath, but also returns the directory that
+// shadows dir, if any. When dir is in a GOPATH, but its import path would
+// resolve to a directory in the GOROOT or an earlier GOPATH entry, the import
+// path is "." (like ImportPath and go/build) and conflictDir is the directory
+// the import path resolves to. This is the same as the ConflictDir field of
+// build.Package and allows tools to warn about shadowed packages.
+func ImportPathConflict(ctxt *build.Context, dir string) (importPath, conflictDir string, err error) {
 	if dir == "" {
-		return "", errors.New("empty source dir")
+		return "", "", errors.New("empty source dir")
 	}
 	if !isDir(ctxt, dir) {
-		return ".", errors.New("cannot find package \".\" in:\n\t" + filepath.FromSlash(dir))
+		return ".", "", errors.New("cannot find package \".\" in:\n\t" + filepath.FromSlash(dir))
 	}
-	importPath := "."
+	importPath = "."
 	if !strings.HasPrefix(dir, ctxt.GOROOT) {
 		all := gopath(ctxt)
 		for i, root := range all {
@@ -599,13 +851,13 @@ func ImportPath(ctxt *build.Context, dir string) (string, error) {
 				// else first.
 				if ctxt.GOROOT != "" {
 					if dir := joinPath(ctxt, ctxt.GOROOT, "src", sub); isDir(ctxt, dir) {
-						// go/build records a conflict here
+						conflictDir = dir
 						goto Found
 					}
 				}
 				for _, earlyRoot := range all[:i] {
 					if dir := joinPath(ctxt, earlyRoot, "src", sub); isDir(ctxt, dir) {
-						// go/build records a conflict here
+						conflictDir = dir
 						goto Found
 					}
 				}
@@ -625,7 +877,55 @@ func ImportPath(ctxt *build.Context, dir string) (string, error) {
 	}
 
 Found:
-	return importPath, nil
+	return importPath, conflictDir, nil
+}
+
+// CopyContext returns a copy of orig that does not share the BuildTags,
+// ToolTags or ReleaseTags slices of orig, which allows the tags of the copy
+// to be modified without changing orig (a shallow copy of a build.Context
+// shares the underlying arrays of its slices). If orig is nil a copy of
+// build.Default is returned.
+//
+// The function hooks (JoinPath, SplitPathList, IsAbsPath, IsDir, HasSubdir,
+// ReadDir and OpenFile) are carried over as is, so the copy shares any state
+// they close over, such as the file system of a scoped or fake Context. Use
+// CopyContextOptions to remove them.
+func CopyContext(orig *build.Context) *build.Context {
+	if orig == nil {
+		orig = &build.Default
+	}
+	return util.CopyContext(orig)
+}
+
+// CopyOptions configures how CopyContextOptions copies a build.Context.
+type CopyOptions struct {
+	// StripHooks sets the function hooks of the copy to nil so that it uses
+	// the local file system and does not share the state of the hooks of
+	// the original. Functions cannot be serialized, so this makes the copy
+	// behave the same as a Context that was serialized and read back.
+	StripHooks bool
+
+	// Dir, if not empty, replaces the Dir of the copy, which is the
+	// directory relative paths are resolved against.
+	Dir string
+}
+
+// CopyContextOptions is like CopyContext, but uses opts to modify the copy.
+func CopyContextOptions(orig *build.Context, opts CopyOptions) *build.Context {
+	ctxt := CopyContext(orig)
+	if opts.StripHooks {
+		ctxt.JoinPath = nil
+		ctxt.SplitPathList = nil
+		ctxt.IsAbsPath = nil
+		ctxt.IsDir = nil
+		ctxt.HasSubdir = nil
+		ctxt.ReadDir = nil
+		ctxt.OpenFile = nil
+	}
+	if opts.Dir != "" {
+		ctxt.Dir = opts.Dir
+	}
+	return ctxt
 }
 
 // joinPath calls ctxt.JoinPath (if not nil) or else filepath.Join.
@@ -636,6 +936,14 @@ func joinPath(ctxt *build.Context, elem ...string) string {
 	return filepath.Join(elem...)
 }
 
+// isAbsPath calls ctxt.IsAbsPath (if not nil) or else filepath.IsAbs.
+func isAbsPath(ctxt *build.Context, path string) bool {
+	if f := ctxt.IsAbsPath; f != nil {
+		return f(path)
+	}
+	return filepath.IsAbs(path)
+}
+
 // splitPathList calls ctxt.SplitPathList (if not nil) or else filepath.SplitList.
 func splitPathList(ctxt *build.Context, s string) []string {
 	if f := ctxt.SplitPathList; f != nil {
@@ -649,7 +957,7 @@ func isDir(ctxt *build.Context, path string) bool {
 	if f := ctxt.IsDir; f != nil {
 		return f(path)
 	}
-	fi, err := os.Stat(path)
+	fi, err := fsys.Stat(path)
 	return err == nil && fi.IsDir()
 }
 
@@ -668,8 +976,8 @@ func hasSubdirCtxt(ctxt *build.Context, root, dir string) (rel string, ok bool)
 	// Try expanding symlinks and comparing
 	// expanded against unexpanded and
 	// expanded against expanded.
-	rootSym, _ := filepath.EvalSymlinks(root)
-	dirSym, _ := filepath.EvalSymlinks(dir)
+	rootSym, _ := fsys.EvalSymlinks(root)
+	dirSym, _ := fsys.EvalSymlinks(dir)
 
 	if rel, ok = hasSubdir(rootSym, dir); ok {
 		return
@@ -681,20 +989,12 @@ func hasSubdirCtxt(ctxt *build.Context, root, dir string) (rel string, ok bool)
 }
 
 // isSubdir reports if dir is within root by performing lexical analysis only.
-func isSubdir(root, dir string) bool {
-	n := len(root)
-	return 0 < n && n < len(dir) && dir[0:n] == root && os.IsPathSeparator(dir[n])
-}
+func isSubdir(root, dir string) bool { return util.IsSubdir(root, dir) }
 
 // hasSubdir reports if dir is within root by performing lexical analysis only.
 //
 // NOTE: this is a faster alloc free version of: go/build.hasSubdir
-func hasSubdir(root, dir string) (rel string, ok bool) {
-	if isSubdir(root, dir) {
-		return filepath.ToSlash(dir[len(root)+1:]), true
-	}
-	return "", false
-}
+func hasSubdir(root, dir string) (rel string, ok bool) { return util.HasSubdir(root, dir) }
 
 // gopath returns the list of Go path directories.
 func gopath(ctxt *build.Context) []string {
@@ -741,6 +1041,40 @@ func KnownArchList() []string {
 	return s
 }
 
+// KnownReleaseTags returns a copy of the release tags of ctxt ("go1.1" through
+// "go1.N"), or of build.Default if ctxt is nil, which are the Go versions
+// whose build constraints ctxt satisfies.
+func KnownReleaseTags(ctxt *build.Context) []string {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	s := make([]string, len(ctxt.ReleaseTags))
+	copy(s, ctxt.ReleaseTags)
+	return s
+}
+
+// IsReleaseTag reports if s is one of the release tags of ctxt, or of
+// build.Default if ctxt is nil. Unlike checking if s looks like a Go
+// version, this reports false for versions newer than ctxt supports.
+//
+// Release tags are listed in order ("go1.1" is first) so this runs in
+// constant time unless the ReleaseTags of ctxt are not in the standard
+// form, in which case they are searched.
+func IsReleaseTag(ctxt *build.Context, s string) bool {
+	if ctxt == nil {
+		return knownReleaseTag[s]
+	}
+	tags := ctxt.ReleaseTags
+	n := len(tags)
+	if n != 0 && tags[0] == "go1.1" && tags[n-1] == "go1."+strconv.Itoa(n) {
+		minor, ok := parseReleaseTagMinor(s)
+		return ok && 0 < minor && minor <= n && tags[minor-1] == s
+	}
+	return util.StringsContains(tags, s)
+}
+
+// knownReleaseTag is the set of release tags known to the current toolchain.
+// It must not be modified after initialization.
 var knownReleaseTag = func() map[string]bool {
 	m := make(map[string]bool, len(build.Default.ReleaseTags))
 	for _, v := range build.Default.ReleaseTags {
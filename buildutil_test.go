@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"go/build"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -40,7 +41,7 @@ func testPreferredList(t testing.TB, list []string, want map[string]bool) {
 // know the valid OS/Arch combos?
 func TestPreferredOSList(t *testing.T) {
 	oses := make(map[string]bool)
-	for _, p := range DefaultGoPlatforms {
+	for _, p := range knownPlatforms {
 		oses[p.GOOS] = true
 	}
 	testPreferredList(t, PreferredOSList, oses)
@@ -50,7 +51,7 @@ func TestPreferredOSList(t *testing.T) {
 // know the valid OS/Arch combos?
 func TestPreferredArchList(t *testing.T) {
 	arches := make(map[string]bool)
-	for _, p := range DefaultGoPlatforms {
+	for _, p := range knownPlatforms {
 		arches[p.GOARCH] = true
 	}
 	testPreferredList(t, PreferredArchList, arches)
@@ -482,6 +483,112 @@ func TestShortImport_Full(t *testing.T) {
 	}
 }
 
+func TestIncludeSrc(t *testing.T) {
+	const file1 = ShouldBuild_File1
+	const file2 = ShouldBuild_File2
+
+	ctx := &build.Context{GOOS: "linux", GOARCH: "amd64", BuildTags: []string{"tag1"}}
+	ctx.OpenFile = func(path string) (io.ReadCloser, error) {
+		t.Errorf("OpenFile called with: %q", path)
+		return nil, os.ErrNotExist
+	}
+	tests := []struct {
+		path, src string
+		want      bool
+	}{
+		{"file1.go", file1, true},
+		{"file2.go", file2, true},
+		{"file1_windows.go", file1, false},
+		{"file1_linux.go", file1, true},
+	}
+	for _, x := range tests {
+		if got := IncludeSrc(ctx, x.path, x.src); got != x.want {
+			t.Errorf("IncludeSrc(%q) = %t; want: %t", x.path, got, x.want)
+		}
+		tags := make(map[string]bool)
+		got, err := IncludeTagsSrc(ctx, x.path, x.src, tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != x.want {
+			t.Errorf("IncludeTagsSrc(%q) = %t; want: %t", x.path, got, x.want)
+		}
+		name, ok := ShortImportSrc(ctx, x.path, []byte(x.src))
+		if ok != x.want {
+			t.Errorf("ShortImportSrc(%q) = %t; want: %t", x.path, ok, x.want)
+		}
+		if ok && name != "build" {
+			t.Errorf("ShortImportSrc(%q) = %q; want: %q", x.path, name, "build")
+		}
+	}
+
+	// file1 requires tag1
+	ctx.BuildTags = nil
+	if IncludeSrc(ctx, "file1.go", file1) {
+		t.Errorf("IncludeSrc(%q) = %t; want: %t", "file1.go", true, false)
+	}
+}
+
+func TestIncludeTagsMulti(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"file1.go":         ShouldBuild_File1,
+		"file1_windows.go": ShouldBuild_File1,
+		"unix.go":          "//go:build linux || darwin\n\npackage p\n",
+		"plus.go":          "// +build linux darwin\n\npackage p\n",
+		"none.go":          "package p\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctxts := []*build.Context{
+		{GOOS: "linux", GOARCH: "amd64", BuildTags: []string{"tag1"}},
+		{GOOS: "darwin", GOARCH: "arm64"},
+		{GOOS: "windows", GOARCH: "amd64", BuildTags: []string{"tag1"}},
+	}
+	tests := map[string][]bool{
+		"file1.go":         {true, false, true},
+		"file1_windows.go": {false, false, true},
+		"unix.go":          {true, true, false},
+		"plus.go":          {true, true, false},
+		"none.go":          {true, true, true},
+	}
+	for name, want := range tests {
+		path := filepath.Join(dir, name)
+		got, err := IncludeTagsMulti(ctxts, path)
+		if err != nil {
+			t.Errorf("IncludeTagsMulti(%q): %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("IncludeTagsMulti(%q) = %v; want: %v", name, got, want)
+		}
+		// Results must be the same as Include
+		for i, ctxt := range ctxts {
+			if ok := Include(ctxt, path); ok != got[i] {
+				t.Errorf("%s: IncludeTagsMulti()[%d] = %t; Include() = %t", name, i, got[i], ok)
+			}
+		}
+	}
+
+	if _, err := IncludeTagsMulti(ctxts, filepath.Join(dir, "missing.go")); err == nil {
+		t.Error("IncludeTagsMulti: expected error for missing file")
+	}
+	if got, err := IncludeTagsMulti(nil, filepath.Join(dir, "none.go")); got != nil || err != nil {
+		t.Errorf("IncludeTagsMulti(nil) = %v, %v; want: nil, nil", got, err)
+	}
+
+	// A nil Context is the same as build.Default
+	path := filepath.Join(dir, "none.go")
+	want := []bool{Include(&build.Default, path)}
+	if got, err := IncludeTagsMulti([]*build.Context{nil}, path); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("IncludeTagsMulti([nil]) = %v, %v; want: %v, %v", got, err, want, nil)
+	}
+}
+
 // The following tests are buildutil specific.
 
 type goodOSArchFileTest struct {
@@ -644,6 +751,53 @@ func TestImportPath(t *testing.T) {
 	}
 }
 
+func TestImportPathConflict(t *testing.T) {
+	tempdir := t.TempDir()
+	gopath1 := filepath.Join(tempdir, "gopath1")
+	gopath2 := filepath.Join(tempdir, "gopath2")
+	for _, dir := range []string{
+		filepath.Join(gopath1, "src", "a", "pkg"),
+		filepath.Join(gopath2, "src", "a", "pkg"),
+		filepath.Join(gopath2, "src", "a", "other"),
+		filepath.Join(gopath2, "src", "fmt"),
+	} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctxt := build.Default
+	ctxt.GOPATH = gopath1 + string(filepath.ListSeparator) + gopath2
+
+	tests := []struct {
+		dir, importPath, conflictDir string
+	}{
+		{filepath.Join(gopath1, "src", "a", "pkg"), "a/pkg", ""},
+		{filepath.Join(gopath2, "src", "a", "pkg"), ".", filepath.Join(gopath1, "src", "a", "pkg")},
+		{filepath.Join(gopath2, "src", "a", "other"), "a/other", ""},
+		{filepath.Join(gopath2, "src", "fmt"), ".", filepath.Join(ctxt.GOROOT, "src", "fmt")},
+	}
+	for _, x := range tests {
+		importPath, conflictDir, err := ImportPathConflict(&ctxt, x.dir)
+		if err != nil {
+			t.Errorf("ImportPathConflict(%q): %v", x.dir, err)
+			continue
+		}
+		if importPath != x.importPath || conflictDir != x.conflictDir {
+			t.Errorf("ImportPathConflict(%q) = %q, %q; want: %q, %q",
+				x.dir, importPath, conflictDir, x.importPath, x.conflictDir)
+		}
+		pkg, err := ctxt.ImportDir(x.dir, build.FindOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pkg.ImportPath != importPath || pkg.ConflictDir != conflictDir {
+			t.Errorf("ImportPathConflict(%q) = %q, %q; go/build: %q, %q",
+				x.dir, importPath, conflictDir, pkg.ImportPath, pkg.ConflictDir)
+		}
+	}
+}
+
 var parseBuildConstraintTests = []struct {
 	buildComment string
 	plusBuild    string
@@ -692,6 +846,67 @@ func TestParseBuildConstraint(t *testing.T) {
 	}
 }
 
+func TestParseHeaderOffsets(t *testing.T) {
+	// The offsets are given as the text of the range (or "" if missing)
+	tests := []struct {
+		src       string
+		goBuild   string
+		plusBuild string
+		header    string
+	}{
+		{
+			src:       "// Copyright\n\n//go:build linux\n// +build linux\n\npackage p\n",
+			goBuild:   "//go:build linux\n",
+			plusBuild: "// +build linux\n",
+			header:    "// Copyright\n\n//go:build linux\n// +build linux\n\n",
+		},
+		{
+			src:       "// +build a\n// +build b\n\n// Package p\npackage p\n",
+			plusBuild: "// +build a\n// +build b\n",
+			header:    "// +build a\n// +build b\n\n",
+		},
+		{
+			// The +build line must be followed by a blank line
+			src:     "  //go:build a\r\n\r\n// +build a\npackage p\n",
+			goBuild: "  //go:build a\r\n",
+			header:  "  //go:build a\r\n\r\n",
+		},
+		{
+			src: "// +build a\npackage p\n",
+		},
+		{
+			src: "package p\n\n//go:build ignored\n",
+		},
+	}
+	text := func(src string, r HeaderRange) string {
+		if !r.Valid() {
+			return ""
+		}
+		return src[r.Start:r.End]
+	}
+	for _, x := range tests {
+		h, err := ParseHeaderOffsets([]byte(x.src))
+		if err != nil {
+			t.Errorf("ParseHeaderOffsets(%q): %v", x.src, err)
+			continue
+		}
+		if got := text(x.src, h.GoBuild); got != x.goBuild {
+			t.Errorf("ParseHeaderOffsets(%q).GoBuild = %q; want: %q", x.src, got, x.goBuild)
+		}
+		if got := text(x.src, h.PlusBuild); got != x.plusBuild {
+			t.Errorf("ParseHeaderOffsets(%q).PlusBuild = %q; want: %q", x.src, got, x.plusBuild)
+		}
+		if got := x.src[:h.End]; got != x.header {
+			t.Errorf("ParseHeaderOffsets(%q).End = %q; want: %q", x.src, got, x.header)
+		}
+	}
+
+	_, err := ParseHeaderOffsets([]byte("//go:build a\n//go:build b\n\npackage p\n"))
+	if err != errMultipleGoBuild {
+		t.Errorf("ParseHeaderOffsets: error = %v; want: %v", err, errMultipleGoBuild)
+	}
+}
+
 func testMatchFile(t *testing.T, ctxt *build.Context, dir string) {
 	des, err := os.ReadDir(dir)
 	if err != nil {
@@ -757,6 +972,99 @@ func TestMatchFile(t *testing.T) {
 	})
 }
 
+func TestConstraintMatchesContext(t *testing.T) {
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "amd64"
+	ctxt.CgoEnabled = false
+	ctxt.BuildTags = []string{"integration"}
+
+	for _, name := range []string{"x.go", "x_linux.go", "x_windows.go", "x_linux_arm64.go"} {
+		for _, header := range []string{
+			"",
+			"//go:build integration",
+			"//go:build !integration",
+			"//go:build linux && amd64",
+			"//go:build cgo || windows",
+			"// +build linux,!integration",
+		} {
+			src := header + "\n\npackage x\n"
+			_, want, err := MatchFile(&ctxt, "", name, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := ParseConstraint(&ctxt, name, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ConstraintMatchesContext(&ctxt, name, c); got != want {
+				t.Errorf("ConstraintMatchesContext(%q, %q) = %t; want: %t", name, header, got, want)
+			}
+		}
+	}
+
+	// A nil Constraint only checks the file name
+	if !ConstraintMatchesContext(&ctxt, "/a/x_linux.go", nil) {
+		t.Error("ConstraintMatchesContext(nil): want: true")
+	}
+	if ConstraintMatchesContext(&ctxt, "/a/x_windows.go", nil) {
+		t.Error("ConstraintMatchesContext(nil): want: false")
+	}
+}
+
+func TestMatchFileAssumeTags(t *testing.T) {
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "amd64"
+	ctxt.CgoEnabled = false
+	ctxt.BuildTags = nil
+
+	tests := []struct {
+		name, build string
+		want        bool
+	}{
+		{"main.go", "", true},
+		{"main.go", "//go:build integration", true},
+		{"main.go", "//go:build integration && linux", true},
+		{"main.go", "// +build integration,linux", true},
+		{"main.go", "//go:build integration && windows", false},
+		{"main.go", "//go:build cgo && integration", false},
+		{"main.go", "//go:build gccgo", false},
+		{"main.go", "//go:build !" + latestReleaseTag, false},
+		{"main_windows.go", "//go:build integration", false},
+		{"main_linux.go", "//go:build integration", true},
+	}
+	for _, x := range tests {
+		src := "package main\n"
+		if x.build != "" {
+			src = x.build + "\n\n" + src
+		}
+		_, match, err := MatchFileAssumeTags(&ctxt, "", x.name, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match != x.want {
+			t.Errorf("MatchFileAssumeTags(%q, %q) = %t; want: %t", x.name, x.build, match, x.want)
+		}
+		c, err := ParseConstraint(&ctxt, x.name, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ConstraintMatchesContextAssumeTags(&ctxt, x.name, c); got != x.want {
+			t.Errorf("ConstraintMatchesContextAssumeTags(%q, %q) = %t; want: %t",
+				x.name, x.build, got, x.want)
+		}
+		if x.name == "main.go" {
+			if got := ShouldBuildAssumeTags(&ctxt, []byte(src), nil); got != x.want {
+				t.Errorf("ShouldBuildAssumeTags(%q) = %t; want: %t", x.build, got, x.want)
+			}
+			if got := c.EvalAssumeTags(&ctxt); got != x.want {
+				t.Errorf("EvalAssumeTags(%q) = %t; want: %t", x.build, got, x.want)
+			}
+		}
+	}
+}
+
 func BenchmarkImportPath(b *testing.B) {
 	wd, err := os.Getwd()
 	if err != nil {
@@ -871,3 +1179,44 @@ func BenchmarkMatchFile(b *testing.B) {
 		}
 	}
 }
+
+func TestCopyContext(t *testing.T) {
+	orig := build.Default
+	orig.BuildTags = []string{"a", "b"}
+	orig.ToolTags = []string{"t"}
+	ctxt := CopyContext(&orig)
+	ctxt.BuildTags[0] = "x"
+	ctxt.BuildTags = append(ctxt.BuildTags, "c")
+	ctxt.ToolTags[0] = "y"
+	if !reflect.DeepEqual(orig.BuildTags, []string{"a", "b"}) || orig.ToolTags[0] != "t" {
+		t.Errorf("CopyContext: modifying the copy changed the original: %q %q",
+			orig.BuildTags, orig.ToolTags)
+	}
+	if ctxt := CopyContext(nil); ctxt.GOOS != build.Default.GOOS || ctxt == &build.Default {
+		t.Errorf("CopyContext(nil) = %p; want a copy of build.Default", ctxt)
+	}
+}
+
+func TestCopyContextOptions(t *testing.T) {
+	orig := build.Default
+	orig.Dir = "/orig"
+	orig.ReadDir = func(string) ([]fs.FileInfo, error) { return nil, nil }
+	orig.OpenFile = func(string) (io.ReadCloser, error) { return nil, nil }
+	orig.IsDir = func(string) bool { return true }
+
+	ctxt := CopyContextOptions(&orig, CopyOptions{})
+	if ctxt.ReadDir == nil || ctxt.OpenFile == nil || ctxt.IsDir == nil || ctxt.Dir != "/orig" {
+		t.Error("CopyContextOptions: hooks and Dir should be copied by default")
+	}
+
+	ctxt = CopyContextOptions(&orig, CopyOptions{StripHooks: true, Dir: "/new"})
+	if ctxt.ReadDir != nil || ctxt.OpenFile != nil || ctxt.IsDir != nil {
+		t.Error("CopyContextOptions: StripHooks did not remove the hooks")
+	}
+	if ctxt.Dir != "/new" {
+		t.Errorf("CopyContextOptions: Dir = %q; want: %q", ctxt.Dir, "/new")
+	}
+	if orig.ReadDir == nil || orig.Dir != "/orig" {
+		t.Error("CopyContextOptions: modified the original Context")
+	}
+}
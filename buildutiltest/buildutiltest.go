@@ -0,0 +1,199 @@
+// Package buildutiltest provides helpers for testing code that uses the
+// buildutil package: creating fake GOPATHs and modules, and extracting
+// source trees for regression tests and benchmarks.
+//
+// The package only depends on the standard library so that it can be used
+// by the tests of buildutil and its subpackages.
+package buildutiltest
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"go/build"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// WriteFiles writes files, which maps slash-separated paths relative to dir
+// to their content, creating any missing directories.
+func WriteFiles(tb testing.TB, dir string, files map[string]string) {
+	tb.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+// NewGOPATH creates a GOPATH workspace in a temporary directory and returns
+// its path. The keys of files are import paths relative to the "src"
+// directory of the workspace ("example.com/p/p.go"). The temporary
+// directory is removed when the test completes.
+func NewGOPATH(tb testing.TB, files map[string]string) string {
+	tb.Helper()
+	gopath := tb.TempDir()
+	WriteFiles(tb, filepath.Join(gopath, "src"), files)
+	return gopath
+}
+
+// NewModule creates a module with path modulePath in a temporary directory
+// and returns its root directory. The keys of files are relative to the root
+// of the module. A minimal go.mod file is created if files does not have
+// one. The temporary directory is removed when the test completes.
+func NewModule(tb testing.TB, modulePath string, files map[string]string) string {
+	tb.Helper()
+	dir := tb.TempDir()
+	if _, ok := files["go.mod"]; !ok {
+		WriteFiles(tb, dir, map[string]string{"go.mod": "module " + modulePath + "\n"})
+	}
+	WriteFiles(tb, dir, files)
+	return dir
+}
+
+// GOPATHContext returns a copy of orig with GOPATH set to gopath. If orig is
+// nil build.Default is used.
+func GOPATHContext(orig *build.Context, gopath string) *build.Context {
+	if orig == nil {
+		orig = &build.Default
+	}
+	ctxt := *orig
+	ctxt.GOPATH = gopath
+	return &ctxt
+}
+
+// ExtractTarball extracts the gzip compressed tarball to a temporary
+// directory and returns its path. Only regular files and directories are
+// extracted. The test fails if the name of a file is absolute or contains
+// ".." elements, which would extract it outside of the temporary directory.
+// The temporary directory is removed when the test completes.
+func ExtractTarball(tb testing.TB, tarball string) string {
+	tb.Helper()
+	tempdir := tb.TempDir()
+
+	fi, err := os.Open(tarball)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer fi.Close()
+	gr, err := gzip.NewReader(fi)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+
+	seen := make(map[string]bool)
+	mkdir := func(dir string) error {
+		if seen[dir] {
+			return nil
+		}
+		seen[dir] = true
+		return os.MkdirAll(dir, 0755)
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err != io.EOF {
+				tb.Fatal(err)
+			}
+			break
+		}
+		if !validTarName(hdr.Name) {
+			tb.Fatalf("%s: invalid file name: %q", tarball, hdr.Name)
+		}
+		path := filepath.Join(tempdir, hdr.Name)
+		if hdr.Typeflag == tar.TypeDir {
+			if err := mkdir(path); err != nil {
+				tb.Fatal(err)
+			}
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			tb.Logf("%s: unsupported type flag: %d", hdr.Name, hdr.Typeflag)
+			continue
+		}
+		if err := mkdir(filepath.Dir(path)); err != nil {
+			tb.Fatal(err)
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, hdr.FileInfo().Mode())
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if _, err := io.CopyBuffer(f, tr, buf); err != nil {
+			tb.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	if err := gr.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	return tempdir
+}
+
+// validTarName reports if name, the name of a file in a tarball, is a
+// relative path that does not escape the directory it is extracted to.
+func validTarName(name string) bool {
+	if name == "" || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return false
+	}
+	name = filepath.ToSlash(name)
+	if strings.HasPrefix(name, "/") {
+		return false
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteTarball writes files, which maps slash-separated paths to their
+// content, to a gzip compressed tarball named name. It is the inverse of
+// ExtractTarball.
+func WriteTarball(tb testing.TB, name string, files map[string]string) {
+	tb.Helper()
+	f, err := os.Create(name)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data := files[name]
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			tb.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		tb.Fatal(err)
+	}
+}
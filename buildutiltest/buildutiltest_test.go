@@ -0,0 +1,118 @@
+package buildutiltest
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func readFiles(t *testing.T, root string) map[string]string {
+	files := make(map[string]string)
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestNewGOPATH(t *testing.T) {
+	files := map[string]string{
+		"example.com/p/p.go":     "package p\n",
+		"example.com/p/q/q.go":   "package q\n",
+		"example.com/r/README":   "readme\n",
+		"example.com/r/r_test.g": "",
+	}
+	gopath := NewGOPATH(t, files)
+	if got := readFiles(t, filepath.Join(gopath, "src")); !reflect.DeepEqual(got, files) {
+		t.Errorf("NewGOPATH() files = %q; want: %q", got, files)
+	}
+
+	ctxt := GOPATHContext(nil, gopath)
+	if ctxt == &build.Default || ctxt.GOPATH != gopath {
+		t.Errorf("GOPATHContext(nil, %q) = %q", gopath, ctxt.GOPATH)
+	}
+	if build.Default.GOPATH == gopath {
+		t.Error("GOPATHContext modified build.Default")
+	}
+}
+
+func TestNewModule(t *testing.T) {
+	dir := NewModule(t, "example.com/m", map[string]string{"m.go": "package m\n"})
+	want := map[string]string{
+		"go.mod": "module example.com/m\n",
+		"m.go":   "package m\n",
+	}
+	if got := readFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewModule() files = %q; want: %q", got, want)
+	}
+
+	// Existing go.mod files are not replaced
+	want = map[string]string{"go.mod": "module example.com/x\n\ngo 1.17\n"}
+	dir = NewModule(t, "example.com/m", want)
+	if got := readFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewModule() files = %q; want: %q", got, want)
+	}
+}
+
+func TestTarball(t *testing.T) {
+	files := map[string]string{
+		"go1.0/src/fmt/print.go":    "package fmt\n",
+		"go1.0/src/os/file_unix.go": "package os\n",
+		"go1.0/README":              "readme\n",
+	}
+	tarball := filepath.Join(t.TempDir(), "go1.0.tgz")
+	WriteTarball(t, tarball, files)
+	if got := readFiles(t, ExtractTarball(t, tarball)); !reflect.DeepEqual(got, files) {
+		t.Errorf("ExtractTarball() files = %q; want: %q", got, files)
+	}
+}
+
+// fatalTB records calls to Fatal instead of failing the test.
+type fatalTB struct {
+	testing.TB
+	fatal bool
+}
+
+func (t *fatalTB) Fatal(args ...interface{}) {
+	t.fatal = true
+	runtime.Goexit()
+}
+
+func (t *fatalTB) Fatalf(format string, args ...interface{}) { t.Fatal() }
+
+func TestExtractTarballInvalidName(t *testing.T) {
+	for _, name := range []string{"../evil.go", "go1.0/../../evil.go", "/evil.go"} {
+		tarball := filepath.Join(t.TempDir(), "evil.tgz")
+		WriteTarball(t, tarball, map[string]string{name: "package evil\n"})
+		tb := &fatalTB{TB: t}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			ExtractTarball(tb, tarball)
+		}()
+		<-done
+		if !tb.fatal {
+			t.Errorf("ExtractTarball(%q): expected the test to fail", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(os.TempDir(), "evil.go")); err == nil {
+		t.Error("ExtractTarball: extracted a file outside of its directory")
+	}
+}
@@ -0,0 +1,18 @@
+// Command buildconstraint checks the build constraints of Go source files
+// for conflicts with the file name, stale "// +build" lines, misspelled tags
+// and constraints that are never satisfied. It is run by go vet:
+//
+//	go install github.com/charlievieth/buildutil/cmd/buildconstraint
+//	go vet -vettool=$(which buildconstraint) ./...
+//
+// See the buildconstraint package for the Analyzers.
+package main
+
+import (
+	"github.com/charlievieth/buildutil/analysis/buildconstraint"
+	"golang.org/x/tools/go/analysis/unitchecker"
+)
+
+func main() {
+	unitchecker.Main(buildconstraint.Analyzers...)
+}
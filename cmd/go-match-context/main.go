@@ -5,17 +5,13 @@ import (
 	"flag"
 	"fmt"
 	"go/build"
-	"log"
 	"os"
 	"path/filepath"
 
 	"github.com/charlievieth/buildutil"
+	"github.com/charlievieth/buildutil/internal/cmdutil"
 )
 
-func init() {
-	log.SetFlags(log.Lshortfile)
-}
-
 type Context struct {
 	GOARCH        string
 	GOOS          string
@@ -31,27 +27,104 @@ type Context struct {
 	InstallSuffix string
 }
 
+// A Result is the JSON output with -diff: the matched Context and its
+// differences from build.Default.
+type Result struct {
+	Context      Context
+	Diff         *buildutil.ContextDiff
+	TagConflicts []string `json:",omitempty"`
+}
+
 func main() {
 	flag.Usage = func() {
 		const usage = "Usage: %s [OPTION] FILE\n" +
-			"MatchContext for FILE and print the new build.Context\n"
+			"MatchContext for FILE and print the new build.Context and its\n" +
+			"differences from build.Default\n" +
+			"\n" +
+			"With -goversion the release tags and platforms of that Go version\n" +
+			"are used instead of those of the toolchain this command was built\n" +
+			"with, which does not require a Go installation.\n" +
+			"\n" +
+			"With -json only the build.Context is printed, unless -diff is\n" +
+			"also given, in which case it is printed as:\n" +
+			"{\"Context\", \"Diff\", \"TagConflicts\"}\n" +
+			"\n" +
+			"Exit status is 0 if FILE was matched, 1 for any other error,\n" +
+			"2 if no build.Context can build FILE and 3 if the build\n" +
+			"constraints of FILE are invalid. With -json errors are\n" +
+			"printed to stdout as: {\"error\": {\"code\", \"kind\", \"path\", \"message\",\n" +
+			"\"match\"}} where match is the MatchError, if any, as:\n" +
+			"{\"path\", \"permanent\", \"kind\", \"message\"}\n" +
+			"\n"
 		fmt.Fprintf(os.Stdout, usage, filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
 	}
 	printJSON := flag.Bool("json", false, "Print output as JSON")
-	flag.Parse()
+	printDiff := flag.Bool("diff", false,
+		"With -json, also print the differences from build.Default and tag conflicts")
+	noGoroot := flag.Bool("no-goroot", false,
+		"Do not use a GOROOT (for environments that only have source trees)")
+	goVersion := flag.String("goversion", "",
+		"Use the release tags and platforms of Go `VERSION` (e.g. go1.20)")
+	project := flag.Bool("project", false,
+		"Use the "+buildutil.ProjectConfigFile+" of the project containing FILE, if any")
+	cmdutil.ParseFlags()
 	if flag.NArg() != 1 {
-		log.Panicln("error: expect one FILE argument")
-		flag.Usage()
-		os.Exit(1)
+		cmdutil.Usagef(flag.Usage, "expect one FILE argument")
 	}
 	filename := flag.Arg(0)
 
-	ctxt, err := buildutil.MatchContext(&build.Default, filename, nil)
+	base := &build.Default
+	var opts *buildutil.MatchOptions
+	if *noGoroot {
+		opts = &buildutil.MatchOptions{NoGOROOT: true}
+	}
+	if *goVersion != "" {
+		var err error
+		if base, err = buildutil.ContextForGoVersion(&build.Default, *goVersion); err != nil {
+			cmdutil.Usagef(flag.Usage, "-goversion: %v", err)
+		}
+		platforms, err := buildutil.GoVersionPlatforms(*goVersion)
+		if err != nil {
+			cmdutil.Usagef(flag.Usage, "-goversion: %v", err)
+		}
+		if opts == nil {
+			opts = new(buildutil.MatchOptions)
+		}
+		opts.Platforms = platforms
+	}
+	if *project {
+		conf, _, err := buildutil.FindProjectConfig(base, filename)
+		if err != nil {
+			cmdutil.Fatal(err, *printJSON)
+		}
+		if conf != nil {
+			if opts == nil {
+				opts = new(buildutil.MatchOptions)
+			}
+			opts.Project = conf
+		}
+	}
+	ctxt, err := buildutil.MatchContextOptions(base, filename, nil, opts)
 	if err != nil {
-		log.Fatal("error:", err)
+		cmdutil.Fatal(err, *printJSON)
 	}
 
+	diff := buildutil.DiffContexts(base, ctxt)
+
+	// Report the tags that MatchContext changed which conflict with those of
+	// GOFLAGS, build.Default or the project config.
+	tctxt := *base
+	if abs, err := filepath.Abs(filename); err == nil {
+		tctxt.Dir = filepath.Dir(abs)
+	}
+	tags := buildutil.GoCommandTags(&tctxt)
+	tags.AddDiff(buildutil.TagSourceMatch, diff)
+	var conflicts []string
+	for _, c := range tags.Conflicts() {
+		conflicts = append(conflicts, c.String())
+		fmt.Fprintln(os.Stderr, "warning:", c)
+	}
 	if *printJSON {
 		c := Context{
 			GOARCH:        ctxt.GOARCH,
@@ -67,12 +140,16 @@ func main() {
 			ReleaseTags:   ctxt.ReleaseTags,
 			InstallSuffix: ctxt.InstallSuffix,
 		}
-		data, err := json.MarshalIndent(&c, "", "    ")
+		var v interface{} = &c
+		if *printDiff {
+			v = &Result{Context: c, Diff: diff, TagConflicts: conflicts}
+		}
+		data, err := json.MarshalIndent(v, "", "    ")
 		if err != nil {
-			log.Fatal("error:", err)
+			cmdutil.Fatal(err, false)
 		}
 		if _, err := os.Stdout.Write(data); err != nil {
-			log.Fatal("error:", err)
+			cmdutil.Fatal(err, false)
 		}
 	} else {
 		fmt.Printf("GOARCH=%q\n", ctxt.GOARCH)
@@ -87,5 +164,11 @@ func main() {
 		fmt.Printf("ToolTags=%q\n", ctxt.ToolTags)
 		fmt.Printf("ReleaseTags=%q\n", ctxt.ReleaseTags)
 		fmt.Printf("InstallSuffix=%q\n", ctxt.InstallSuffix)
+		if diff.Empty() {
+			fmt.Println("\n# Same as build.Default")
+		} else {
+			fmt.Println("\n# Changes from build.Default:")
+			fmt.Println(diff)
+		}
 	}
 }
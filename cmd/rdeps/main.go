@@ -0,0 +1,175 @@
+// Command rdeps prints the packages within the current module or workspace
+// that directly or transitively import a package.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charlievieth/buildutil"
+	"github.com/charlievieth/buildutil/contextutil"
+	"github.com/charlievieth/buildutil/internal/cmdutil"
+	"github.com/charlievieth/buildutil/internal/modfile"
+)
+
+type Package struct {
+	ImportPath string
+	Dir        string
+}
+
+// readFile reads the file name using the OpenFile function of the Context,
+// if set, so that overlays are respected.
+func readFile(ctxt *build.Context, name string) ([]byte, error) {
+	var rc io.ReadCloser
+	var err error
+	if ctxt.OpenFile != nil {
+		rc, err = ctxt.OpenFile(name)
+	} else {
+		rc, err = os.Open(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// workspaceRoots returns the root directories of the modules of the go.work
+// file containing dir, if any, else the root of the module containing dir.
+// If dir is not in a module dir itself is returned (GOPATH mode).
+func workspaceRoots(ctxt *build.Context, dir string) ([]string, error) {
+	if work, err := contextutil.ContainingDirectory(ctxt, dir, "", "go.work"); err == nil {
+		data, err := readFile(ctxt, filepath.Join(work, "go.work"))
+		if err != nil {
+			return nil, err
+		}
+		f, err := modfile.Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Join(work, "go.work"), err)
+		}
+		roots := make([]string, 0, len(f.Use))
+		for _, use := range f.Use {
+			use = filepath.FromSlash(use)
+			if !filepath.IsAbs(use) {
+				use = filepath.Join(work, use)
+			}
+			roots = append(roots, use)
+		}
+		return roots, nil
+	}
+	if root, err := contextutil.ContainingDirectory(ctxt, dir, "", "go.mod"); err == nil {
+		return []string{root}, nil
+	}
+	return []string{dir}, nil
+}
+
+// resolvePackage returns the import path of pkg, which may be an import path
+// or a relative or absolute directory.
+func resolvePackage(g *buildutil.ImportGraph, pkg string) (string, error) {
+	if !strings.HasPrefix(pkg, ".") && !filepath.IsAbs(pkg) {
+		return pkg, nil
+	}
+	dir, err := filepath.Abs(pkg)
+	if err != nil {
+		return "", err
+	}
+	for _, path := range g.Packages() {
+		if g.Dir(path) == dir {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no package in directory: %s", dir)
+}
+
+func main() {
+	flag.Usage = func() {
+		const usage = "Usage: %s [OPTION] PKG\n" +
+			"Print the packages within the module or workspace that import PKG\n" +
+			"\n" +
+			"Exit status is 0 on success and 1 on error, or, if the -match FILE\n" +
+			"cannot be matched, 2 if no build.Context can build FILE and 3 if\n" +
+			"the build constraints of FILE are invalid\n" +
+			"\n"
+		fmt.Fprintf(os.Stdout, usage, filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	printJSON := flag.Bool("json", false, "Print output as JSON")
+	direct := flag.Bool("direct", false, "Only print packages that directly import PKG")
+	tags := flag.String("tags", "", "Comma-separated list of build tags")
+	goos := flag.String("goos", build.Default.GOOS, "GOOS of the build.Context")
+	goarch := flag.String("goarch", build.Default.GOARCH, "GOARCH of the build.Context")
+	match := flag.String("match", "", "Evaluate the import graph under the build.Context\n"+
+		"matched to `FILE` (see buildutil.MatchContext)")
+	policy := buildutil.DefaultWalkPolicy()
+	policy.AddFlags(flag.CommandLine)
+	cmdutil.ParseFlags()
+	if flag.NArg() != 1 {
+		cmdutil.Usagef(flag.Usage, "expect one PKG argument")
+	}
+
+	ctxt := build.Default
+	ctxt.GOOS = *goos
+	ctxt.GOARCH = *goarch
+	ctxt.BuildTags = buildutil.SplitTagArg(*tags)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		cmdutil.Fatal(err, *printJSON)
+	}
+	roots, err := workspaceRoots(&ctxt, wd)
+	if err != nil {
+		cmdutil.Fatal(err, *printJSON)
+	}
+	gctxt := &ctxt
+	if *match != "" {
+		gctxt, err = buildutil.MatchContext(&ctxt, *match, nil)
+		if err != nil {
+			cmdutil.Fatal(err, *printJSON)
+		}
+		var ts buildutil.TagSet
+		ts.Add(buildutil.TagSourceArgs, ctxt.BuildTags...)
+		ts.AddDiff(buildutil.TagSourceMatch, buildutil.DiffContexts(&ctxt, gctxt))
+		for _, c := range ts.Conflicts() {
+			fmt.Fprintln(os.Stderr, "warning:", c)
+		}
+	}
+	g, err := buildutil.BuildImportGraphPolicy(gctxt, policy, roots...)
+	if err != nil {
+		cmdutil.Fatal(err, *printJSON)
+	}
+	importPath, err := resolvePackage(g, flag.Arg(0))
+	if err != nil {
+		cmdutil.Fatal(err, *printJSON)
+	}
+
+	var deps []string
+	if *direct {
+		deps = g.Importers(importPath)
+	} else {
+		deps = g.ReverseDeps(importPath)
+	}
+	if *printJSON {
+		pkgs := make([]Package, 0, len(deps))
+		for _, path := range deps {
+			pkgs = append(pkgs, Package{ImportPath: path, Dir: g.Dir(path)})
+		}
+		data, err := json.MarshalIndent(pkgs, "", "    ")
+		if err != nil {
+			cmdutil.Fatal(err, *printJSON)
+		}
+		if _, err := os.Stdout.Write(append(data, '\n')); err != nil {
+			cmdutil.Fatal(err, *printJSON)
+		}
+	} else {
+		for _, path := range deps {
+			fmt.Println(path)
+		}
+	}
+}
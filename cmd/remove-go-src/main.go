@@ -6,52 +6,30 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
-	"go/ast"
-	"go/build/constraint"
-	"go/format"
-	"go/parser"
-	"go/token"
-	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
-	"strings"
 
 	"github.com/charlievieth/buildutil"
 )
 
 var goBuildRe = regexp.MustCompile(`(?m)^//(go:build|\s+\+build)\s+[[:print:]]+`)
-var osArchRe *regexp.Regexp
+var osArchRe = regexp.MustCompile(buildutil.FilenameConstraintPattern())
 
 func init() {
 	log.SetFlags(log.Lshortfile)
-
-	join := func(a []string) string {
-		for i := range a {
-			a[i] = regexp.QuoteMeta(a[i])
-		}
-		return strings.Join(a, "|")
-	}
-	pattern := fmt.Sprintf("_(%s)(_(%s))?\\.go$",
-		join(buildutil.KnownOSList()),
-		join(buildutil.KnownArchList()))
-	osArchRe = regexp.MustCompile(pattern)
 }
 
-func hasBuildDirective(g *ast.CommentGroup) bool {
-	if g == nil {
-		return false
+func copyFile(from, to string) error {
+	src, err := os.ReadFile(from)
+	if err != nil {
+		return err
 	}
-	for _, c := range g.List {
-		if constraint.IsGoBuild(c.Text) || constraint.IsPlusBuild(c.Text) {
-			return true
-		}
+	data, err := buildutil.StripToConstraints(src)
+	if err != nil {
+		return fmt.Errorf("%s: %w", from, err)
 	}
-	return false
-}
-
-func copyFile(from, to string) error {
 	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
 		return err
 	}
@@ -59,36 +37,22 @@ func copyFile(from, to string) error {
 	if err != nil {
 		return err
 	}
-	exit := func(err error) error {
+	if _, err := fo.Write(data); err != nil {
 		fo.Close()
 		os.Remove(to)
 		return err
 	}
-
-	fset := token.NewFileSet()
-	af, err := parser.ParseFile(fset, from, nil, parser.PackageClauseOnly|parser.ParseComments)
-	if err != nil {
-		return exit(err)
-	}
-	// Remove non-build directive comments
-	if len(af.Comments) != 0 {
-		a := af.Comments[:0]
-		for _, g := range af.Comments {
-			if hasBuildDirective(g) {
-				a = append(a, g)
-			}
-		}
-		af.Comments = a
-	}
-	if err := format.Node(fo, fset, af); err != nil {
-		return exit(err)
-	}
 	if err := fo.Close(); err != nil {
-		return exit(err)
+		os.Remove(to)
+		return err
 	}
 	return nil
 }
 
+// includeFile reports if the Go file name has build constraints, either a
+// build directive or a $GOOS and/or $GOARCH file name suffix. The suffixes
+// are those of buildutil.FilenameConstraintPattern, so files with only a
+// $GOARCH suffix (e.g. "x_amd64.go") and test files are included.
 func includeFile(name string) bool {
 	if filepath.Ext(name) != ".go" {
 		return false
@@ -108,6 +72,8 @@ func main() {
 	fromFlag := flag.String("from", "", "copy Go files from this directory")
 	toFlag := flag.String("to", "", "copy Go files to this directory")
 	verbose := flag.Bool("v", false, "verbose output")
+	policy := buildutil.DefaultWalkPolicy()
+	policy.AddFlags(flag.CommandLine)
 	flag.Parse()
 
 	if *fromFlag == "" {
@@ -128,25 +94,20 @@ func main() {
 		log.Fatal("refusing to overwrite destination directory: " + to)
 	}
 
-	err = filepath.WalkDir(from, func(path string, d fs.DirEntry, err error) error {
+	err = policy.Walk(from, func(path string) error {
+		rel, err := filepath.Rel(from, path)
 		if err != nil {
 			return err
 		}
-		if d.Type().IsRegular() && filepath.Ext(path) == ".go" {
-			rel, err := filepath.Rel(from, path)
-			if err != nil {
-				return err
+		if includeFile(path) {
+			if *verbose {
+				fmt.Fprintf(os.Stderr, "copying:  %s\n", rel)
 			}
-			if includeFile(path) {
-				if *verbose {
-					fmt.Fprintf(os.Stderr, "copying:  %s\n", rel)
-				}
-				if err := copyFile(path, filepath.Join(to, rel)); err != nil {
-					return err
-				}
-			} else if *verbose {
-				fmt.Fprintf(os.Stderr, "ignoring: %s\n", rel)
+			if err := copyFile(path, filepath.Join(to, rel)); err != nil {
+				return err
 			}
+		} else if *verbose {
+			fmt.Fprintf(os.Stderr, "ignoring: %s\n", rel)
 		}
 		return nil
 	})
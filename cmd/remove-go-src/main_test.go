@@ -0,0 +1,35 @@
+//go:build never
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIncludeFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, data string
+		want       bool
+	}{
+		{"file.go", "package p\n", false},
+		{"file_linux.go", "package p\n", true},
+		{"file_linux_amd64.go", "package p\n", true},
+		{"file_amd64.go", "package p\n", true},      // GOARCH only
+		{"file_linux_test.go", "package p\n", true}, // test file
+		{"file_foo.go", "package p\n", false},
+		{"tags.go", "//go:build tag\n\npackage p\n", true},
+		{"file_linux.c", "int x;\n", false},
+	}
+	for _, x := range tests {
+		path := filepath.Join(dir, x.name)
+		if err := os.WriteFile(path, []byte(x.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := includeFile(path); got != x.want {
+			t.Errorf("includeFile(%q) = %t; want: %t", x.name, got, x.want)
+		}
+	}
+}
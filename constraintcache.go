@@ -0,0 +1,185 @@
+package buildutil
+
+import (
+	"crypto/sha256"
+	"go/build"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultConstraintCacheSize is the maximum number of files cached by a
+// ConstraintCache created with a size less than or equal to zero.
+const DefaultConstraintCacheSize = 4096
+
+// modTimeCutoff is how old a file's modification time must be before it is
+// trusted to detect changes. A file modified more recently than this may be
+// modified again without its modification time changing (the resolution of
+// some file systems is as coarse as 1-2 seconds), so its header is hashed.
+const modTimeCutoff = 2 * time.Second
+
+// A ConstraintCache caches the build constraints of Go source files by file
+// name, which avoids re-reading and re-parsing the headers of unchanged
+// files. It is intended for long running processes, such as editors and
+// language servers, that match the same files to many Contexts.
+//
+// If the Context's OpenFile function is not set, a file is considered
+// unchanged if its size and modification time are the same as when it was
+// cached; otherwise its header is read and is unchanged if the hash of the
+// header is the same, which still saves parsing it. Changed files are
+// re-parsed. Callers that learn of changes, such as an editor saving a file,
+// should call Invalidate.
+//
+// The cache holds at most the number of files it was created with, once
+// full an arbitrary file is evicted to make room for a new one. The zero
+// value is an empty cache that holds at most DefaultConstraintCacheSize
+// files. A ConstraintCache is safe for concurrent use.
+type ConstraintCache struct {
+	max     int
+	mu      sync.Mutex
+	entries map[string]*constraintCacheEntry
+	now     func() time.Time // for testing
+}
+
+type constraintCacheEntry struct {
+	size    int64
+	modTime time.Time // zero if the file was not stat'd
+	hash    FileHash  // hash of the header
+	c       *Constraint
+	err     error // error parsing the header
+}
+
+// NewConstraintCache returns a new ConstraintCache that holds at most max
+// files. If max is less than or equal to zero DefaultConstraintCacheSize is
+// used.
+func NewConstraintCache(max int) *ConstraintCache {
+	if max <= 0 {
+		max = DefaultConstraintCacheSize
+	}
+	return &ConstraintCache{
+		max:     max,
+		entries: make(map[string]*constraintCacheEntry),
+		now:     time.Now,
+	}
+}
+
+// Get is like ParseConstraint, but returns the cached Constraint of filename
+// if the file is unchanged. Errors parsing the build constraints are cached
+// as well, but errors reading the file are not. If the cache is full, adding
+// filename evicts an arbitrary file, not the least recently used one.
+func (c *ConstraintCache) Get(ctxt *build.Context, filename string) (*Constraint, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	key := filepath.Clean(filename)
+	c.mu.Lock()
+	e := c.entries[key]
+	c.mu.Unlock()
+
+	var fi os.FileInfo
+	if ctxt.OpenFile == nil {
+		var err error
+		fi, err = os.Stat(filename)
+		if err != nil {
+			c.Invalidate(filename)
+			return nil, err
+		}
+		if e != nil && !e.modTime.IsZero() && e.size == fi.Size() && e.modTime.Equal(fi.ModTime()) {
+			return e.c, e.err
+		}
+	}
+
+	rc, err := openReader(ctxt, filename, nil)
+	if err != nil {
+		c.Invalidate(filename)
+		return nil, err
+	}
+	data, err := readImportsFast(rc)
+	rc.Close()
+	if err != nil && err != errSyntax {
+		c.Invalidate(filename)
+		return nil, err
+	}
+	hash := FileHash(sha256.Sum256(data))
+
+	ne := &constraintCacheEntry{hash: hash}
+	if e != nil && e.hash == hash {
+		ne.c, ne.err = e.c, e.err
+	} else if err != nil {
+		ne.err = err // syntax error (same as ParseConstraint)
+	} else if expr, err := parseBuildConstraint(data); err != nil {
+		ne.err = err
+	} else {
+		ne.c = &Constraint{expr: expr}
+	}
+	// Only trust the modification time once the file is old enough that
+	// another change would update it.
+	now := c.now
+	if now == nil {
+		now = time.Now
+	}
+	if fi != nil && now().Sub(fi.ModTime()) > modTimeCutoff {
+		ne.size = fi.Size()
+		ne.modTime = fi.ModTime()
+	}
+	c.store(key, ne)
+	return ne.c, ne.err
+}
+
+// MatchFile reports whether the Go file filename matches ctxt, using the
+// cached Constraint of the file (see Get and ConstraintMatchesContext).
+func (c *ConstraintCache) MatchFile(ctxt *build.Context, filename string) (bool, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	if !goodOSArchFile(ctxt, filepath.Base(filename), nil) {
+		return false, nil
+	}
+	con, err := c.Get(ctxt, filename)
+	if err != nil {
+		return false, err
+	}
+	return con.Eval(ctxt), nil
+}
+
+func (c *ConstraintCache) store(key string, e *constraintCacheEntry) {
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]*constraintCacheEntry)
+	}
+	max := c.max
+	if max <= 0 {
+		max = DefaultConstraintCacheSize
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= max {
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = e
+	c.mu.Unlock()
+}
+
+// Invalidate removes the cached Constraint of filename, if any.
+func (c *ConstraintCache) Invalidate(filename string) {
+	c.mu.Lock()
+	delete(c.entries, filepath.Clean(filename))
+	c.mu.Unlock()
+}
+
+// Len returns the number of files in the cache.
+func (c *ConstraintCache) Len() int {
+	c.mu.Lock()
+	n := len(c.entries)
+	c.mu.Unlock()
+	return n
+}
+
+// Reset removes all files from the cache.
+func (c *ConstraintCache) Reset() {
+	c.mu.Lock()
+	c.entries = make(map[string]*constraintCacheEntry)
+	c.mu.Unlock()
+}
@@ -0,0 +1,146 @@
+package buildutil
+
+import (
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConstraintCache(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.go")
+	write := func(src string) {
+		t.Helper()
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("//go:build linux\n\npackage p\n")
+
+	c := NewConstraintCache(0)
+	c.now = func() time.Time { return time.Now().Add(time.Hour) } // trust mtimes
+
+	linux := build.Default
+	linux.GOOS = "linux"
+	windows := build.Default
+	windows.GOOS = "windows"
+
+	c1, err := c.Get(&linux, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := c.Get(&windows, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 != c2 {
+		t.Error("Get: expected the cached Constraint to be returned")
+	}
+	if ok, err := c.MatchFile(&linux, name); err != nil || !ok {
+		t.Errorf("MatchFile(linux) = %t, %v; want: %t, %v", ok, err, true, nil)
+	}
+	if ok, err := c.MatchFile(&windows, name); err != nil || ok {
+		t.Errorf("MatchFile(windows) = %t, %v; want: %t, %v", ok, err, false, nil)
+	}
+
+	// Changing the size of the file invalidates the entry
+	write("//go:build windows\n\npackage p\n")
+	if ok, err := c.MatchFile(&windows, name); err != nil || !ok {
+		t.Errorf("MatchFile(windows) = %t, %v; want: %t, %v", ok, err, true, nil)
+	}
+
+	// Parse errors are cached
+	write("//go:build (\n\npackage p\n")
+	if _, err := c.Get(&linux, name); err == nil {
+		t.Error("Get: expected an error for an invalid constraint")
+	}
+	if _, err := c.Get(&linux, name); err == nil {
+		t.Error("Get: expected the cached error")
+	}
+
+	// Read errors are not
+	if err := os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(&linux, name); !os.IsNotExist(err) {
+		t.Errorf("Get: error = %v; want: IsNotExist", err)
+	}
+	if n := c.Len(); n != 0 {
+		t.Errorf("Len() = %d; want: %d", n, 0)
+	}
+}
+
+func TestConstraintCache_OpenFile(t *testing.T) {
+	files := map[string]string{
+		"/p/a.go": "//go:build linux\n\npackage p\n",
+		"/p/b.go": "package p\n",
+		"/p/c.go": "package p\n",
+	}
+	opens := 0
+	ctxt := build.Default
+	ctxt.OpenFile = func(path string) (io.ReadCloser, error) {
+		opens++
+		if src, ok := files[path]; ok {
+			return ioutil.NopCloser(strings.NewReader(src)), nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	c := NewConstraintCache(2)
+	c1, err := c.Get(&ctxt, "/p/a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header must be re-read, but is not re-parsed if unchanged
+	c2, err := c.Get(&ctxt, "/p/a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 != c2 || opens != 2 {
+		t.Errorf("Get: same Constraint = %t opens = %d; want: %t %d", c1 == c2, opens, true, 2)
+	}
+	files["/p/a.go"] = "//go:build windows\n\npackage p\n"
+	c3, err := c.Get(&ctxt, "/p/a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c3 == c1 || c3.Expr().String() != "windows" {
+		t.Errorf("Get: Constraint = %v; want: %s", c3.Expr(), "windows")
+	}
+
+	for _, name := range []string{"/p/b.go", "/p/c.go"} {
+		if _, err := c.Get(&ctxt, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := c.Len(); n != 2 {
+		t.Errorf("Len() = %d; want: %d", n, 2)
+	}
+	c.Invalidate("/p/c.go")
+	if n := c.Len(); n != 1 {
+		t.Errorf("Len() = %d; want: %d", n, 1)
+	}
+	c.Reset()
+	if n := c.Len(); n != 0 {
+		t.Errorf("Len() = %d; want: %d", n, 0)
+	}
+}
+
+func TestConstraintCache_ZeroValue(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "a.go")
+	if err := ioutil.WriteFile(name, []byte("//go:build linux\n\npackage p\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var c ConstraintCache
+	if ok, err := c.MatchFile(nil, name); err != nil || ok != (build.Default.GOOS == "linux") {
+		t.Errorf("MatchFile() = %t, %v; want: %t, %v", ok, err, build.Default.GOOS == "linux", nil)
+	}
+	if n := c.Len(); n != 1 {
+		t.Errorf("Len() = %d; want: %d", n, 1)
+	}
+}
@@ -0,0 +1,275 @@
+package buildutil
+
+import (
+	"bytes"
+	"go/build"
+	"go/build/constraint"
+	"strconv"
+	"strings"
+)
+
+// A ConstraintCheck identifies a check performed by CheckConstraints.
+type ConstraintCheck int
+
+const (
+	// CheckFilenameConflict reports files whose build constraint excludes
+	// every platform allowed by the GOOS/GOARCH suffix of their name
+	// (e.g. "//go:build windows" in "x_linux.go").
+	CheckFilenameConflict ConstraintCheck = iota
+
+	// CheckStalePlusBuild reports "// +build" lines that do not match the
+	// "//go:build" line or that have no "//go:build" line.
+	CheckStalePlusBuild
+
+	// CheckUnknownTag reports build tags that are probably misspellings of
+	// a known tag (e.g. "linx") and malformed Go release tags.
+	CheckUnknownTag
+
+	// CheckDeadFile reports files whose build constraint cannot be satisfied
+	// by any GOOS and GOARCH (e.g. "//go:build linux && windows").
+	CheckDeadFile
+)
+
+var constraintCheckNames = [...]string{
+	CheckFilenameConflict: "filename",
+	CheckStalePlusBuild:   "plusbuild",
+	CheckUnknownTag:       "unknowntag",
+	CheckDeadFile:         "deadfile",
+}
+
+func (c ConstraintCheck) String() string {
+	if 0 <= c && int(c) < len(constraintCheckNames) {
+		return constraintCheckNames[c]
+	}
+	return "ConstraintCheck(" + strconv.Itoa(int(c)) + ")"
+}
+
+// A ConstraintIssue is a problem with the build constraints of a file found
+// by CheckConstraints.
+type ConstraintIssue struct {
+	Check   ConstraintCheck
+	Offset  int // byte offset of the problem in the file
+	Message string
+}
+
+func (i ConstraintIssue) String() string { return i.Check.String() + ": " + i.Message }
+
+// maxFreeTags is the maximum number of tags, other than GOOS and GOARCH
+// tags, that are enumerated to check if a build constraint can be
+// satisfied. Constraints with more tags are assumed to be satisfiable.
+const maxFreeTags = 10
+
+// CheckConstraints checks the build constraints of the Go source file with
+// base name name and content content, which only needs to include the
+// header of the file (see ReadImportsFast). The checks do not depend on a
+// Context: every combination of the known GOOS and GOARCH values and of the
+// other tags is considered. An error is returned if the build constraints
+// cannot be parsed.
+func CheckConstraints(name string, content []byte) ([]ConstraintIssue, error) {
+	h, err := ParseHeader(content)
+	if err != nil {
+		return nil, err
+	}
+	var issues []ConstraintIssue
+	add := func(check ConstraintCheck, offset int, msg string) {
+		issues = append(issues, ConstraintIssue{Check: check, Offset: offset, Message: msg})
+	}
+
+	offset := 0 // of the build constraint
+	switch {
+	case h.Offsets.GoBuild.Valid():
+		offset = h.Offsets.GoBuild.Start
+	case h.Offsets.PlusBuild.Valid():
+		offset = h.Offsets.PlusBuild.Start
+	}
+
+	if r := h.Offsets.PlusBuild; r.Valid() {
+		if !h.Offsets.GoBuild.Valid() {
+			add(CheckStalePlusBuild, r.Start, "+build lines without //go:build line")
+		} else {
+			plus, err := plusBuildExpr(content[r.Start:r.End])
+			if err != nil {
+				return nil, err
+			}
+			if !equivalentExprs(h.Constraint, plus) {
+				add(CheckStalePlusBuild, r.Start, "+build lines do not match //go:build condition")
+			}
+		}
+	}
+
+	x := h.Constraint
+	if x == nil {
+		return issues, nil
+	}
+	seen := make(map[string]bool)
+	walkTags(x, func(tag string) {
+		if seen[tag] {
+			return
+		}
+		seen[tag] = true
+		if msg := checkTag(tag); msg != "" {
+			add(CheckUnknownTag, offset, msg)
+		}
+	})
+
+	switch {
+	case !satisfiable(x, nil):
+		add(CheckDeadFile, offset, "build constraint "+strconv.Quote(x.String())+
+			" is not satisfied by any GOOS/GOARCH")
+	case !satisfiable(x, func(ctxt *build.Context) bool { return goodOSArchFile(ctxt, name, nil) }):
+		add(CheckFilenameConflict, offset, "build constraint "+strconv.Quote(x.String())+
+			" excludes all platforms allowed by file name "+strconv.Quote(name))
+	}
+	return issues, nil
+}
+
+// plusBuildExpr returns the AND of the "// +build" lines in content.
+func plusBuildExpr(content []byte) (constraint.Expr, error) {
+	var x constraint.Expr
+	for _, line := range bytes.Split(content, []byte{'\n'}) {
+		text := string(bytes.TrimSpace(line))
+		if !constraint.IsPlusBuild(text) {
+			continue
+		}
+		y, err := parseConstraint(text)
+		if err != nil {
+			return nil, err
+		}
+		if x == nil {
+			x = y
+		} else {
+			x = andExpr(x, y)
+		}
+	}
+	return x, nil
+}
+
+// exprTags returns the unique tags of x in the order they first appear.
+func exprTags(x constraint.Expr) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	walkTags(x, func(tag string) {
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	})
+	return tags
+}
+
+// equivalentExprs reports if x and y have the same truth table. If they
+// have too many tags to compare, they are compared by their "// +build"
+// lines.
+func equivalentExprs(x, y constraint.Expr) bool {
+	if x == nil || y == nil {
+		return x == nil && y == nil
+	}
+	tags := exprTags(andExpr(x, y))
+	if len(tags) > maxFreeTags {
+		lx, err1 := constraint.PlusBuildLines(x)
+		ly, err2 := constraint.PlusBuildLines(y)
+		return err1 == nil && err2 == nil && strings.Join(lx, "\n") == strings.Join(ly, "\n")
+	}
+	index := make(map[string]uint, len(tags))
+	for i, tag := range tags {
+		index[tag] = uint(i)
+	}
+	for mask := 0; mask < 1<<len(tags); mask++ {
+		ok := func(tag string) bool { return mask&(1<<index[tag]) != 0 }
+		if x.Eval(ok) != y.Eval(ok) {
+			return false
+		}
+	}
+	return true
+}
+
+// satisfiable reports if x is satisfied by any combination of known GOOS
+// and GOARCH values, for which platform returns true, and the tags of x that
+// are not GOOS or GOARCH tags. If platform is nil all combinations are
+// considered. Combinations that are not supported platforms are included
+// since files are often written for platforms that are newer than this
+// package or unsupported (e.g. "zos").
+func satisfiable(x constraint.Expr, platform func(ctxt *build.Context) bool) bool {
+	var free []string
+	index := make(map[string]uint)
+	for _, tag := range exprTags(x) {
+		switch ClassifyTag(nil, tag) {
+		case TagOS:
+			continue
+		case TagArch:
+			if !isArchFeatureTag(tag) {
+				continue
+			}
+		}
+		index[tag] = uint(len(free))
+		free = append(free, tag)
+	}
+	if len(free) > maxFreeTags {
+		return true
+	}
+	for goos := range knownOS {
+		for goarch := range knownArch {
+			ctxt := &build.Context{GOOS: goos, GOARCH: goarch}
+			if platform != nil && !platform(ctxt) {
+				continue
+			}
+			for mask := 0; mask < 1<<len(free); mask++ {
+				ok := x.Eval(func(tag string) bool {
+					if i, ok := index[tag]; ok {
+						return mask&(1<<i) != 0
+					}
+					return matchTag(ctxt, tag, nil)
+				})
+				if ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// checkTag returns a message describing why tag is probably a mistake or
+// an empty string if it is not.
+func checkTag(tag string) string {
+	if ClassifyTag(nil, tag) != TagUserDefined {
+		return ""
+	}
+	if strings.HasPrefix(tag, "go1") {
+		return "invalid Go release tag " + strconv.Quote(tag) +
+			": release tags have the form \"go1.N\""
+	}
+	for _, list := range [][]string{KnownOSList(), KnownArchList(), {"unix", "cgo", "gccgo"}} {
+		for _, known := range list {
+			if strings.EqualFold(tag, known) || len(tag) >= 4 && len(known) >= 4 &&
+				editDistanceOne(tag, known) {
+				return "unknown build tag " + strconv.Quote(tag) +
+					": did you mean " + strconv.Quote(known) + "?"
+			}
+		}
+	}
+	return ""
+}
+
+// editDistanceOne reports if a and b differ by exactly one inserted,
+// deleted or substituted byte or by two adjacent transposed bytes.
+func editDistanceOne(a, b string) bool {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	if len(a)-len(b) > 1 || a == b {
+		return false
+	}
+	i := 0
+	for i < len(b) && a[i] == b[i] {
+		i++
+	}
+	if len(a) != len(b) {
+		return a[i+1:] == b[i:] // deletion
+	}
+	if a[i+1:] == b[i+1:] {
+		return true // substitution
+	}
+	// transposition
+	return i+1 < len(a) && a[i] == b[i+1] && a[i+1] == b[i] && a[i+2:] == b[i+2:]
+}
@@ -0,0 +1,90 @@
+package buildutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckConstraints(t *testing.T) {
+	tests := []struct {
+		name, src string
+		want      []ConstraintCheck
+	}{
+		{"x.go", "package x\n", nil},
+		{"x.go", "//go:build linux && amd64\n\npackage x\n", nil},
+		{"x.go", "//go:build linux\n// +build linux\n\npackage x\n", nil},
+		{"x.go", "//go:build linux || darwin\n// +build darwin linux\n\npackage x\n", nil},
+		{"x.go", "//go:build ignore\n\npackage x\n", nil},
+		{"x.go", "//go:build integration && !go1.18\n\npackage x\n", nil},
+		{"x_linux.go", "//go:build amd64 || arm64\n\npackage x\n", nil},
+		{"x_linux.go", "//go:build unix\n\npackage x\n", nil},
+		{"x_android.go", "//go:build linux\n\npackage x\n", nil},
+		{"x.go", "//go:build amd64.v3\n\npackage x\n", nil},
+
+		{"x.go", "// +build linux\n\npackage x\n", []ConstraintCheck{CheckStalePlusBuild}},
+		{"x.go", "//go:build linux\n// +build darwin\n\npackage x\n", []ConstraintCheck{CheckStalePlusBuild}},
+		{"x.go", "//go:build linx\n\npackage x\n", []ConstraintCheck{CheckUnknownTag}},
+		{"x.go", "//go:build Windows\n\npackage x\n", []ConstraintCheck{CheckUnknownTag}},
+		{"x.go", "//go:build go1.21.0\n\npackage x\n", []ConstraintCheck{CheckUnknownTag}},
+		{"x.go", "//go:build linux && windows\n\npackage x\n", []ConstraintCheck{CheckDeadFile}},
+		{"x.go", "//go:build cgo && !cgo\n\npackage x\n", []ConstraintCheck{CheckDeadFile}},
+		{"x_linux.go", "//go:build windows\n\npackage x\n", []ConstraintCheck{CheckFilenameConflict}},
+		{"x_arm64.go", "//go:build amd64 && !ignore\n\npackage x\n", []ConstraintCheck{CheckFilenameConflict}},
+		{"x_linux_test.go", "//go:build !unix\n\npackage x\n", []ConstraintCheck{CheckFilenameConflict}},
+		{
+			"x_linux.go",
+			"//go:build windows && darwn\n// +build windows\n\npackage x\n",
+			[]ConstraintCheck{CheckStalePlusBuild, CheckUnknownTag, CheckFilenameConflict},
+		},
+	}
+	for _, test := range tests {
+		issues, err := CheckConstraints(test.name, []byte(test.src))
+		if err != nil {
+			t.Errorf("CheckConstraints(%q, %q): %v", test.name, test.src, err)
+			continue
+		}
+		var got []ConstraintCheck
+		for _, x := range issues {
+			got = append(got, x.Check)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("CheckConstraints(%q, %q) = %v; want: %v", test.name, test.src, issues, test.want)
+		}
+	}
+
+	if _, err := CheckConstraints("x.go", []byte("//go:build (\n\npackage x\n")); err == nil {
+		t.Error("CheckConstraints: expected error for invalid build constraint")
+	}
+}
+
+func TestCheckConstraintsOffset(t *testing.T) {
+	src := "// Copyright\n\n//go:build linx\n\npackage x\n"
+	issues, err := CheckConstraints("x.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || issues[0].Offset != len("// Copyright\n\n") {
+		t.Errorf("CheckConstraints(%q) = %+v; want offset: %d", src, issues, len("// Copyright\n\n"))
+	}
+}
+
+func TestEditDistanceOne(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"linux", "linux", false},
+		{"linx", "linux", true},
+		{"linuxx", "linux", true},
+		{"lniux", "linux", true},
+		{"linus", "linux", true},
+		{"lnx", "linux", false},
+		{"darwin", "linux", false},
+		{"", "a", true},
+	}
+	for _, x := range tests {
+		if got := editDistanceOne(x.a, x.b); got != x.want {
+			t.Errorf("editDistanceOne(%q, %q) = %t; want: %t", x.a, x.b, got, x.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package buildutil
+
+import (
+	"go/build"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charlievieth/buildutil/internal/util"
+)
+
+// A FieldDiff is a build.Context field with different values in two Contexts.
+// List fields, such as ToolTags, are formatted as comma separated lists.
+type FieldDiff struct {
+	Field string // name of the build.Context field (e.g. "GOOS")
+	Old   string // value in the old Context
+	New   string // value in the new Context
+}
+
+func (f FieldDiff) String() string {
+	return f.Field + ": " + strconv.Quote(f.Old) + " => " + strconv.Quote(f.New)
+}
+
+// A ContextDiff describes the differences between two build.Contexts, such
+// as a Context returned by MatchContext and the Context it was matched from.
+type ContextDiff struct {
+	Fields      []FieldDiff // changed fields, other than BuildTags
+	TagsAdded   []string    // sorted BuildTags only in the new Context
+	TagsRemoved []string    // sorted BuildTags only in the old Context
+}
+
+// Empty reports if the Contexts are the same.
+func (d *ContextDiff) Empty() bool {
+	return d == nil || len(d.Fields) == 0 && len(d.TagsAdded) == 0 && len(d.TagsRemoved) == 0
+}
+
+// String returns the diff with one change per line, such as:
+//
+//	GOOS: "linux" => "windows"
+//	+tag: integration
+//	-tag: foo
+func (d *ContextDiff) String() string {
+	if d.Empty() {
+		return ""
+	}
+	var b strings.Builder
+	for _, f := range d.Fields {
+		b.WriteString(f.String())
+		b.WriteByte('\n')
+	}
+	for _, tag := range d.TagsAdded {
+		b.WriteString("+tag: " + tag + "\n")
+	}
+	for _, tag := range d.TagsRemoved {
+		b.WriteString("-tag: " + tag + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// DiffContexts returns the differences between Contexts oldCtxt and newCtxt.
+// Only the fields that affect which files are matched, and where packages
+// are found, are compared. The function fields (e.g. ReadDir) are ignored.
+// A nil Context is treated as build.Default.
+func DiffContexts(oldCtxt, newCtxt *build.Context) *ContextDiff {
+	if oldCtxt == nil {
+		oldCtxt = &build.Default
+	}
+	if newCtxt == nil {
+		newCtxt = &build.Default
+	}
+	d := &ContextDiff{}
+	add := func(field, o, n string) {
+		if o != n {
+			d.Fields = append(d.Fields, FieldDiff{Field: field, Old: o, New: n})
+		}
+	}
+	add("GOARCH", oldCtxt.GOARCH, newCtxt.GOARCH)
+	add("GOOS", oldCtxt.GOOS, newCtxt.GOOS)
+	add("GOROOT", oldCtxt.GOROOT, newCtxt.GOROOT)
+	add("GOPATH", oldCtxt.GOPATH, newCtxt.GOPATH)
+	add("Dir", oldCtxt.Dir, newCtxt.Dir)
+	add("CgoEnabled", strconv.FormatBool(oldCtxt.CgoEnabled), strconv.FormatBool(newCtxt.CgoEnabled))
+	add("UseAllFiles", strconv.FormatBool(oldCtxt.UseAllFiles), strconv.FormatBool(newCtxt.UseAllFiles))
+	add("Compiler", oldCtxt.Compiler, newCtxt.Compiler)
+	add("ToolTags", strings.Join(oldCtxt.ToolTags, ","), strings.Join(newCtxt.ToolTags, ","))
+	add("ReleaseTags", strings.Join(oldCtxt.ReleaseTags, ","), strings.Join(newCtxt.ReleaseTags, ","))
+	add("InstallSuffix", oldCtxt.InstallSuffix, newCtxt.InstallSuffix)
+
+	d.TagsAdded = stringsDifference(newCtxt.BuildTags, oldCtxt.BuildTags)
+	d.TagsRemoved = stringsDifference(oldCtxt.BuildTags, newCtxt.BuildTags)
+	return d
+}
+
+// A FileSetDiff describes the differences between the included files of two
+// BuildableFileSets, such as the files of a package for two platforms.
+type FileSetDiff struct {
+	Added   []string // sorted files only included in the new set
+	Removed []string // sorted files only included in the old set
+}
+
+// Empty reports if the sets include the same files.
+func (d *FileSetDiff) Empty() bool {
+	return d == nil || len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+// String returns the diff with one file per line, such as:
+//
+//	+file_windows.go
+//	-file_linux.go
+func (d *FileSetDiff) String() string {
+	if d.Empty() {
+		return ""
+	}
+	var b strings.Builder
+	for _, name := range d.Added {
+		b.WriteString("+" + name + "\n")
+	}
+	for _, name := range d.Removed {
+		b.WriteString("-" + name + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// DiffFileSets returns the differences between the included Go and other
+// source files of oldSet and newSet. A nil set is treated as an empty set.
+func DiffFileSets(oldSet, newSet *BuildableFileSet) *FileSetDiff {
+	files := func(set *BuildableFileSet) []string {
+		if set == nil {
+			return nil
+		}
+		a := make([]string, 0, len(set.GoFiles)+len(set.OtherFiles))
+		a = append(a, set.GoFiles...)
+		return append(a, set.OtherFiles...)
+	}
+	oldFiles := files(oldSet)
+	newFiles := files(newSet)
+	return &FileSetDiff{
+		Added:   stringsDifference(newFiles, oldFiles),
+		Removed: stringsDifference(oldFiles, newFiles),
+	}
+}
+
+// stringsDifference returns the sorted elements of a that are not in b.
+func stringsDifference(a, b []string) []string {
+	var diff []string
+	for _, s := range a {
+		if !util.StringsContains(b, s) && !util.StringsContains(diff, s) {
+			diff = append(diff, s)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
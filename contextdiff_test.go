@@ -0,0 +1,89 @@
+package buildutil
+
+import (
+	"go/build"
+	"reflect"
+	"testing"
+)
+
+func TestDiffContexts(t *testing.T) {
+	old := &build.Context{
+		GOOS:        "linux",
+		GOARCH:      "amd64",
+		CgoEnabled:  true,
+		Compiler:    "gc",
+		BuildTags:   []string{"foo", "bar"},
+		ReleaseTags: []string{"go1.1", "go1.2"},
+	}
+	new := &build.Context{
+		GOOS:        "windows",
+		GOARCH:      "amd64",
+		Compiler:    "gc",
+		BuildTags:   []string{"bar", "integration", "baz"},
+		ReleaseTags: []string{"go1.1", "go1.2"},
+		ToolTags:    []string{"goexperiment.foo"},
+	}
+	d := DiffContexts(old, new)
+	want := &ContextDiff{
+		Fields: []FieldDiff{
+			{Field: "GOOS", Old: "linux", New: "windows"},
+			{Field: "CgoEnabled", Old: "true", New: "false"},
+			{Field: "ToolTags", Old: "", New: "goexperiment.foo"},
+		},
+		TagsAdded:   []string{"baz", "integration"},
+		TagsRemoved: []string{"foo"},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("DiffContexts() = %+v; want: %+v", d, want)
+	}
+	const wantStr = `GOOS: "linux" => "windows"
+CgoEnabled: "true" => "false"
+ToolTags: "" => "goexperiment.foo"
++tag: baz
++tag: integration
+-tag: foo`
+	if s := d.String(); s != wantStr {
+		t.Errorf("String() = %q; want: %q", s, wantStr)
+	}
+	if d.Empty() {
+		t.Error("Empty() = true; want: false")
+	}
+
+	d = DiffContexts(old, old)
+	if !d.Empty() || d.String() != "" {
+		t.Errorf("DiffContexts(old, old) = %+v; want empty diff", d)
+	}
+	if d := DiffContexts(nil, nil); !d.Empty() {
+		t.Errorf("DiffContexts(nil, nil) = %+v; want empty diff", d)
+	}
+}
+
+func TestDiffFileSets(t *testing.T) {
+	old := &BuildableFileSet{
+		GoFiles:    []string{"main.go", "sys_linux.go"},
+		OtherFiles: []string{"asm_amd64.s"},
+	}
+	new := &BuildableFileSet{
+		GoFiles:    []string{"main.go", "sys_windows.go", "zsys_windows.go"},
+		OtherFiles: []string{"asm_amd64.s"},
+	}
+	d := DiffFileSets(old, new)
+	want := &FileSetDiff{
+		Added:   []string{"sys_windows.go", "zsys_windows.go"},
+		Removed: []string{"sys_linux.go"},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("DiffFileSets() = %+v; want: %+v", d, want)
+	}
+	const wantStr = "+sys_windows.go\n+zsys_windows.go\n-sys_linux.go"
+	if s := d.String(); s != wantStr {
+		t.Errorf("String() = %q; want: %q", s, wantStr)
+	}
+	if d := DiffFileSets(old, old); !d.Empty() || d.String() != "" {
+		t.Errorf("DiffFileSets(old, old) = %+v; want empty diff", d)
+	}
+	d = DiffFileSets(nil, old)
+	if want := []string{"asm_amd64.s", "main.go", "sys_linux.go"}; !reflect.DeepEqual(d.Added, want) {
+		t.Errorf("DiffFileSets(nil, old).Added = %q; want: %q", d.Added, want)
+	}
+}
@@ -1,22 +1,32 @@
 package contextutil
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"go/build"
+	"io"
 	"io/fs"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 
+	"github.com/charlievieth/buildutil/internal/fsys"
+	"github.com/charlievieth/buildutil/internal/modfile"
 	"github.com/charlievieth/buildutil/internal/readdir"
+	"github.com/charlievieth/buildutil/internal/util"
 	"golang.org/x/tools/go/buildutil"
 )
 
 // DefaultProjectTombstones are the files used by FindProjectRoot to
-// determine the root of a project.
+// determine the root of a project. Use FindProjectRootTombstones to resolve
+// the root of nested projects by the priority of their tombstones, its
+// DefaultTombstones are derived from DefaultProjectTombstones.
 var DefaultProjectTombstones = []string{
 	".git",
 	"go.mod",
@@ -27,6 +37,14 @@ var DefaultProjectTombstones = []string{
 
 var errNotAbsolute = errors.New("path is not absolute")
 
+// ErrPathLoop is returned (wrapped in an *fs.PathError) when walking the
+// parents of a directory exceeds the maximum directory depth.
+var ErrPathLoop = errors.New("too many levels of symbolic links or directories")
+
+// maxPathDepth is the maximum number of parent directories walked.
+// It is larger than the number of directories that can fit in PATH_MAX.
+const maxPathDepth = 2048
+
 // ContainingDirectory finds the parent directory of child containing an
 // entry named by tombstones. The child directory must be absolute.
 //
@@ -42,28 +60,78 @@ var errNotAbsolute = errors.New("path is not absolute")
 //		"go.mod",
 //	)
 func ContainingDirectory(ctxt *build.Context, child, stopAt string, tombstones ...string) (string, error) {
+	var found string
+	err := walkContainingDirectories(ctxt, "contextutil: ContainingDirectory",
+		child, stopAt, tombstones, func(dir, _ string) bool {
+			found = dir
+			return false
+		})
+	if err != nil {
+		return "", err
+	}
+	if found == "" {
+		return child, os.ErrNotExist
+	}
+	return found, nil
+}
+
+// ContainingDirectories is like ContainingDirectory, but returns every parent
+// directory of child that contains an entry named by tombstones ordered from
+// innermost to outermost. This allows callers to distinguish a nested module
+// from its parent workspace.
+//
+// If no directory contains any of the tombstones os.ErrNotExist is returned.
+func ContainingDirectories(ctxt *build.Context, child, stopAt string, tombstones ...string) ([]string, error) {
+	var dirs []string
+	err := walkContainingDirectories(ctxt, "contextutil: ContainingDirectories",
+		child, stopAt, tombstones, func(dir, _ string) bool {
+			dirs = append(dirs, dir)
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+	if len(dirs) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return dirs, nil
+}
+
+// walkContainingDirectories calls fn with each parent of child, innermost
+// first, that contains an entry named by tombstones, and the first of the
+// tombstones it contains, until fn returns false or stopAt is reached.
+func walkContainingDirectories(ctxt *build.Context, op, child, stopAt string,
+	tombstones []string, fn func(dir, tombstone string) bool) error {
+
 	if len(tombstones) == 0 {
-		return "", errors.New("contextutil: no tombstone files specified")
+		return errors.New("contextutil: no tombstone files specified")
 	}
 
 	// TODO: don't require absolute paths
 	if stopAt != "" && !buildutil.IsAbsPath(ctxt, stopAt) {
-		return "", &fs.PathError{Op: "contextutil: ContainingDirectory",
-			Path: stopAt, Err: errNotAbsolute}
+		return &fs.PathError{Op: op, Path: stopAt, Err: errNotAbsolute}
 	}
 	if !buildutil.IsAbsPath(ctxt, child) {
-		return "", &fs.PathError{Op: "contextutil: ContainingDirectory",
-			Path: child, Err: errNotAbsolute}
+		return &fs.PathError{Op: op, Path: child, Err: errNotAbsolute}
 	}
 
 	if stopAt != "" {
 		stopAt = filepath.Clean(stopAt)
 	}
+	// The parents are found lexically so the walk cannot loop, even if
+	// the path traverses a symlink cycle (e.g. "/a/l/l/l" where "l" links
+	// to "/a"), but the depth is capped as a safeguard.
 	dir := filepath.Clean(child)
-	for {
+	for depth := 0; ; depth++ {
+		if depth >= maxPathDepth {
+			return &fs.PathError{Op: op, Path: child, Err: ErrPathLoop}
+		}
 		for _, name := range tombstones {
 			if buildutil.FileExists(ctxt, join2(ctxt, dir, name)) {
-				return dir, nil
+				if !fn(dir, name) {
+					return nil
+				}
+				break
 			}
 		}
 		if dir == stopAt {
@@ -75,7 +143,7 @@ func ContainingDirectory(ctxt *build.Context, child, stopAt string, tombstones .
 		}
 		dir = parent
 	}
-	return child, os.ErrNotExist
+	return nil
 }
 
 // join2 joins two paths, which must be clean.
@@ -117,7 +185,7 @@ func isFile(ctxt *build.Context, name string) bool {
 		f.Close()
 		return true
 	}
-	fi, err := os.Stat(name)
+	fi, err := fsys.Stat(name)
 	return err == nil && fi.Mode().IsRegular()
 }
 
@@ -135,26 +203,11 @@ func isFile(ctxt *build.Context, name string) bool {
 //
 // os.ErrNotExist is returned if the project directory was not found.
 func FindProjectRoot(ctxt *build.Context, path string, extra ...string) (string, error) {
-	var err error
-	path, err = absPath(ctxt, path)
+	path, root, err := projectSearchPath(ctxt, path)
 	if err != nil {
 		return "", err
 	}
 
-	// Allow path to be a file
-	if isFile(ctxt, path) {
-		path = filepath.Dir(path)
-	}
-
-	// Find the GOROOT or GOPATH that is the parent of path, if any.
-	var root string
-	for _, p := range ctxt.SrcDirs() {
-		if isSubdir(p, path) {
-			root = p
-			break
-		}
-	}
-
 	tombstones := DefaultProjectTombstones
 	if len(extra) != 0 {
 		tombstones = make([]string, len(extra)+len(DefaultProjectTombstones))
@@ -196,29 +249,32 @@ func hasSubdirImpl(ctxt *build.Context, root, dir string) (rel string, ok bool)
 	// If either root or dir is GOROOT, or a child of it, and the other is
 	// a child of GOPATH we can assume the two do not overlap and skip the
 	// expensive call to filepath.EvalSymlinks.
-	goroot := filepath.Clean(ctxt.GOROOT)
-	if (root == goroot || isSubdir(goroot, root)) && inGopath(ctxt, dir) ||
-		(dir == goroot || isSubdir(goroot, dir)) && inGopath(ctxt, root) {
+	if goroot := filepath.Clean(ctxt.GOROOT); ctxt.GOROOT != "" &&
+		((root == goroot || isSubdir(goroot, root)) && inGopath(ctxt, dir) ||
+			(dir == goroot || isSubdir(goroot, dir)) && inGopath(ctxt, root)) {
 		return "", false
 	}
 
 	// Use os.SameFile to determine if dir is a child of root or contains
 	// a symlink before attempting to use filepath.EvalSymlinks, which will
 	// stat each element of both root and dir.
-	rootInfo, err := os.Stat(root)
+	rootInfo, err := fsys.Stat(root)
 	if err != nil {
 		return "", false
 	}
 	path := dir
-	for {
-		fi, err := os.Lstat(path)
+	for depth := 0; ; depth++ {
+		if depth >= maxPathDepth {
+			return "", false // pathological path
+		}
+		fi, err := fsys.Lstat(path)
 		if err != nil {
 			return "", false
 		}
 		if fi.Mode()&os.ModeSymlink != 0 {
 			break // issue #14054 symlink in dir
 		}
-		if os.SameFile(rootInfo, fi) {
+		if fsys.SameFile(rootInfo, fi) {
 			break // symlink in root
 		}
 		parent := filepath.Dir(path)
@@ -231,11 +287,11 @@ func hasSubdirImpl(ctxt *build.Context, root, dir string) (rel string, ok bool)
 	// Try expanding symlinks and comparing
 	// expanded against unexpanded and
 	// expanded against expanded.
-	rootSym, _ := filepath.EvalSymlinks(root)
+	rootSym, _ := fsys.EvalSymlinks(root)
 	if rel, ok = hasSubdir(rootSym, dir); ok {
 		return
 	}
-	dirSym, _ := filepath.EvalSymlinks(dir)
+	dirSym, _ := fsys.EvalSymlinks(dir)
 	if rel, ok = hasSubdir(root, dirSym); ok {
 		return
 	}
@@ -243,19 +299,10 @@ func hasSubdirImpl(ctxt *build.Context, root, dir string) (rel string, ok bool)
 }
 
 // isSubdir reports if dir is within root by performing lexical analysis only.
-func isSubdir(root, dir string) bool {
-	n := len(root)
-	return 0 < n && n < len(dir) && dir[0:n] == root &&
-		n < len(dir) /* BCE */ && os.IsPathSeparator(dir[n])
-}
+func isSubdir(root, dir string) bool { return util.IsSubdir(root, dir) }
 
 // hasSubdir reports if dir is within root by performing lexical analysis only.
-func hasSubdir(root, dir string) (rel string, ok bool) {
-	if isSubdir(root, dir) {
-		return filepath.ToSlash(dir[len(root)+1:]), true
-	}
-	return "", false
-}
+func hasSubdir(root, dir string) (rel string, ok bool) { return util.HasSubdir(root, dir) }
 
 // inGopath reports if dir is within the gopath, which may be a list of
 // paths joined by os.PathListSeparator.
@@ -300,17 +347,72 @@ func inGopath(ctxt *build.Context, dir string) bool {
 	return false
 }
 
+// symlinkGen is incremented by InvalidateSymlinks to invalidate every
+// symlinkCache.
+var symlinkGen uint64
+
+// InvalidateSymlinks discards the symbolic links resolved by the Contexts
+// returned by ScopedContext and related functions, which are cached since
+// resolving them is expensive. It should be called when a directory or
+// symbolic link is created, deleted or renamed, otherwise a scoped Context
+// may not find a directory that was moved into its scope. The links are
+// resolved again when needed.
+func InvalidateSymlinks() {
+	atomic.AddUint64(&symlinkGen, 1)
+}
+
+// symlinkCache caches the result of filepath.EvalSymlinks and is safe for
+// concurrent use. It is emptied when InvalidateSymlinks is called.
+type symlinkCache struct {
+	mu  sync.Mutex
+	gen uint64 // symlinkGen when m was populated
+	m   map[string]string
+}
+
+// eval returns the result of calling filepath.EvalSymlinks on path or path
+// if an error occurred. Errors are not cached since the path may be created
+// later.
+func (c *symlinkCache) eval(path string) string {
+	gen := atomic.LoadUint64(&symlinkGen)
+	c.mu.Lock()
+	if c.gen != gen {
+		c.m = nil
+		c.gen = gen
+	}
+	real, ok := c.m[path]
+	c.mu.Unlock()
+	if ok {
+		return real
+	}
+	real, err := fsys.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+	c.mu.Lock()
+	if c.gen == gen {
+		if c.m == nil {
+			c.m = make(map[string]string)
+		}
+		c.m[path] = real
+	}
+	c.mu.Unlock()
+	return real
+}
+
 func sameFile(name, base string, baseInfo os.FileInfo) bool {
 	if filepath.Base(name) == base {
-		if fi, err := os.Stat(name); err == nil {
-			return os.SameFile(fi, baseInfo)
+		if fi, err := fsys.Stat(name); err == nil {
+			return fsys.SameFile(fi, baseInfo)
 		}
 	}
 	return false
 }
 
 func cleanGoPaths(ctxt *build.Context) {
-	ctxt.GOROOT = filepath.Clean(ctxt.GOROOT)
+	// An empty GOROOT means there is no GOROOT, don't clean it to "."
+	if ctxt.GOROOT != "" {
+		ctxt.GOROOT = filepath.Clean(ctxt.GOROOT)
+	}
 
 	// If there is a custom SplitPathList function we can't reliably
 	// rejoin the list after cleaning.
@@ -333,22 +435,8 @@ func cleanGoPaths(ctxt *build.Context) {
 	}
 }
 
-func sortUniqueStrings(list []string) []string {
-	if len(list) <= 1 {
-		return list
-	}
-	sort.Strings(list)
-	a := list[:1]
-	k := a[0]
-	for i := 1; i < len(list); i++ {
-		if v := list[i]; v != k {
-			a = append(a, v)
-			k = v
-		}
-	}
-	return a
-}
-
+// readSubdirs returns the FileInfos of subdirs, which must have the same
+// parent directory, sorted by name.
 func readSubdirs(ctxt *build.Context, subdirs []string, names map[string]struct{}) ([]os.FileInfo, error) {
 	if len(subdirs) == 0 {
 		return nil, nil
@@ -362,19 +450,20 @@ func readSubdirs(ctxt *build.Context, subdirs []string, names map[string]struct{
 		if len(fis) == 0 {
 			return nil, nil
 		}
-		// Filter out any FileInfos that are outside the scope of this Context
-		a := fis[:0]
+		// Filter out any FileInfos that are outside the scope of this Context.
+		// The ReadDir function may return a cached slice so do not modify it.
+		a := make([]fs.FileInfo, 0, len(names))
 		for _, fi := range fis {
 			if _, ok := names[fi.Name()]; ok {
 				a = append(a, fi)
 			}
 		}
-		return a, nil
+		return sortFileInfos(a), nil
 	}
 
 	fis := make([]fs.FileInfo, 0, len(subdirs))
 	for _, sub := range subdirs {
-		fi, err := os.Lstat(sub)
+		fi, err := fsys.Lstat(sub)
 		if err != nil {
 			if os.IsNotExist(err) {
 				continue
@@ -383,7 +472,19 @@ func readSubdirs(ctxt *build.Context, subdirs []string, names map[string]struct{
 		}
 		fis = append(fis, fi)
 	}
-	return fis, nil
+	return sortFileInfos(fis), nil
+}
+
+// sortFileInfos returns fis sorted by name. If fis is not already sorted, a
+// sorted copy is returned and fis is not modified.
+func sortFileInfos(fis []fs.FileInfo) []fs.FileInfo {
+	if sort.SliceIsSorted(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() }) {
+		return fis
+	}
+	a := make([]fs.FileInfo, len(fis))
+	copy(a, fis)
+	sort.Slice(a, func(i, j int) bool { return a[i].Name() < a[j].Name() })
+	return a
 }
 
 // minPackage is a subset of build.Package except that SrcRoot is the src
@@ -404,15 +505,17 @@ func (m minPackage) String() string {
 }
 
 func minImportDir(ctxt *build.Context, dir string) (*minPackage, error) {
-	root := join2(ctxt, ctxt.GOROOT, "src")
-	if rel, ok := HasSubdir(ctxt, root, dir); ok {
-		pkg := &minPackage{
-			ImportPath: filepath.ToSlash(rel),
-			Root:       filepath.Dir(root),
-			SrcRoot:    root,
-			Goroot:     true,
+	if ctxt.GOROOT != "" {
+		root := join2(ctxt, ctxt.GOROOT, "src")
+		if rel, ok := HasSubdir(ctxt, root, dir); ok {
+			pkg := &minPackage{
+				ImportPath: filepath.ToSlash(rel),
+				Root:       filepath.Dir(root),
+				SrcRoot:    root,
+				Goroot:     true,
+			}
+			return pkg, nil
 		}
-		return pkg, nil
 	}
 	for _, src := range buildutil.SplitPathList(ctxt, ctxt.GOPATH) {
 		src = join2(ctxt, src, "src")
@@ -439,17 +542,132 @@ func minImportDir(ctxt *build.Context, dir string) (*minPackage, error) {
 	return pkg, nil
 }
 
+func readModFile(ctxt *build.Context, name string) *modfile.File {
+	rc, err := buildutil.OpenFile(ctxt, name)
+	if err != nil {
+		return nil
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil
+	}
+	f, err := modfile.Parse(data)
+	if err != nil {
+		return nil
+	}
+	return f
+}
+
+// workspaceDirs returns the directories of the modules used by the go.work
+// file containing the module root, if any, and the directories of any local
+// replace directives in the go.work and go.mod files.
+func workspaceDirs(ctxt *build.Context, root string) []string {
+	seen := map[string]bool{root: true}
+	var dirs []string
+	resolve := func(base, path string) string {
+		path = filepath.FromSlash(path)
+		if !buildutil.IsAbsPath(ctxt, path) {
+			path = buildutil.JoinPath(ctxt, base, path)
+		}
+		return filepath.Clean(path)
+	}
+	add := func(dir string) {
+		if !seen[dir] && buildutil.IsDir(ctxt, dir) {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	addReplace := func(base string, f *modfile.File) {
+		for _, r := range f.Replace {
+			if modfile.IsDirectoryPath(r.New) {
+				add(resolve(base, r.New))
+			}
+		}
+	}
+
+	modRoots := []string{root}
+	if work, err := ContainingDirectory(ctxt, root, "", "go.work"); err == nil {
+		if f := readModFile(ctxt, join2(ctxt, work, "go.work")); f != nil {
+			for _, use := range f.Use {
+				dir := resolve(work, use)
+				add(dir)
+				modRoots = append(modRoots, dir)
+			}
+			addReplace(work, f)
+		}
+	}
+	for _, dir := range modRoots {
+		if f := readModFile(ctxt, join2(ctxt, dir, "go.mod")); f != nil {
+			addReplace(dir, f)
+		}
+	}
+	return dirs
+}
+
 // TODO: export and note that this is faster than buildutil.readDir
 //
 // readDir behaves like ioutil.readDir, but uses the build context's file
-// system interface, if any.
+// system interface, if any. The entries are sorted by name even if the
+// ReadDir function of the build context does not sort them. The returned
+// slice may be the one returned by the build context and must not be
+// modified.
 func readDir(ctxt *build.Context, path string) ([]fs.FileInfo, error) {
 	if f := ctxt.ReadDir; f != nil {
-		return f(path)
+		fis, err := f(path)
+		if err == nil {
+			fis = sortFileInfos(fis)
+		}
+		return fis, err
 	}
 	return readdir.ReadDir(path)
 }
 
+// An OutOfScopePolicy controls what the ReadDir function of a scoped
+// Context returns for a directory that exists, but is not in scope.
+type OutOfScopePolicy int
+
+const (
+	// OutOfScopeNotExist returns an error satisfying
+	// errors.Is(err, fs.ErrNotExist) for out of scope directories.
+	// If the original Context has a ReadDir function it is used instead.
+	OutOfScopeNotExist OutOfScopePolicy = iota
+
+	// OutOfScopeEmptyDir returns an empty listing for out of scope
+	// directories. This is useful for walkers that treat ErrNotExist
+	// as a fatal error.
+	OutOfScopeEmptyDir
+
+	// OutOfScopePassThrough reads out of scope directories normally.
+	// Only the listings of the ancestors of the package directories
+	// are limited.
+	OutOfScopePassThrough
+)
+
+// ScopeOptions configures the Context returned by ScopedContextOptions.
+type ScopeOptions struct {
+	OutOfScope OutOfScopePolicy
+
+	// SkipDir, if non-nil, is called with the base name of each directory
+	// read by ReadDir and directories for which it returns true are omitted
+	// from the listing. This is typically buildutil.IsIgnoredDir from
+	// the github.com/charlievieth/buildutil package.
+	SkipDir func(name string) bool
+
+	// MaxDepth, if greater than zero, limits how many levels ReadDir may
+	// descend below a package directory (or module root). Sub-directories
+	// are omitted from the listing of directories at the maximum depth
+	// and any deeper directory is treated as out of scope.
+	MaxDepth int
+
+	// Module widens the scope of a package directory in the GOPATH to
+	// the root of its containing module (the nearest directory with a
+	// go.mod file below GOPATH/src), which allows tools to find sibling
+	// packages that import it. Other GOPATH entries are still pruned.
+	// Packages outside of the GOPATH are always scoped to their module.
+	Module bool
+}
+
 // ScopedContext returns a build.Context with a ReadDir that is scoped to the
 // directories listed by pkgdirs and the GOROOT. That is, ReadDir when called
 // with an ancestor of pkgdirs will only return immediate ancestors (that lead
@@ -460,6 +678,14 @@ func readDir(ctxt *build.Context, path string) ([]fs.FileInfo, error) {
 // entire GOPATH (e.g. "golang.org/x/tools/refactor/rename"), which can greatly
 // speed up processing time.
 //
+// The ReadDir function of the returned Context is safe for concurrent use and
+// returns the entries sorted by name on every platform, even if the ReadDir
+// function of orig does not.
+//
+// An empty GOROOT means that there is no GOROOT, such as in a container that
+// only has source trees, and only the pkgdirs (and their modules) are in
+// scope. The GOROOT is never defaulted to runtime.GOROOT().
+//
 //	// In the below example we limit the search path to "/go/src/pkg/buildutil".
 //	ctxt, _ := ScopedContext(&build.Default, "/go/src/pkg/buildutil")
 //	ctxt.ReadDir("/go")                               // => ["src"]
@@ -468,6 +694,44 @@ func readDir(ctxt *build.Context, path string) ([]fs.FileInfo, error) {
 //	ctxt.ReadDir("/go/src/pkg/buildutil")             // => [ALL ENTRIES]
 //	ctxt.ReadDir("/go/src/pkg/buildutil/contextutil") // => [ALL ENTRIES]
 func ScopedContext(orig *build.Context, pkgdirs ...string) (*build.Context, error) {
+	return ScopedContextOptions(orig, nil, pkgdirs...)
+}
+
+// ScopedContextOptions is like ScopedContext, but allows configuring how
+// directories that are not in scope are handled. If opts is nil the
+// defaults are used, which matches the behavior of ScopedContext.
+func ScopedContextOptions(orig *build.Context, opts *ScopeOptions, pkgdirs ...string) (*build.Context, error) {
+	scope, err := NewScope(orig, opts, pkgdirs...)
+	if err != nil {
+		return nil, err
+	}
+	return scope.context(orig, opts), nil
+}
+
+// A Scope is the set of directories computed by ScopedContextOptions. It can
+// be encoded with encoding/json (see WriteScope) and used to create a scoped
+// Context in another process, which saves short-lived tools working in large
+// trees from having to recompute the scope (resolve the import path of each
+// package directory, evaluate symlinks and read go.work files).
+//
+// A Scope is not updated when the file system changes and is only valid for
+// Contexts with the same GOROOT and GOPATH.
+type Scope struct {
+	GOROOT  string              `json:"goroot"`
+	GOPATH  string              `json:"gopath"`
+	Goroots []string            `json:"goroots"`           // GOROOT and its resolved path, if any
+	Modules []string            `json:"modules,omitempty"` // module and workspace roots
+	PkgDirs []string            `json:"pkgdirs"`           // package directories and their resolved paths
+	Dirs    map[string][]string `json:"dirs,omitempty"`    // ancestor => sorted sub-directories that lead to PkgDirs
+}
+
+// ErrScopeMismatch is returned by Scope.Context when the GOROOT or GOPATH
+// of the Context does not match the Scope.
+var ErrScopeMismatch = errors.New("contextutil: scope does not match the Context GOROOT or GOPATH")
+
+// NewScope computes the scope of the Context returned by ScopedContextOptions
+// for orig, opts and pkgdirs.
+func NewScope(orig *build.Context, opts *ScopeOptions, pkgdirs ...string) (*Scope, error) {
 	// TODO: allow no pkgdirs to limit Context to GOROOT?
 	if len(pkgdirs) == 0 {
 		return nil, errors.New("contextutil: no package directories specified")
@@ -492,17 +756,23 @@ func ScopedContext(orig *build.Context, pkgdirs ...string) (*build.Context, erro
 		pkgdirs[i] = filepath.Clean(dir)
 	}
 
-	// TODO: this will not work for all cases of symlinks
+	// Eagerly resolve symlinked pkgdirs since they are the common case, any
+	// other symlinks are resolved lazily by ReadDir.
 	for _, dir := range pkgdirs {
-		if p, err := filepath.EvalSymlinks(dir); err == nil && p != dir {
+		if p, err := fsys.EvalSymlinks(dir); err == nil && p != dir {
 			pkgdirs = append(pkgdirs, p)
 		}
 	}
 
-	goroots := []string{ctxt.GOROOT}
-	if p, err := filepath.EvalSymlinks(ctxt.GOROOT); err == nil && p != ctxt.GOROOT {
-		goroots = append(goroots, p)
+	var goroots []string
+	if ctxt.GOROOT != "" {
+		goroots = append(goroots, ctxt.GOROOT)
+		if p, err := fsys.EvalSymlinks(ctxt.GOROOT); err == nil && p != ctxt.GOROOT {
+			goroots = append(goroots, p)
+		}
 	}
+	// Any goroots added after this are module roots
+	nGoroot := len(goroots)
 
 	// File system map:
 	// 	"/go":     ["/go/src"]
@@ -518,10 +788,22 @@ func ScopedContext(orig *build.Context, pkgdirs ...string) (*build.Context, erro
 			// Treat the module directory as a GOROOT since we can assume
 			// all of it's children are valid and relevant.
 			goroots = append(goroots, pkg.Root)
+			// Same for the modules of the workspace and any local
+			// replacements so that tools can navigate into them.
+			goroots = append(goroots, workspaceDirs(ctxt, pkg.Root)...)
 			continue
 		}
 
 		dir := buildutil.JoinPath(ctxt, pkg.SrcRoot, pkg.ImportPath)
+		if opts != nil && opts.Module && !pkg.Goroot {
+			modRoot, err := ContainingDirectory(ctxt, dir, pkg.SrcRoot, "go.mod")
+			if err == nil && modRoot != pkg.SrcRoot && modRoot != dir {
+				// Treat the module root like a module outside of
+				// the GOPATH, but keep pruning its ancestors.
+				goroots = append(goroots, modRoot)
+				dir = modRoot
+			}
+		}
 		child := filepath.Dir(dir)
 		for dir != pkg.SrcRoot && dir != child {
 			dirs[child] = append(dirs[child], dir)
@@ -536,10 +818,66 @@ func ScopedContext(orig *build.Context, pkgdirs ...string) (*build.Context, erro
 	// due to symlinks.
 	for root, subdirs := range dirs {
 		if len(subdirs) > 1 {
-			dirs[root] = sortUniqueStrings(subdirs)
+			dirs[root] = util.SortUniqueStrings(subdirs)
 		}
 	}
 
+	scope := &Scope{
+		GOROOT:  ctxt.GOROOT,
+		GOPATH:  ctxt.GOPATH,
+		Goroots: goroots[:nGoroot],
+		Modules: goroots[nGoroot:],
+		PkgDirs: pkgdirs,
+		Dirs:    dirs,
+	}
+	if len(scope.Modules) == 0 {
+		scope.Modules = nil
+	}
+	return scope, nil
+}
+
+// Context returns a copy of orig with a ReadDir function that is limited to
+// the Scope, like the Context returned by ScopedContextOptions. The Scope
+// must not be modified after calling Context. ErrScopeMismatch is returned
+// if the GOROOT or GOPATH of orig do not match the Scope.
+func (s *Scope) Context(orig *build.Context, opts *ScopeOptions) (*build.Context, error) {
+	if len(s.PkgDirs) == 0 {
+		return nil, errors.New("contextutil: invalid scope: no package directories")
+	}
+	copy := *orig // make a copy
+	cleanGoPaths(&copy)
+	if copy.GOROOT != s.GOROOT || copy.GOPATH != s.GOPATH {
+		return nil, ErrScopeMismatch
+	}
+	return s.context(orig, opts), nil
+}
+
+// WriteScope writes the JSON encoding of scope to w.
+func WriteScope(w io.Writer, scope *Scope) error {
+	return json.NewEncoder(w).Encode(scope)
+}
+
+// ReadScope reads a Scope written by WriteScope from r.
+func ReadScope(r io.Reader) (*Scope, error) {
+	var scope Scope
+	if err := json.NewDecoder(r).Decode(&scope); err != nil {
+		return nil, err
+	}
+	return &scope, nil
+}
+
+func (s *Scope) context(orig *build.Context, opts *ScopeOptions) *build.Context {
+	copy := *orig // make a copy
+	ctxt := &copy
+	cleanGoPaths(ctxt)
+
+	goroots := make([]string, 0, len(s.Goroots)+len(s.Modules))
+	goroots = append(goroots, s.Goroots...)
+	goroots = append(goroots, s.Modules...)
+	nGoroot := len(s.Goroots)
+	pkgdirs := s.PkgDirs
+	dirs := s.Dirs
+
 	// If orig.ReadDir is non-nil create a map of file names to speed up
 	// filtering when reading scoped sub-directories.
 	var names map[string]map[string]struct{}
@@ -554,6 +892,96 @@ func ScopedContext(orig *build.Context, pkgdirs ...string) (*build.Context, erro
 		}
 	}
 
+	// Symlinks are resolved lazily and cached since most callers will only
+	// read directories that are lexically within the scope.
+	links := new(symlinkCache)
+	var (
+		scopeMu   sync.Mutex
+		scopeGen  uint64            // symlinkGen when the scope was resolved
+		realRoots []string          // resolved goroots and pkgdirs
+		realDirs  map[string]string // resolved dirs key => dirs key
+	)
+	loadScope := func() ([]string, map[string]string) {
+		gen := atomic.LoadUint64(&symlinkGen)
+		scopeMu.Lock()
+		defer scopeMu.Unlock()
+		if realDirs != nil && scopeGen == gen {
+			return realRoots, realDirs
+		}
+		roots := make([]string, 0, len(goroots)+len(pkgdirs))
+		for _, a := range [][]string{goroots, pkgdirs} {
+			for _, p := range a {
+				roots = append(roots, links.eval(p))
+			}
+		}
+		m := make(map[string]string, len(dirs))
+		for dir := range dirs {
+			m[links.eval(dir)] = dir
+		}
+		realRoots, realDirs, scopeGen = roots, m, gen
+		return realRoots, realDirs
+	}
+
+	var policy OutOfScopePolicy
+	var skipDir func(name string) bool
+	var maxDepth int
+	if opts != nil {
+		policy = opts.OutOfScope
+		skipDir = opts.SkipDir
+		maxDepth = opts.MaxDepth
+	}
+	readAll := func(dir string) ([]fs.FileInfo, error) {
+		fis, err := readDir(orig, dir)
+		if err != nil || skipDir == nil {
+			return fis, err
+		}
+		a := make([]fs.FileInfo, 0, len(fis))
+		for _, fi := range fis {
+			if !fi.IsDir() || !skipDir(fi.Name()) {
+				a = append(a, fi)
+			}
+		}
+		return a, nil
+	}
+	notInScope := func(dir string) ([]fs.FileInfo, error) {
+		switch policy {
+		case OutOfScopeEmptyDir:
+			if buildutil.IsDir(orig, dir) {
+				return []fs.FileInfo{}, nil
+			}
+		case OutOfScopePassThrough:
+			return readAll(dir)
+		}
+		return nil, &fs.PathError{Op: "open", Path: dir, Err: os.ErrNotExist}
+	}
+	// readRoot reads dir, which is root or one of its children, and
+	// enforces the MaxDepth limit, if any.
+	readRoot := func(root, dir string) ([]fs.FileInfo, error) {
+		if maxDepth <= 0 {
+			return readAll(dir)
+		}
+		depth := 0
+		if dir != root {
+			rel := filepath.ToSlash(strings.TrimPrefix(dir[len(root):], string(filepath.Separator)))
+			depth = strings.Count(rel, "/") + 1
+		}
+		if depth > maxDepth {
+			return notInScope(dir)
+		}
+		fis, err := readAll(dir)
+		if err != nil || depth < maxDepth {
+			return fis, err
+		}
+		// Omit sub-directories since they cannot be read
+		a := fis[:0]
+		for _, fi := range fis {
+			if !fi.IsDir() {
+				a = append(a, fi)
+			}
+		}
+		return a, nil
+	}
+
 	ctxt.ReadDir = func(dir string) ([]fs.FileInfo, error) {
 		if !buildutil.IsAbsPath(ctxt, dir) {
 			return nil, &fs.PathError{Op: "contextutil: ReadDir", Path: dir, Err: errNotAbsolute}
@@ -561,29 +989,52 @@ func ScopedContext(orig *build.Context, pkgdirs ...string) (*build.Context, erro
 		dir = filepath.Clean(dir)
 
 		// Never limit GOROOT
-		for _, p := range goroots {
+		for _, p := range goroots[:nGoroot] {
 			if p == dir || isSubdir(p, dir) {
-				return readDir(orig, dir)
+				return readAll(dir)
 			}
 		}
 
 		// Dir is within the package - read normally
 		for _, p := range pkgdirs {
 			if p == dir || isSubdir(p, dir) {
-				return readDir(orig, dir)
+				return readRoot(p, dir)
+			}
+		}
+
+		// Dir is within a module
+		for _, p := range goroots[nGoroot:] {
+			if p == dir || isSubdir(p, dir) {
+				return readRoot(p, dir)
 			}
 		}
 
 		if len(dirs) == 0 {
-			return nil, &fs.PathError{Op: "open", Path: dir, Err: os.ErrNotExist}
+			return notInScope(dir)
 		}
 
 		if subdirs, ok := dirs[dir]; ok {
 			return readSubdirs(orig, subdirs, names[dir])
 		}
 
+		// Resolve any symlinks in dir (or the scope) and check if the
+		// real directory is in scope.
+		realRoots, realDirs := loadScope()
+		real := links.eval(dir)
+		for i, p := range realRoots {
+			if p == real || isSubdir(p, real) {
+				if i < nGoroot {
+					return readAll(dir)
+				}
+				return readRoot(p, real)
+			}
+		}
+		if key, ok := realDirs[real]; ok {
+			return readSubdirs(orig, dirs[key], names[key])
+		}
+
 		// Try comparing file stats
-		fi, err := os.Stat(dir)
+		fi, err := fsys.Stat(dir)
 		if err != nil {
 			return nil, err
 		}
@@ -595,25 +1046,112 @@ func ScopedContext(orig *build.Context, pkgdirs ...string) (*build.Context, erro
 		base := filepath.Base(dir)
 		for _, p := range pkgdirs {
 			if sameFile(p, base, fi) {
-				return readDir(orig, dir)
+				return readRoot(dir, dir)
 			}
 		}
 		for root, subdirs := range dirs {
 			if sameFile(root, base, fi) {
-				return readSubdirs(orig, subdirs, names[dir])
+				return readSubdirs(orig, subdirs, names[root])
 			}
 		}
 
 		// Fall back to the previous ReadDir, if any.
-		if orig.ReadDir != nil {
+		if policy == OutOfScopeNotExist && orig.ReadDir != nil {
 			return orig.ReadDir(dir)
 		}
 
-		// TODO: make sure returning an error here doesn't lead to
-		// any issues as the directory *may* actually exist, but is
-		// not included in our list of "valid" directories.
-		return nil, &fs.PathError{Op: "open", Path: dir, Err: os.ErrNotExist}
+		// The directory exists, but is not in scope.
+		return notInScope(dir)
+	}
+
+	return ctxt
+}
+
+// ScopedContextForFile is like ScopedContext, but the scope is the package
+// directory containing filename, which is convenient for editors that have
+// a file path and not a package directory. If the package is part of a
+// module the scope includes the entire module (see ScopedContext). The file
+// does not need to exist (e.g. an unsaved buffer), but its directory must.
+// If filename is not absolute it is joined with orig.Dir (if set) or the
+// current working directory. A directory may also be provided. If orig is
+// nil build.Default is used.
+func ScopedContextForFile(orig *build.Context, filename string) (*build.Context, error) {
+	if orig == nil {
+		orig = &build.Default
 	}
+	path, err := absPath(orig, filename)
+	if err != nil {
+		return nil, err
+	}
+	dir := path
+	if !buildutil.IsDir(orig, path) {
+		dir = filepath.Dir(path)
+	}
+	return ScopedContext(orig, dir)
+}
+
+// ScopedContextPatterns is like ScopedContext, but the scope is specified by
+// package patterns, like those passed to "go list", which are resolved using
+// the build.Context orig. A pattern may be an import path
+// ("github.com/org/repo/..."), a relative path ("./...") that is resolved
+// against orig.Dir or the current working directory, or an absolute path.
+//
+// Since a ScopedContext includes all the children of its package directories
+// any "..." wildcard is resolved to the directory that contains all possible
+// matches (e.g. "a/b/..." => "a/b" and "a/b..." => "a").
+func ScopedContextPatterns(orig *build.Context, patterns ...string) (*build.Context, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("contextutil: no patterns specified")
+	}
+	pkgdirs := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		dir, err := resolvePattern(orig, pattern)
+		if err != nil {
+			return nil, err
+		}
+		pkgdirs = append(pkgdirs, dir)
+	}
+	return ScopedContext(orig, pkgdirs...)
+}
 
-	return ctxt, nil
+// patternRoot returns the longest prefix of pattern that does not contain
+// a "..." wildcard and is a complete path element.
+func patternRoot(pattern string) string {
+	i := strings.Index(pattern, "...")
+	if i == -1 {
+		return pattern
+	}
+	prefix := pattern[:i]
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		return strings.TrimSuffix(prefix, "/")
+	}
+	if j := strings.LastIndexByte(prefix, '/'); j >= 0 {
+		return prefix[:j]
+	}
+	return ""
+}
+
+func isLocalPattern(pattern string) bool {
+	return pattern == "." || pattern == ".." ||
+		strings.HasPrefix(pattern, "./") || strings.HasPrefix(pattern, "../")
+}
+
+// resolvePattern returns the directory of the package pattern.
+func resolvePattern(ctxt *build.Context, pattern string) (string, error) {
+	isLocal := isLocalPattern(filepath.ToSlash(pattern))
+	root := patternRoot(filepath.ToSlash(pattern))
+	if isLocal || buildutil.IsAbsPath(ctxt, pattern) {
+		if root == "" {
+			root = "/"
+		}
+		return absPath(ctxt, filepath.FromSlash(root))
+	}
+	if root == "" {
+		return "", fmt.Errorf("contextutil: pattern matches all packages: %q", pattern)
+	}
+	pkg, err := ctxt.Import(root, ctxt.Dir, build.FindOnly)
+	if err != nil {
+		return "", fmt.Errorf("contextutil: resolving pattern %q: %w", pattern, err)
+	}
+	return pkg.Dir, nil
 }
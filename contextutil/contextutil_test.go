@@ -1,6 +1,7 @@
 package contextutil
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,6 +19,8 @@ import (
 	"syscall"
 	"testing"
 
+	"github.com/charlievieth/buildutil/buildutiltest"
+	"github.com/charlievieth/buildutil/internal/fsys"
 	"github.com/charlievieth/buildutil/internal/readdir"
 	"github.com/charlievieth/buildutil/internal/util"
 	"golang.org/x/tools/go/buildutil"
@@ -149,6 +152,140 @@ func TestContainingDirectory_FakeContext(t *testing.T) {
 	}
 }
 
+func TestContainingDirectories(t *testing.T) {
+	ctxt := buildutil.FakeContext(map[string]map[string]string{
+		"ws": {
+			"go.work": "go 1.18\n\nuse ./mod\n",
+		},
+		"ws/mod": {
+			"go.mod": "module mod",
+		},
+		"ws/mod/nested": {
+			"go.mod": "module mod/nested",
+		},
+		"ws/mod/nested/p": {
+			"p.go": "package p\n",
+		},
+	})
+	const child = "/go/src/ws/mod/nested/p"
+	tombstones := []string{"go.mod", "go.work"}
+
+	dirs, err := ContainingDirectories(ctxt, child, "", tombstones...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range dirs {
+		dirs[i] = filepath.ToSlash(dirs[i])
+	}
+	want := []string{"/go/src/ws/mod/nested", "/go/src/ws/mod", "/go/src/ws"}
+	if !reflect.DeepEqual(dirs, want) {
+		t.Errorf("ContainingDirectories(%q) = %q; want: %q", child, dirs, want)
+	}
+
+	// stopAt
+	dirs, err = ContainingDirectories(ctxt, child, "/go/src/ws/mod", tombstones...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 2 {
+		t.Errorf("ContainingDirectories(%q) = %q; want: %q", child, dirs, want[:2])
+	}
+
+	// Not found
+	if _, err := ContainingDirectories(ctxt, child, "", ".git"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error mismatch got: %v want: %v", err, os.ErrNotExist)
+	}
+}
+
+func TestContainingDirectory_SymlinkLoop(t *testing.T) {
+	switch runtime.GOOS {
+	case "windows", "plan9":
+		t.Skip("skipping: test requires symlinks")
+	}
+	tempdir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(tempdir, "root")
+	writeFile(t, filepath.Join(root, "go.mod"), "module root\n")
+	if err := os.Symlink(root, filepath.Join(root, "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Walking a path that does not traverse the cycle must succeed
+	dir, err := ContainingDirectory(&build.Default, filepath.Join(root, "loop"), "", "go.mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(root, "loop"); dir != want {
+		t.Errorf("ContainingDirectory = %q; want: %q", dir, want)
+	}
+
+	// The parents are walked lexically so a path that traverses the cycle
+	// is not an error.
+	child := filepath.Join(root, "loop", "loop", "loop")
+	dirs, err := ContainingDirectories(&build.Default, child, "", "go.mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		child,
+		filepath.Join(root, "loop", "loop"),
+		filepath.Join(root, "loop"),
+		root,
+	}
+	if !reflect.DeepEqual(dirs, want) {
+		t.Errorf("ContainingDirectories(%q) = %q; want: %q", child, dirs, want)
+	}
+}
+
+// fakePath returns the slash-separated absolute path name as an absolute
+// path for the local OS (on Windows the volume of the temp dir is used).
+func fakePath(name string) string {
+	return filepath.VolumeName(os.TempDir()) + filepath.FromSlash(name)
+}
+
+func TestContainingDirectories_MaxDepth(t *testing.T) {
+	ctxt := NewFakeContext(FakeFiles(map[string]string{"/go.mod": ""}))
+	child := "/" + strings.Repeat("d/", maxPathDepth)
+	_, err := ContainingDirectories(ctxt, child, "", "go.mod")
+	if !errors.Is(err, ErrPathLoop) {
+		t.Errorf("ContainingDirectories(%.40q) = %v; want: %v", child, err, ErrPathLoop)
+	}
+}
+
+func TestHasSubdir_FakeSymlinks(t *testing.T) {
+	t.Cleanup(fsys.Set(fsys.Fake{
+		fakePath("/real/pkg/sub/x.go"): {},
+		fakePath("/go/src/pkg"):        {Link: fakePath("/real/pkg")},
+		fakePath("/go/src/other/y.go"): {},
+		fakePath("/goroot/src/fmt"):    {Dir: true},
+	}))
+	ctxt := build.Default
+	ctxt.GOROOT = fakePath("/goroot")
+	ctxt.GOPATH = fakePath("/go")
+
+	tests := []struct {
+		root, dir string
+		rel       string
+		ok        bool
+	}{
+		{"/go/src/pkg", "/real/pkg/sub", "sub", true},   // symlink in root
+		{"/real/pkg", "/go/src/pkg/sub", "sub", true},   // symlink in dir
+		{"/go/src", "/go/src/pkg/sub", "pkg/sub", true}, // lexical
+		{"/go/src/other", "/real/pkg/sub", "", false},
+		{"/goroot/src", "/go/src/pkg", "", false},
+	}
+	for _, x := range tests {
+		root, dir := fakePath(x.root), fakePath(x.dir)
+		rel, ok := hasSubdirImpl(&ctxt, root, dir)
+		if rel != x.rel || ok != x.ok {
+			t.Errorf("hasSubdirImpl(%q, %q) = %q, %t; want: %q, %t",
+				x.root, x.dir, rel, ok, x.rel, x.ok)
+		}
+	}
+}
+
 func TestFindProjectRoot(t *testing.T) {
 	touch := func(t *testing.T, name string) {
 		t.Helper()
@@ -271,6 +408,64 @@ func TestFindProjectRoot_FakeContext(t *testing.T) {
 	}
 }
 
+func TestFindProjectRootTombstones(t *testing.T) {
+	tempdir := t.TempDir()
+	for _, name := range []string{
+		"repo/.git/HEAD",
+		"repo/go.work",
+		"repo/mod/go.mod",
+		"repo/mod/pkg/pkg.go",
+		"repo/nested/go.mod",
+		"repo/nested/sub/go.mod",
+		"repo/nested/sub/sub.go",
+		"other/.git/HEAD",
+		"other/mod/go.mod",
+		"other/mod/mod.go",
+	} {
+		writeFile(t, filepath.Join(tempdir, filepath.FromSlash(name)), "")
+	}
+	ctxt := build.Default
+	ctxt.GOPATH = ""
+
+	tests := []struct {
+		path       string
+		tombstones []Tombstone
+		want       string
+	}{
+		{"repo/mod/pkg/pkg.go", nil, "repo"},
+		{"repo/nested/sub", nil, "repo"},
+		{"other/mod/mod.go", nil, "other/mod"},
+		{"other/mod/mod.go", []Tombstone{{".git", 2}, {"go.mod", 1}}, "other"},
+		// Ties are won by the innermost directory
+		{"repo/nested/sub", []Tombstone{{"go.mod", 1}}, "repo/nested/sub"},
+	}
+	for _, x := range tests {
+		path := filepath.Join(tempdir, filepath.FromSlash(x.path))
+		got, err := FindProjectRootTombstones(&ctxt, path, x.tombstones...)
+		if err != nil {
+			t.Errorf("FindProjectRootTombstones(%q, %v): %v", x.path, x.tombstones, err)
+			continue
+		}
+		want := filepath.Join(tempdir, filepath.FromSlash(x.want))
+		if got != want {
+			t.Errorf("FindProjectRootTombstones(%q, %v) = %q; want: %q", x.path, x.tombstones, got, want)
+		}
+	}
+
+	// The DefaultProjectTombstones are the only default configuration
+	orig := DefaultProjectTombstones
+	t.Cleanup(func() { DefaultProjectTombstones = orig })
+	DefaultProjectTombstones = []string{".git"}
+	path := filepath.Join(tempdir, "other", "mod")
+	want := filepath.Join(tempdir, "other")
+	if got, err := FindProjectRootTombstones(&ctxt, path); err != nil || got != want {
+		t.Errorf("FindProjectRootTombstones(%q) = %q, %v; want: %q, %v", path, got, err, want, nil)
+	}
+	if _, err := FindProjectRootTombstones(&ctxt, tempdir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("FindProjectRootTombstones(%q) error = %v; want: %v", tempdir, err, os.ErrNotExist)
+	}
+}
+
 func testReadDir(t *testing.T, ctxt *build.Context, dirname string, expected ...string) {
 	t.Helper()
 	if len(expected) == 0 {
@@ -316,9 +511,6 @@ func writeFile(t testing.TB, name string, data interface{}) {
 	default:
 		t.Fatalf("invalid type: %T", data)
 	}
-	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
-		t.Fatal(err)
-	}
 	if filepath.Ext(name) == ".go" {
 		var err error
 		b, err = format.Source(b)
@@ -326,9 +518,8 @@ func writeFile(t testing.TB, name string, data interface{}) {
 			t.Fatal(err)
 		}
 	}
-	if err := os.WriteFile(name, b, 0644); err != nil {
-		t.Fatal(err)
-	}
+	dir, base := filepath.Split(name)
+	buildutiltest.WriteFiles(t, dir, map[string]string{base: string(b)})
 }
 
 func TestScopedContext(t *testing.T) {
@@ -573,22 +764,15 @@ func TestScopedContext(t *testing.T) {
 }
 
 func TestScopedContext_FakeContext(t *testing.T) {
-	orig := buildutil.FakeContext(map[string]map[string]string{
-		"modpkg": {
-			"go.mod":  "module modpkg",
-			"main.go": "package main",
-		},
-		"other": {
-			"go.mod":   "module other",
-			"other.go": "package other",
-		},
-		"modpkg/internal/p": {
-			"p.go": "package p\n\nconst P = 1\n",
-		},
-	})
-	orig.GOPATH = orig.GOROOT
-	orig.GOROOT = "/xgo"
-	ctxt, err := ScopedContext(orig, "/go/src/modpkg")
+	orig := NewFakeContext(FakeFiles(map[string]string{
+		"/gopath/src/modpkg/go.mod":          "module modpkg",
+		"/gopath/src/modpkg/main.go":         "package main",
+		"/gopath/src/other/go.mod":           "module other",
+		"/gopath/src/other/other.go":         "package other",
+		"/gopath/src/modpkg/internal/p/p.go": "package p\n\nconst P = 1\n",
+		"/goroot/src/fmt/print.go":           "package fmt",
+	}))
+	ctxt, err := ScopedContext(orig, "/gopath/src/modpkg")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -620,20 +804,603 @@ func TestScopedContext_FakeContext(t *testing.T) {
 		})
 	}
 
-	test(t, "/go/src", []FileInfo{{"modpkg", 0755}})
-	test(t, "/go/src/modpkg", []FileInfo{{"go.mod", 0644}, {"main.go", 0644}})
+	test(t, "/gopath/src", []FileInfo{{"modpkg", fs.ModeDir | 0755}})
+	test(t, "/gopath/src/modpkg", []FileInfo{
+		{"go.mod", 0644},
+		{"internal", fs.ModeDir | 0755},
+		{"main.go", 0644},
+	})
+	test(t, "/goroot/src", []FileInfo{{"fmt", fs.ModeDir | 0755}})
 
 	t.Run("NotFound", func(t *testing.T) {
-		_, err := ctxt.ReadDir("/go/src/other")
+		_, err := ctxt.ReadDir("/gopath/src/other")
 		if err == nil {
-			t.Fatalf("ReadDir(%q) should error", "/go/src/other")
+			t.Fatalf("ReadDir(%q) should error", "/gopath/src/other")
 		}
 		if !os.IsNotExist(err) {
-			t.Fatalf("ReadDir(%q) return IsNotExist error got: %v", "/go/src/other", err)
+			t.Fatalf("ReadDir(%q) return IsNotExist error got: %v", "/gopath/src/other", err)
 		}
 	})
 }
 
+func TestScopedContext_NoGOROOT(t *testing.T) {
+	orig := NewFakeContext(FakeFiles(map[string]string{
+		"/gopath/src/p/p.go":       "package p",
+		"/gopath/src/q/q.go":       "package q",
+		"/goroot/src/fmt/print.go": "package fmt",
+		"/src/p/p.go":              "package p",
+	}))
+	orig.GOROOT = ""
+
+	scope, err := NewScope(orig, nil, "/gopath/src/p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scope.GOROOT != "" || len(scope.Goroots) != 0 {
+		t.Errorf("NewScope: GOROOT = %q, Goroots = %q; want: none", scope.GOROOT, scope.Goroots)
+	}
+	if want := map[string][]string{
+		"/gopath":     {"/gopath/src"},
+		"/gopath/src": {"/gopath/src/p"},
+	}; !reflect.DeepEqual(scope.Dirs, want) {
+		t.Errorf("NewScope: Dirs = %q; want: %q", scope.Dirs, want)
+	}
+
+	ctxt, err := scope.Context(orig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctxt.GOROOT != "" {
+		t.Errorf("GOROOT = %q; want: %q", ctxt.GOROOT, "")
+	}
+	for dir, want := range map[string][]string{
+		"/gopath/src":   {"p"},
+		"/gopath/src/p": {"p.go"},
+	} {
+		fis, err := ctxt.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("ReadDir(%q) = %q; want: %q", dir, names, want)
+		}
+	}
+	for _, dir := range []string{"/goroot/src", "/gopath/src/q"} {
+		if _, err := ctxt.ReadDir(dir); !os.IsNotExist(err) {
+			t.Errorf("ReadDir(%q): want: IsNotExist error got: %v", dir, err)
+		}
+	}
+}
+
+func TestPatternRoot(t *testing.T) {
+	tests := map[string]string{
+		"a/b":            "a/b",
+		"a/b/...":        "a/b",
+		"a/b...":         "a",
+		"a/.../c":        "a",
+		"./...":          ".",
+		"...":            "",
+		"a...":           "",
+		"/abs/path/...":  "/abs/path",
+		"../x/y/z...":    "../x/y",
+		"github.com/a/b": "github.com/a/b",
+	}
+	for pattern, want := range tests {
+		if got := patternRoot(pattern); got != want {
+			t.Errorf("patternRoot(%q) = %q; want: %q", pattern, got, want)
+		}
+	}
+}
+
+func TestScopedContextPatterns(t *testing.T) {
+	// Use GOPATH mode so that the import paths are resolved without
+	// invoking the go command.
+	t.Setenv("GO111MODULE", "off")
+
+	gopath := t.TempDir()
+	modpkg := filepath.Join(gopath, "src", "modpkg")
+	writeFile(t, filepath.Join(modpkg, "main.go"), "package main\n")
+	writeFile(t, filepath.Join(modpkg, "internal", "p", "p.go"), "package p\n")
+	writeFile(t, filepath.Join(modpkg, "internal", "q", "q.go"), "package q\n")
+	writeFile(t, filepath.Join(gopath, "src", "other", "other.go"), "package other\n")
+
+	orig := util.CopyContext(&build.Default)
+	orig.GOPATH = gopath
+	orig.Dir = modpkg
+
+	for _, patterns := range [][]string{
+		{"modpkg/internal/p/..."},
+		{"modpkg/internal/p"},
+		{"./internal/p/..."},
+		{"./internal/p"},
+		{filepath.Join(modpkg, "internal", "p") + "/..."},
+	} {
+		ctxt, err := ScopedContextPatterns(orig, patterns...)
+		if err != nil {
+			t.Errorf("%q: %v", patterns, err)
+			continue
+		}
+		testReadDir(t, ctxt, filepath.Join(gopath, "src"), "modpkg")
+		testReadDir(t, ctxt, modpkg, "internal")
+		testReadDir(t, ctxt, filepath.Join(modpkg, "internal"), "p")
+	}
+
+	// Partial path element
+	ctxt, err := ScopedContextPatterns(orig, "./internal/p...")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testReadDir(t, ctxt, filepath.Join(modpkg, "internal"), "p", "q")
+
+	for _, pattern := range []string{"...", "missing/..."} {
+		if _, err := ScopedContextPatterns(orig, pattern); err == nil {
+			t.Errorf("ScopedContextPatterns(%q): expected error", pattern)
+		}
+	}
+}
+
+func TestScopedContextForFile(t *testing.T) {
+	tempdir := t.TempDir()
+	gopath := filepath.Join(tempdir, "gopath")
+	pkg := filepath.Join(gopath, "src", "a", "pkg")
+	writeFile(t, filepath.Join(pkg, "pkg.go"), "package pkg\n")
+	writeFile(t, filepath.Join(gopath, "src", "a", "other", "other.go"), "package other\n")
+	mod := filepath.Join(tempdir, "mod")
+	writeFile(t, filepath.Join(mod, "go.mod"), "module mod\n")
+	writeFile(t, filepath.Join(mod, "sub", "sub.go"), "package sub\n")
+	writeFile(t, filepath.Join(mod, "other", "other.go"), "package other\n")
+
+	orig := util.CopyContext(&build.Default)
+	orig.GOPATH = gopath
+
+	for _, name := range []string{
+		filepath.Join(pkg, "pkg.go"),
+		filepath.Join(pkg, "unsaved.go"), // file does not exist
+		pkg,
+	} {
+		ctxt, err := ScopedContextForFile(orig, name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		testReadDir(t, ctxt, filepath.Join(gopath, "src", "a"), "pkg")
+		testReadDir(t, ctxt, pkg, "pkg.go")
+	}
+
+	// Relative to orig.Dir
+	orig.Dir = pkg
+	ctxt, err := ScopedContextForFile(orig, "pkg.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testReadDir(t, ctxt, filepath.Join(gopath, "src", "a"), "pkg")
+	orig.Dir = ""
+
+	// The entire module is in scope
+	ctxt, err = ScopedContextForFile(orig, filepath.Join(mod, "sub", "sub.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testReadDir(t, ctxt, mod, "go.mod", "other", "sub")
+	testReadDir(t, ctxt, filepath.Join(mod, "other"), "other.go")
+
+	// A nil Context is the same as build.Default
+	ctxt, err = ScopedContextForFile(nil, filepath.Join(mod, "sub", "sub.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testReadDir(t, ctxt, mod, "go.mod", "other", "sub")
+
+	name := filepath.Join(tempdir, "missing", "file.go")
+	if _, err := ScopedContextForFile(orig, name); err == nil {
+		t.Errorf("ScopedContextForFile(%q): expected error", name)
+	}
+}
+
+func TestScopedContext_Workspace(t *testing.T) {
+	tempdir := t.TempDir()
+	ws := filepath.Join(tempdir, "ws")
+	writeFile(t, filepath.Join(ws, "go.work"), "go 1.18\n\nuse (\n\t./a\n\t./b\n)\n")
+	writeFile(t, filepath.Join(ws, "a", "go.mod"),
+		"module a\n\nreplace example.com/c => ../../c\n")
+	writeFile(t, filepath.Join(ws, "a", "a.go"), "package a\n")
+	writeFile(t, filepath.Join(ws, "b", "go.mod"), "module b\n")
+	writeFile(t, filepath.Join(ws, "b", "b.go"), "package b\n")
+	writeFile(t, filepath.Join(ws, "d", "d.go"), "package d\n")
+	writeFile(t, filepath.Join(tempdir, "c", "go.mod"), "module example.com/c\n")
+	writeFile(t, filepath.Join(tempdir, "c", "c.go"), "package c\n")
+
+	orig := util.CopyContext(&build.Default)
+	orig.GOPATH = filepath.Join(tempdir, "gopath")
+	ctxt, err := ScopedContext(orig, filepath.Join(ws, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testReadDir(t, ctxt, filepath.Join(ws, "a"), "a.go", "go.mod")
+	testReadDir(t, ctxt, filepath.Join(ws, "b"), "b.go", "go.mod")
+	testReadDir(t, ctxt, filepath.Join(tempdir, "c"), "c.go", "go.mod")
+
+	dir := filepath.Join(ws, "d")
+	if _, err := ctxt.ReadDir(dir); !os.IsNotExist(err) {
+		t.Errorf("ReadDir(%q): want IsNotExist error got: %v", dir, err)
+	}
+}
+
+func TestScopedContext_SymlinkChain(t *testing.T) {
+	switch runtime.GOOS {
+	case "windows", "plan9":
+		t.Skip("skipping: test requires symlinks")
+	}
+	tempdir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopath := filepath.Join(tempdir, "go")
+	pkgDir := filepath.Join(gopath, "src", "a", "b", "pkg")
+	writeFile(t, filepath.Join(pkgDir, "pkg.go"), "package pkg\n")
+	writeFile(t, filepath.Join(gopath, "src", "a", "b", "other", "other.go"), "package other\n")
+	writeFile(t, filepath.Join(gopath, "src", "a", "c", "c.go"), "package c\n")
+
+	// links/link2 => links/link1 => $GOPATH/src/a
+	links := filepath.Join(tempdir, "links")
+	if err := os.MkdirAll(links, 0755); err != nil {
+		t.Fatal(err)
+	}
+	link1 := filepath.Join(links, "link1")
+	if err := os.Symlink(filepath.Join(gopath, "src", "a"), link1); err != nil {
+		t.Fatal(err)
+	}
+	link2 := filepath.Join(links, "link2")
+	if err := os.Symlink(link1, link2); err != nil {
+		t.Fatal(err)
+	}
+
+	orig := util.CopyContext(&build.Default)
+	orig.GOPATH = gopath
+	ctxt, err := ScopedContext(orig, pkgDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, link := range []string{link1, link2} {
+		testReadDir(t, ctxt, link, "b")
+		testReadDir(t, ctxt, filepath.Join(link, "b"), "pkg")
+		testReadDir(t, ctxt, filepath.Join(link, "b", "pkg"), "pkg.go")
+	}
+
+	// Symlinked GOPATH
+	gopathLink := filepath.Join(links, "gopath")
+	if err := os.Symlink(gopath, gopathLink); err != nil {
+		t.Fatal(err)
+	}
+	orig.GOPATH = gopathLink
+	ctxt, err = ScopedContext(orig, filepath.Join(gopathLink, "src", "a", "b", "pkg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testReadDir(t, ctxt, filepath.Join(gopath, "src"), "a")
+	testReadDir(t, ctxt, filepath.Join(gopath, "src", "a"), "b")
+	testReadDir(t, ctxt, filepath.Join(link2, "b"), "pkg")
+}
+
+// Test that the lazily initialized state of a ScopedContext is safe for
+// concurrent use (run with -race).
+func TestScopedContext_Concurrent(t *testing.T) {
+	switch runtime.GOOS {
+	case "windows", "plan9":
+		t.Skip("skipping: test requires symlinks")
+	}
+	tempdir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopath := filepath.Join(tempdir, "go")
+	pkgDir := filepath.Join(gopath, "src", "a", "b", "pkg")
+	writeFile(t, filepath.Join(pkgDir, "pkg.go"), "package pkg\n")
+	writeFile(t, filepath.Join(gopath, "src", "a", "c", "c.go"), "package c\n")
+	link := filepath.Join(tempdir, "link")
+	if err := os.Symlink(filepath.Join(gopath, "src", "a"), link); err != nil {
+		t.Fatal(err)
+	}
+
+	orig := util.CopyContext(&build.Default)
+	orig.GOPATH = gopath
+	ctxt, err := ScopedContext(orig, pkgDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for i := 0; i < 20; i++ {
+				testReadDir(t, ctxt, filepath.Join(gopath, "src"), "a")
+				testReadDir(t, ctxt, link, "b")
+				testReadDir(t, ctxt, filepath.Join(link, "b"), "pkg")
+				testReadDir(t, ctxt, pkgDir, "pkg.go")
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+}
+
+func TestScopedContextOptions(t *testing.T) {
+	tempdir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopath := filepath.Join(tempdir, "go")
+	pkgDir := filepath.Join(gopath, "src", "a", "b", "pkg")
+	outDir := filepath.Join(gopath, "src", "a", "c")
+	writeFile(t, filepath.Join(pkgDir, "pkg.go"), "package pkg\n")
+	writeFile(t, filepath.Join(outDir, "c.go"), "package c\n")
+
+	orig := util.CopyContext(&build.Default)
+	orig.GOPATH = gopath
+
+	tests := []struct {
+		policy OutOfScopePolicy
+		want   []string // nil if an error is expected
+	}{
+		{OutOfScopeNotExist, nil},
+		{OutOfScopeEmptyDir, []string{}},
+		{OutOfScopePassThrough, []string{"c.go"}},
+	}
+	for _, test := range tests {
+		ctxt, err := ScopedContextOptions(orig, &ScopeOptions{OutOfScope: test.policy}, pkgDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// In scope directories are not affected by the policy
+		testReadDir(t, ctxt, filepath.Join(gopath, "src", "a"), "b")
+		testReadDir(t, ctxt, pkgDir, "pkg.go")
+
+		fis, err := ctxt.ReadDir(outDir)
+		if test.want == nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("%d: ReadDir(%q) = %v, %v; want: %v", test.policy, outDir,
+					fis, err, fs.ErrNotExist)
+			}
+		} else {
+			if err != nil {
+				t.Fatalf("%d: ReadDir(%q): %v", test.policy, outDir, err)
+			}
+			names := make([]string, 0, len(fis))
+			for _, fi := range fis {
+				names = append(names, fi.Name())
+			}
+			if !reflect.DeepEqual(names, test.want) {
+				t.Errorf("%d: ReadDir(%q) = %q; want: %q", test.policy, outDir,
+					names, test.want)
+			}
+		}
+
+		// Directories that do not exist are always an error
+		missing := filepath.Join(gopath, "src", "a", "missing")
+		if _, err := ctxt.ReadDir(missing); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%d: ReadDir(%q) = %v; want: %v", test.policy, missing,
+				err, fs.ErrNotExist)
+		}
+	}
+}
+
+func TestScopedContextOptions_SkipDir(t *testing.T) {
+	orig := NewFakeContext(FakeFiles(map[string]string{
+		"/work/mod/go.mod":                "module mod",
+		"/work/mod/mod.go":                "package mod",
+		"/work/mod/.git/HEAD":             "ref: refs/heads/main",
+		"/work/mod/testdata/x.go":         "package x",
+		"/work/mod/node_modules/x/x.js":   "",
+		"/work/mod/internal/p/p.go":       "package p",
+		"/work/mod/internal/p/testdata/a": "",
+	}))
+	opts := &ScopeOptions{
+		SkipDir: func(name string) bool {
+			return strings.HasPrefix(name, ".") || name == "testdata" ||
+				name == "node_modules"
+		},
+	}
+	ctxt, err := ScopedContextOptions(orig, opts, "/work/mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][]string{
+		"/work/mod":            {"go.mod", "internal", "mod.go"},
+		"/work/mod/internal/p": {"p.go"},
+	}
+	for dir, want := range tests {
+		fis, err := ctxt.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("ReadDir(%q) = %q; want: %q", dir, names, want)
+		}
+	}
+}
+
+func TestScopedContextOptions_MaxDepth(t *testing.T) {
+	orig := NewFakeContext(FakeFiles(map[string]string{
+		"/work/mod/go.mod":           "module mod",
+		"/work/mod/mod.go":           "package mod",
+		"/work/mod/a/a.go":           "package a",
+		"/work/mod/a/b/b.go":         "package b",
+		"/work/mod/a/b/c/c.go":       "package c",
+		"/goroot/src/fmt/fmt.go":     "package fmt",
+		"/goroot/src/fmt/a/b/c/c.go": "package c",
+	}))
+	opts := &ScopeOptions{MaxDepth: 1, OutOfScope: OutOfScopeEmptyDir}
+	ctxt, err := ScopedContextOptions(orig, opts, "/work/mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][]string{
+		"/work/mod":             {"a", "go.mod", "mod.go"},
+		"/work/mod/a":           {"a.go"},
+		"/work/mod/a/b":         nil,
+		"/work/mod/a/b/c":       nil,
+		"/goroot/src/fmt/a/b":   {"c"}, // GOROOT is never limited
+		"/goroot/src/fmt/a/b/c": {"c.go"},
+	}
+	for dir, want := range tests {
+		fis, err := ctxt.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("ReadDir(%q) = %q; want: %q", dir, names, want)
+		}
+	}
+}
+
+func TestScopedContextOptions_Module(t *testing.T) {
+	orig := NewFakeContext(FakeFiles(map[string]string{
+		"/gopath/src/github.com/x/mod/go.mod":     "module github.com/x/mod",
+		"/gopath/src/github.com/x/mod/mod.go":     "package mod",
+		"/gopath/src/github.com/x/mod/a/a.go":     "package a",
+		"/gopath/src/github.com/x/mod/b/b.go":     "package b",
+		"/gopath/src/github.com/x/other/other.go": "package other",
+		"/gopath/src/github.com/y/y.go":           "package y",
+	}))
+	pkgDir := "/gopath/src/github.com/x/mod/a"
+
+	tests := []struct {
+		module bool
+		want   map[string][]string
+	}{
+		{
+			module: false,
+			want: map[string][]string{
+				"/gopath/src/github.com":         {"x"},
+				"/gopath/src/github.com/x":       {"mod"},
+				"/gopath/src/github.com/x/mod":   {"a"},
+				"/gopath/src/github.com/x/mod/a": {"a.go"},
+			},
+		},
+		{
+			module: true,
+			want: map[string][]string{
+				"/gopath/src/github.com":         {"x"},
+				"/gopath/src/github.com/x":       {"mod"},
+				"/gopath/src/github.com/x/mod":   {"a", "b", "go.mod", "mod.go"},
+				"/gopath/src/github.com/x/mod/a": {"a.go"},
+				"/gopath/src/github.com/x/mod/b": {"b.go"},
+			},
+		},
+	}
+	for _, test := range tests {
+		opts := &ScopeOptions{Module: test.module, OutOfScope: OutOfScopeEmptyDir}
+		ctxt, err := ScopedContextOptions(orig, opts, pkgDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for dir, want := range test.want {
+			fis, err := ctxt.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, fi := range fis {
+				names = append(names, fi.Name())
+			}
+			if !reflect.DeepEqual(names, want) {
+				t.Errorf("Module=%t: ReadDir(%q) = %q; want: %q", test.module, dir, names, want)
+			}
+		}
+	}
+}
+
+func TestScope(t *testing.T) {
+	orig := NewFakeContext(FakeFiles(map[string]string{
+		"/gopath/src/github.com/x/p/p.go":     "package p",
+		"/gopath/src/github.com/x/p/sub/s.go": "package sub",
+		"/gopath/src/github.com/x/q/q.go":     "package q",
+		"/gopath/src/github.com/y/y.go":       "package y",
+		"/work/mod/go.mod":                    "module mod",
+		"/work/mod/a/a.go":                    "package a",
+		"/work/other/o.go":                    "package o",
+		"/goroot/src/fmt/fmt.go":              "package fmt",
+	}))
+	pkgdirs := []string{"/gopath/src/github.com/x/p", "/work/mod/a"}
+	opts := &ScopeOptions{OutOfScope: OutOfScopeEmptyDir}
+
+	scope, err := NewScope(orig, opts, pkgdirs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteScope(&buf, scope); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadScope(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, scope) {
+		t.Errorf("ReadScope() = %+v; want: %+v", loaded, scope)
+	}
+	ctxt, err := loaded.Context(orig, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ScopedContextOptions(orig, opts, pkgdirs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{
+		"/gopath",
+		"/gopath/src",
+		"/gopath/src/github.com",
+		"/gopath/src/github.com/x",
+		"/gopath/src/github.com/x/p/sub",
+		"/gopath/src/github.com/y",
+		"/work",
+		"/work/mod",
+		"/work/other",
+		"/goroot/src",
+	} {
+		got, err1 := ctxt.ReadDir(dir)
+		exp, err2 := want.ReadDir(dir)
+		if (err1 != nil) != (err2 != nil) {
+			t.Errorf("ReadDir(%q): err = %v; want: %v", dir, err1, err2)
+			continue
+		}
+		var gotNames, expNames []string
+		for _, fi := range got {
+			gotNames = append(gotNames, fi.Name())
+		}
+		for _, fi := range exp {
+			expNames = append(expNames, fi.Name())
+		}
+		if !reflect.DeepEqual(gotNames, expNames) {
+			t.Errorf("ReadDir(%q) = %q; want: %q", dir, gotNames, expNames)
+		}
+	}
+
+	other := *orig
+	other.GOPATH = "/other/gopath"
+	if _, err := loaded.Context(&other, opts); err != ErrScopeMismatch {
+		t.Errorf("Context: err = %v; want: %v", err, ErrScopeMismatch)
+	}
+}
+
 func TestScopedContext_Parallel(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Short test")
@@ -747,38 +1514,6 @@ func TestInGopath(t *testing.T) {
 	}
 }
 
-func TestSortUniqueStrings(t *testing.T) {
-	tests := []struct {
-		in, exp []string
-	}{
-		{nil, nil},
-		{[]string{}, []string{}},
-		{[]string{"a"}, []string{"a"}},
-		{[]string{"a", "a", "z"}, []string{"a", "z"}},
-		{[]string{"b", "a", "a", "b"}, []string{"a", "b"}},
-	}
-	equal := func(s1, s2 []string) bool {
-		if len(s1) != len(s2) {
-			return false
-		}
-		for i := range s1 {
-			if s1[i] != s2[i] {
-				return false
-			}
-		}
-		return true
-	}
-	for _, tt := range tests {
-		got := sortUniqueStrings(append([]string(nil), tt.in...))
-		if !equal(got, tt.exp) {
-			t.Errorf("sortUniqueStrings(%q) = %q; want %q", tt.in, got, tt.exp)
-		}
-		if !sort.StringsAreSorted(got) {
-			t.Errorf("result %q is not sorted!", got)
-		}
-	}
-}
-
 func TestReadSubdirs(t *testing.T) {
 	ctxt := util.CopyContext(&build.Default)
 
@@ -788,10 +1523,11 @@ func TestReadSubdirs(t *testing.T) {
 			t.Fatal(err)
 		}
 	}
+	// The result is sorted regardless of the order of subdirs
 	subdirs := []string{
-		filepath.Join(tmp, "a"),
 		filepath.Join(tmp, "b"),
 		filepath.Join(tmp, "c"), // does not exist
+		filepath.Join(tmp, "a"),
 	}
 	dirnames := make(map[string]struct{})
 	for _, dir := range subdirs {
@@ -817,6 +1553,35 @@ func TestReadSubdirs(t *testing.T) {
 
 	ctxt.ReadDir = readdir.ReadDir
 	test(readSubdirs(ctxt, subdirs, dirnames))
+
+	// ReadDir functions that do not sort
+	ctxt.ReadDir = func(dir string) ([]fs.FileInfo, error) {
+		fis, err := readdir.ReadDir(dir)
+		for i, j := 0, len(fis)-1; i < j; i, j = i+1, j-1 {
+			fis[i], fis[j] = fis[j], fis[i]
+		}
+		return fis, err
+	}
+	test(readSubdirs(ctxt, subdirs, dirnames))
+
+	// ReadDir functions that return a cached slice, which must not be
+	// modified.
+	cached, err := readdir.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached[0], cached[len(cached)-1] = cached[len(cached)-1], cached[0]
+	want := append([]fs.FileInfo(nil), cached...)
+	ctxt.ReadDir = func(dir string) ([]fs.FileInfo, error) {
+		return cached, nil
+	}
+	test(readSubdirs(ctxt, subdirs, dirnames))
+	if _, err := readDir(ctxt, tmp); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cached, want) {
+		t.Error("ReadDir result was modified")
+	}
 }
 
 type SubdirTest struct {
@@ -839,6 +1604,8 @@ var subdirTests = []SubdirTest{
 	{Root: "/a/b", Dir: "/a/b/c//", Rel: "c", Ok: true},
 	{Root: "/a/b", Dir: "/a/b/"},
 	{Root: "/a/b", Dir: "/a/b"},
+	{Root: "/", Dir: "/a", Rel: "a", Ok: true},
+	{Root: "/", Dir: "/a/b", Rel: "a/b", Ok: true},
 	{Root: "", Dir: ""},
 	{Root: "/", Dir: ""},
 	{Root: "", Dir: "/"},
@@ -0,0 +1,194 @@
+package contextutil
+
+import (
+	"errors"
+	"go/build"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/charlievieth/buildutil/internal/fsys"
+)
+
+// A FakeFile is a file, directory or symbolic link in the file tree of
+// a Context created by NewFakeContext.
+type FakeFile struct {
+	// Data is the content of a regular file or the target of a symbolic
+	// link. It is ignored for directories.
+	Data string
+
+	// Mode is the mode of the file. The zero value is a regular file with
+	// permissions 0644. Directories and symbolic links are specified with
+	// fs.ModeDir and fs.ModeSymlink, respectively.
+	Mode fs.FileMode
+}
+
+// FakeFiles returns a file tree for NewFakeContext with a regular file for
+// each entry of files, which maps file names to contents.
+func FakeFiles(files map[string]string) map[string]FakeFile {
+	m := make(map[string]FakeFile, len(files))
+	for name, data := range files {
+		m[name] = FakeFile{Data: data}
+	}
+	return m
+}
+
+// NewFakeContext returns a build.Context for the in-memory file tree files,
+// which maps slash-separated absolute file names to files. Parent
+// directories are created implicitly and empty directories may be created
+// with a FakeFile of mode fs.ModeDir.
+//
+// Unlike buildutil.FakeContext from golang.org/x/tools, the file tree is
+// not limited to packages in the GOROOT, which allows for modeling modules,
+// nested modules and workspaces (go.mod and go.work files are regular files).
+// The fake Context has a GOROOT of "/goroot" and a GOPATH of "/gopath",
+// either of which may be changed by the caller.
+//
+// The fake Context overrides the IsAbsPath, IsDir, ReadDir and OpenFile
+// functions of the Context. Symbolic links are followed by all of them,
+// but ReadDir reports them as links (like ioutil.ReadDir).
+func NewFakeContext(files map[string]FakeFile) *build.Context {
+	fake := newFakeFS(files)
+
+	ctxt := build.Default // copy
+	ctxt.GOROOT = "/goroot"
+	ctxt.GOPATH = "/gopath"
+	ctxt.Compiler = "gc"
+	ctxt.IsAbsPath = func(name string) bool {
+		// Don't rely on the default (filepath.IsAbs) since on
+		// Windows, it reports virtual paths as non-absolute.
+		return strings.HasPrefix(filepath.ToSlash(name), "/")
+	}
+	ctxt.IsDir = func(name string) bool {
+		f, _, err := fake.lookup(name)
+		return err == nil && f.Mode.IsDir()
+	}
+	ctxt.ReadDir = fake.readDir
+	ctxt.OpenFile = fake.openFile
+	return &ctxt
+}
+
+type fakeFS struct {
+	files map[string]*FakeFile // cleaned name => file
+	dirs  map[string][]string  // cleaned name => sorted names of children
+}
+
+var errIsDir = errors.New("is a directory")
+
+func cleanFakeName(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+func newFakeFS(files map[string]FakeFile) *fakeFS {
+	fake := &fakeFS{
+		files: map[string]*FakeFile{"/": {Mode: fs.ModeDir | 0755}},
+		dirs:  make(map[string][]string),
+	}
+	for name, f := range files {
+		name = cleanFakeName(name)
+		if name == "/" {
+			continue
+		}
+		f := f // copy
+		if f.Mode.Perm() == 0 {
+			switch f.Mode.Type() {
+			case fs.ModeDir:
+				f.Mode |= 0755
+			case fs.ModeSymlink:
+				f.Mode |= 0777
+			default:
+				f.Mode |= 0644
+			}
+		}
+		fake.files[name] = &f
+	}
+	names := make([]string, 0, len(fake.files))
+	for name := range fake.files {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		for name != "/" {
+			dir := path.Dir(name)
+			fake.dirs[dir] = append(fake.dirs[dir], path.Base(name))
+			if _, ok := fake.files[dir]; ok {
+				break
+			}
+			fake.files[dir] = &FakeFile{Mode: fs.ModeDir | 0755}
+			name = dir
+		}
+	}
+	for _, names := range fake.dirs {
+		sort.Strings(names)
+	}
+	return fake
+}
+
+// lookup returns the file named by name, following any symbolic links,
+// and the resolved name of the file.
+func (fake *fakeFS) lookup(name string) (*FakeFile, string, error) {
+	resolved, err := fsys.Resolve("open", cleanFakeName(name), true, func(name string) (string, bool) {
+		f, ok := fake.files[name]
+		if !ok {
+			return "", false
+		}
+		if f.Mode.Type() == fs.ModeSymlink {
+			return f.Data, true
+		}
+		return "", true
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return fake.files[resolved], resolved, nil
+}
+
+func (fake *fakeFS) readDir(name string) ([]fs.FileInfo, error) {
+	f, resolved, err := fake.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if !f.Mode.IsDir() {
+		// Replicate the behavior of ioutil.ReadDir
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	names := fake.dirs[resolved]
+	fis := make([]fs.FileInfo, len(names))
+	for i, base := range names {
+		fis[i] = &fakeFileInfo{name: base, file: fake.files[path.Join(resolved, base)]}
+	}
+	return fis, nil
+}
+
+func (fake *fakeFS) openFile(name string) (io.ReadCloser, error) {
+	f, _, err := fake.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if f.Mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+	}
+	return ioutil.NopCloser(strings.NewReader(f.Data)), nil
+}
+
+type fakeFileInfo struct {
+	name string
+	file *FakeFile
+}
+
+func (fi *fakeFileInfo) Name() string { return fi.name }
+func (fi *fakeFileInfo) Size() int64 {
+	if fi.file.Mode.IsRegular() {
+		return int64(len(fi.file.Data))
+	}
+	return 0
+}
+func (fi *fakeFileInfo) Mode() fs.FileMode  { return fi.file.Mode }
+func (fi *fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *fakeFileInfo) IsDir() bool        { return fi.file.Mode.IsDir() }
+func (fi *fakeFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,121 @@
+package contextutil
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/charlievieth/buildutil/internal/fsys"
+)
+
+func TestNewFakeContext(t *testing.T) {
+	ctxt := NewFakeContext(map[string]FakeFile{
+		"/ws/go.work":            {Data: "go 1.18\n\nuse (\n\t./a\n\t./b\n)\n"},
+		"/ws/a/go.mod":           {Data: "module a\n"},
+		"/ws/a/a.go":             {Data: "package a\n"},
+		"/ws/a/nested/go.mod":    {Data: "module a/nested\n"},
+		"/ws/a/nested/n.go":      {Data: "package nested\n"},
+		"/ws/b/go.mod":           {Data: "module b\n"},
+		"/ws/b/run.sh":           {Data: "#!/bin/sh\n", Mode: 0755},
+		"/ws/b/empty":            {Mode: fs.ModeDir},
+		"/ws/link":               {Data: "a/nested", Mode: fs.ModeSymlink},
+		"/ws/loop":               {Data: "loop", Mode: fs.ModeSymlink},
+		"/goroot/src/fmt/fmt.go": {Data: "package fmt\n"},
+	})
+
+	readDir := func(dir string) map[string]fs.FileMode {
+		t.Helper()
+		fis, err := ctxt.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string]fs.FileMode, len(fis))
+		for _, fi := range fis {
+			m[fi.Name()] = fi.Mode()
+		}
+		return m
+	}
+
+	tests := map[string]map[string]fs.FileMode{
+		"/": {
+			"goroot": fs.ModeDir | 0755,
+			"ws":     fs.ModeDir | 0755,
+		},
+		"/ws": {
+			"a":       fs.ModeDir | 0755,
+			"b":       fs.ModeDir | 0755,
+			"go.work": 0644,
+			"link":    fs.ModeSymlink | 0777,
+			"loop":    fs.ModeSymlink | 0777,
+		},
+		"/ws/b": {
+			"empty":  fs.ModeDir | 0755,
+			"go.mod": 0644,
+			"run.sh": 0755,
+		},
+		"/ws/b/empty": {},
+		"/ws/link": {
+			"go.mod": 0644,
+			"n.go":   0644,
+		},
+	}
+	for dir, want := range tests {
+		if got := readDir(dir); !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadDir(%q) = %v; want: %v", dir, got, want)
+		}
+	}
+
+	for name, want := range map[string]bool{
+		"/":            true,
+		"/ws/a":        true,
+		"/ws/link":     true,
+		"/ws/a/a.go":   false,
+		"/ws/missing":  false,
+		"/ws/loop":     false,
+		"/goroot/src":  true,
+		"/ws/b/empty":  true,
+		"/ws/b/run.sh": false,
+	} {
+		if got := ctxt.IsDir(name); got != want {
+			t.Errorf("IsDir(%q) = %t; want: %t", name, got, want)
+		}
+	}
+
+	rc, err := ctxt.OpenFile("/ws/link/go.mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "module a/nested\n" {
+		t.Errorf("OpenFile(%q) = %q; want: %q", "/ws/link/go.mod", data, "module a/nested\n")
+	}
+
+	if _, err := ctxt.OpenFile("/ws/missing.go"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("OpenFile(%q) = %v; want: %v", "/ws/missing.go", err, fs.ErrNotExist)
+	}
+	if _, err := ctxt.OpenFile("/ws/a"); err == nil {
+		t.Errorf("OpenFile(%q): expected error for directory", "/ws/a")
+	}
+	if _, err := ctxt.ReadDir("/ws/a/a.go"); err == nil {
+		t.Errorf("ReadDir(%q): expected error for file", "/ws/a/a.go")
+	}
+	if _, err := ctxt.ReadDir("/ws/loop"); !errors.Is(err, fsys.ErrTooManyLinks) {
+		t.Errorf("ReadDir(%q) = %v; want: %v", "/ws/loop", err, fsys.ErrTooManyLinks)
+	}
+
+	// Test that the fake Context works with the functions of this package.
+	dir, err := ContainingDirectory(ctxt, "/ws/a/nested/n.go", "", "go.work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir = filepath.ToSlash(dir); dir != "/ws" {
+		t.Errorf("ContainingDirectory = %q; want: %q", dir, "/ws")
+	}
+}
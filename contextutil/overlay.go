@@ -0,0 +1,221 @@
+package contextutil
+
+import (
+	"bytes"
+	"go/build"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/tools/go/buildutil"
+)
+
+// OverlayContext returns a copy of orig that uses the file contents of
+// overlay, which maps absolute file names to their contents, instead of the
+// files on disk (e.g. the unsaved buffers of an editor). A nil value means
+// the file has been deleted. The file system functions of the Context are
+// overridden as follows:
+//
+//   - OpenFile returns the overlay contents of a file, if any.
+//   - ReadDir includes the overlay files of the directory, and any
+//     directories that only exist in the overlay, and omits deleted files.
+//     Directories that only exist in the overlay may be read.
+//   - IsDir reports that directories that only exist in the overlay are
+//     directories.
+//
+// All other files are accessed using the functions of orig, if set. The
+// overlay must not be modified after OverlayContext is called.
+func OverlayContext(orig *build.Context, overlay map[string][]byte) *build.Context {
+	return newOverlayFS(orig, overlay, nil).context()
+}
+
+// ScopedOverlayContext returns an OverlayContext on top of a scoped Context
+// (see ScopedContextOptions), which is the combination used by editors that
+// have unsaved files. The ReadDir function of the returned Context merges
+// the overlay files into the scoped listings, but only the files that are in
+// scope, so the overlay does not widen the scope. OpenFile always prefers
+// the overlay contents.
+//
+// The package directories pkgdirs may be directories that only exist in the
+// overlay (e.g. a new package that has not been saved).
+func ScopedOverlayContext(orig *build.Context, overlay map[string][]byte, opts *ScopeOptions, pkgdirs ...string) (*build.Context, error) {
+	// Use the overlay when computing the scope so that directories, and
+	// go.mod files, that only exist in the overlay are found.
+	scope, err := NewScope(OverlayContext(orig, overlay), opts, pkgdirs...)
+	if err != nil {
+		return nil, err
+	}
+	return newOverlayFS(scope.context(orig, opts), overlay, scope.contains).context(), nil
+}
+
+// contains reports if name is within the GOROOT, a module root or a package
+// directory of the Scope, or is an ancestor of a package directory.
+func (s *Scope) contains(name string) bool {
+	for _, a := range [][]string{s.Goroots, s.Modules, s.PkgDirs} {
+		for _, p := range a {
+			if p == name || isSubdir(p, name) {
+				return true
+			}
+		}
+	}
+	for _, p := range s.Dirs[filepath.Dir(name)] {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// An overlayEntry is a file or an implicit directory of an overlay.
+type overlayEntry struct {
+	data    []byte
+	dir     bool // directory that contains overlay files
+	deleted bool // file was deleted
+}
+
+type overlayFS struct {
+	orig  *build.Context
+	files map[string]*overlayEntry            // cleaned name => file or directory
+	dirs  map[string]map[string]*overlayEntry // cleaned dir => base name => entry
+}
+
+// newOverlayFS returns an overlayFS for overlay. If listed is not nil only
+// the files and directories for which it returns true are merged into the
+// listings of ReadDir.
+func newOverlayFS(orig *build.Context, overlay map[string][]byte, listed func(name string) bool) *overlayFS {
+	o := &overlayFS{
+		orig:  orig,
+		files: make(map[string]*overlayEntry, len(overlay)),
+		dirs:  make(map[string]map[string]*overlayEntry),
+	}
+	add := func(name string, e *overlayEntry) {
+		if listed != nil && !listed(name) {
+			return
+		}
+		dir := filepath.Dir(name)
+		m := o.dirs[dir]
+		if m == nil {
+			m = make(map[string]*overlayEntry)
+			o.dirs[dir] = m
+		}
+		m[filepath.Base(name)] = e
+	}
+	for name, data := range overlay {
+		name = filepath.Clean(name)
+		e := &overlayEntry{data: data, deleted: data == nil}
+		o.files[name] = e
+		add(name, e)
+	}
+	// Create the implicit parent directories of the files
+	for name, e := range o.files {
+		if e.deleted || e.dir {
+			continue
+		}
+		for dir := filepath.Dir(name); ; dir = filepath.Dir(dir) {
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			if d, ok := o.files[dir]; ok && d.dir {
+				break // already added
+			}
+			d := &overlayEntry{dir: true}
+			o.files[dir] = d
+			add(dir, d)
+		}
+	}
+	return o
+}
+
+func (o *overlayFS) context() *build.Context {
+	ctxt := *o.orig // copy
+	ctxt.OpenFile = o.openFile
+	ctxt.ReadDir = o.readDir
+	ctxt.IsDir = o.isDir
+	return &ctxt
+}
+
+func (o *overlayFS) openFile(name string) (io.ReadCloser, error) {
+	if e, ok := o.files[filepath.Clean(name)]; ok && !e.dir {
+		if e.deleted {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		return ioutil.NopCloser(bytes.NewReader(e.data)), nil
+	}
+	if fn := o.orig.OpenFile; fn != nil {
+		return fn(name)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (o *overlayFS) isDir(name string) bool {
+	if e, ok := o.files[filepath.Clean(name)]; ok && e.dir {
+		return true
+	}
+	return buildutil.IsDir(o.orig, name)
+}
+
+func (o *overlayFS) readDir(dir string) ([]fs.FileInfo, error) {
+	entries := o.dirs[filepath.Clean(dir)]
+	fis, err := readDir(o.orig, dir)
+	if err != nil {
+		// The directory may only exist in the overlay
+		if len(entries) == 0 || !os.IsNotExist(err) {
+			return fis, err
+		}
+		fis = nil
+	}
+	if len(entries) == 0 {
+		return fis, nil
+	}
+	// The slice returned by orig may be cached so do not modify it.
+	seen := make(map[string]bool, len(fis))
+	a := make([]fs.FileInfo, 0, len(fis)+len(entries))
+	for _, fi := range fis {
+		name := fi.Name()
+		seen[name] = true
+		if e, ok := entries[name]; ok && !e.dir {
+			if !e.deleted {
+				a = append(a, &overlayFileInfo{name: name, size: int64(len(e.data))})
+			}
+			continue
+		}
+		a = append(a, fi)
+	}
+	for name, e := range entries {
+		if seen[name] || e.deleted {
+			continue
+		}
+		if e.dir {
+			a = append(a, &overlayFileInfo{name: name, dir: true})
+		} else {
+			a = append(a, &overlayFileInfo{name: name, size: int64(len(e.data))})
+		}
+	}
+	return sortFileInfos(a), nil
+}
+
+type overlayFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (fi *overlayFileInfo) Name() string { return fi.name }
+func (fi *overlayFileInfo) Size() int64  { return fi.size }
+func (fi *overlayFileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+func (fi *overlayFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *overlayFileInfo) IsDir() bool        { return fi.dir }
+func (fi *overlayFileInfo) Sys() interface{}   { return nil }
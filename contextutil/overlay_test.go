@@ -0,0 +1,185 @@
+package contextutil
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func readDirNames(t *testing.T, readDir func(string) ([]os.FileInfo, error), dir string) []string {
+	t.Helper()
+	fis, err := readDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir(%q): %v", dir, err)
+	}
+	names := make([]string, len(fis))
+	for i, fi := range fis {
+		names[i] = fi.Name()
+		if fi.IsDir() {
+			names[i] += "/"
+		}
+	}
+	return names
+}
+
+func TestOverlayContext(t *testing.T) {
+	orig := NewFakeContext(FakeFiles(map[string]string{
+		"/gopath/src/p/a.go": "package p\n",
+		"/gopath/src/p/b.go": "package p\n",
+		"/gopath/src/p/c.go": "package p\n",
+	}))
+	ctxt := OverlayContext(orig, map[string][]byte{
+		"/gopath/src/p/a.go":      []byte("package p // edited\n"),
+		"/gopath/src/p/new.go":    []byte("package p // new\n"),
+		"/gopath/src/p/c.go":      nil, // deleted
+		"/gopath/src/p/q/q.go":    []byte("package q\n"),
+		"/gopath/src/p/../r/r.go": []byte("package r\n"),
+	})
+
+	t.Run("OpenFile", func(t *testing.T) {
+		for name, want := range map[string]string{
+			"/gopath/src/p/a.go":   "package p // edited\n",
+			"/gopath/src/p/b.go":   "package p\n",
+			"/gopath/src/p/new.go": "package p // new\n",
+			"/gopath/src/r/r.go":   "package r\n",
+		} {
+			rc, err := ctxt.OpenFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != want {
+				t.Errorf("OpenFile(%q) = %q; want: %q", name, data, want)
+			}
+		}
+		if _, err := ctxt.OpenFile("/gopath/src/p/c.go"); !os.IsNotExist(err) {
+			t.Errorf("OpenFile(%q): want: IsNotExist error got: %v", "/gopath/src/p/c.go", err)
+		}
+	})
+
+	t.Run("ReadDir", func(t *testing.T) {
+		tests := map[string][]string{
+			"/gopath/src":   {"p/", "r/"},
+			"/gopath/src/p": {"a.go", "b.go", "new.go", "q/"},
+			"/gopath/src/q": nil,
+			"/gopath/src/r": {"r.go"},
+		}
+		for dir, want := range tests {
+			if want == nil {
+				if _, err := ctxt.ReadDir(dir); !os.IsNotExist(err) {
+					t.Errorf("ReadDir(%q): want: IsNotExist error got: %v", dir, err)
+				}
+				continue
+			}
+			got := readDirNames(t, ctxt.ReadDir, dir)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ReadDir(%q) = %q; want: %q", dir, got, want)
+			}
+		}
+		fis, err := ctxt.ReadDir("/gopath/src/p")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi := fis[0]; fi.Size() != int64(len("package p // edited\n")) {
+			t.Errorf("ReadDir: %s: Size() = %d; want: %d", fi.Name(), fi.Size(),
+				len("package p // edited\n"))
+		}
+	})
+
+	// The slice returned by the ReadDir function of orig may be cached and
+	// must not be modified.
+	t.Run("CachedReadDir", func(t *testing.T) {
+		cached, err := orig.ReadDir("/gopath/src/p")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := append([]os.FileInfo(nil), cached...)
+		orig := *orig
+		orig.ReadDir = func(string) ([]os.FileInfo, error) { return cached, nil }
+		ctxt := OverlayContext(&orig, map[string][]byte{
+			"/gopath/src/p/a.go": nil, // deleted
+			"/gopath/src/p/b.go": []byte("package p // edited\n"),
+		})
+		if _, err := ctxt.ReadDir("/gopath/src/p"); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(cached, want) {
+			t.Error("ReadDir: modified the slice returned by the original ReadDir")
+		}
+	})
+
+	t.Run("IsDir", func(t *testing.T) {
+		for name, want := range map[string]bool{
+			"/gopath/src/p":      true,
+			"/gopath/src/p/q":    true,
+			"/gopath/src/r":      true,
+			"/gopath/src/p/a.go": false,
+			"/gopath/src/x":      false,
+		} {
+			if got := ctxt.IsDir(name); got != want {
+				t.Errorf("IsDir(%q) = %t; want: %t", name, got, want)
+			}
+		}
+	})
+}
+
+func TestScopedOverlayContext(t *testing.T) {
+	orig := NewFakeContext(FakeFiles(map[string]string{
+		"/gopath/src/modpkg/go.mod":  "module modpkg",
+		"/gopath/src/modpkg/main.go": "package main",
+		"/gopath/src/other/other.go": "package other",
+		"/goroot/src/fmt/print.go":   "package fmt",
+	}))
+	overlay := map[string][]byte{
+		"/gopath/src/modpkg/main.go":    []byte("package main // edited"),
+		"/gopath/src/modpkg/util.go":    []byte("package main"),
+		"/gopath/src/modpkg/sub/sub.go": []byte("package sub"),
+		"/gopath/src/newpkg/new.go":     []byte("package newpkg"),
+		"/gopath/src/other/extra.go":    []byte("package other"),
+		"/gopath/src/outside/out.go":    []byte("package outside"),
+		"/gopath/src/top.go":            []byte("package top"),
+	}
+	ctxt, err := ScopedOverlayContext(orig, overlay, nil, "/gopath/src/modpkg", "/gopath/src/newpkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]string{
+		"/gopath/src":        {"modpkg/", "newpkg/"},
+		"/gopath/src/modpkg": {"go.mod", "main.go", "sub/", "util.go"},
+		"/gopath/src/newpkg": {"new.go"},
+		"/goroot/src":        {"fmt/"},
+	}
+	for dir, want := range tests {
+		got := readDirNames(t, ctxt.ReadDir, dir)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadDir(%q) = %q; want: %q", dir, got, want)
+		}
+	}
+
+	// The overlay must not widen the scope
+	for _, dir := range []string{"/gopath/src/other", "/gopath/src/outside"} {
+		if _, err := ctxt.ReadDir(dir); !os.IsNotExist(err) {
+			t.Errorf("ReadDir(%q): want: IsNotExist error got: %v", dir, err)
+		}
+	}
+
+	rc, err := ctxt.OpenFile("/gopath/src/modpkg/main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "package main // edited" {
+		t.Errorf("OpenFile(%q) = %q; want: %q", "/gopath/src/modpkg/main.go", data,
+			"package main // edited")
+	}
+}
@@ -0,0 +1,235 @@
+package contextutil
+
+import (
+	"bytes"
+	"errors"
+	"go/build"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/tools/go/buildutil"
+)
+
+// Operations recorded by RecordContext.
+const (
+	OpReadDir  = "ReadDir"
+	OpOpenFile = "OpenFile"
+	OpIsDir    = "IsDir"
+)
+
+// A RecordedEntry is a directory entry returned by ReadDir.
+type RecordedEntry struct {
+	Name string      `json:"name"`
+	Mode fs.FileMode `json:"mode"`
+	Size int64       `json:"size,omitempty"`
+}
+
+// A RecordedCall is a file system call made through a Context returned by
+// RecordContext.
+type RecordedCall struct {
+	Op       string          `json:"op"`                  // OpReadDir, OpOpenFile or OpIsDir
+	Path     string          `json:"path"`                // path argument of the call
+	Err      string          `json:"err,omitempty"`       // error message, if any
+	NotExist bool            `json:"not_exist,omitempty"` // error is fs.ErrNotExist
+	IsDir    bool            `json:"is_dir,omitempty"`    // result of IsDir
+	Entries  []RecordedEntry `json:"entries,omitempty"`   // result of ReadDir
+	Data     []byte          `json:"data,omitempty"`      // bytes read from the opened file
+}
+
+// A Recording is a log of the file system calls made through a Context
+// returned by RecordContext. It can be encoded with encoding/json and
+// replayed with ReplayContext, which is useful for reproducing issues
+// (e.g. the wrong Context being matched to a file) without access to
+// the original file system.
+type Recording struct {
+	mu    sync.Mutex
+	Calls []RecordedCall `json:"calls"`
+}
+
+// Snapshot returns a copy of the calls recorded so far. Unlike accessing
+// Calls directly, it is safe to call while the recorded Context is in use.
+func (r *Recording) Snapshot() *Recording {
+	r.mu.Lock()
+	calls := make([]RecordedCall, len(r.Calls))
+	copy(calls, r.Calls)
+	r.mu.Unlock()
+	return &Recording{Calls: calls}
+}
+
+func (r *Recording) add(call RecordedCall) int {
+	r.mu.Lock()
+	r.Calls = append(r.Calls, call)
+	n := len(r.Calls) - 1
+	r.mu.Unlock()
+	return n
+}
+
+func (r *Recording) setData(i int, data []byte) {
+	r.mu.Lock()
+	r.Calls[i].Data = data
+	r.mu.Unlock()
+}
+
+func recordError(call *RecordedCall, err error) {
+	if err != nil {
+		call.Err = err.Error()
+		call.NotExist = errors.Is(err, fs.ErrNotExist)
+	}
+}
+
+// RecordContext returns a copy of orig with ReadDir, OpenFile and IsDir
+// functions that record every call, and its result, to the returned
+// Recording. Only the bytes read from opened files are recorded. The
+// functions of orig, if set, are used to access the file system.
+//
+// The returned Context is safe for concurrent use if orig is.
+func RecordContext(orig *build.Context) (*build.Context, *Recording) {
+	if orig == nil {
+		orig = &build.Default
+	}
+	rec := new(Recording)
+	ctxt := *orig // copy
+
+	ctxt.ReadDir = func(dir string) ([]fs.FileInfo, error) {
+		fis, err := readDir(orig, dir)
+		call := RecordedCall{Op: OpReadDir, Path: dir}
+		recordError(&call, err)
+		for _, fi := range fis {
+			call.Entries = append(call.Entries, RecordedEntry{
+				Name: fi.Name(),
+				Mode: fi.Mode(),
+				Size: fi.Size(),
+			})
+		}
+		rec.add(call)
+		return fis, err
+	}
+	ctxt.OpenFile = func(path string) (io.ReadCloser, error) {
+		var rc io.ReadCloser
+		var err error
+		if fn := orig.OpenFile; fn != nil {
+			rc, err = fn(path)
+		} else {
+			var f *os.File
+			f, err = os.Open(path)
+			if err == nil {
+				rc = f
+			}
+		}
+		call := RecordedCall{Op: OpOpenFile, Path: path}
+		recordError(&call, err)
+		i := rec.add(call)
+		if err != nil {
+			return nil, err
+		}
+		return &recordReader{rc: rc, rec: rec, index: i}, nil
+	}
+	ctxt.IsDir = func(path string) bool {
+		ok := buildutil.IsDir(orig, path)
+		rec.add(RecordedCall{Op: OpIsDir, Path: path, IsDir: ok})
+		return ok
+	}
+	return &ctxt, rec
+}
+
+type recordReader struct {
+	rc    io.ReadCloser
+	buf   bytes.Buffer
+	rec   *Recording
+	index int
+	once  sync.Once
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	r.buf.Write(p[:n])
+	return n, err
+}
+
+func (r *recordReader) Close() error {
+	r.once.Do(func() {
+		r.rec.setData(r.index, r.buf.Bytes())
+	})
+	return r.rc.Close()
+}
+
+type replayKey struct {
+	op, path string
+}
+
+// ReplayContext returns a copy of base with ReadDir, OpenFile and IsDir
+// functions that return the results recorded by rec. If a path was recorded
+// more than once the last result is used, except for opened files where the
+// longest read is used. Calls that were not recorded
+// return an error satisfying errors.Is(err, fs.ErrNotExist) (or false for
+// IsDir). Opened files contain only the bytes that were read when recorded.
+//
+// The returned Context does not access the file system and is safe for
+// concurrent use.
+func ReplayContext(base *build.Context, rec *Recording) *build.Context {
+	if base == nil {
+		base = &build.Default
+	}
+	calls := make(map[replayKey]*RecordedCall)
+	rec = rec.Snapshot()
+	for i := range rec.Calls {
+		c := &rec.Calls[i]
+		key := replayKey{c.Op, c.Path}
+		// Files may be read to different lengths so use the longest read
+		if p := calls[key]; p != nil && c.Op == OpOpenFile && c.Err == "" &&
+			p.Err == "" && len(p.Data) > len(c.Data) {
+			continue
+		}
+		calls[key] = c
+	}
+	replayError := func(op, path string, c *RecordedCall) error {
+		if c == nil || c.NotExist {
+			return &fs.PathError{Op: op, Path: path, Err: fs.ErrNotExist}
+		}
+		return &fs.PathError{Op: op, Path: path, Err: errors.New(c.Err)}
+	}
+
+	ctxt := *base // copy
+	ctxt.ReadDir = func(dir string) ([]fs.FileInfo, error) {
+		c := calls[replayKey{OpReadDir, dir}]
+		if c == nil || c.Err != "" {
+			return nil, replayError("open", dir, c)
+		}
+		fis := make([]fs.FileInfo, len(c.Entries))
+		for i := range c.Entries {
+			fis[i] = &replayFileInfo{&c.Entries[i]}
+		}
+		return fis, nil
+	}
+	ctxt.OpenFile = func(path string) (io.ReadCloser, error) {
+		c := calls[replayKey{OpOpenFile, path}]
+		if c == nil || c.Err != "" {
+			return nil, replayError("open", path, c)
+		}
+		return ioutil.NopCloser(bytes.NewReader(c.Data)), nil
+	}
+	ctxt.IsDir = func(path string) bool {
+		if c := calls[replayKey{OpIsDir, path}]; c != nil {
+			return c.IsDir
+		}
+		// The directory may have been read, but not checked
+		c := calls[replayKey{OpReadDir, path}]
+		return c != nil && c.Err == ""
+	}
+	return &ctxt
+}
+
+type replayFileInfo struct {
+	e *RecordedEntry
+}
+
+func (fi *replayFileInfo) Name() string       { return fi.e.Name }
+func (fi *replayFileInfo) Size() int64        { return fi.e.Size }
+func (fi *replayFileInfo) Mode() fs.FileMode  { return fi.e.Mode }
+func (fi *replayFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *replayFileInfo) IsDir() bool        { return fi.e.Mode.IsDir() }
+func (fi *replayFileInfo) Sys() interface{}   { return nil }
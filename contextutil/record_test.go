@@ -0,0 +1,134 @@
+package contextutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestRecordContext(t *testing.T) {
+	orig := NewFakeContext(FakeFiles(map[string]string{
+		"/work/mod/go.mod":  "module mod\n",
+		"/work/mod/main.go": "//go:build linux\n\npackage main\n\nfunc main() {}\n",
+		"/work/mod/a/a.go":  "package a\n",
+	}))
+	ctxt, rec := RecordContext(orig)
+
+	fis, err := ctxt.ReadDir("/work/mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctxt.ReadDir("/work/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("ReadDir: want: %v got: %v", fs.ErrNotExist, err)
+	}
+	rc, err := ctxt.OpenFile("/work/mod/main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, len("//go:build linux\n"))
+	if _, err := rc.Read(header); err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if !ctxt.IsDir("/work/mod/a") || ctxt.IsDir("/work/mod/go.mod") {
+		t.Fatal("IsDir: invalid result")
+	}
+
+	// Round trip through JSON
+	data, err := json.Marshal(rec.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec2 Recording
+	if err := json.Unmarshal(data, &rec2); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec2.Calls) != 5 {
+		t.Fatalf("Calls: got: %d want: %d", len(rec2.Calls), 5)
+	}
+
+	replay := ReplayContext(nil, &rec2)
+	fis2, err := replay.ReadDir("/work/mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != len(fis2) {
+		t.Fatalf("ReadDir: got: %d entries want: %d", len(fis2), len(fis))
+	}
+	for i := range fis {
+		if fis[i].Name() != fis2[i].Name() || fis[i].Mode() != fis2[i].Mode() ||
+			fis[i].IsDir() != fis2[i].IsDir() {
+			t.Errorf("ReadDir: %d: got: {%s %s} want: {%s %s}", i, fis2[i].Name(),
+				fis2[i].Mode(), fis[i].Name(), fis[i].Mode())
+		}
+	}
+	if _, err := replay.ReadDir("/work/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadDir: want: %v got: %v", fs.ErrNotExist, err)
+	}
+
+	// Only the bytes read are recorded
+	rc, err = replay.OpenFile("/work/mod/main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, header) {
+		t.Errorf("OpenFile: got: %q want: %q", got, header)
+	}
+	if _, err := replay.OpenFile("/work/mod/a/a.go"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("OpenFile: want: %v got: %v", fs.ErrNotExist, err)
+	}
+
+	for path, want := range map[string]bool{
+		"/work/mod/a":      true,
+		"/work/mod/go.mod": false,
+		"/work/mod":        true, // inferred from ReadDir
+		"/work/missing":    false,
+	} {
+		if got := replay.IsDir(path); got != want {
+			t.Errorf("IsDir(%q) = %t; want: %t", path, got, want)
+		}
+	}
+}
+
+func TestRecordContext_Concurrent(t *testing.T) {
+	orig := NewFakeContext(FakeFiles(map[string]string{
+		"/work/mod/go.mod": "module mod\n",
+	}))
+	ctxt, rec := RecordContext(orig)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				ctxt.IsDir("/work/mod")
+				if rc, err := ctxt.OpenFile("/work/mod/go.mod"); err == nil {
+					ioutil.ReadAll(rc)
+					rc.Close()
+				}
+				rec.Snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+	calls := rec.Snapshot().Calls
+	if len(calls) != 8*10*2 {
+		t.Fatalf("Calls: got: %d want: %d", len(calls), 8*10*2)
+	}
+	want := RecordedCall{Op: OpOpenFile, Path: "/work/mod/go.mod", Data: []byte("module mod\n")}
+	for _, c := range calls {
+		if c.Op == OpOpenFile && !reflect.DeepEqual(c, want) {
+			t.Fatalf("got: %+v want: %+v", c, want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package contextutil
+
+import (
+	"go/build"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A ProjectRootCache caches the results of FindProjectRoot by directory,
+// which avoids walking the parent directories of each file an editor opens
+// or saves. This is measurable on network file systems.
+//
+// Cached results expire after the TTL of the cache and must be invalidated,
+// with Invalidate, when a tombstone file is created or removed. The cache
+// holds at most MaxProjectRootCacheSize results, once full expired results
+// are removed and, if there are none, an arbitrary result is evicted. The
+// zero value is an empty cache whose results do not expire. A
+// ProjectRootCache is safe for concurrent use.
+type ProjectRootCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[rootCacheKey]rootCacheEntry
+	now     func() time.Time // for testing
+}
+
+// MaxProjectRootCacheSize is the maximum number of results held by a
+// ProjectRootCache.
+const MaxProjectRootCacheSize = 4096
+
+type rootCacheKey struct {
+	dir    string
+	goroot string
+	gopath string
+	extra  string // NUL separated extra tombstones
+}
+
+type rootCacheEntry struct {
+	root    string
+	err     error
+	expires time.Time
+}
+
+// NewProjectRootCache returns a new ProjectRootCache whose entries expire
+// after ttl. If ttl is less than or equal to zero entries do not expire.
+func NewProjectRootCache(ttl time.Duration) *ProjectRootCache {
+	return &ProjectRootCache{
+		ttl:     ttl,
+		entries: make(map[rootCacheKey]rootCacheEntry),
+		now:     time.Now,
+	}
+}
+
+// FindProjectRoot is like FindProjectRoot, but returns the cached result for
+// the directory of path, if any.
+func (c *ProjectRootCache) FindProjectRoot(ctxt *build.Context, path string, extra ...string) (string, error) {
+	dir, _, err := projectSearchPath(ctxt, path)
+	if err != nil {
+		return "", err
+	}
+	key := rootCacheKey{
+		dir:    dir,
+		goroot: ctxt.GOROOT,
+		gopath: ctxt.GOPATH,
+		extra:  strings.Join(extra, "\x00"),
+	}
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && (e.expires.IsZero() || c.timeNow().Before(e.expires)) {
+		return e.root, e.err
+	}
+
+	root, err := FindProjectRoot(ctxt, dir, extra...)
+	e = rootCacheEntry{root: root, err: err}
+	if c.ttl > 0 {
+		e.expires = c.timeNow().Add(c.ttl)
+	}
+	c.store(key, e)
+	return root, err
+}
+
+func (c *ProjectRootCache) timeNow() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+func (c *ProjectRootCache) store(key rootCacheKey, e rootCacheEntry) {
+	now := c.timeNow()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[rootCacheKey]rootCacheEntry)
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= MaxProjectRootCacheSize {
+		for k, old := range c.entries {
+			if !old.expires.IsZero() && !now.Before(old.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= MaxProjectRootCacheSize {
+			for k := range c.entries {
+				delete(c.entries, k)
+				break
+			}
+		}
+	}
+	c.entries[key] = e
+}
+
+// Invalidate removes the cached results that may be changed by the creation
+// or removal of the file or directory name, which must be absolute. These are
+// the results for name's parent directory and its sub-directories.
+func (c *ProjectRootCache) Invalidate(name string) {
+	dir := filepath.Dir(filepath.Clean(name))
+	c.mu.Lock()
+	for key := range c.entries {
+		if key.dir == dir || isSubdir(dir, key.dir) {
+			delete(c.entries, key)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// Reset removes all cached results.
+func (c *ProjectRootCache) Reset() {
+	c.mu.Lock()
+	c.entries = make(map[rootCacheKey]rootCacheEntry)
+	c.mu.Unlock()
+}
+
+// Len returns the number of cached results.
+func (c *ProjectRootCache) Len() int {
+	c.mu.Lock()
+	n := len(c.entries)
+	c.mu.Unlock()
+	return n
+}
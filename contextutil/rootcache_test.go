@@ -0,0 +1,100 @@
+package contextutil
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestProjectRootCache(t *testing.T) {
+	tempdir := t.TempDir()
+	writeFile(t, filepath.Join(tempdir, "repo", ".git", "HEAD"), "")
+	writeFile(t, filepath.Join(tempdir, "repo", "mod", "pkg", "pkg.go"), "package pkg\n")
+	ctxt := build.Default
+	ctxt.GOPATH = ""
+
+	now := time.Now()
+	c := NewProjectRootCache(time.Minute)
+	c.now = func() time.Time { return now }
+
+	pkg := filepath.Join(tempdir, "repo", "mod", "pkg")
+	find := func(want string) {
+		t.Helper()
+		got, err := c.FindProjectRoot(&ctxt, filepath.Join(pkg, "pkg.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("FindProjectRoot() = %q; want: %q", got, want)
+		}
+	}
+	repo := filepath.Join(tempdir, "repo")
+	mod := filepath.Join(repo, "mod")
+	find(repo)
+	if n := c.Len(); n != 1 {
+		t.Errorf("Len() = %d; want: %d", n, 1)
+	}
+
+	// The cached result is returned until it is invalidated or expires
+	writeFile(t, filepath.Join(mod, "go.mod"), "module mod\n")
+	find(repo)
+
+	c.Invalidate(filepath.Join(tempdir, "unrelated", "go.mod"))
+	find(repo)
+
+	c.Invalidate(filepath.Join(mod, "go.mod"))
+	if n := c.Len(); n != 0 {
+		t.Errorf("Len() = %d; want: %d", n, 0)
+	}
+	find(mod)
+
+	if err := os.Remove(filepath.Join(mod, "go.mod")); err != nil {
+		t.Fatal(err)
+	}
+	find(mod)
+	now = now.Add(time.Minute)
+	find(repo)
+
+	c.Reset()
+	if n := c.Len(); n != 0 {
+		t.Errorf("Len() = %d; want: %d", n, 0)
+	}
+}
+
+func TestProjectRootCache_ZeroValue(t *testing.T) {
+	tempdir := t.TempDir()
+	writeFile(t, filepath.Join(tempdir, "repo", ".git", "HEAD"), "")
+	ctxt := build.Default
+	ctxt.GOPATH = ""
+
+	var c ProjectRootCache
+	repo := filepath.Join(tempdir, "repo")
+	if got, err := c.FindProjectRoot(&ctxt, filepath.Join(repo, "a.go")); err != nil || got != repo {
+		t.Errorf("FindProjectRoot() = %q, %v; want: %q, %v", got, err, repo, nil)
+	}
+	if n := c.Len(); n != 1 {
+		t.Errorf("Len() = %d; want: %d", n, 1)
+	}
+}
+
+func TestProjectRootCache_MaxSize(t *testing.T) {
+	now := time.Now()
+	c := NewProjectRootCache(time.Minute)
+	c.now = func() time.Time { return now }
+	for i := 0; i < MaxProjectRootCacheSize+10; i++ {
+		c.store(rootCacheKey{dir: strconv.Itoa(i)}, rootCacheEntry{expires: now.Add(time.Minute)})
+	}
+	if n := c.Len(); n != MaxProjectRootCacheSize {
+		t.Errorf("Len() = %d; want: %d", n, MaxProjectRootCacheSize)
+	}
+
+	// Expired results are removed first
+	now = now.Add(time.Hour)
+	c.store(rootCacheKey{dir: "new"}, rootCacheEntry{expires: now.Add(time.Minute)})
+	if n := c.Len(); n != 1 {
+		t.Errorf("Len() = %d; want: %d", n, 1)
+	}
+}
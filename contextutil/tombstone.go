@@ -0,0 +1,120 @@
+package contextutil
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// A Tombstone is the name of a file or directory that marks the root of a
+// project. When the parents of a path contain different tombstones the
+// directory containing the tombstone with the highest Priority is the root.
+type Tombstone struct {
+	Name     string
+	Priority int
+}
+
+// Priorities of the DefaultTombstones. A go.work file beats a go.mod file,
+// which beats the root of a repository.
+const (
+	workspaceTombstonePriority = 30
+	moduleTombstonePriority    = 20
+	projectTombstonePriority   = 10
+)
+
+// DefaultTombstones returns the Tombstones used by FindProjectRootTombstones
+// when none are specified. These are the DefaultProjectTombstones with
+// go.work having the highest priority, followed by go.mod and then all
+// others, which typically mark the root of a repository.
+func DefaultTombstones() []Tombstone {
+	a := make([]Tombstone, len(DefaultProjectTombstones))
+	for i, name := range DefaultProjectTombstones {
+		a[i] = Tombstone{Name: name, Priority: projectTombstonePriority}
+		switch name {
+		case "go.work":
+			a[i].Priority = workspaceTombstonePriority
+		case "go.mod":
+			a[i].Priority = moduleTombstonePriority
+		}
+	}
+	return a
+}
+
+// FindProjectRootTombstones is like FindProjectRoot, but uses the priorities
+// of tombstones to resolve ambiguities between nested projects, such as a
+// module within a repository or workspace. Of the parent directories of path
+// that contain a tombstone, the one containing the tombstone with the highest
+// priority is returned. Ties are won by the innermost directory. For example,
+// using the DefaultTombstones the root of a workspace is returned for all of
+// the modules it contains.
+//
+// If tombstones is empty the DefaultTombstones are used.
+//
+// os.ErrNotExist is returned if the project directory was not found.
+func FindProjectRootTombstones(ctxt *build.Context, path string, tombstones ...Tombstone) (string, error) {
+	if len(tombstones) == 0 {
+		tombstones = DefaultTombstones()
+	} else {
+		tombstones = append([]Tombstone(nil), tombstones...)
+	}
+	// Check the tombstones in priority order so that the first tombstone
+	// found in a directory is the one with the highest priority.
+	sort.SliceStable(tombstones, func(i, j int) bool {
+		return tombstones[i].Priority > tombstones[j].Priority
+	})
+	names := make([]string, len(tombstones))
+	priority := make(map[string]int, len(tombstones))
+	for i, t := range tombstones {
+		names[i] = t.Name
+		if _, ok := priority[t.Name]; !ok {
+			priority[t.Name] = t.Priority
+		}
+	}
+
+	path, root, err := projectSearchPath(ctxt, path)
+	if err != nil {
+		return "", err
+	}
+	var found string
+	best := 0
+	err = walkContainingDirectories(ctxt, "contextutil: FindProjectRootTombstones",
+		path, root, names, func(dir, name string) bool {
+			if p := priority[name]; found == "" || p > best {
+				found = dir
+				best = p
+			}
+			// Nothing can beat the highest priority tombstone
+			return best < tombstones[0].Priority
+		})
+	if err != nil {
+		return "", err
+	}
+	if found == "" {
+		return path, os.ErrNotExist
+	}
+	return found, nil
+}
+
+// projectSearchPath returns the absolute directory of path, which can be a
+// file or a directory, and the GOROOT or GOPATH containing it, if any.
+func projectSearchPath(ctxt *build.Context, path string) (dir, root string, err error) {
+	dir, err = absPath(ctxt, path)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Allow path to be a file
+	if isFile(ctxt, dir) {
+		dir = filepath.Dir(dir)
+	}
+
+	// Find the GOROOT or GOPATH that is the parent of path, if any.
+	for _, p := range ctxt.SrcDirs() {
+		if isSubdir(p, dir) {
+			root = p
+			break
+		}
+	}
+	return dir, root, nil
+}
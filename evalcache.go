@@ -0,0 +1,139 @@
+package buildutil
+
+import (
+	"fmt"
+	"go/build"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxEvalCacheSize is the maximum number of results cached by the
+// evalCache. The cache is cleared once it reaches this size.
+const maxEvalCacheSize = 4096
+
+type evalCacheKey struct {
+	expr string // text of the //go:build line
+	ctxt string // fingerprint of the Context (see contextFingerprint)
+}
+
+type evalCacheEntry struct {
+	ok   bool
+	tags []string // tags consulted when evaluating the expression
+}
+
+// evalCache caches the result of evaluating //go:build constraints against
+// a Context. Functions that evaluate the files of a directory, or the same
+// files for many Contexts (e.g. BuildableFiles and PlatformFileSets), see
+// the same few constraints ("//go:build !windows") repeatedly and the cache
+// saves re-parsing and re-evaluating them.
+type evalCache struct {
+	mu sync.Mutex
+	m  map[evalCacheKey]evalCacheEntry
+}
+
+var constraintEvalCache evalCache
+
+func (c *evalCache) Load(key evalCacheKey) (evalCacheEntry, bool) {
+	c.mu.Lock()
+	e, ok := c.m[key]
+	c.mu.Unlock()
+	return e, ok
+}
+
+func (c *evalCache) Store(key evalCacheKey, e evalCacheEntry) {
+	c.mu.Lock()
+	if c.m == nil || len(c.m) >= maxEvalCacheSize {
+		c.m = make(map[evalCacheKey]evalCacheEntry)
+	}
+	c.m[key] = e
+	c.mu.Unlock()
+}
+
+func (c *evalCache) Len() int {
+	c.mu.Lock()
+	n := len(c.m)
+	c.mu.Unlock()
+	return n
+}
+
+func (c *evalCache) Reset() {
+	c.mu.Lock()
+	c.m = nil
+	c.mu.Unlock()
+}
+
+// contextFingerprint returns a string that identifies the fields of ctxt
+// that are consulted when matching build tags (see matchTag). Contexts with
+// the same fingerprint evaluate every build constraint the same.
+func contextFingerprint(ctxt *build.Context) string {
+	var b strings.Builder
+	write := func(s string) {
+		b.WriteString(s)
+		b.WriteByte(0)
+	}
+	writeList := func(a []string) {
+		write(strconv.Itoa(len(a)))
+		for _, s := range a {
+			write(s)
+		}
+	}
+	write(ctxt.GOOS)
+	write(ctxt.GOARCH)
+	write(ctxt.Compiler)
+	write(strconv.FormatBool(ctxt.CgoEnabled))
+	writeList(ctxt.BuildTags)
+	writeList(ctxt.ToolTags)
+	writeList(ctxt.ReleaseTags)
+	return b.String()
+}
+
+// A tagEvaluator evaluates the build constraints of files for a Context
+// using the constraintEvalCache. The Context must not be modified while it
+// is used by the tagEvaluator.
+type tagEvaluator struct {
+	ctxt *build.Context
+	key  string // fingerprint of ctxt
+}
+
+func newTagEvaluator(ctxt *build.Context) *tagEvaluator {
+	return &tagEvaluator{ctxt: ctxt, key: contextFingerprint(ctxt)}
+}
+
+// shouldBuild is the same as shouldBuild, but caches the result of
+// evaluating //go:build lines. Files without a //go:build line are not
+// cached.
+func (e *tagEvaluator) shouldBuild(content []byte, allTags map[string]bool) (bool, error) {
+	_, goBuild, _, err := parseFileHeader(content)
+	if err != nil {
+		return false, err
+	}
+	if goBuild == nil {
+		ok, _, err := shouldBuild(e.ctxt, content, allTags)
+		return ok, err
+	}
+	key := evalCacheKey{expr: string(goBuild), ctxt: e.key}
+	if ent, ok := constraintEvalCache.Load(key); ok {
+		if allTags != nil {
+			for _, tag := range ent.tags {
+				allTags[tag] = true
+			}
+		}
+		return ent.ok, nil
+	}
+	x, err := parseConstraint(key.expr)
+	if err != nil {
+		return false, fmt.Errorf("parsing //go:build line: %v", err)
+	}
+	tags := make(map[string]bool)
+	ok := eval(e.ctxt, x, tags)
+	ent := evalCacheEntry{ok: ok, tags: make([]string, 0, len(tags))}
+	for tag := range tags {
+		ent.tags = append(ent.tags, tag)
+		if allTags != nil {
+			allTags[tag] = true
+		}
+	}
+	constraintEvalCache.Store(key, ent)
+	return ok, nil
+}
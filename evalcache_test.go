@@ -0,0 +1,100 @@
+package buildutil
+
+import (
+	"go/build"
+	"reflect"
+	"testing"
+)
+
+func TestContextFingerprint(t *testing.T) {
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "amd64"
+	ctxt.ReleaseTags = []string{"go1.1", "go1.20"}
+
+	same := ctxt
+	same.GOPATH = "/go" // not consulted when matching tags
+	if contextFingerprint(&ctxt) != contextFingerprint(&same) {
+		t.Error("contextFingerprint: Contexts that only differ by GOPATH have different fingerprints")
+	}
+	for _, fn := range []func(c *build.Context){
+		func(c *build.Context) { c.GOOS = "windows" },
+		func(c *build.Context) { c.GOARCH = "arm64" },
+		func(c *build.Context) { c.Compiler = "gccgo" },
+		func(c *build.Context) { c.CgoEnabled = !c.CgoEnabled },
+		func(c *build.Context) { c.BuildTags = []string{"foo"} },
+		func(c *build.Context) { c.ToolTags = []string{"goexperiment.foo"} },
+		func(c *build.Context) { c.ReleaseTags = []string{"go1.1", "go1.20", "go1.21"} },
+		// The list boundaries are part of the fingerprint
+		func(c *build.Context) { c.BuildTags, c.ReleaseTags = []string{"go1.1"}, []string{"go1.20"} },
+	} {
+		c := ctxt
+		fn(&c)
+		if contextFingerprint(&ctxt) == contextFingerprint(&c) {
+			t.Errorf("contextFingerprint: %s/%s %q %q: same fingerprint as original",
+				c.GOOS, c.GOARCH, c.BuildTags, c.ReleaseTags)
+		}
+	}
+}
+
+func TestTagEvaluator(t *testing.T) {
+	constraintEvalCache.Reset()
+	t.Cleanup(constraintEvalCache.Reset)
+
+	go120 := build.Default
+	go120.GOOS = "linux"
+	go120.GOARCH = "amd64"
+	go120.CgoEnabled = true
+	go120.BuildTags = nil
+	go120.ToolTags = nil
+	go120.ReleaseTags = []string{"go1.1", "go1.20"}
+	go121 := go120
+	go121.ReleaseTags = []string{"go1.1", "go1.20", "go1.21"}
+	windows := go120
+	windows.GOOS = "windows"
+
+	headers := []string{
+		"package p\n",
+		"//go:build go1.21\n\npackage p\n",
+		"//go:build !windows && cgo\n\npackage p\n",
+		"// +build linux\n\npackage p\n",
+		"//go:build linux || (foo && !go1.21)\n\npackage p\n",
+	}
+	for _, ctxt := range []*build.Context{&go120, &go121, &windows} {
+		ev := newTagEvaluator(ctxt)
+		for _, header := range headers {
+			wantTags := make(map[string]bool)
+			want, _, err := shouldBuild(ctxt, []byte(header), wantTags)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Evaluate twice so that the second call is cached
+			for i := 0; i < 2; i++ {
+				tags := make(map[string]bool)
+				got, err := ev.shouldBuild([]byte(header), tags)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want || !reflect.DeepEqual(tags, wantTags) {
+					t.Errorf("%s/%s %q: %q: got: %t %v want: %t %v", ctxt.GOOS, ctxt.GOARCH,
+						ctxt.ReleaseTags, header, got, tags, want, wantTags)
+				}
+			}
+		}
+	}
+	// Only //go:build lines are cached: 3 lines for 3 Contexts
+	if n := constraintEvalCache.Len(); n != 9 {
+		t.Errorf("constraintEvalCache.Len() = %d; want: %d", n, 9)
+	}
+
+	// Invalid constraints are not cached
+	ev := newTagEvaluator(&go120)
+	for i := 0; i < 2; i++ {
+		if _, err := ev.shouldBuild([]byte("//go:build (\n\npackage p\n"), nil); err == nil {
+			t.Error("shouldBuild: expected an error for an invalid constraint")
+		}
+	}
+	if n := constraintEvalCache.Len(); n != 9 {
+		t.Errorf("constraintEvalCache.Len() = %d; want: %d", n, 9)
+	}
+}
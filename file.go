@@ -5,7 +5,10 @@
 package buildutil
 
 import (
+	"fmt"
 	"go/build"
+	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -52,3 +55,30 @@ func goodOSArchFile(ctxt *build.Context, name string, allTags map[string]bool) b
 	}
 	return true
 }
+
+// FilenameConstraintPattern returns a regular expression that matches the
+// names of Go source files that contain a $GOOS and/or $GOARCH suffix (see
+// GoodOSArchFile for the recognized name formats). The pattern is generated
+// from KnownOSList and KnownArchList so that tools do not need to construct
+// (and maintain) it themselves.
+//
+// The pattern only matches file names with a ".go" extension.
+func FilenameConstraintPattern() string {
+	join := func(a []string) string {
+		q := make([]string, len(a))
+		for i, s := range a {
+			q[i] = regexp.QuoteMeta(s)
+		}
+		return strings.Join(q, "|")
+	}
+	return fmt.Sprintf(`_((%[1]s)(_(%[2]s))?|(%[2]s))(_test)?\.go$`,
+		join(knownOSList), join(knownArchList))
+}
+
+// HasFilenameConstraint reports if the base name of path contains a $GOOS
+// and/or $GOARCH suffix that constrains the platforms it is built for.
+func HasFilenameConstraint(path string) bool {
+	tags := make(map[string]bool)
+	goodOSArchFile(&build.Context{}, filepath.Base(path), tags)
+	return len(tags) != 0
+}
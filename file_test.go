@@ -2,7 +2,9 @@ package buildutil
 
 import (
 	"go/build"
+	"path/filepath"
 	"reflect"
+	"regexp"
 	"runtime"
 	"testing"
 )
@@ -97,3 +99,33 @@ func BenchmarkGoodOSArch(b *testing.B) {
 		goodOSArchFile(&ctxt, benchmark[i%len(benchmark)], nil)
 	}
 }
+
+func TestFilenameConstraintPattern(t *testing.T) {
+	re := regexp.MustCompile(FilenameConstraintPattern())
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"file.go", false},
+		{"linux.go", false},
+		{"file_foo.go", false},
+		{"file_linux.go", true},
+		{"file_amd64.go", true},
+		{"file_linux_amd64.go", true},
+		{"file_linux_test.go", true},
+		{"file_amd64_test.go", true},
+		{"file_linux_amd64_test.go", true},
+		{"file_foo_wasm.go", true},
+		{"file_linux.c", false},
+	}
+	for _, x := range tests {
+		if got := re.MatchString(x.name); got != x.want {
+			t.Errorf("FilenameConstraintPattern: MatchString(%q) = %t; want: %t", x.name, got, x.want)
+		}
+		if x.want || filepath.Ext(x.name) == ".go" {
+			if got := HasFilenameConstraint(x.name); got != x.want {
+				t.Errorf("HasFilenameConstraint(%q) = %t; want: %t", x.name, got, x.want)
+			}
+		}
+	}
+}
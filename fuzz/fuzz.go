@@ -0,0 +1,84 @@
+// Package fuzz provides fuzz functions for the parsers of the buildutil
+// package in the format used by go-fuzz and OSS-Fuzz. The parsers are used
+// on untrusted input, such as the unsaved buffers of an editor, and must not
+// panic.
+//
+// Each function returns 1 if data was parsed successfully and 0 otherwise,
+// and panics if a parser violates one of its invariants. The native fuzz
+// targets used by "go test -fuzz" are in the buildutil package.
+package fuzz
+
+import (
+	"bytes"
+	"go/build"
+	"go/build/constraint"
+	"strings"
+
+	"github.com/charlievieth/buildutil"
+)
+
+// FuzzParseHeader fuzzes buildutil.ParseHeader.
+func FuzzParseHeader(data []byte) int {
+	h, err := buildutil.ParseHeader(data)
+	if err != nil && strings.HasPrefix(err.Error(), "buildutil: internal error") {
+		panic(err)
+	}
+	if h == nil {
+		if err == nil {
+			panic("ParseHeader returned a nil Header and error")
+		}
+		return 0
+	}
+	if h.Offsets.End > len(data) {
+		panic("ParseHeader: header end is out of range")
+	}
+	for _, r := range []buildutil.HeaderRange{h.Offsets.GoBuild, h.Offsets.PlusBuild} {
+		if r.Valid() && (r.Start > r.End || r.End > len(data)) {
+			panic("ParseHeader: invalid header range")
+		}
+	}
+	if err != nil {
+		return 0
+	}
+	if h.Constraint != nil {
+		if _, err := constraint.Parse("//go:build " + h.Constraint.String()); err != nil {
+			panic("ParseHeader: constraint does not round trip: " + err.Error())
+		}
+	}
+	return 1
+}
+
+// FuzzReadPackageName fuzzes buildutil.ReadPackageName.
+func FuzzReadPackageName(data []byte) int {
+	name, err := buildutil.ReadPackageName("fuzz.go", data)
+	if err != nil {
+		return 0
+	}
+	if name == "" {
+		panic("ReadPackageName returned an empty name")
+	}
+	return 1
+}
+
+// FuzzReadImports fuzzes buildutil.ReadImports.
+func FuzzReadImports(data []byte) int {
+	if _, _, err := buildutil.ReadImports("fuzz.go", data); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzParseConstraint fuzzes buildutil.ParseConstraint and the evaluation of
+// the parsed constraint.
+func FuzzParseConstraint(data []byte) int {
+	c, err := buildutil.ParseConstraint(&build.Default, "fuzz.go", data)
+	if err != nil {
+		return 0
+	}
+	c.Eval(&build.Default)
+	c.EvalAssumeTags(&build.Default)
+	if !c.Empty() && !bytes.Contains(data, []byte("build")) {
+		panic("ParseConstraint: constraint parsed from file without build lines")
+	}
+	return 1
+}
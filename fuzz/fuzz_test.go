@@ -0,0 +1,30 @@
+package fuzz
+
+import "testing"
+
+var seeds = []struct {
+	data string
+	ok   bool // FuzzParseHeader result
+}{
+	{"", true},
+	{"package p\n", true},
+	{"//go:build linux && amd64\n\npackage p\n", true},
+	{"// +build linux,amd64 !cgo\n\npackage p\n", true},
+	{"//go:build (\n\npackage p\n", false},
+	{"//go:build a\n//go:build b\n\npackage p\n", false},
+	{"package\x00p\n", false},
+	{"/*", true},
+}
+
+func TestFuzzFunctions(t *testing.T) {
+	for _, x := range seeds {
+		data := []byte(x.data)
+		if got := FuzzParseHeader(data); got != 0 != x.ok {
+			t.Errorf("FuzzParseHeader(%q) = %d; want ok: %t", x.data, got, x.ok)
+		}
+		// Only check that the functions do not panic.
+		FuzzReadPackageName(data)
+		FuzzReadImports(data)
+		FuzzParseConstraint(data)
+	}
+}
@@ -0,0 +1,134 @@
+//go:build go1.18
+// +build go1.18
+
+package buildutil
+
+import (
+	"bytes"
+	"errors"
+	"go/build/constraint"
+	"testing"
+)
+
+// fuzzSeeds are the initial corpus of the fuzz targets.
+var fuzzSeeds = []string{
+	"",
+	"package p",
+	"package p\n",
+	"// Copyright\n\npackage main\n\nimport \"fmt\"\n",
+	"//go:build linux && amd64\n\npackage p\n",
+	"// +build linux,amd64 !cgo\n\npackage p\n",
+	"//go:build !windows\n// +build !windows\n\npackage p\n",
+	"//go:build (\n\npackage p\n",
+	"/* comment */ package p; import (\"a\"; b \"b\")\n",
+	"//go:binary-only-package\n\npackage p\n",
+	"//go:build a\n//go:build b\n\npackage p\n",
+	"/*\n//go:build ignore\n*/\npackage p\n",
+	"package\x00p\n",
+	"// +build\n",
+	"/",
+	"//",
+	"/*",
+}
+
+func addFuzzSeeds(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s))
+	}
+}
+
+func FuzzReadPackageName(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, src []byte) {
+		name, err := readPackageName(src)
+		if err != nil {
+			return
+		}
+		if name == "" {
+			t.Fatalf("readPackageName(%q) = %q, nil; want non-empty name", src, name)
+		}
+		for i := 0; i < len(name); i++ {
+			if !isIdent(name[i]) {
+				t.Fatalf("readPackageName(%q) = %q: invalid identifier", src, name)
+			}
+		}
+	})
+}
+
+func FuzzParseFileHeader(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, src []byte) {
+		trimmed, goBuild, _, err := parseFileHeader(src)
+		if err != nil {
+			return
+		}
+		if !bytes.HasPrefix(src, trimmed) {
+			t.Fatalf("parseFileHeader(%q): trimmed %q is not a prefix", src, trimmed)
+		}
+		if goBuild != nil && !bytes.Contains(src, goBuild) {
+			t.Fatalf("parseFileHeader(%q): //go:build line %q not in src", src, goBuild)
+		}
+		h, err := ParseHeaderOffsets(src)
+		if err != nil {
+			t.Fatalf("ParseHeaderOffsets(%q) = %v; parseFileHeader succeeded", src, err)
+		}
+		for _, r := range []HeaderRange{h.GoBuild, h.PlusBuild} {
+			if r.Valid() && (r.Start > r.End || r.End > h.End && r == h.PlusBuild || r.End > len(src)) {
+				t.Fatalf("ParseHeaderOffsets(%q): invalid range %+v in %+v", src, r, h)
+			}
+		}
+	})
+}
+
+func FuzzReadGoInfo(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, src []byte) {
+		info := fileInfo{name: "fuzz.go"}
+		if err := readGoInfo(bytes.NewReader(src), &info); err != nil {
+			return
+		}
+		if !bytes.HasPrefix(src, info.header) {
+			t.Fatalf("readGoInfo(%q): header %q is not a prefix", src, info.header)
+		}
+	})
+}
+
+func FuzzParseBuildConstraint(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, src []byte) {
+		x, err := parseBuildConstraint(src)
+		if err != nil || x == nil {
+			return
+		}
+		// The expression must round trip.
+		y, err := constraint.Parse("//go:build " + x.String())
+		if err != nil {
+			t.Fatalf("parseBuildConstraint(%q) = %q: does not round trip: %v", src, x, err)
+		}
+		if x.String() != y.String() {
+			t.Fatalf("parseBuildConstraint(%q) = %q: round trip: %q", src, x, y)
+		}
+	})
+}
+
+func FuzzParseHeader(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, src []byte) {
+		h, err := parseHeader(src)
+		if errors.Is(err, errHeaderInternal) {
+			t.Fatalf("parseHeader(%q): %v", src, err)
+		}
+		if h == nil {
+			if err == nil {
+				t.Fatalf("parseHeader(%q) = nil, nil", src)
+			}
+			return
+		}
+		if h.Offsets.End > len(src) {
+			t.Fatalf("parseHeader(%q): End %d > len(src) %d", src, h.Offsets.End, len(src))
+		}
+		if r := h.Offsets.GoBuild; r.Valid() && !isGoBuildComment(bytes.TrimSpace(src[r.Start:r.End])) {
+			t.Fatalf("parseHeader(%q): GoBuild %+v is not a //go:build line", src, r)
+		}
+	})
+}
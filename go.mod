@@ -2,10 +2,7 @@ module github.com/charlievieth/buildutil
 
 go 1.17
 
-require (
-	github.com/charlievieth/reonce v1.2.1
-	golang.org/x/tools v0.1.13-0.20220805170418-06d96ee8fcfe
-)
+require golang.org/x/tools v0.1.13-0.20220805170418-06d96ee8fcfe
 
 require (
 	golang.org/x/mod v0.6.0-dev.0.20220419223038-86c51ed26bb4 // indirect
@@ -4,6 +4,7 @@ import (
 	"context"
 	"go/build"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/charlievieth/buildutil/internal/util"
@@ -13,15 +14,114 @@ import (
 // context.Context.  The Cmd's env is set to that of the Context. The args
 // contains a "-tags" flag it is updated to match the build constraints of
 // the Context otherwise the "-tags" are provided via the GOFLAGS env var.
+// The tags of the Context are merged with those already in the "-tags" flag
+// of the args or GOFLAGS, instead of replacing them, and take precedence
+// over them (see GoCommandTags).
+//
+// If the Context's Dir is set, the BuildTags of the ProjectConfigFile of the
+// project containing it, if any, are added to the tags of the Context and
+// the Cmd's Dir is set to it (see UseChdirFlag to use the "-C" flag instead).
 func GoCommandContext(ctx context.Context, ctxt *build.Context, name string, args ...string) *exec.Cmd {
+	return goCommandContext(ctx, ctxt, util.NewEnviron(), name, args...)
+}
+
+// GoCommandContextEnv is like GoCommandContext, but the Cmd's env is built
+// from env instead of os.Environ. This allows for reproducible commands that
+// do not inherit any variables from the current process. The env slice is
+// not modified.
+func GoCommandContextEnv(ctx context.Context, ctxt *build.Context, env []string, name string, args ...string) *exec.Cmd {
+	return goCommandContext(ctx, ctxt, util.NewEnvironFrom(env), name, args...)
+}
+
+// CommandContext is like GoCommandContext, but name may also be one of the
+// common analyzers that are not run through the go command. The build tags
+// of the Context are passed to them using their own flag, which must be
+// provided explicitly since they do not read the GOFLAGS environment
+// variable consistently:
+//
+//	golangci-lint  --build-tags
+//	staticcheck    -tags
+//
+// If args already contains the flag its value is merged with the tags of
+// the Context. Any other tool, including the go command, is handled the same
+// as GoCommandContext. The GOOS, GOARCH, CGO_ENABLED, GOPATH and GOEXPERIMENT
+// environment variables are set for all tools, and the Dir of the Context,
+// if any, is the working directory of the command.
+func CommandContext(ctx context.Context, ctxt *build.Context, name string, args ...string) *exec.Cmd {
+	return commandContext(ctx, ctxt, util.NewEnviron(), name, args...)
+}
+
+// A toolTagsFlag describes how a tool receives build tags.
+type toolTagsFlag struct {
+	flag       string // flag, including leading dashes
+	subcommand bool   // flags follow a subcommand (e.g. "golangci-lint run")
+}
+
+var toolTagsFlags = map[string]toolTagsFlag{
+	"golangci-lint": {flag: "--build-tags", subcommand: true},
+	"staticcheck":   {flag: "-tags"},
+}
+
+// toolName returns the name of the tool invoked by command name.
+func toolName(name string) string {
+	name = filepath.Base(name)
+	if ext := filepath.Ext(name); strings.EqualFold(ext, ".exe") {
+		name = name[:len(name)-len(ext)]
+	}
+	return name
+}
+
+func commandContext(ctx context.Context, ctxt *build.Context, e *util.Environ, name string, args ...string) *exec.Cmd {
+	tf, ok := toolTagsFlags[toolName(name)]
+	if !ok {
+		return goCommandContext(ctx, ctxt, e, name, args...)
+	}
+	ctxt = projectContext(setContextEnv(ctxt, e))
+	// Analyzers do not instrument code so sanitizer tags are passed
+	// as build tags.
+	tags := ctxt.BuildTags
+	if flags := sanitizerFlags(ctxt); len(flags) != 0 {
+		tags = util.DuplicateStrings(tags)
+		for _, flag := range flags {
+			tags = util.StringsAppend(tags, flag[1:])
+		}
+	}
+	if len(tags) != 0 {
+		flag := strings.TrimLeft(tf.flag, "-")
+		if existingTags := extractFlagValues(args, flag); len(existingTags) != 0 {
+			var ts TagSet
+			ts.Add(TagSourceArgs, existingTags...)
+			ts.Add(TagSourceEditor, tags...)
+			args = replaceFlagValues(args, flag, ts.Tags())
+		} else {
+			// Don't modify the caller's args
+			i := 0
+			if tf.subcommand && len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+				i = 1
+			}
+			a := make([]string, 0, len(args)+1)
+			a = append(a, args[:i]...)
+			a = append(a, tf.flag+"="+strings.Join(tags, ","))
+			args = append(a, args[i:]...)
+		}
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = e.Environ()
+	cmd.Dir = ctxt.Dir
+	return cmd
+}
+
+// setContextEnv sets the environment variables of e that configure the go
+// command for ctxt, which is returned or a copy of build.Default if nil.
+// Build tags are not set.
+func setContextEnv(ctxt *build.Context, e *util.Environ) *build.Context {
 	if ctxt == nil {
 		orig := build.Default
 		ctxt = &orig
 	}
 
-	e := util.NewEnviron()
 	e.Set("GOPATH", ctxt.GOPATH)
-	if s, _ := e.Lookup("GOROOT"); s != "" && s != ctxt.GOROOT {
+	if s, _ := e.Lookup("GOROOT"); s != "" && ctxt.GOROOT != "" && s != ctxt.GOROOT {
 		e.Set("GOROOT", ctxt.GOROOT)
 	}
 	if ctxt.GOOS != "" {
@@ -44,26 +144,48 @@ func GoCommandContext(ctx context.Context, ctxt *build.Context, name string, arg
 		}
 		e.Set("GOEXPERIMENT", strings.Join(a, ","))
 	}
+	return ctxt
+}
+
+func goCommandContext(ctx context.Context, ctxt *build.Context, e *util.Environ, name string, args ...string) *exec.Cmd {
+	ctxt = setContextEnv(ctxt, e)
+	conf := projectConfig(ctxt)
+	ts := commandTagSet(ctxt, conf, e, args)
+	if conf != nil {
+		ctxt = conf.context(ctxt)
+	}
 
-	if len(ctxt.BuildTags) != 0 {
+	var goflags []string
+	envFlags, _ := e.Lookup("GOFLAGS")
+	if len(userBuildTags(ctxt)) != 0 {
 		// Command line arguments take precedence over the GOFLAGS
 		// environment variable so we have to update the "-tags"
-		// argument, if provided.
-		existingTags := extractTagArgs(args)
-		if len(existingTags) != 0 {
-			args = replaceTagArgs(args, mergeTagArgs(existingTags, ctxt.BuildTags))
+		// argument, if provided. The tags of every source are merged
+		// so that none are silently overridden.
+		tags := withoutSanitizerTags(ts.Tags())
+		if len(ExtractTagArgs(args)) != 0 {
+			args = ReplaceTagArgs(args, tags)
 		} else {
-			if s, _ := e.Lookup("GOFLAGS"); s != "" {
-				// TODO: check if "-tags" is already defined
-				e.Set("GOFLAGS", s+" -tags="+strings.Join(ctxt.BuildTags, ","))
-			} else {
-				e.Set("GOFLAGS", "-tags="+strings.Join(ctxt.BuildTags, ","))
-			}
+			envFlags = removeGoFlag(envFlags, "tags")
+			goflags = append(goflags, "-tags="+strings.Join(tags, ","))
+		}
+	}
+	for _, flag := range sanitizerFlags(ctxt) {
+		if !hasFlag(args, flag[1:]) {
+			goflags = append(goflags, flag)
+		}
+	}
+	if len(goflags) != 0 {
+		if envFlags != "" {
+			e.Set("GOFLAGS", envFlags+" "+strings.Join(goflags, " "))
+		} else {
+			e.Set("GOFLAGS", strings.Join(goflags, " "))
 		}
 	}
 
 	cmd := exec.CommandContext(ctx, name, args...)
 	cmd.Env = e.Environ()
+	cmd.Dir = ctxt.Dir
 
 	return cmd
 
@@ -99,9 +221,9 @@ func GoCommandContext(ctx context.Context, ctxt *build.Context, name string, arg
 	// 	// Command line arguments take precedence over the GOFLAGS
 	// 	// environment variable so we have to update the "-tags"
 	// 	// argument, if provided.
-	// 	existingTags := extractTagArgs(args)
+	// 	existingTags := ExtractTagArgs(args)
 	// 	if len(existingTags) != 0 {
-	// 		args = replaceTagArgs(args, mergeTagArgs(existingTags, ctxt.BuildTags))
+	// 		args = ReplaceTagArgs(args, MergeTagArgs(existingTags, ctxt.BuildTags))
 	// 	} else {
 	// 		if s, _ := e.Lookup("GOFLAGS"); s != "" {
 	// 			// TODO: check if "-tags" is already defined
@@ -149,9 +271,9 @@ func GoCommandContext(ctx context.Context, ctxt *build.Context, name string, arg
 	// 	// Command line arguments take precedence over the GOFLAGS
 	// 	// environment variable so we have to update the "-tags"
 	// 	// argument, if provided.
-	// 	existingTags := extractTagArgs(args)
+	// 	existingTags := ExtractTagArgs(args)
 	// 	if len(existingTags) != 0 {
-	// 		args = replaceTagArgs(args, mergeTagArgs(existingTags, ctxt.BuildTags))
+	// 		args = ReplaceTagArgs(args, MergeTagArgs(existingTags, ctxt.BuildTags))
 	// 	} else {
 	// 		if s := m["GOFLAGS"]; s != "" {
 	// 			// TODO: check if "-tags" is already defined
@@ -173,6 +295,135 @@ func GoCommandContext(ctx context.Context, ctxt *build.Context, name string, arg
 	// return cmd
 }
 
+// GoCommandTags returns the TagSet of the build tags that GoCommand merges
+// for ctxt and args: the "-tags" flag of the GOFLAGS environment variable
+// and of args, the BuildTags of ctxt and the BuildTags of the
+// ProjectConfigFile of ctxt.Dir, if any. It allows callers to report the
+// Conflicts between these sources.
+func GoCommandTags(ctxt *build.Context, args ...string) *TagSet {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	return commandTagSet(ctxt, projectConfig(ctxt), util.NewEnviron(), args)
+}
+
+// commandTagSet returns the TagSet of the tags of the GOFLAGS of e, args,
+// ctxt and conf, which may be nil.
+func commandTagSet(ctxt *build.Context, conf *ProjectConfig, e *util.Environ, args []string) *TagSet {
+	ts := new(TagSet)
+	if s, _ := e.Lookup("GOFLAGS"); s != "" {
+		ts.AddGoFlags(TagSourceEnv, s)
+	}
+	ts.Add(TagSourceArgs, ExtractTagArgs(args)...)
+	ts.Add(TagSourceEditor, ctxt.BuildTags...)
+	if conf != nil {
+		ts.Add(TagSourceProject, conf.BuildTags...)
+	}
+	return ts
+}
+
+// removeGoFlag returns the value of a GOFLAGS environment variable without
+// the flag name.
+func removeGoFlag(goflags, name string) string {
+	fields := strings.Fields(goflags)
+	a := fields[:0]
+	for _, s := range fields {
+		if _, _, ok := isFlag(s, name); !ok {
+			a = append(a, s)
+		}
+	}
+	return strings.Join(a, " ")
+}
+
+// UseChdirFlag changes cmd, which must not have been started, to run the go
+// command in its Dir using the "-C" flag instead of the Dir of the Cmd, so
+// that the rendered command can be copied and run from any directory. The
+// "-C" flag was added in go1.20 and it is the caller's responsibility to
+// check that the go command supports it. UseChdirFlag reports if cmd was
+// changed, which is not the case if cmd does not run the go command, has no
+// Dir or already has a "-C" flag.
+func UseChdirFlag(cmd *exec.Cmd) bool {
+	if cmd.Dir == "" || len(cmd.Args) == 0 || toolName(cmd.Args[0]) != "go" ||
+		hasFlag(cmd.Args[1:], "C") {
+		return false
+	}
+	args := make([]string, 0, len(cmd.Args)+2)
+	args = append(args, cmd.Args[0], "-C", cmd.Dir)
+	cmd.Args = append(args, cmd.Args[1:]...)
+	cmd.Dir = ""
+	return true
+}
+
+// GoFlagsFor returns the value of the GOFLAGS environment variable that
+// applies the build.Context ctxt to the go command. Currently, this is the
+// "-tags" flag and the "-race", "-msan" and "-asan" flags, which are used
+// for the sanitizer tags ("race", "msan" and "asan") of the ToolTags (or
+// BuildTags) of ctxt, since the GOOS, GOARCH and CGO_ENABLED settings have
+// their own environment variables. An empty string is returned if ctxt
+// has no build or sanitizer tags.
+//
+// GoFlagsFor is useful for embedding a Context in an env file, devcontainer
+// or direnv configuration. It does not depend on the environment, unlike the
+// GOFLAGS set by GoCommand, which merges the tags of ctxt with those already
+// in the GOFLAGS environment variable and arguments of the command (see
+// GoCommandTags).
+func GoFlagsFor(ctxt *build.Context) string {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	flags := sanitizerFlags(ctxt)
+	if tags := userBuildTags(ctxt); len(tags) != 0 {
+		flags = append([]string{"-tags=" + strings.Join(tags, ",")}, flags...)
+	}
+	return strings.Join(flags, " ")
+}
+
+// userBuildTags returns the BuildTags of ctxt without any sanitizer tags.
+func userBuildTags(ctxt *build.Context) []string {
+	return withoutSanitizerTags(ctxt.BuildTags)
+}
+
+// withoutSanitizerTags returns tags without any sanitizer tags.
+func withoutSanitizerTags(tags []string) []string {
+	for _, tag := range tags {
+		if sanitizerTags[tag] {
+			a := make([]string, 0, len(tags))
+			for _, tag := range tags {
+				if !sanitizerTags[tag] {
+					a = append(a, tag)
+				}
+			}
+			return a
+		}
+	}
+	return tags
+}
+
+// sanitizerFlags returns the go command flags ("-race", "-msan" or "-asan")
+// for the sanitizer tags in the ToolTags or BuildTags of ctxt.
+func sanitizerFlags(ctxt *build.Context) []string {
+	var flags []string
+	for _, tag := range [...]string{"race", "msan", "asan"} {
+		if util.StringsContains(ctxt.ToolTags, tag) || util.StringsContains(ctxt.BuildTags, tag) {
+			flags = append(flags, "-"+tag)
+		}
+	}
+	return flags
+}
+
+// hasFlag reports if args contains the boolean flag name.
+func hasFlag(args []string, name string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if _, _, ok := isFlag(arg, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // GoCommand returns an exec.Cmd for the provided build.Context. The Cmd's
 // env is set to that of the Context. The args contains a "-tags" flag it
 // is updated to match the build constraints of the Context otherwise the
@@ -190,7 +441,10 @@ func envMap(a []string) map[string]string {
 	return m
 }
 
-func mergeTagArgs(old, new []string) []string {
+// MergeTagArgs merges build tags new into old, tags in new take precedence
+// over tags in old and a negated tag ("!tag") replaces its non-negated form
+// (and vice versa).
+func MergeTagArgs(old, new []string) []string {
 	if len(old) == 0 {
 		return new
 	}
@@ -211,26 +465,85 @@ Loop:
 	return append(args, new...)
 }
 
-func extractTagArgs(args []string) []string {
+// isFlag reports if arg is the flag name, with either one or two leading
+// dashes, and returns the flag's value if it was provided in the
+// "-name=value" form.
+func isFlag(arg, name string) (value string, hasValue, ok bool) {
+	if !strings.HasPrefix(arg, "-") {
+		return "", false, false
+	}
+	s := arg[1:]
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+	}
+	if s == name {
+		return "", false, true
+	}
+	if strings.HasPrefix(s, name+"=") {
+		return s[len(name)+1:], true, true
+	}
+	return "", false, false
+}
+
+// SplitTagArg splits the value of a "-tags" flag into a list of build tags.
+// Like the go command, tags are comma-separated unless the value contains
+// no commas, in which case the legacy space-separated form is used.
+func SplitTagArg(s string) []string {
+	if strings.Contains(s, ",") {
+		a := strings.Split(s, ",")
+		tags := a[:0]
+		for _, tag := range a {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+		return tags
+	}
+	return strings.Fields(s)
+}
+
+// ExtractTagArgs returns the build tags provided via the "-tags" flag in
+// args. Both the "-tags=a,b" and "-tags a,b" forms are supported. Parsing
+// stops at the first "--" argument. If there is no "-tags" flag nil is
+// returned.
+func ExtractTagArgs(args []string) []string {
+	return extractFlagValues(args, "tags")
+}
+
+// extractFlagValues returns the build tags provided via flag name in args.
+func extractFlagValues(args []string, name string) []string {
 	for i := 0; i < len(args); i++ {
-		switch s := args[i]; {
-		case s == "--":
+		s := args[i]
+		if s == "--" {
 			// stop parsing args
 			return nil
-		case s == "-tags":
-			if i < len(args)-1 {
-				return strings.Split(args[i+1], ",")
-			}
-			// invalid -tags argument (ignore)
-			return nil
-		case strings.HasPrefix(s, "-tags="):
-			return strings.Split(strings.TrimPrefix(s, "-tags="), ",")
 		}
+		value, hasValue, ok := isFlag(s, name)
+		if !ok {
+			continue
+		}
+		if hasValue {
+			return SplitTagArg(value)
+		}
+		if i < len(args)-1 {
+			return SplitTagArg(args[i+1])
+		}
+		// invalid -tags argument (ignore)
+		return nil
 	}
 	return nil
 }
 
-func replaceTagArgs(args, tags []string) []string {
+// ReplaceTagArgs returns a copy of args with the value of the first "-tags"
+// flag replaced with tags. Parsing stops at the first "--" argument. If args
+// does not contain a "-tags" flag the returned copy is unchanged.
+func ReplaceTagArgs(args, tags []string) []string {
+	return replaceFlagValues(args, "tags", tags)
+}
+
+// replaceFlagValues returns a copy of args with the value of the first flag
+// name replaced with tags.
+func replaceFlagValues(args []string, name string, tags []string) []string {
 	a := make([]string, len(args))
 	copy(a, args)
 	for i := 0; i < len(a); i++ {
@@ -238,16 +551,16 @@ func replaceTagArgs(args, tags []string) []string {
 		if s == "--" {
 			break // stop parsing args
 		}
-		if s == "-tags" {
-			if i < len(a)-1 {
-				a[i+1] = strings.Join(tags, ",")
-			}
-			break
+		value, hasValue, ok := isFlag(s, name)
+		if !ok {
+			continue
 		}
-		if strings.HasPrefix(s, "-tags=") {
-			a[i] = "-tags=" + strings.Join(tags, ",")
-			break
+		if hasValue {
+			a[i] = s[:len(s)-len(value)] + strings.Join(tags, ",")
+		} else if i < len(a)-1 {
+			a[i+1] = strings.Join(tags, ",")
 		}
+		break
 	}
 	return a
 }
@@ -15,6 +15,8 @@ import (
 	"sort"
 	"strings"
 	"testing"
+
+	"github.com/charlievieth/buildutil/buildutiltest"
 )
 
 var testGoCommandAll = flag.Bool("gocommand-all", false,
@@ -165,30 +167,18 @@ func TestGoCommandAll(t *testing.T) {
 }
 
 func createCommandTestFiles(t *testing.T) (dir, gopath string) {
-	tempdir := t.TempDir()
-	dirname := filepath.Join(tempdir, "go", "src", "pkg1")
-	if err := os.MkdirAll(dirname, 0755); err != nil {
-		t.Fatal(err)
-	}
-
 	platforms, err := LoadGoPlatforms()
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	files := make(map[string]string)
 	writeFile := func(name, content string) {
-		data := []byte(content)
-		if filepath.Ext(name) == ".go" {
-			b, err := format.Source([]byte(content))
-			if err != nil {
-				t.Fatal(err)
-			}
-			data = b
-		}
-		path := filepath.Join(dirname, name)
-		if err := os.WriteFile(path, data, 0644); err != nil {
+		b, err := format.Source([]byte(content))
+		if err != nil {
 			t.Fatal(err)
 		}
+		files["pkg1/"+name] = string(b)
 	}
 
 	const buildTag = "somebuildtag"
@@ -226,7 +216,270 @@ func createCommandTestFiles(t *testing.T) (dir, gopath string) {
 			p.GOOS, p.GOARCH, packageName)
 		writeFile(name, src)
 	}
-	return dirname, filepath.Join(tempdir, "go")
+	gopath = buildutiltest.NewGOPATH(t, files)
+	return filepath.Join(gopath, "src", "pkg1"), gopath
+}
+
+func TestGoCommandContextEnv(t *testing.T) {
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "arm64"
+	ctxt.GOPATH = "/go"
+	ctxt.CgoEnabled = false
+	ctxt.BuildTags = []string{"tag1"}
+	ctxt.ToolTags = nil
+
+	env := []string{"HOME=/home/user", "GOFLAGS=-mod=mod"}
+	cmd := GoCommandContextEnv(context.Background(), &ctxt, env, "go", "list")
+	want := map[string]string{
+		"HOME":        "/home/user",
+		"GOPATH":      "/go",
+		"GOOS":        "linux",
+		"GOARCH":      "arm64",
+		"CGO_ENABLED": "0",
+		"GOFLAGS":     "-mod=mod -tags=tag1",
+	}
+	if got := envMap(cmd.Env); !reflect.DeepEqual(got, want) {
+		t.Errorf("GoCommandContextEnv: Env = %q; want: %q", got, want)
+	}
+	if env[1] != "GOFLAGS=-mod=mod" {
+		t.Errorf("GoCommandContextEnv: modified env: %q", env)
+	}
+
+	// The tags of GOFLAGS are merged with those of the Context
+	env = []string{"GOFLAGS=-tags=tag0,tag1 -mod=mod"}
+	cmd = GoCommandContextEnv(context.Background(), &ctxt, env, "go", "list")
+	if got := envMap(cmd.Env)["GOFLAGS"]; got != "-mod=mod -tags=tag0,tag1" {
+		t.Errorf("GoCommandContextEnv: GOFLAGS = %q; want: %q", got, "-mod=mod -tags=tag0,tag1")
+	}
+	cmd = GoCommandContextEnv(context.Background(), &ctxt, env, "go", "list", "-tags", "tag2")
+	if want := []string{"go", "list", "-tags", "tag0,tag2,tag1"}; !reflect.DeepEqual(cmd.Args, want) {
+		t.Errorf("GoCommandContextEnv: Args = %q; want: %q", cmd.Args, want)
+	}
+
+	// An empty GOROOT does not clear the GOROOT of the environment
+	ctxt.GOROOT = ""
+	env = []string{"GOROOT=/usr/local/go"}
+	cmd = GoCommandContextEnv(context.Background(), &ctxt, env, "go", "list")
+	if got := envMap(cmd.Env)["GOROOT"]; got != "/usr/local/go" {
+		t.Errorf("GoCommandContextEnv: GOROOT = %q; want: %q", got, "/usr/local/go")
+	}
+}
+
+func TestGoCommandChdir(t *testing.T) {
+	ctxt := build.Default
+	ctxt.Dir = t.TempDir()
+	ctxt.BuildTags = nil
+
+	// The go command is not probed: the Dir of the Cmd is set unless the
+	// caller asks for the "-C" flag.
+	cmd := GoCommand(&ctxt, "go", "list")
+	if cmd.Dir != ctxt.Dir || len(cmd.Args) != 2 {
+		t.Errorf("GoCommand: Args = %q Dir = %q; want: [go list] %q", cmd.Args, cmd.Dir, ctxt.Dir)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+		dir  string
+		ok   bool
+	}{
+		{"go", []string{"list"}, []string{"go", "-C", ctxt.Dir, "list"}, "", true},
+		{"go", []string{"-C", "x", "list"}, []string{"go", "-C", "x", "list"}, ctxt.Dir, false},
+		{"gopls", []string{"check"}, []string{"gopls", "check"}, ctxt.Dir, false},
+	}
+	for _, x := range tests {
+		cmd := GoCommand(&ctxt, x.name, x.args...)
+		ok := UseChdirFlag(cmd)
+		if got := append([]string{filepath.Base(cmd.Args[0])}, cmd.Args[1:]...); !reflect.DeepEqual(got, x.want) {
+			t.Errorf("UseChdirFlag(%q, %q): Args = %q; want: %q", x.name, x.args, got, x.want)
+		}
+		if cmd.Dir != x.dir || ok != x.ok {
+			t.Errorf("UseChdirFlag(%q, %q) = %t: Dir = %q; want: %t %q", x.name, x.args, ok, cmd.Dir, x.ok, x.dir)
+		}
+	}
+
+	// The Dir is not changed if not set
+	c := ctxt
+	c.Dir = ""
+	cmd = GoCommand(&c, "go", "list")
+	if UseChdirFlag(cmd) || len(cmd.Args) != 2 || cmd.Dir != "" {
+		t.Errorf("GoCommand: Args = %q Dir = %q; want: [go list] \"\"", cmd.Args, cmd.Dir)
+	}
+
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found:", err)
+	}
+	c = ctxt
+	c.Dir = buildutiltest.NewModule(t, "example.com/m", nil)
+	out, err := GoCommand(&c, "go", "env", "GOMOD").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(c.Dir, "go.mod"); strings.TrimSpace(string(out)) != want {
+		t.Errorf("go env GOMOD = %q; want: %q", strings.TrimSpace(string(out)), want)
+	}
+}
+
+func TestCommandContext(t *testing.T) {
+	t.Setenv("GOFLAGS", "")
+
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "arm64"
+	ctxt.CgoEnabled = false
+	ctxt.BuildTags = []string{"tag1", "tag2"}
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		goflags bool // GOFLAGS contains the tags
+	}{
+		{
+			name: "golangci-lint",
+			args: []string{"run", "./..."},
+			want: []string{"run", "--build-tags=tag1,tag2", "./..."},
+		},
+		{
+			name: "/usr/bin/golangci-lint.exe",
+			args: []string{"--verbose", "run"},
+			want: []string{"--build-tags=tag1,tag2", "--verbose", "run"},
+		},
+		{
+			name: "golangci-lint",
+			args: []string{"run", "--build-tags", "tag3,!tag2", "./..."},
+			want: []string{"run", "--build-tags", "tag3,tag1,tag2", "./..."},
+		},
+		{
+			name: "staticcheck",
+			args: []string{"./..."},
+			want: []string{"-tags=tag1,tag2", "./..."},
+		},
+		{
+			name: "staticcheck",
+			args: []string{"-tags=tag3", "./..."},
+			want: []string{"-tags=tag3,tag1,tag2", "./..."},
+		},
+		{
+			name:    "go",
+			args:    []string{"vet", "./..."},
+			want:    []string{"vet", "./..."},
+			goflags: true,
+		},
+	}
+	for _, x := range tests {
+		args := append([]string(nil), x.args...)
+		cmd := CommandContext(context.Background(), &ctxt, x.name, args...)
+		if got := cmd.Args[1:]; !reflect.DeepEqual(got, x.want) {
+			t.Errorf("CommandContext(%q, %q) = %q; want: %q", x.name, x.args, got, x.want)
+		}
+		if !reflect.DeepEqual(args, x.args) {
+			t.Errorf("CommandContext(%q, %q): modified args: %q", x.name, x.args, args)
+		}
+		env := envMap(cmd.Env)
+		if env["GOOS"] != "linux" || env["GOARCH"] != "arm64" || env["CGO_ENABLED"] != "0" {
+			t.Errorf("CommandContext(%q, %q): invalid env: GOOS=%q GOARCH=%q CGO_ENABLED=%q",
+				x.name, x.args, env["GOOS"], env["GOARCH"], env["CGO_ENABLED"])
+		}
+		if hasTags := strings.Contains(env["GOFLAGS"], "-tags="); hasTags != x.goflags {
+			t.Errorf("CommandContext(%q, %q): GOFLAGS = %q", x.name, x.args, env["GOFLAGS"])
+		}
+	}
+
+	// Analyzers are run in the Dir of the Context
+	ctxt.Dir = "dir"
+	for _, name := range []string{"golangci-lint", "staticcheck"} {
+		if cmd := CommandContext(context.Background(), &ctxt, name); cmd.Dir != "dir" {
+			t.Errorf("CommandContext(%q).Dir = %q; want: %q", name, cmd.Dir, "dir")
+		}
+	}
+}
+
+func TestGoFlagsFor(t *testing.T) {
+	tests := []struct {
+		tags     []string
+		toolTags []string
+		want     string
+	}{
+		{nil, nil, ""},
+		{[]string{"tag1"}, nil, "-tags=tag1"},
+		{[]string{"tag1", "tag2"}, nil, "-tags=tag1,tag2"},
+		{nil, []string{"amd64.v1", "race"}, "-race"},
+		{[]string{"tag1", "msan"}, []string{"race"}, "-tags=tag1 -race -msan"},
+		{[]string{"asan"}, nil, "-asan"},
+	}
+	for _, test := range tests {
+		ctxt := build.Default
+		ctxt.BuildTags = test.tags
+		ctxt.ToolTags = test.toolTags
+		if got := GoFlagsFor(&ctxt); got != test.want {
+			t.Errorf("GoFlagsFor(%q, %q) = %q; want: %q", test.tags, test.toolTags, got, test.want)
+		}
+	}
+}
+
+func TestGoCommandSanitizerFlags(t *testing.T) {
+	t.Setenv("GOFLAGS", "")
+	ctxt := build.Default
+	ctxt.BuildTags = []string{"foo"}
+	ctxt.ToolTags = []string{"race"}
+
+	goflags := func(env []string) string {
+		for _, s := range env {
+			if strings.HasPrefix(s, "GOFLAGS=") {
+				return strings.TrimPrefix(s, "GOFLAGS=")
+			}
+		}
+		return ""
+	}
+	tests := []struct {
+		args     []string
+		wantArgs []string
+		goflags  string
+	}{
+		{[]string{"test"}, []string{"test"}, "-tags=foo -race"},
+		{[]string{"test", "-race"}, []string{"test", "-race"}, "-tags=foo"},
+		{[]string{"test", "-tags=bar"}, []string{"test", "-tags=bar,foo"}, "-race"},
+	}
+	for _, test := range tests {
+		cmd := GoCommand(&ctxt, "go", test.args...)
+		if args := cmd.Args[1:]; !reflect.DeepEqual(args, test.wantArgs) {
+			t.Errorf("%q: Args = %q; want: %q", test.args, args, test.wantArgs)
+		}
+		if s := goflags(cmd.Env); s != test.goflags {
+			t.Errorf("%q: GOFLAGS = %q; want: %q", test.args, s, test.goflags)
+		}
+	}
+
+	// Analyzers receive sanitizer tags as build tags
+	cmd := CommandContext(context.Background(), &ctxt, "staticcheck", "./...")
+	if want := []string{"-tags=foo,race", "./..."}; !reflect.DeepEqual(cmd.Args[1:], want) {
+		t.Errorf("staticcheck: Args = %q; want: %q", cmd.Args[1:], want)
+	}
+}
+
+func TestSplitTagArg(t *testing.T) {
+	tests := map[string][]string{
+		"":       {},
+		"a":      {"a"},
+		"a,b":    {"a", "b"},
+		"a b":    {"a", "b"},
+		" a  b ": {"a", "b"},
+		"a,,b,":  {"a", "b"},
+		"a, b":   {"a", "b"},
+		"a b,c":  {"a b", "c"},
+	}
+	for in, want := range tests {
+		got := SplitTagArg(in)
+		if len(got) == 0 && len(want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("SplitTagArg(%q) = %q; want: %q", in, got, want)
+		}
+	}
 }
 
 func TestEnvMap(t *testing.T) {
@@ -244,14 +497,14 @@ func TestEnvMap(t *testing.T) {
 
 func TestMergeTagArgs(t *testing.T) {
 	exp := []string{"foo", "race", "bar"}
-	tags := mergeTagArgs([]string{"!race", "foo"}, []string{"race", "bar"})
+	tags := MergeTagArgs([]string{"!race", "foo"}, []string{"race", "bar"})
 	if !reflect.DeepEqual(tags, exp) {
 		t.Errorf("got: %q want: %q", tags, exp)
 	}
 }
 
 func TestExtractTagArgs(t *testing.T) {
-	if a := extractTagArgs([]string{"-v"}); a != nil {
+	if a := ExtractTagArgs([]string{"-v"}); a != nil {
 		t.Errorf("got: %v want: %v", a, nil)
 	}
 	exp := []string{"race", "integration"}
@@ -259,8 +512,12 @@ func TestExtractTagArgs(t *testing.T) {
 		{"-c", "-tags=race,integration"},
 		{"-c", "-tags", "race,integration"},
 		{"-c", "-tags", "race,integration", "--", "-tags=foo"},
+		{"-c", "-tags", "race integration"},
+		{"-c", "-tags=race, integration"},
+		{"-c", "--tags=race,integration"},
+		{"-c", "--tags", "race,integration"},
 	} {
-		a := extractTagArgs(args)
+		a := ExtractTagArgs(args)
 		if !reflect.DeepEqual(a, exp) {
 			t.Errorf("%q: got: %q want: %q", args, a, exp)
 		}
@@ -273,9 +530,11 @@ func TestReplaceTagArgs(t *testing.T) {
 		{"-c", "-tags=race,integration"},
 		{"-c", "-tags", "race,integration"},
 		{"-c", "-tags", "race,integration", "--", "-tags=foo"},
+		{"-c", "-tags", "race integration"},
+		{"-c", "--tags=race,integration"},
 	} {
-		newArgs := replaceTagArgs(args, replace)
-		tags := extractTagArgs(newArgs)
+		newArgs := ReplaceTagArgs(args, replace)
+		tags := ExtractTagArgs(newArgs)
 		if !reflect.DeepEqual(tags, replace) {
 			t.Errorf("%q: got: %q want: %q", args, tags, replace)
 		}
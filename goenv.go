@@ -0,0 +1,110 @@
+package buildutil
+
+import (
+	"errors"
+	"go/build"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charlievieth/buildutil/internal/util"
+)
+
+// GoEnvFile returns the name of the go command's environment configuration
+// file, which is written by "go env -w". This is the value of the GOENV
+// environment variable, if set, otherwise it is "go/env" in the directory
+// returned by os.UserConfigDir. An empty name is returned if GOENV is "off"
+// or the user config directory cannot be determined.
+func GoEnvFile() string {
+	if file := os.Getenv("GOENV"); file != "" {
+		if file == "off" {
+			return ""
+		}
+		return file
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "go", "env")
+}
+
+// ParseGoEnv parses the contents of a go env file (see GoEnvFile), which
+// consists of "KEY=VALUE" lines, and returns the variables it sets. Like
+// the go command, lines without an "=" are ignored and values are not
+// unquoted.
+func ParseGoEnv(data []byte) map[string]string {
+	env := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if key, val, ok := cut(line, "="); ok && key != "" {
+			env[key] = val
+		}
+	}
+	return env
+}
+
+// NewContextFromGoEnv returns a copy of build.Default updated with the
+// settings of the go env file (see GoEnvFile) that build.Default ignores.
+// These are GOOS, GOARCH, CGO_ENABLED, GOPATH and the "-tags" flag of GOFLAGS,
+// which is added to the BuildTags. As with the go command, a variable set in
+// the process environment takes precedence over the file.
+//
+// The returned Context can be passed to MatchContext so that the matched
+// Context reflects the user's configured defaults. If the file does not
+// exist, or GOENV is "off", a copy of build.Default is returned.
+func NewContextFromGoEnv() (*build.Context, error) {
+	ctxt := CopyContext(&build.Default)
+	name := GoEnvFile()
+	if name == "" {
+		return ctxt, nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ctxt, nil
+		}
+		return nil, err
+	}
+	return applyGoEnv(ctxt, ParseGoEnv(data), os.Getenv), nil
+}
+
+// applyGoEnv applies the variables of the go env file env to ctxt, unless
+// they are set in the environment according to getenv.
+func applyGoEnv(ctxt *build.Context, env map[string]string, getenv func(string) string) *build.Context {
+	lookup := func(key string) (string, bool) {
+		if getenv(key) != "" {
+			return "", false
+		}
+		val, ok := env[key]
+		return val, ok && val != ""
+	}
+	goos, goarch := ctxt.GOOS, ctxt.GOARCH
+	if s, ok := lookup("GOOS"); ok {
+		goos = s
+	}
+	if s, ok := lookup("GOARCH"); ok {
+		goarch = s
+	}
+	if goos != ctxt.GOOS || goarch != ctxt.GOARCH {
+		ctxt = contextFor(ctxt, goos, goarch, ctxt.CgoEnabled)
+	}
+	if s, ok := lookup("CGO_ENABLED"); ok {
+		switch s {
+		case "0":
+			ctxt.CgoEnabled = false
+		case "1":
+			ctxt.CgoEnabled = cgoEnabled[ctxt.GOOS+"/"+ctxt.GOARCH]
+		}
+	}
+	if s, ok := lookup("GOPATH"); ok {
+		ctxt.GOPATH = s
+	}
+	if s, ok := lookup("GOFLAGS"); ok {
+		for _, tag := range ExtractTagArgs(strings.Fields(s)) {
+			ctxt.BuildTags = util.StringsAppend(ctxt.BuildTags, tag)
+		}
+	}
+	return ctxt
+}
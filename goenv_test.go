@@ -0,0 +1,82 @@
+package buildutil
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGoEnvFile(t *testing.T) {
+	t.Setenv("GOENV", "/tmp/goenv")
+	if name := GoEnvFile(); name != "/tmp/goenv" {
+		t.Errorf("GoEnvFile() = %q; want: %q", name, "/tmp/goenv")
+	}
+	t.Setenv("GOENV", "off")
+	if name := GoEnvFile(); name != "" {
+		t.Errorf("GoEnvFile() = %q; want: %q", name, "")
+	}
+	t.Setenv("GOENV", "")
+	if dir, err := os.UserConfigDir(); err == nil {
+		want := filepath.Join(dir, "go", "env")
+		if name := GoEnvFile(); name != want {
+			t.Errorf("GoEnvFile() = %q; want: %q", name, want)
+		}
+	}
+}
+
+func TestParseGoEnv(t *testing.T) {
+	data := "GOOS=plan9\r\nGOFLAGS=-tags=a,b -mod=mod\n\n# comment\nGOPATH=/a=b\n=x\nGOARCH=\n"
+	want := map[string]string{
+		"GOOS":    "plan9",
+		"GOFLAGS": "-tags=a,b -mod=mod",
+		"GOPATH":  "/a=b",
+		"GOARCH":  "",
+	}
+	if env := ParseGoEnv([]byte(data)); !reflect.DeepEqual(env, want) {
+		t.Errorf("ParseGoEnv() = %q; want: %q", env, want)
+	}
+}
+
+func TestNewContextFromGoEnv(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "env")
+	data := "GOOS=windows\nGOARCH=386\nCGO_ENABLED=0\nGOPATH=/file/gopath\n" +
+		"GOFLAGS=-mod=mod -tags=foo,bar\n"
+	if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOENV", name)
+	for _, key := range []string{"GOOS", "GOARCH", "CGO_ENABLED", "GOFLAGS"} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("GOPATH", "/env/gopath") // the environment takes precedence
+
+	ctxt, err := NewContextFromGoEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctxt.GOOS != "windows" || ctxt.GOARCH != "386" {
+		t.Errorf("GOOS/GOARCH = %s/%s; want: %s", ctxt.GOOS, ctxt.GOARCH, "windows/386")
+	}
+	if ctxt.CgoEnabled {
+		t.Error("CgoEnabled = true; want: false")
+	}
+	if ctxt.GOPATH != build.Default.GOPATH {
+		t.Errorf("GOPATH = %q; want: %q", ctxt.GOPATH, build.Default.GOPATH)
+	}
+	want := append(append([]string(nil), build.Default.BuildTags...), "foo", "bar")
+	if !reflect.DeepEqual(ctxt.BuildTags, want) {
+		t.Errorf("BuildTags = %q; want: %q", ctxt.BuildTags, want)
+	}
+
+	t.Setenv("GOENV", filepath.Join(t.TempDir(), "missing"))
+	ctxt, err = NewContextFromGoEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctxt.GOOS != build.Default.GOOS || ctxt.GOARCH != build.Default.GOARCH {
+		t.Errorf("missing file: GOOS/GOARCH = %s/%s; want: %s/%s",
+			ctxt.GOOS, ctxt.GOARCH, build.Default.GOOS, build.Default.GOARCH)
+	}
+}
@@ -0,0 +1,141 @@
+package buildutil
+
+import (
+	"context"
+	"go/build"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/charlievieth/buildutil/internal/util"
+)
+
+// A GoLister runs "go list" commands for a build.Context. Concurrent calls
+// with the same arguments, directory and environment share one invocation
+// of the go command, which prevents a storm of identical commands when an
+// editor requests the same information from multiple places at once.
+//
+// If Cache is true the output of successful commands is also cached until
+// Invalidate is called, which should be done whenever a Go source file,
+// go.mod or go.work file changes.
+//
+// The zero value is ready to use. A GoLister must not be copied after first
+// use.
+type GoLister struct {
+	// Cache the output of successful commands until Invalidate is called.
+	Cache bool
+
+	mu    sync.Mutex
+	calls map[string]*goListCall
+	cache map[string][]byte
+	gen   int // incremented by Invalidate
+
+	// run runs cmd and returns its output, if nil cmd.Output is used
+	run func(cmd *exec.Cmd) ([]byte, error)
+}
+
+// A goListCall is an in-flight or completed "go list" command.
+type goListCall struct {
+	done     chan struct{}
+	out      []byte
+	err      error
+	canceled bool // the context of the caller that ran the command was done
+}
+
+// defaultGoLister is used by GoList.
+var defaultGoLister GoLister
+
+// GoList runs "go list" with args for the build.Context (see GoCommandContext)
+// in the directory ctxt.Dir and returns its standard output. Concurrent calls
+// with the same arguments share one invocation of the go command. If the
+// command fails the returned error is an *exec.ExitError, which contains the
+// standard error of the command.
+func GoList(ctx context.Context, ctxt *build.Context, args ...string) ([]byte, error) {
+	return defaultGoLister.GoList(ctx, ctxt, args...)
+}
+
+// GoList is like the GoList function, but uses the GoLister to deduplicate
+// and cache commands. The returned output must not be modified.
+func (l *GoLister) GoList(ctx context.Context, ctxt *build.Context, args ...string) ([]byte, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	cmd := goCommandContext(ctx, ctxt, util.NewEnviron(), "go", append([]string{"list"}, args...)...)
+	key := goListKey(cmd)
+
+	for {
+		l.mu.Lock()
+		if out, ok := l.cache[key]; ok {
+			l.mu.Unlock()
+			return out, nil
+		}
+		if c, ok := l.calls[key]; ok {
+			l.mu.Unlock()
+			select {
+			case <-c.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			// The command was killed because the context of the caller that
+			// ran it was done, which should not fail this caller so retry.
+			if c.canceled && ctx.Err() == nil {
+				continue
+			}
+			return c.out, c.err
+		}
+		c := &goListCall{done: make(chan struct{})}
+		if l.calls == nil {
+			l.calls = make(map[string]*goListCall)
+		}
+		l.calls[key] = c
+		gen := l.gen
+		l.mu.Unlock()
+
+		if l.run != nil {
+			c.out, c.err = l.run(cmd)
+		} else {
+			c.out, c.err = cmd.Output()
+		}
+		c.canceled = c.err != nil && ctx.Err() != nil
+
+		l.mu.Lock()
+		delete(l.calls, key)
+		// Do not cache the output if the GoLister was invalidated while
+		// the command was running since it may be stale.
+		if l.Cache && c.err == nil && gen == l.gen {
+			if l.cache == nil {
+				l.cache = make(map[string][]byte)
+			}
+			l.cache[key] = c.out
+		}
+		l.mu.Unlock()
+		close(c.done)
+		return c.out, c.err
+	}
+}
+
+// Invalidate removes all cached output. Commands that are running when
+// Invalidate is called are not cached.
+func (l *GoLister) Invalidate() {
+	l.mu.Lock()
+	l.cache = nil
+	l.gen++
+	l.mu.Unlock()
+}
+
+// goListKey returns the key that identifies the command, which is its
+// directory, arguments and environment.
+func goListKey(cmd *exec.Cmd) string {
+	var b strings.Builder
+	b.WriteString(cmd.Dir)
+	for _, s := range cmd.Args {
+		b.WriteByte(0)
+		b.WriteString(s)
+	}
+	b.WriteByte(0)
+	for _, s := range cmd.Env {
+		b.WriteByte(0)
+		b.WriteString(s)
+	}
+	return b.String()
+}
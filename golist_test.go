@@ -0,0 +1,108 @@
+package buildutil
+
+import (
+	"context"
+	"go/build"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGoLister(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	l := &GoLister{
+		run: func(cmd *exec.Cmd) ([]byte, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return []byte(cmd.Args[len(cmd.Args)-1]), nil
+		},
+	}
+	ctxt := build.Default
+	ctxt.Dir = t.TempDir()
+
+	const N = 8
+	var wg sync.WaitGroup
+	outs := make([]string, N)
+	for i := 0; i < N; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			out, err := l.GoList(context.Background(), &ctxt, "-json", "p")
+			if err != nil {
+				t.Error(err)
+			}
+			outs[i] = string(out)
+		}(i)
+	}
+	// Wait for the command to start before releasing it.
+	for {
+		l.mu.Lock()
+		n := len(l.calls)
+		l.mu.Unlock()
+		if n != 0 {
+			break
+		}
+	}
+	close(release)
+	wg.Wait()
+	for i, out := range outs {
+		if out != "p" {
+			t.Errorf("%d: output = %q; want: %q", i, out, "p")
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n < 1 || n > N {
+		t.Errorf("calls = %d; want: 1..%d", n, N)
+	}
+
+	// Without caching every sequential call runs the command.
+	atomic.StoreInt32(&calls, 0)
+	for i := 0; i < 2; i++ {
+		if _, err := l.GoList(context.Background(), &ctxt, "p"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("calls = %d; want: %d", n, 2)
+	}
+
+	l.Cache = true
+	atomic.StoreInt32(&calls, 0)
+	for i := 0; i < 2; i++ {
+		if _, err := l.GoList(context.Background(), &ctxt, "p"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("cached calls = %d; want: %d", n, 1)
+	}
+	l.Invalidate()
+	if _, err := l.GoList(context.Background(), &ctxt, "p"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("calls after Invalidate = %d; want: %d", n, 2)
+	}
+}
+
+func TestGoListerDir(t *testing.T) {
+	var cmds []*exec.Cmd
+	l := &GoLister{
+		run: func(cmd *exec.Cmd) ([]byte, error) {
+			cmds = append(cmds, cmd)
+			return nil, nil
+		},
+	}
+	ctxt := build.Default
+	ctxt.Dir = "rel"
+	if _, err := l.GoList(context.Background(), &ctxt, "p"); err != nil {
+		t.Fatal(err)
+	}
+	if len(cmds) != 1 {
+		t.Fatalf("calls = %d; want: %d", len(cmds), 1)
+	}
+	if cmd := cmds[0]; cmd.Dir != "rel" || cmd.Args[1] == "-C" {
+		t.Errorf("Dir = %q Args = %q; want: Dir = %q without -C", cmd.Dir, cmd.Args, "rel")
+	}
+}
@@ -0,0 +1,169 @@
+package buildutil
+
+import (
+	"errors"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/charlievieth/buildutil/contextutil"
+	"github.com/charlievieth/buildutil/internal/modfile"
+)
+
+// A GoMod contains the directives of a go.mod file that are of interest
+// when matching files and creating Contexts.
+type GoMod struct {
+	Module    string // module path
+	Go        string // go directive version (e.g. "1.19"), if any
+	Toolchain string // toolchain directive (e.g. "go1.21.0"), if any
+}
+
+// ReadGoMod reads and parses the go.mod file in directory dir.
+// The Context's OpenFile and JoinPath functions, if set, are used.
+func ReadGoMod(ctxt *build.Context, dir string) (*GoMod, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	name := joinPath(ctxt, dir, "go.mod")
+	rc, err := openReader(ctxt, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	mod, err := ParseGoMod(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return mod, nil
+}
+
+// ModulePath returns the module path of the go.mod file in directory dir.
+func ModulePath(ctxt *build.Context, dir string) (string, error) {
+	mod, err := ReadGoMod(ctxt, dir)
+	if err != nil {
+		return "", err
+	}
+	return mod.Module, nil
+}
+
+// GoVersion returns the version of the go directive of the go.mod file in
+// directory dir or an empty string if there is no go directive.
+func GoVersion(ctxt *build.Context, dir string) (string, error) {
+	mod, err := ReadGoMod(ctxt, dir)
+	if err != nil {
+		return "", err
+	}
+	return mod.Go, nil
+}
+
+// Toolchain returns the toolchain directive of the go.mod file in directory
+// dir or an empty string if there is no toolchain directive.
+func Toolchain(ctxt *build.Context, dir string) (string, error) {
+	mod, err := ReadGoMod(ctxt, dir)
+	if err != nil {
+		return "", err
+	}
+	return mod.Toolchain, nil
+}
+
+var errNoModuleDirective = errors.New("no module directive")
+
+// ParseGoMod parses the module, go and toolchain directives of the go.mod
+// file data. All other directives are ignored. This is not a complete parser
+// (see golang.org/x/mod/modfile for that), but is sufficient for extracting
+// the module path and Go version.
+func ParseGoMod(data []byte) (*GoMod, error) {
+	f, err := modfile.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if f.Module == "" {
+		return nil, errNoModuleDirective
+	}
+	return &GoMod{Module: f.Module, Go: f.Go, Toolchain: f.Toolchain}, nil
+}
+
+// MatchContextMod is like MatchContextOptions, but also respects the go
+// directive of the go.mod file of the module containing filename, which is
+// the minimum Go version the go command will build the module with. A
+// Context whose ReleaseTags are for an older version of Go would be
+// rejected by "go build", so instead a permanent MatchError wrapping
+// ErrImpossibleGoVersion is returned. This happens when the Go version of
+// orig is older than that of the module or, if the Strategies of opts
+// include StrategyGoVersion, when the file requires an older Go version
+// (e.g. "//go:build !go1.21" in a go1.21 module). If StrategyGoVersion is
+// allowed and orig is older than the module, the Go version of the module
+// is assumed instead.
+//
+// The go.mod file is only searched for if filename is absolute. Files not in
+// a module, or in a module without a go directive, are matched the same as
+// MatchContextOptions. Errors reading the go.mod file are returned as a
+// MatchError.
+func MatchContextMod(orig *build.Context, filename string, src interface{}, opts *MatchOptions) (*build.Context, error) {
+	if orig == nil {
+		orig = &build.Default
+	}
+	if !isAbsPath(orig, filename) {
+		return MatchContextOptions(orig, filename, src, opts)
+	}
+	root, err := contextutil.ContainingDirectory(orig, filepath.Dir(filename), "", "go.mod")
+	if err != nil {
+		return MatchContextOptions(orig, filename, src, opts)
+	}
+	mod, err := ReadGoMod(orig, root)
+	if err != nil {
+		return nil, &MatchError{Path: filename, Err: err}
+	}
+	min, ok := goDirectiveMinor(mod.Go)
+	if !ok {
+		return MatchContextOptions(orig, filename, src, opts)
+	}
+	if releaseMinor(orig) < min && hasStrategy(opts.strategies(), StrategyGoVersion) {
+		orig = CopyContext(orig)
+		orig.ReleaseTags, _ = GoVersionReleaseTags("go1." + strconv.Itoa(min))
+	}
+	ctxt, err := MatchContextOptions(orig, filename, src, opts)
+	if err != nil {
+		return nil, err
+	}
+	if v := releaseMinor(ctxt); v < min {
+		return nil, &MatchError{Path: filename, Permanent: true,
+			Err: fmt.Errorf("%w: %s requires go >= 1.%d, but the matched Context is go1.%d",
+				ErrImpossibleGoVersion, joinPath(orig, root, "go.mod"), min, v)}
+	}
+	return ctxt, nil
+}
+
+// releaseMinor returns the minor version of the newest Go 1 release tag of
+// ctxt.
+func releaseMinor(ctxt *build.Context) int {
+	minor := 0
+	for _, tag := range ctxt.ReleaseTags {
+		if v, ok := ParseGoVersionTag(tag); ok && v.Major == 1 && v.Minor > minor {
+			minor = v.Minor
+		}
+	}
+	return minor
+}
+
+// goDirectiveMinor returns the minor version of the Go 1 version of a go
+// directive (e.g. 21 for "1.21", "1.21.3" or "1.21rc1").
+func goDirectiveMinor(version string) (int, bool) {
+	if i := strings.IndexFunc(version, func(r rune) bool {
+		return r != '.' && (r < '0' || r > '9')
+	}); i != -1 {
+		version = version[:i] // remove any pre-release suffix
+	}
+	v, _, ok := parseGoVersion("go" + version)
+	if !ok || v.Major != 1 {
+		return 0, false
+	}
+	return v.Minor, true
+}
@@ -0,0 +1,148 @@
+package buildutil
+
+import (
+	"errors"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/charlievieth/buildutil/contextutil"
+)
+
+func TestParseGoMod(t *testing.T) {
+	tests := []struct {
+		data string
+		want *GoMod
+	}{
+		{
+			data: "module example.com/m\n",
+			want: &GoMod{Module: "example.com/m"},
+		},
+		{
+			data: "// comment\nmodule \"example.com/m\" // comment\n\ngo 1.21\n\ntoolchain go1.21.3\n",
+			want: &GoMod{Module: "example.com/m", Go: "1.21", Toolchain: "go1.21.3"},
+		},
+		{
+			data: "module\texample.com/m\ngo\t1.19\n" +
+				"require (\n\tgo 1.0.0\n\tmodule v1.0.0\n)\n" +
+				"require example.com/x v1.0.0\n",
+			want: &GoMod{Module: "example.com/m", Go: "1.19"},
+		},
+		{
+			data: "go 1.19\n",
+			want: nil,
+		},
+		{
+			data: "module \"example.com/m\n",
+			want: nil,
+		},
+	}
+	for _, x := range tests {
+		got, err := ParseGoMod([]byte(x.data))
+		if x.want == nil {
+			if err == nil {
+				t.Errorf("ParseGoMod(%q): expected error got: %+v", x.data, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseGoMod(%q): %v", x.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, x.want) {
+			t.Errorf("ParseGoMod(%q) = %+v; want: %+v", x.data, got, x.want)
+		}
+	}
+}
+
+func TestReadGoMod(t *testing.T) {
+	const data = "module example.com/m\n\ngo 1.20\n\ntoolchain go1.21.0\n"
+	dir := filepath.Join("/virtual", "m")
+	ctxt := build.Default
+	ctxt.OpenFile = func(path string) (io.ReadCloser, error) {
+		if path == filepath.Join(dir, "go.mod") {
+			return ioutil.NopCloser(strings.NewReader(data)), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	if s, err := ModulePath(&ctxt, dir); err != nil || s != "example.com/m" {
+		t.Errorf("ModulePath(%q) = %q, %v; want: %q, %v", dir, s, err, "example.com/m", nil)
+	}
+	if s, err := GoVersion(&ctxt, dir); err != nil || s != "1.20" {
+		t.Errorf("GoVersion(%q) = %q, %v; want: %q, %v", dir, s, err, "1.20", nil)
+	}
+	if s, err := Toolchain(&ctxt, dir); err != nil || s != "go1.21.0" {
+		t.Errorf("Toolchain(%q) = %q, %v; want: %q, %v", dir, s, err, "go1.21.0", nil)
+	}
+	if _, err := ReadGoMod(&ctxt, "/virtual"); err == nil {
+		t.Error("ReadGoMod: expected error for missing go.mod")
+	}
+}
+
+func TestMatchContextMod(t *testing.T) {
+	orig := contextutil.NewFakeContext(contextutil.FakeFiles(map[string]string{
+		"/gopath/src/m/go.mod":    "module m\n\ngo 1.21\n",
+		"/gopath/src/m/m.go":      "package m\n",
+		"/gopath/src/m/old.go":    "//go:build !go1.21\n\npackage m\n",
+		"/gopath/src/p/p.go":      "package p\n",
+		"/gopath/src/rc/go.mod":   "module rc\n\ngo 1.21rc1\n",
+		"/gopath/src/rc/rc.go":    "package rc\n",
+		"/gopath/src/nogo/go.mod": "module nogo\n",
+		"/gopath/src/nogo/n.go":   "package nogo\n",
+		"/gopath/src/bad/go.mod":  "go 1.21\n",
+		"/gopath/src/bad/b.go":    "package bad\n",
+	}))
+	var err error
+	orig.ReleaseTags, err = GoVersionReleaseTags("go1.20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	goVersion := &MatchOptions{Strategies: []MatchStrategy{StrategyGoVersion}}
+
+	tests := []struct {
+		filename string
+		opts     *MatchOptions
+		minor    int  // expected Go version of the matched Context
+		err      bool // expect ErrImpossibleGoVersion
+	}{
+		{"/gopath/src/m/m.go", nil, 0, true},
+		{"/gopath/src/m/m.go", goVersion, 21, false},
+		{"/gopath/src/m/old.go", goVersion, 0, true},
+		{"/gopath/src/rc/rc.go", goVersion, 21, false},
+		{"/gopath/src/p/p.go", nil, 20, false},    // not in a module
+		{"/gopath/src/nogo/n.go", nil, 20, false}, // no go directive
+	}
+	for _, test := range tests {
+		ctxt, err := MatchContextMod(orig, test.filename, nil, test.opts)
+		if test.err {
+			var merr *MatchError
+			if !errors.Is(err, ErrImpossibleGoVersion) || !errors.As(err, &merr) || !merr.Permanent {
+				t.Errorf("MatchContextMod(%q) = %v; want: permanent %v", test.filename, err, ErrImpossibleGoVersion)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("MatchContextMod(%q): %v", test.filename, err)
+			continue
+		}
+		if got := releaseMinor(ctxt); got != test.minor {
+			t.Errorf("MatchContextMod(%q) = go1.%d; want: go1.%d", test.filename, got, test.minor)
+		}
+	}
+	if got := releaseMinor(orig); got != 20 {
+		t.Errorf("MatchContextMod modified the ReleaseTags of orig: go1.%d", got)
+	}
+
+	// Errors reading the go.mod file are a MatchError
+	var merr *MatchError
+	if _, err := MatchContextMod(orig, "/gopath/src/bad/b.go", nil, nil); !errors.As(err, &merr) ||
+		!errors.Is(err, errNoModuleDirective) {
+		t.Errorf("MatchContextMod(%q) = %v; want: %T wrapping %v", "/gopath/src/bad/b.go",
+			err, merr, errNoModuleDirective)
+	}
+}
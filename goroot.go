@@ -0,0 +1,73 @@
+package buildutil
+
+import (
+	"errors"
+	"go/build"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/charlievieth/buildutil/internal/readdir"
+)
+
+// ErrNoGOROOT is returned when an operation requires the GOROOT, but the
+// GOROOT of the Context is not set.
+var ErrNoGOROOT = errors.New("buildutil: GOROOT is not set")
+
+// GorootPackages returns the sorted import paths of the packages in the
+// "src" directory of the GOROOT of ctxt (the standard library), which are
+// the directories that contain at least one Go file. Like "go list std" the
+// commands ("cmd") are omitted, as are the directories ignored by
+// IsIgnoredDir, which includes "vendor" and "testdata" directories.
+//
+// The build constraints of the files are not evaluated, so the packages of
+// all platforms are returned. The ReadDir function of ctxt is used, if set,
+// otherwise the directories are read with a faster version of
+// ioutil.ReadDir. Directories that cannot be read, other than the GOROOT
+// "src" directory, are skipped.
+func GorootPackages(ctxt *build.Context) ([]string, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	if ctxt.GOROOT == "" {
+		return nil, ErrNoGOROOT
+	}
+	readDir := ctxt.ReadDir
+	if readDir == nil {
+		readDir = readdir.ReadDirUnsorted
+	}
+
+	var pkgs []string
+	var walk func(dir, importPath string) error
+	walk = func(dir, importPath string) error {
+		fis, err := readDir(dir)
+		if err != nil {
+			return err
+		}
+		hasGo := false
+		for _, fi := range fis {
+			name := fi.Name()
+			if fi.IsDir() {
+				if IsIgnoredDir(name) || (importPath == "" && name == "cmd") {
+					continue
+				}
+				// Ignore errors reading sub-directories
+				_ = walk(joinPath(ctxt, dir, name), path.Join(importPath, name))
+				continue
+			}
+			if !hasGo && fi.Mode().IsRegular() && strings.HasSuffix(name, ".go") &&
+				!strings.HasPrefix(name, "_") && !strings.HasPrefix(name, ".") {
+				hasGo = true
+			}
+		}
+		if hasGo && importPath != "" {
+			pkgs = append(pkgs, importPath)
+		}
+		return nil
+	}
+	if err := walk(joinPath(ctxt, ctxt.GOROOT, "src"), ""); err != nil {
+		return nil, err
+	}
+	sort.Strings(pkgs)
+	return pkgs, nil
+}
@@ -0,0 +1,123 @@
+package buildutil
+
+import (
+	"go/build"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/charlievieth/buildutil/buildutiltest"
+	"github.com/charlievieth/buildutil/contextutil"
+)
+
+var gorootTestFiles = map[string]string{
+	"src/fmt/print.go":                   "package fmt",
+	"src/fmt/fmt_test.go":                "package fmt",
+	"src/net/http/server.go":             "package http",
+	"src/net/http/testdata/x.go":         "package x",
+	"src/net/net.go":                     "package net",
+	"src/internal/abi/abi.go":            "package abi",
+	"src/cmd/go/main.go":                 "package main",
+	"src/vendor/golang.org/x/net/dns.go": "package dns",
+	"src/unicode/utf8/_ignored.go":       "package utf8",
+	"src/unicode/utf8/README":            "",
+	"src/unicode/unicode.go":             "package unicode",
+	"src/.git/hooks/x.go":                "package hooks",
+	"src/builtin/builtin.go":             "package builtin",
+	"src/go.mod":                         "module std",
+	"src/empty/sub/sub.go":               "package sub",
+	"src/syscall/js/js.go":               "//go:build js\n\npackage js",
+	"src/syscall/syscall_linux_amd64.go": "package syscall",
+}
+
+var gorootTestPackages = []string{
+	"builtin",
+	"empty/sub",
+	"fmt",
+	"internal/abi",
+	"net",
+	"net/http",
+	"syscall",
+	"syscall/js",
+	"unicode",
+}
+
+func TestGorootPackages(t *testing.T) {
+	ctxt := build.Default
+	ctxt.GOROOT = t.TempDir()
+	buildutiltest.WriteFiles(t, ctxt.GOROOT, gorootTestFiles)
+
+	pkgs, err := GorootPackages(&ctxt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pkgs, gorootTestPackages) {
+		t.Errorf("GorootPackages() = %q; want: %q", pkgs, gorootTestPackages)
+	}
+}
+
+func TestGorootPackages_FakeContext(t *testing.T) {
+	files := make(map[string]string, len(gorootTestFiles))
+	for name, data := range gorootTestFiles {
+		files["/goroot/"+name] = data
+	}
+	ctxt := contextutil.NewFakeContext(contextutil.FakeFiles(files))
+
+	pkgs, err := GorootPackages(ctxt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pkgs, gorootTestPackages) {
+		t.Errorf("GorootPackages() = %q; want: %q", pkgs, gorootTestPackages)
+	}
+}
+
+func TestGorootPackages_Errors(t *testing.T) {
+	ctxt := build.Default
+	ctxt.GOROOT = ""
+	if _, err := GorootPackages(&ctxt); err != ErrNoGOROOT {
+		t.Errorf("GorootPackages(GOROOT=%q) = %v; want: %v", ctxt.GOROOT, err, ErrNoGOROOT)
+	}
+	ctxt.GOROOT = t.TempDir() // no "src" directory
+	if _, err := GorootPackages(&ctxt); err == nil {
+		t.Errorf("GorootPackages(GOROOT=%q): expected an error", ctxt.GOROOT)
+	}
+}
+
+func TestGorootPackages_Runtime(t *testing.T) {
+	ctxt := build.Default
+	ctxt.GOROOT = runtime.GOROOT()
+	pkgs, err := GorootPackages(&ctxt)
+	if err != nil {
+		t.Skip("GOROOT is not readable:", err)
+	}
+	seen := make(map[string]bool, len(pkgs))
+	for _, p := range pkgs {
+		seen[p] = true
+	}
+	for _, p := range []string{"fmt", "net/http", "go/build", "runtime"} {
+		if !seen[p] {
+			t.Errorf("GorootPackages(): missing package: %q", p)
+		}
+	}
+	for _, p := range []string{"cmd/go", "vendor/golang.org/x/net/dns/dnsmessage"} {
+		if seen[p] {
+			t.Errorf("GorootPackages(): unexpected package: %q", p)
+		}
+	}
+}
+
+func BenchmarkGorootPackages(b *testing.B) {
+	ctxt := build.Default
+	ctxt.GOROOT = runtime.GOROOT()
+	if _, err := GorootPackages(&ctxt); err != nil {
+		b.Skip("GOROOT is not readable:", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := GorootPackages(&ctxt); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package buildutil
+
+import (
+	"errors"
+	"fmt"
+	"go/build"
+	"strconv"
+	"strings"
+)
+
+// A GoRelease is a Go release version parsed by ParseGoVersionTag.
+type GoRelease struct {
+	Major int // major version (1 for "go1.21.3")
+	Minor int // minor version (21 for "go1.21.3")
+	Patch int // patch version (3 for "go1.21.3", 0 if not specified)
+}
+
+// ParseGoVersionTag parses the Go version tag, which must be a release tag
+// like "go1.21" or a point release like "go1.21.3". Unlike the release tags
+// of go/build it is not limited to Go 1, so "go2.0" is a valid tag. Pre-release
+// versions (e.g. "go1.21rc1"), versions with leading zeros, and versions
+// without a minor version (e.g. "go1") are not accepted.
+//
+// The returned GoRelease can be compared numerically with Compare.
+func ParseGoVersionTag(tag string) (GoRelease, bool) {
+	v, _, ok := parseGoVersion(tag)
+	return v, ok
+}
+
+// parseGoVersion is like ParseGoVersionTag, but also reports if the version
+// had a patch version (which release tags do not).
+func parseGoVersion(tag string) (v GoRelease, hasPatch, ok bool) {
+	if !strings.HasPrefix(tag, "go") {
+		return GoRelease{}, false, false
+	}
+	parts := strings.Split(tag[len("go"):], ".")
+	if len(parts) != 2 && len(parts) != 3 {
+		return GoRelease{}, false, false
+	}
+	var nums [3]int
+	for i, s := range parts {
+		n, ok := parseVersionNumber(s)
+		if !ok {
+			return GoRelease{}, false, false
+		}
+		nums[i] = n
+	}
+	if nums[0] == 0 {
+		return GoRelease{}, false, false
+	}
+	return GoRelease{Major: nums[0], Minor: nums[1], Patch: nums[2]}, len(parts) == 3, true
+}
+
+// parseVersionNumber parses the decimal number s, which must not have
+// leading zeros or a sign.
+func parseVersionNumber(s string) (int, bool) {
+	if s == "" || len(s) > 1 && s[0] == '0' {
+		return 0, false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.Atoi(s)
+	return n, err == nil
+}
+
+// Compare returns -1, 0, or +1 depending on whether v < w, v == w, or v > w.
+func (v GoRelease) Compare(w GoRelease) int {
+	switch {
+	case v.Major != w.Major:
+		return compareInt(v.Major, w.Major)
+	case v.Minor != w.Minor:
+		return compareInt(v.Minor, w.Minor)
+	default:
+		return compareInt(v.Patch, w.Patch)
+	}
+}
+
+// Less reports if v is less than w.
+func (v GoRelease) Less(w GoRelease) bool { return v.Compare(w) < 0 }
+
+// String returns the version as a tag (e.g. "go1.21" or "go1.21.3"). The
+// patch version is omitted if it is zero.
+func (v GoRelease) String() string {
+	s := "go" + strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor)
+	if v.Patch != 0 {
+		s += "." + strconv.Itoa(v.Patch)
+	}
+	return s
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// isReleaseTagForm reports if s has the form of a release tag ("goN.M").
+func isReleaseTagForm(s string) bool {
+	_, hasPatch, ok := parseGoVersion(s)
+	return ok && !hasPatch
+}
+
+// ErrUnsupportedGoVersion is returned for Go versions that are invalid or
+// that the tables of this package do not cover.
+var ErrUnsupportedGoVersion = errors.New("buildutil: unsupported Go version")
+
+// minPlatformMinor is the minor version of the oldest Go 1 release that
+// GoVersionPlatforms knows the platforms of.
+const minPlatformMinor = 17
+
+// parseGo1Version parses the Go 1 version (e.g. "go1.20" or "go1.20.3") and
+// returns its minor version.
+func parseGo1Version(version string) (int, error) {
+	v, _, ok := parseGoVersion(version)
+	if !ok || v.Major != 1 {
+		return 0, fmt.Errorf("%w: %q", ErrUnsupportedGoVersion, version)
+	}
+	return v.Minor, nil
+}
+
+// GoVersionReleaseTags returns the release tags of the Go 1 version, which
+// may be a release tag or point release (e.g. "go1.20" or "go1.20.3"). The
+// release tags are "go1.1" through the version ("go1.20").
+func GoVersionReleaseTags(version string) ([]string, error) {
+	minor, err := parseGo1Version(version)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]string, minor)
+	for i := range tags {
+		tags[i] = "go1." + strconv.Itoa(i+1)
+	}
+	return tags, nil
+}
+
+// GoVersionPlatforms returns the platforms supported by the Go 1 version
+// (e.g. "go1.20"), in the order of DefaultGoPlatforms. The platforms are
+// those of DefaultGoPlatforms and the newer platforms known by this package
+// (e.g. "wasip1/wasm"), filtered using a table of when they were added or
+// removed, so no Go installation is needed. Versions older than go1.17 are
+// not supported.
+func GoVersionPlatforms(version string) ([]GoPlatform, error) {
+	minor, err := parseGo1Version(version)
+	if err != nil {
+		return nil, err
+	}
+	if minor < minPlatformMinor {
+		return nil, fmt.Errorf("%w: %s (the platforms of go1.%d and newer are known)",
+			ErrUnsupportedGoVersion, version, minPlatformMinor)
+	}
+	var platforms []GoPlatform
+	for _, p := range knownPlatforms {
+		if v, ok := platformVersions[p.String()]; ok &&
+			(minor < v.added || v.removed != 0 && minor >= v.removed) {
+			continue
+		}
+		platforms = append(platforms, p)
+	}
+	return platforms, nil
+}
+
+// archFeatureTagsMinor is the minor version of the Go 1 release that added
+// the default architecture feature tool tags (see defaultArchFeatureTags) of
+// a GOARCH, if not go1.18.
+var archFeatureTagsMinor = map[string]int{
+	"arm64":   23, // GOARM64
+	"riscv64": 23, // GORISCV64
+	"wasm":    24, // satconv and signext are always enabled
+}
+
+// goVersionToolTags returns the tool tags of tags, the tool tags of a
+// Context for goarch, that are valid for the Go 1 release minor. The
+// architecture feature tags are removed if the release does not have them,
+// all other tool tags (e.g. "race" or GOEXPERIMENT tags) are kept.
+func goVersionToolTags(tags []string, goarch string, minor int) []string {
+	added, ok := archFeatureTagsMinor[goarch]
+	if !ok {
+		added = 18
+	}
+	if minor >= added {
+		return tags
+	}
+	var a []string
+	for _, tag := range tags {
+		if !isArchFeatureTag(tag) {
+			a = append(a, tag)
+		}
+	}
+	return a
+}
+
+// ContextForGoVersion returns a copy of base (or build.Default if nil) with
+// the release tags of the Go 1 version (see GoVersionReleaseTags), which
+// matches files as if that version of Go was used instead of the toolchain
+// this package was built with. Use the platforms of GoVersionPlatforms as
+// the Platforms of MatchOptions to also limit matching to the platforms of
+// the version.
+//
+// The tool tags of base are kept, except for the architecture feature tags
+// (e.g. "amd64.v1") if the version does not have them.
+func ContextForGoVersion(base *build.Context, version string) (*build.Context, error) {
+	minor, err := parseGo1Version(version)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := GoVersionReleaseTags(version)
+	if err != nil {
+		return nil, err
+	}
+	ctxt := CopyContext(base)
+	ctxt.ReleaseTags = tags
+	ctxt.ToolTags = goVersionToolTags(ctxt.ToolTags, ctxt.GOARCH, minor)
+	return ctxt, nil
+}
@@ -0,0 +1,185 @@
+package buildutil
+
+import (
+	"errors"
+	"go/build"
+	"reflect"
+	"testing"
+)
+
+func TestParseGoVersionTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want GoRelease
+		ok   bool
+	}{
+		{"go1.21", GoRelease{1, 21, 0}, true},
+		{"go1.21.3", GoRelease{1, 21, 3}, true},
+		{"go1.0", GoRelease{1, 0, 0}, true},
+		{"go2.0", GoRelease{2, 0, 0}, true},
+		{"go2.1.10", GoRelease{2, 1, 10}, true},
+		{"go10.100", GoRelease{10, 100, 0}, true},
+		{"go1", GoRelease{}, false},
+		{"go0.1", GoRelease{}, false},
+		{"go1.021", GoRelease{}, false},
+		{"go1.21rc1", GoRelease{}, false},
+		{"go1.21beta1", GoRelease{}, false},
+		{"go1.21.", GoRelease{}, false},
+		{"go1..1", GoRelease{}, false},
+		{"go1.2.3.4", GoRelease{}, false},
+		{"go1.+2", GoRelease{}, false},
+		{"go1.-2", GoRelease{}, false},
+		{"1.21", GoRelease{}, false},
+		{"gox.1", GoRelease{}, false},
+		{"", GoRelease{}, false},
+	}
+	for _, test := range tests {
+		got, ok := ParseGoVersionTag(test.tag)
+		if got != test.want || ok != test.ok {
+			t.Errorf("ParseGoVersionTag(%q) = %+v, %t; want: %+v, %t",
+				test.tag, got, ok, test.want, test.ok)
+		}
+		if ok {
+			if s := got.String(); s != test.tag && s+".0" != test.tag {
+				t.Errorf("%+v.String() = %q; want: %q", got, s, test.tag)
+			}
+		}
+	}
+}
+
+func TestGoReleaseCompare(t *testing.T) {
+	versions := []string{
+		"go1.0",
+		"go1.2",
+		"go1.2.1",
+		"go1.2.10",
+		"go1.10",
+		"go1.21",
+		"go1.21.3",
+		"go2.0",
+		"go2.1",
+		"go10.0",
+	}
+	for i, a := range versions {
+		va, ok := ParseGoVersionTag(a)
+		if !ok {
+			t.Fatalf("ParseGoVersionTag(%q) failed", a)
+		}
+		for j, b := range versions {
+			vb, ok := ParseGoVersionTag(b)
+			if !ok {
+				t.Fatalf("ParseGoVersionTag(%q) failed", b)
+			}
+			want := compareInt(i, j)
+			if got := va.Compare(vb); got != want {
+				t.Errorf("%s.Compare(%s) = %d; want: %d", a, b, got, want)
+			}
+			if got := va.Less(vb); got != (want < 0) {
+				t.Errorf("%s.Less(%s) = %t; want: %t", a, b, got, want < 0)
+			}
+		}
+	}
+}
+
+func TestIsGoReleaseTag(t *testing.T) {
+	tests := map[string]bool{
+		"go1.1":    true,
+		"go1.1000": true,
+		"go2.0":    true,
+		"go1.21.3": false,
+		"go1":      false,
+		"go1.x":    false,
+		"linux":    false,
+	}
+	for tag, want := range tests {
+		if got := isGoReleaseTag(tag); got != want {
+			t.Errorf("isGoReleaseTag(%q) = %t; want: %t", tag, got, want)
+		}
+	}
+}
+
+func TestGoVersionReleaseTags(t *testing.T) {
+	tags, err := GoVersionReleaseTags("go1.3.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"go1.1", "go1.2", "go1.3"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("GoVersionReleaseTags(%q) = %q; want: %q", "go1.3.2", tags, want)
+	}
+	for _, version := range []string{"", "go1", "go2.1", "go1.21rc1"} {
+		if _, err := GoVersionReleaseTags(version); !errors.Is(err, ErrUnsupportedGoVersion) {
+			t.Errorf("GoVersionReleaseTags(%q) = %v; want: %v", version, err, ErrUnsupportedGoVersion)
+		}
+	}
+
+	ctxt, err := ContextForGoVersion(nil, "go1.20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ctxt.ReleaseTags); n != 20 || ctxt.ReleaseTags[n-1] != "go1.20" {
+		t.Errorf("ContextForGoVersion(%q): ReleaseTags = %q", "go1.20", ctxt.ReleaseTags)
+	}
+	if ctxt.GOOS != build.Default.GOOS || len(build.Default.ReleaseTags) == 20 {
+		t.Errorf("ContextForGoVersion(%q): modified build.Default or changed GOOS", "go1.20")
+	}
+
+	// The architecture feature tool tags are only kept for versions that
+	// have them, other tool tags are always kept.
+	base := ContextFor("linux", "amd64", false)
+	base.ToolTags = []string{"goexperiment.regabiargs", "race", "amd64.v1", "amd64.v2"}
+	for version, want := range map[string][]string{
+		"go1.17": {"goexperiment.regabiargs", "race"},
+		"go1.18": {"goexperiment.regabiargs", "race", "amd64.v1", "amd64.v2"},
+	} {
+		ctxt, err := ContextForGoVersion(base, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ctxt.ToolTags, want) {
+			t.Errorf("ContextForGoVersion(%q): ToolTags = %q; want: %q", version, ctxt.ToolTags, want)
+		}
+	}
+	if got := goVersionToolTags([]string{"arm64.v8.0"}, "arm64", 22); got != nil {
+		t.Errorf("goVersionToolTags(%q, %q, %d) = %q; want: %q",
+			[]string{"arm64.v8.0"}, "arm64", 22, got, []string(nil))
+	}
+}
+
+func TestGoVersionPlatforms(t *testing.T) {
+	tests := []struct {
+		version string
+		include []string
+		exclude []string
+	}{
+		{"go1.17", []string{"linux/amd64", "windows/arm", "windows/arm64"},
+			[]string{"linux/loong64", "wasip1/wasm"}},
+		{"go1.19.4", []string{"linux/loong64"}, []string{"wasip1/wasm"}},
+		{"go1.21", []string{"linux/loong64", "wasip1/wasm", "windows/arm"}, nil},
+		{"go1.26", []string{"wasip1/wasm"}, []string{"windows/arm"}},
+	}
+	for _, x := range tests {
+		platforms, err := GoVersionPlatforms(x.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]bool, len(platforms))
+		for _, p := range platforms {
+			got[p.String()] = true
+		}
+		for _, p := range x.include {
+			if !got[p] {
+				t.Errorf("GoVersionPlatforms(%q): missing platform: %s", x.version, p)
+			}
+		}
+		for _, p := range x.exclude {
+			if got[p] {
+				t.Errorf("GoVersionPlatforms(%q): unexpected platform: %s", x.version, p)
+			}
+		}
+	}
+	for _, version := range []string{"go1.16", "go1", "go2.0"} {
+		if _, err := GoVersionPlatforms(version); !errors.Is(err, ErrUnsupportedGoVersion) {
+			t.Errorf("GoVersionPlatforms(%q) = %v; want: %v", version, err, ErrUnsupportedGoVersion)
+		}
+	}
+}
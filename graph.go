@@ -0,0 +1,211 @@
+package buildutil
+
+import (
+	"go/build"
+	"go/parser"
+	"go/token"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/charlievieth/buildutil/internal/util"
+)
+
+// An ImportGraph is the import graph of the packages in one or more source
+// trees. It is built from the import declarations in the header of each file
+// (see ReadImportsFast) and does not require type checking, which makes it
+// cheap enough to build before deciding the scope of a refactoring.
+type ImportGraph struct {
+	dirs       map[string]string   // import path => directory
+	imports    map[string][]string // import path => sorted imports
+	importedBy map[string][]string // import path => sorted importers
+}
+
+// BuildImportGraph builds the import graph of the packages in the directory
+// trees rooted at roots. If a root contains a go.mod file the import paths of
+// its packages are derived from the module path, otherwise they are derived
+// from the GOPATH or GOROOT (see ImportPath) and directories without an import
+// path are skipped. Nested modules, and directories skipped by the
+// DefaultWalkPolicy, are not walked.
+//
+// Only the files that match ctxt are considered and the imports of _test.go
+// files are included since refactoring tools must update them as well. The
+// Context's ReadDir and OpenFile functions, if set, are used, which allows the
+// graph to be limited to the directories of a scoped Context (see the
+// contextutil package). Files that cannot be parsed are ignored.
+func BuildImportGraph(ctxt *build.Context, roots ...string) (*ImportGraph, error) {
+	return BuildImportGraphPolicy(ctxt, nil, roots...)
+}
+
+// BuildImportGraphPolicy is like BuildImportGraph, but uses policy to select
+// the directories and files that are walked. If policy is nil the
+// DefaultWalkPolicy is used.
+func BuildImportGraphPolicy(ctxt *build.Context, policy *WalkPolicy, roots ...string) (*ImportGraph, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	if policy == nil {
+		policy = DefaultWalkPolicy()
+	}
+	w := newPolicyWalker(ctxt, policy)
+	w.skipDir = func(dir string) bool {
+		return fileExists(ctxt, joinPath(ctxt, dir, "go.mod")) // nested module
+	}
+	g := &ImportGraph{
+		dirs:       make(map[string]string),
+		imports:    make(map[string][]string),
+		importedBy: make(map[string][]string),
+	}
+	ev := newTagEvaluator(ctxt)
+	for _, root := range roots {
+		modPath := ""
+		if fileExists(ctxt, joinPath(ctxt, root, "go.mod")) {
+			var err error
+			modPath, err = ModulePath(ctxt, root)
+			if err != nil {
+				return nil, err
+			}
+		}
+		var dirs []string // in the order walked
+		pkgs := make(map[string]map[string]bool)
+		w.file = func(dir, name string) error {
+			reason, header, err := classifyFile(ev, dir, name, true, nil)
+			if reason != 0 || err != nil {
+				return nil
+			}
+			f, err := parser.ParseFile(token.NewFileSet(), name, header, parser.ImportsOnly)
+			if err != nil {
+				return nil
+			}
+			imports := pkgs[dir]
+			if imports == nil {
+				imports = make(map[string]bool)
+				pkgs[dir] = imports
+				dirs = append(dirs, dir)
+			}
+			for _, spec := range f.Imports {
+				if imp, err := strconv.Unquote(spec.Path.Value); err == nil && imp != "C" {
+					imports[imp] = true
+				}
+			}
+			return nil
+		}
+		if err := w.walk(root); err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			var importPath string
+			if modPath != "" {
+				if rel, err := filepath.Rel(root, dir); err == nil {
+					importPath = path.Join(modPath, filepath.ToSlash(rel))
+				}
+			} else {
+				importPath, _ = ImportPath(ctxt, dir)
+			}
+			if importPath != "" && importPath != "." {
+				g.addPackage(importPath, dir, pkgs[dir])
+			}
+		}
+	}
+	for pkg, imports := range g.imports {
+		for _, imp := range imports {
+			g.importedBy[imp] = append(g.importedBy[imp], pkg)
+		}
+	}
+	for _, importers := range g.importedBy {
+		sort.Strings(importers)
+	}
+	return g, nil
+}
+
+// MatchImportGraph is like BuildImportGraph, but the graph is evaluated under
+// the Context returned by MatchContext for filename, which is also returned.
+// This ensures that the imports of a package that is only buildable on some
+// platforms, and the imports of its importers, are evaluated under the same
+// platform and that files excluded on that platform do not contribute edges
+// to the graph.
+func MatchImportGraph(orig *build.Context, filename string, roots ...string) (*ImportGraph, *build.Context, error) {
+	ctxt, err := MatchContext(orig, filename, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	g, err := BuildImportGraph(ctxt, roots...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return g, ctxt, nil
+}
+
+func (g *ImportGraph) addPackage(importPath, dir string, imports map[string]bool) {
+	g.dirs[importPath] = dir
+	a := g.imports[importPath]
+	for imp := range imports {
+		// Ignore the import of the package by its external tests
+		if imp != importPath {
+			a = append(a, imp)
+		}
+	}
+	g.imports[importPath] = util.SortUniqueStrings(a)
+}
+
+// Packages returns the sorted import paths of the packages in the graph.
+func (g *ImportGraph) Packages() []string {
+	pkgs := make([]string, 0, len(g.dirs))
+	for pkg := range g.dirs {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	return pkgs
+}
+
+// Dir returns the directory of the package with import path importPath or
+// an empty string if the package is not in the graph.
+func (g *ImportGraph) Dir(importPath string) string {
+	return g.dirs[importPath]
+}
+
+// Imports returns the sorted import paths directly imported by the package
+// with import path importPath. The result must not be modified.
+func (g *ImportGraph) Imports(importPath string) []string {
+	return g.imports[importPath]
+}
+
+// Importers returns the sorted import paths of the packages in the graph
+// that directly import importPath. The result must not be modified.
+func (g *ImportGraph) Importers(importPath string) []string {
+	return g.importedBy[importPath]
+}
+
+// ReverseDeps returns the sorted import paths of the packages in the graph
+// that directly or transitively import any of importPaths. The packages
+// named by importPaths are not included unless they import one another.
+func (g *ImportGraph) ReverseDeps(importPaths ...string) []string {
+	seen := make(map[string]bool)
+	queue := append([]string(nil), importPaths...)
+	for len(queue) != 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		for _, p := range g.importedBy[pkg] {
+			if !seen[p] {
+				seen[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	deps := make([]string, 0, len(seen))
+	for p := range seen {
+		deps = append(deps, p)
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+func fileExists(ctxt *build.Context, path string) bool {
+	rc, err := openReader(ctxt, path, nil)
+	if err != nil {
+		return false
+	}
+	rc.Close()
+	return true
+}
@@ -0,0 +1,141 @@
+package buildutil
+
+import (
+	"go/build"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/charlievieth/buildutil/buildutiltest"
+)
+
+func TestBuildImportGraph(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"go.mod":               "module example.com/m\n",
+		"a/a.go":               "package a\n\nimport \"fmt\"\n\nvar _ = fmt.Sprint\n",
+		"b/b.go":               "package b\n\nimport _ \"example.com/m/a\"\n",
+		"c/c.go":               "package c\n\nimport (\n\t_ \"example.com/m/b\"\n\t_ \"os\"\n)\n",
+		"c/c_test.go":          "package c_test\n\nimport _ \"example.com/m/c\"\n",
+		"d/d.go":               "package d\n",
+		"d/d_ignore.go":        "//go:build ignore\n\npackage d\n\nimport _ \"example.com/m/a\"\n",
+		"e/e_test.go":          "package e\n\nimport _ \"example.com/m/c\"\n",
+		"testdata/t/t.go":      "package t\n\nimport _ \"example.com/m/a\"\n",
+		"nested/go.mod":        "module example.com/nested\n",
+		"nested/n.go":          "package nested\n\nimport _ \"example.com/m/a\"\n",
+		"invalid/invalid.go":   "package invalid\n\nimport 123\n",
+		"invalid/x/x.go":       "package x\n\nimport _ \"example.com/m/invalid\"\n",
+		"_underscore/u/u.go":   "package u\n\nimport _ \"example.com/m/a\"\n",
+		"a/internal/ai/ai.go":  "package ai\n",
+		"a/internal/ai/ai2.go": "package ai\n\nimport \"C\"\n",
+	}
+	buildutiltest.WriteFiles(t, root, files)
+
+	ctxt := build.Default
+	ctxt.CgoEnabled = true
+	g, err := BuildImportGraph(&ctxt, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantPkgs := []string{
+		"example.com/m/a",
+		"example.com/m/a/internal/ai",
+		"example.com/m/b",
+		"example.com/m/c",
+		"example.com/m/d",
+		"example.com/m/e",
+		"example.com/m/invalid/x",
+	}
+	if pkgs := g.Packages(); !reflect.DeepEqual(pkgs, wantPkgs) {
+		t.Errorf("Packages() = %q; want: %q", pkgs, wantPkgs)
+	}
+	if dir, want := g.Dir("example.com/m/b"), filepath.Join(root, "b"); dir != want {
+		t.Errorf("Dir(%q) = %q; want: %q", "example.com/m/b", dir, want)
+	}
+
+	imports := map[string][]string{
+		"example.com/m/a":             {"fmt"},
+		"example.com/m/a/internal/ai": nil,
+		"example.com/m/c":             {"example.com/m/b", "os"},
+		"example.com/m/d":             nil,
+	}
+	for pkg, want := range imports {
+		if got := g.Imports(pkg); !reflect.DeepEqual(got, want) {
+			t.Errorf("Imports(%q) = %q; want: %q", pkg, got, want)
+		}
+	}
+
+	importers := map[string][]string{
+		"example.com/m/a": {"example.com/m/b"},
+		"example.com/m/c": {"example.com/m/e"},
+		"fmt":             {"example.com/m/a"},
+	}
+	for pkg, want := range importers {
+		if got := g.Importers(pkg); !reflect.DeepEqual(got, want) {
+			t.Errorf("Importers(%q) = %q; want: %q", pkg, got, want)
+		}
+	}
+
+	rdeps := []struct {
+		pkgs []string
+		want []string
+	}{
+		{[]string{"example.com/m/a"}, []string{"example.com/m/b", "example.com/m/c", "example.com/m/e"}},
+		{[]string{"example.com/m/c"}, []string{"example.com/m/e"}},
+		{[]string{"example.com/m/d"}, []string{}},
+		{[]string{"example.com/m/b", "example.com/m/invalid"}, []string{"example.com/m/c", "example.com/m/e", "example.com/m/invalid/x"}},
+	}
+	for _, test := range rdeps {
+		if got := g.ReverseDeps(test.pkgs...); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ReverseDeps(%q) = %q; want: %q", test.pkgs, got, test.want)
+		}
+	}
+}
+
+func TestMatchImportGraph(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"go.mod":         "module example.com/m\n",
+		"a/a_windows.go": "package a\n\nimport \"syscall\"\n\nvar _ = syscall.Getpid\n",
+		"b/b.go":         "package b\n",
+		"b/b_windows.go": "package b\n\nimport _ \"example.com/m/a\"\n",
+		"b/b_linux.go":   "package b\n\nimport _ \"example.com/m/c\"\n",
+		"c/c.go":         "package c\n\nimport _ \"example.com/m/b\"\n",
+		"d/d_darwin.go":  "package d\n\nimport _ \"example.com/m/a\"\n",
+		"e/e.go":         "//go:build !windows\n\npackage e\n\nimport _ \"example.com/m/b\"\n",
+		"e/e_windows.go": "package e\n",
+	}
+	buildutiltest.WriteFiles(t, root, files)
+
+	orig := build.Default
+	orig.GOOS = "linux"
+	orig.GOARCH = "amd64"
+	g, ctxt, err := MatchImportGraph(&orig, filepath.Join(root, "a", "a_windows.go"), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctxt.GOOS != "windows" {
+		t.Fatalf("GOOS = %q; want: %q", ctxt.GOOS, "windows")
+	}
+	if got, want := g.Imports("example.com/m/b"), []string{"example.com/m/a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Imports(%q) = %q; want: %q", "example.com/m/b", got, want)
+	}
+	want := []string{"example.com/m/b", "example.com/m/c"}
+	if got := g.ReverseDeps("example.com/m/a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("ReverseDeps(%q) = %q; want: %q", "example.com/m/a", got, want)
+	}
+
+	// Under linux a is not a package and b and c import each other
+	g, err = BuildImportGraph(&orig, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := g.ReverseDeps("example.com/m/a"); len(got) != 0 {
+		t.Errorf("BuildImportGraph: ReverseDeps(%q) = %q; want: []", "example.com/m/a", got)
+	}
+	want = []string{"example.com/m/b", "example.com/m/c", "example.com/m/e"}
+	if got := g.ReverseDeps("example.com/m/c"); !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildImportGraph: ReverseDeps(%q) = %q; want: %q", "example.com/m/c", got, want)
+	}
+}
@@ -0,0 +1,86 @@
+package buildutil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"go/build"
+	"io"
+)
+
+// A FileHash is the SHA-256 hash of a file's contents or header.
+type FileHash [sha256.Size]byte
+
+// String returns the hex encoding of h.
+func (h FileHash) String() string { return hex.EncodeToString(h[:]) }
+
+// HashFile returns the hash of the contents of the file at path. The
+// Context's OpenFile function, if set, is used to read the file. If ctxt
+// is nil build.Default is used.
+func HashFile(ctxt *build.Context, path string) (FileHash, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	var sum FileHash
+	rc, err := openReader(ctxt, path, nil)
+	if err != nil {
+		return sum, err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return sum, err
+	}
+	h.Sum(sum[:0])
+	return sum, nil
+}
+
+// HashFileHeader returns the hash of the header of the Go file at path. The
+// header is the leading comments and package clause of the file (see
+// ReadImportsFast), which contain its build constraints and package name,
+// but not its imports. Two files with the same header will always match
+// the same Contexts. If ctxt is nil build.Default is used.
+func HashFileHeader(ctxt *build.Context, path string) (FileHash, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	rc, err := openReader(ctxt, path, nil)
+	if err != nil {
+		return FileHash{}, err
+	}
+	data, err := readImportsFast(rc)
+	rc.Close()
+	if err != nil {
+		return FileHash{}, err
+	}
+	return sha256.Sum256(data), nil
+}
+
+// HashDir returns a hash of the names and contents of the regular files in
+// directory dir, subdirectories are ignored. The Context's ReadDir and
+// OpenFile functions, if set, are used. If ctxt is nil build.Default is
+// used.
+func HashDir(ctxt *build.Context, dir string) (FileHash, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	var sum FileHash
+	fis, err := readSourceDir(ctxt, dir)
+	if err != nil {
+		return sum, err
+	}
+	h := sha256.New()
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		fh, err := HashFile(ctxt, joinPath(ctxt, dir, fi.Name()))
+		if err != nil {
+			return sum, err
+		}
+		h.Write([]byte(fi.Name()))
+		h.Write([]byte{0})
+		h.Write(fh[:])
+	}
+	h.Sum(sum[:0])
+	return sum, nil
+}
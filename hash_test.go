@@ -0,0 +1,107 @@
+package buildutil
+
+import (
+	"go/build"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHashFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	ctxt := build.Default
+
+	p1 := write("a.go", "//go:build linux\n\npackage a\n\nfunc A() {}\n")
+	p2 := write("b.go", "//go:build linux\n\npackage a\n\nfunc B() {}\n")
+
+	h1, err := HashFile(&ctxt, p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := HashFile(&ctxt, p2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h1 == h2 {
+		t.Errorf("HashFile: files with different contents have the same hash: %s", h1)
+	}
+
+	// The headers are the same
+	hh1, err := HashFileHeader(&ctxt, p1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hh2, err := HashFileHeader(&ctxt, p2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hh1 != hh2 {
+		t.Errorf("HashFileHeader: %s != %s", hh1, hh2)
+	}
+
+	d1, err := HashDir(&ctxt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	d2, err := HashDir(&ctxt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d1 != d2 {
+		t.Errorf("HashDir: subdirectories should be ignored: %s != %s", d1, d2)
+	}
+	write("b.go", "package a\n")
+	d3, err := HashDir(&ctxt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d1 == d3 {
+		t.Errorf("HashDir: hash did not change after modifying a file: %s", d1)
+	}
+
+	if _, err := HashFile(&ctxt, filepath.Join(dir, "missing.go")); err == nil {
+		t.Error("HashFile: expected error for missing file")
+	}
+
+	// A nil Context is the same as build.Default
+	if h, err := HashFile(nil, p1); err != nil || h != h1 {
+		t.Errorf("HashFile(nil) = %s, %v; want: %s, %v", h, err, h1, nil)
+	}
+	if h, err := HashFileHeader(nil, p1); err != nil || h != hh1 {
+		t.Errorf("HashFileHeader(nil) = %s, %v; want: %s, %v", h, err, hh1, nil)
+	}
+	if h, err := HashDir(nil, dir); err != nil || h != d3 {
+		t.Errorf("HashDir(nil) = %s, %v; want: %s, %v", h, err, d3, nil)
+	}
+
+	// The slice returned by ReadDir, which may be cached, is not modified
+	cached, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, j := 0, len(cached)-1; i < j; i, j = i+1, j-1 {
+		cached[i], cached[j] = cached[j], cached[i]
+	}
+	want := append([]fs.FileInfo(nil), cached...)
+	ctxt.ReadDir = func(string) ([]fs.FileInfo, error) { return cached, nil }
+	if h, err := HashDir(&ctxt, dir); err != nil || h != d3 {
+		t.Errorf("HashDir(ReadDir) = %s, %v; want: %s, %v", h, err, d3, nil)
+	}
+	if !reflect.DeepEqual(cached, want) {
+		t.Error("HashDir: modified the slice returned by ReadDir")
+	}
+}
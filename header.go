@@ -0,0 +1,167 @@
+package buildutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/build/constraint"
+	"strconv"
+	"strings"
+)
+
+// A Header is the parsed header of a Go source file: the leading comments,
+// which contain any build constraints, and the package clause.
+type Header struct {
+	// Package is the package name or empty if the package clause is
+	// missing or malformed.
+	Package string
+
+	// Constraint is the build constraint of the file or nil if there is
+	// none. If the file has a "//go:build" line it is used, otherwise the
+	// "// +build" lines are combined.
+	Constraint constraint.Expr
+
+	// Offsets are the byte offsets of the build constraints in the source.
+	Offsets HeaderOffsets
+
+	// BinaryOnly is true if the file has a "//go:binary-only-package"
+	// comment.
+	BinaryOnly bool
+
+	// Debug are the "//go:debug" directives that precede the package
+	// clause, in the order they appear. Malformed directives, which lack
+	// a "key=value" setting, are omitted.
+	Debug []GoDebug
+
+	// Lines are the "//line" directives that precede the package clause.
+	Lines []LineDirective
+}
+
+// A GoDebug is a "//go:debug key=value" directive, which sets the default
+// value of a GODEBUG setting for the main package or test (go1.21+).
+type GoDebug struct {
+	Key    string
+	Value  string
+	Offset int // byte offset of the directive
+}
+
+// String returns the setting in the form used by the GODEBUG environment
+// variable: "key=value".
+func (d GoDebug) String() string { return d.Key + "=" + d.Value }
+
+// A LineDirective is a "//line filename:line[:col]" directive, which
+// changes the position reported for the source that follows it.
+type LineDirective struct {
+	Filename string // may be empty
+	Line     int
+	Col      int // zero if omitted
+	Offset   int // byte offset of the directive
+}
+
+// GODEBUG returns the "//go:debug" settings of the header as a value for the
+// GODEBUG environment variable (e.g. "panicnil=1,http2client=0"). The go
+// command embeds these defaults in the binaries it builds, so this is only a
+// suggestion for callers that need them elsewhere; GoCommand does not set
+// GODEBUG. An empty string is returned if there are none.
+func (h *Header) GODEBUG() string {
+	if len(h.Debug) == 0 {
+		return ""
+	}
+	a := make([]string, len(h.Debug))
+	for i, d := range h.Debug {
+		a[i] = d.String()
+	}
+	return strings.Join(a, ",")
+}
+
+// ParseHeader parses the header of the Go source file src. Only the header
+// of src is examined so src may be an entire file.
+//
+// ParseHeader is intended for untrusted input, such as the unsaved buffer
+// of an editor. A missing or malformed package clause is not an error,
+// Package is left empty. An error is returned if the build constraints of
+// the file are invalid, in which case the returned Header holds the rest of
+// what was parsed.
+func ParseHeader(src []byte) (*Header, error) {
+	return parseHeader(src)
+}
+
+// errHeaderInternal is returned by ParseHeader if the reader of the header
+// panics, which indicates a bug in the reader.
+var errHeaderInternal = errors.New("buildutil: internal error reading header")
+
+// readHeader reads the header of src into info with readPackageClause,
+// converting the panic raised by the import reader when it detects that it
+// is looping into an error wrapping errHeaderInternal.
+func readHeader(src []byte, info *fileInfo) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			*info = fileInfo{}
+			err = fmt.Errorf("%w: %v", errHeaderInternal, e)
+		}
+	}()
+	return readPackageClause(bytes.NewReader(src), info)
+}
+
+func parseHeader(src []byte) (*Header, error) {
+	info := fileInfo{name: "dummy.go"}
+	err := readHeader(src, &info)
+	if err != nil && err != errSyntax {
+		return nil, err // NUL byte in input
+	}
+	header := info.header
+	h := &Header{Debug: info.debug, Lines: info.lines}
+	if name, err := readPackageName(header); err == nil {
+		h.Package = name
+	}
+	var sawBinaryOnly bool
+	h.Offsets, sawBinaryOnly, err = scanFileHeader(header)
+	if err != nil {
+		return h, err
+	}
+	h.BinaryOnly = sawBinaryOnly
+	x, err := parseBuildConstraint(header)
+	if err != nil {
+		return h, err
+	}
+	h.Constraint = x
+	return h, nil
+}
+
+var (
+	goDebugPrefix = []byte("//go:debug")
+	linePrefix    = []byte("//line ")
+)
+
+// parseGoDebug parses the "key=value" argument of a //go:debug directive.
+func parseGoDebug(arg []byte) (GoDebug, bool) {
+	if len(arg) != 0 && arg[0] != ' ' && arg[0] != '\t' {
+		return GoDebug{}, false // "//go:debugx" is not a go:debug directive
+	}
+	k, v, ok := cut(string(bytes.TrimSpace(arg)), "=")
+	if !ok || k == "" || strings.ContainsAny(k, " \t") {
+		return GoDebug{}, false
+	}
+	return GoDebug{Key: k, Value: v}, true
+}
+
+// parseLineDirective parses the "filename:line[:col]" argument of a //line
+// directive.
+func parseLineDirective(s string) (LineDirective, bool) {
+	s = strings.TrimRight(s, " \t\r")
+	i := strings.LastIndexByte(s, ':')
+	if i == -1 {
+		return LineDirective{}, false
+	}
+	n, err := strconv.Atoi(s[i+1:])
+	if err != nil || n <= 0 {
+		return LineDirective{}, false
+	}
+	d := LineDirective{Filename: s[:i], Line: n}
+	if j := strings.LastIndexByte(d.Filename, ':'); j != -1 {
+		if m, err := strconv.Atoi(d.Filename[j+1:]); err == nil && m > 0 {
+			d.Filename, d.Line, d.Col = d.Filename[:j], m, n
+		}
+	}
+	return d, true
+}
@@ -0,0 +1,115 @@
+package buildutil
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		src        string
+		pkg        string
+		constraint string
+		binaryOnly bool
+		err        bool
+	}{
+		{"", "", "", false, false},
+		{"package p\n", "p", "", false, false},
+		{"package p", "p", "", false, false},
+		{"// Copyright\n\npackage main\n\nfunc main() {}\n", "main", "", false, false},
+		{"//go:build linux && amd64\n\npackage p\n", "p", "linux && amd64", false, false},
+		{"// +build linux darwin\n// +build amd64\n\npackage p\n", "p", "(linux || darwin) && amd64", false, false},
+		{"//go:binary-only-package\n\npackage p\n", "p", "", true, false},
+		{"//go:build ignore\n\npackage", "", "ignore", false, false},
+		{"//go:build linux\n\n", "", "linux", false, false},
+		{"//go:build (\n\npackage p\n", "p", "", false, true},
+		{"//go:build a\n//go:build b\n\npackage p\n", "p", "", false, true},
+		{"//go:build " + strings.Repeat("!", maxConstraintDepth+1) + "a\n\npackage p\n", "p", "", false, true},
+		{"//go:build " + strings.Repeat("(", maxConstraintDepth+1) + "a\n\npackage p\n", "p", "", false, true},
+		{"package p\x00\n", "", "", false, true},
+	}
+	for _, test := range tests {
+		h, err := ParseHeader([]byte(test.src))
+		if (err != nil) != test.err {
+			t.Errorf("ParseHeader(%.40q) error = %v; want error: %t", test.src, err, test.err)
+		}
+		if h == nil {
+			if !test.err {
+				t.Errorf("ParseHeader(%.40q) = nil", test.src)
+			}
+			continue
+		}
+		var constraint string
+		if h.Constraint != nil {
+			constraint = h.Constraint.String()
+		}
+		if h.Package != test.pkg || constraint != test.constraint || h.BinaryOnly != test.binaryOnly {
+			t.Errorf("ParseHeader(%.40q) = {%q, %q, %t}; want: {%q, %q, %t}", test.src,
+				h.Package, constraint, h.BinaryOnly, test.pkg, test.constraint, test.binaryOnly)
+		}
+	}
+}
+
+func TestParseHeaderDirectives(t *testing.T) {
+	const src = "// Copyright\n" +
+		"\n" +
+		"//go:build linux\n" +
+		"//go:debug panicnil=1\n" +
+		"//go:debug  http2client=0 \n" +
+		"//go:debug missing_value\n" +
+		"//go:debugx=1\n" +
+		"/* //go:debug in_comment=1 */\n" +
+		"//line gen.go:10\n" +
+		"//line C:/dir/gen.go:20:5\n" +
+		"//line bad\n" +
+		" //line indented.go:1\n" +
+		"\n" +
+		"package main // not a //go:debug x=y directive\n"
+
+	h, err := ParseHeader([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDebug := []GoDebug{
+		{"panicnil", "1", strings.Index(src, "//go:debug panicnil")},
+		{"http2client", "0", strings.Index(src, "//go:debug  http2client")},
+	}
+	if !reflect.DeepEqual(h.Debug, wantDebug) {
+		t.Errorf("Debug = %+v; want: %+v", h.Debug, wantDebug)
+	}
+	wantLines := []LineDirective{
+		{"gen.go", 10, 0, strings.Index(src, "//line gen.go")},
+		{"C:/dir/gen.go", 20, 5, strings.Index(src, "//line C:")},
+	}
+	if !reflect.DeepEqual(h.Lines, wantLines) {
+		t.Errorf("Lines = %+v; want: %+v", h.Lines, wantLines)
+	}
+	if s := h.GODEBUG(); s != "panicnil=1,http2client=0" {
+		t.Errorf("GODEBUG() = %q; want: %q", s, "panicnil=1,http2client=0")
+	}
+	if h.Constraint == nil || h.Constraint.String() != "linux" {
+		t.Errorf("Constraint = %v; want: %s", h.Constraint, "linux")
+	}
+
+	// Directives after the package clause are ignored
+	h, err = ParseHeader([]byte("package p\n\n//go:debug panicnil=1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Debug != nil || h.GODEBUG() != "" {
+		t.Errorf("Debug = %+v; want: nil", h.Debug)
+	}
+}
+
+func TestParseConstraintDepth(t *testing.T) {
+	line := "//go:build " + strings.Repeat("(", 100) + "a" + strings.Repeat(")", 100)
+	if _, err := parseConstraint(line); err != nil {
+		t.Errorf("parseConstraint: unexpected error: %v", err)
+	}
+	line = "//go:build " + strings.Repeat("!", maxConstraintDepth*10) + "a"
+	if _, err := parseConstraint(line); !errors.Is(err, errConstraintDepth) {
+		t.Errorf("parseConstraint: error = %v; want: %v", err, errConstraintDepth)
+	}
+}
@@ -0,0 +1,61 @@
+package buildutil
+
+// An IgnoreDirPolicy is a set of flags that specify which directories are
+// ignored when walking a source tree.
+type IgnoreDirPolicy uint
+
+const (
+	// IgnoreDot ignores directories beginning with ".", such as ".git".
+	IgnoreDot IgnoreDirPolicy = 1 << iota
+
+	// IgnoreUnderscore ignores directories beginning with "_".
+	IgnoreUnderscore
+
+	// IgnoreTestdata ignores "testdata" directories.
+	IgnoreTestdata
+
+	// IgnoreVendor ignores "vendor" directories.
+	IgnoreVendor
+
+	// IgnoreNodeModules ignores "node_modules" directories, which often
+	// contain a large number of files and are never part of a Go package.
+	IgnoreNodeModules
+)
+
+// DefaultIgnoreDirPolicy is the policy used by IsIgnoredDir.
+const DefaultIgnoreDirPolicy = IgnoreDot | IgnoreUnderscore | IgnoreTestdata |
+	IgnoreVendor | IgnoreNodeModules
+
+// IsIgnored reports if the directory with base name name is ignored by the
+// policy. The empty name is never ignored.
+func (p IgnoreDirPolicy) IsIgnored(name string) bool {
+	if name == "" {
+		return false
+	}
+	switch name[0] {
+	case '.':
+		return p&IgnoreDot != 0
+	case '_':
+		return p&IgnoreUnderscore != 0
+	}
+	switch name {
+	case "testdata":
+		return p&IgnoreTestdata != 0
+	case "vendor":
+		return p&IgnoreVendor != 0
+	case "node_modules":
+		return p&IgnoreNodeModules != 0
+	}
+	return false
+}
+
+// IsIgnoredDir reports if the directory with base name name should be
+// skipped when walking a source tree. Following the conventions of the go
+// command, directories beginning with "." or "_" and "testdata" directories
+// are ignored, as are "vendor" and "node_modules" directories.
+//
+// Use IgnoreDirPolicy.IsIgnored for finer control over which directories
+// are ignored.
+func IsIgnoredDir(name string) bool {
+	return DefaultIgnoreDirPolicy.IsIgnored(name)
+}
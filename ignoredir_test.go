@@ -0,0 +1,50 @@
+package buildutil
+
+import "testing"
+
+func TestIsIgnoredDir(t *testing.T) {
+	tests := map[string]bool{
+		"":             false,
+		".":            true,
+		".git":         true,
+		"_obj":         true,
+		"testdata":     true,
+		"vendor":       true,
+		"node_modules": true,
+		"pkg":          false,
+		"internal":     false,
+		"testdata1":    false,
+		"x_vendor":     false,
+	}
+	for name, want := range tests {
+		if got := IsIgnoredDir(name); got != want {
+			t.Errorf("IsIgnoredDir(%q) = %t; want: %t", name, got, want)
+		}
+	}
+}
+
+func TestIgnoreDirPolicy(t *testing.T) {
+	tests := []struct {
+		policy IgnoreDirPolicy
+		name   string
+		want   bool
+	}{
+		{0, ".git", false},
+		{0, "testdata", false},
+		{IgnoreDot, ".git", true},
+		{IgnoreDot, "_obj", false},
+		{IgnoreUnderscore, "_obj", true},
+		{IgnoreTestdata, "testdata", true},
+		{IgnoreTestdata, "vendor", false},
+		{IgnoreVendor, "vendor", true},
+		{IgnoreNodeModules, "node_modules", true},
+		{DefaultIgnoreDirPolicy &^ IgnoreVendor, "vendor", false},
+		{DefaultIgnoreDirPolicy &^ IgnoreVendor, "testdata", true},
+	}
+	for _, test := range tests {
+		if got := test.policy.IsIgnored(test.name); got != test.want {
+			t.Errorf("IgnoreDirPolicy(%#x).IsIgnored(%q) = %t; want: %t",
+				uint(test.policy), test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package buildutil
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// importOSHints maps packages that can only be built for some GOOS values to
+// those values. Sub-packages (e.g. "golang.org/x/sys/windows/registry") have
+// the same hint as their parent. A nil value means the unix OSes, js and
+// wasip1, which is where the unix system call packages can be built.
+var importOSHints = map[string][]string{
+	"syscall/js":               {"js"},
+	"golang.org/x/sys/windows": {"windows"},
+	"golang.org/x/sys/plan9":   {"plan9"},
+	"golang.org/x/sys/unix":    nil,
+	"internal/syscall/windows": {"windows"},
+	"internal/syscall/unix":    nil,
+}
+
+// lookupImportOSHint returns the GOOS values that the package with import
+// path path can be built for, or false if path is not platform specific.
+func lookupImportOSHint(path string) (map[string]bool, bool) {
+	for p := path; ; {
+		if oses, ok := importOSHints[p]; ok {
+			m := make(map[string]bool)
+			if oses == nil {
+				for os := range unixOS {
+					m[os] = true
+				}
+				m["js"] = true
+				m["wasip1"] = true
+			}
+			for _, os := range oses {
+				m[os] = true
+			}
+			return m, true
+		}
+		i := strings.LastIndexByte(p, '/')
+		if i == -1 {
+			return nil, false
+		}
+		p = p[:i]
+	}
+}
+
+// importOSHint returns the GOOS values allowed by the platform specific
+// packages imported by the Go file header, or nil if it imports none or if
+// the imports conflict (e.g. "syscall/js" and "golang.org/x/sys/windows").
+func importOSHint(header []byte) map[string]bool {
+	// Fast check for the common case
+	if !bytes.Contains(header, []byte("import")) {
+		return nil
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "", header, parser.ImportsOnly)
+	if err != nil {
+		return nil
+	}
+	var hint map[string]bool
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		oses, ok := lookupImportOSHint(path)
+		if !ok {
+			continue
+		}
+		if hint == nil {
+			hint = oses
+			continue
+		}
+		for os := range hint {
+			if !oses[os] {
+				delete(hint, os)
+			}
+		}
+	}
+	if len(hint) == 0 {
+		return nil
+	}
+	return hint
+}
+
+// preferredOS returns the first OS of the preference list of prefs that is
+// in oses or the lexically first OS in oses if none are preferred.
+func preferredOS(prefs *matchPrefs, oses map[string]bool) string {
+	for _, os := range prefs.osList {
+		if oses[os] {
+			return os
+		}
+	}
+	return mapKeys(oses)[0]
+}
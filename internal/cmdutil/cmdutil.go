@@ -0,0 +1,140 @@
+// Package cmdutil defines the exit codes and error output shared by the
+// commands of buildutil so that scripts and editors can act on the kind of
+// error without parsing its message.
+package cmdutil
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/charlievieth/buildutil"
+)
+
+// An ExitCode is the exit status of a command. The values are stable.
+type ExitCode int
+
+const (
+	// ExitOK means the command succeeded (e.g. the file was matched).
+	ExitOK ExitCode = 0
+
+	// ExitError means the command failed for any reason not covered by
+	// the other exit codes, such as a missing file or an invalid flag.
+	ExitError ExitCode = 1
+
+	// ExitMismatch means no build.Context can build the file (see
+	// buildutil.MatchError.Permanent).
+	ExitMismatch ExitCode = 2
+
+	// ExitParse means a build constraint could not be parsed.
+	ExitParse ExitCode = 3
+)
+
+var exitCodeNames = [...]string{
+	ExitOK:       "ok",
+	ExitError:    "error",
+	ExitMismatch: "mismatch",
+	ExitParse:    "parse",
+}
+
+func (c ExitCode) String() string {
+	if 0 <= c && int(c) < len(exitCodeNames) {
+		return exitCodeNames[c]
+	}
+	return "ExitCode(" + strconv.Itoa(int(c)) + ")"
+}
+
+// Classify returns the ExitCode for err, which is ExitOK if err is nil.
+func Classify(err error) ExitCode {
+	if err == nil {
+		return ExitOK
+	}
+	if errors.Is(err, buildutil.ErrInvalidConstraint) {
+		return ExitParse
+	}
+	var me *buildutil.MatchError
+	if errors.As(err, &me) && me.Permanent {
+		return ExitMismatch
+	}
+	return ExitError
+}
+
+// An Error is the JSON representation of an error, which is printed in an
+// Envelope.
+type Error struct {
+	Code    ExitCode              `json:"code"`
+	Kind    string                `json:"kind"`           // name of the Code
+	Path    string                `json:"path,omitempty"` // file the error is for, if any
+	Message string                `json:"message"`
+	Match   *buildutil.MatchError `json:"match,omitempty"` // the MatchError, if any
+}
+
+// An Envelope is the JSON object printed by the commands when they fail and
+// JSON output is requested. The "match" field of the error is the JSON
+// encoding of the buildutil.MatchError, if the error is one.
+type Envelope struct {
+	Error *Error `json:"error"`
+}
+
+// NewError returns the Error for err, which must not be nil.
+func NewError(err error) *Error {
+	code := Classify(err)
+	e := &Error{Code: code, Kind: code.String(), Message: err.Error()}
+	var me *buildutil.MatchError
+	if errors.As(err, &me) {
+		e.Path = me.Path
+		e.Match = me
+	}
+	return e
+}
+
+// WriteError writes err to w as an indented JSON Envelope if asJSON is true,
+// otherwise as an "error: " prefixed line, and returns the ExitCode of err.
+func WriteError(w io.Writer, err error, asJSON bool) ExitCode {
+	e := NewError(err)
+	if !asJSON {
+		fmt.Fprintln(w, "error:", err)
+		return e.Code
+	}
+	data, jerr := json.MarshalIndent(&Envelope{Error: e}, "", "    ")
+	if jerr != nil {
+		fmt.Fprintln(w, "error:", err)
+		return e.Code
+	}
+	w.Write(append(data, '\n'))
+	return e.Code
+}
+
+// Fatal prints err, as JSON to stdout if asJSON is true otherwise to stderr,
+// and exits with the ExitCode of err.
+func Fatal(err error, asJSON bool) {
+	w := os.Stderr
+	if asJSON {
+		w = os.Stdout
+	}
+	os.Exit(int(WriteError(w, err, asJSON)))
+}
+
+// Usagef prints the formatted usage error followed by the usage message of
+// the command to stderr and exits with ExitError.
+func Usagef(usage func(), format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "error: "+format+"\n", args...)
+	usage()
+	os.Exit(int(ExitError))
+}
+
+// ParseFlags is like flag.Parse, but exits with ExitError, instead of 2 which
+// is ExitMismatch, if the command-line flags are invalid.
+func ParseFlags() {
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(int(ExitOK))
+		}
+		os.Exit(int(ExitError))
+	}
+}
@@ -0,0 +1,86 @@
+package cmdutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/charlievieth/buildutil"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ExitCode
+	}{
+		{nil, ExitOK},
+		{errors.New("no such file"), ExitError},
+		{&buildutil.MatchError{Path: "a.go", Err: buildutil.ErrMatchContext}, ExitError},
+		{&buildutil.MatchError{Path: "a.go", Permanent: true, Err: buildutil.ErrImpossibleGoVersion}, ExitMismatch},
+		{fmt.Errorf("wrapped: %w", &buildutil.MatchError{Path: "a.go", Permanent: true,
+			Err: buildutil.ErrFilenameConflict}), ExitMismatch},
+		{&buildutil.MatchError{Path: "a.go", Err: fmt.Errorf("%w: missing close paren",
+			buildutil.ErrInvalidConstraint)}, ExitParse},
+	}
+	for _, x := range tests {
+		if got := Classify(x.err); got != x.want {
+			t.Errorf("Classify(%v) = %s; want: %s", x.err, got, x.want)
+		}
+	}
+}
+
+func TestExitCodeString(t *testing.T) {
+	for code, want := range map[ExitCode]string{
+		ExitOK:       "ok",
+		ExitError:    "error",
+		ExitMismatch: "mismatch",
+		ExitParse:    "parse",
+		ExitCode(9):  "ExitCode(9)",
+	} {
+		if got := code.String(); got != want {
+			t.Errorf("ExitCode(%d).String() = %q; want: %q", int(code), got, want)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	err := &buildutil.MatchError{Path: "a.go", Permanent: true, Err: buildutil.ErrImpossibleGoVersion}
+
+	var buf bytes.Buffer
+	if code := WriteError(&buf, err, true); code != ExitMismatch {
+		t.Errorf("WriteError() = %s; want: %s", code, ExitMismatch)
+	}
+	var env struct {
+		Error struct {
+			Error
+			Match map[string]interface{} `json:"match"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &env); err != nil {
+		t.Fatal(err)
+	}
+	want := Error{Code: ExitMismatch, Kind: "mismatch", Path: "a.go", Message: err.Error()}
+	if got := env.Error.Error; !reflect.DeepEqual(got, want) {
+		t.Errorf("WriteError() = %+v; want: %+v", got, want)
+	}
+	wantMatch := map[string]interface{}{
+		"path":      "a.go",
+		"permanent": true,
+		"kind":      "impossible-go-version",
+		"message":   buildutil.ErrImpossibleGoVersion.Error(),
+	}
+	if !reflect.DeepEqual(env.Error.Match, wantMatch) {
+		t.Errorf("WriteError(): match = %v; want: %v", env.Error.Match, wantMatch)
+	}
+
+	buf.Reset()
+	if code := WriteError(&buf, errors.New("no such file"), false); code != ExitError {
+		t.Errorf("WriteError() = %s; want: %s", code, ExitError)
+	}
+	if got, want := buf.String(), "error: no such file\n"; got != want {
+		t.Errorf("WriteError() = %q; want: %q", got, want)
+	}
+}
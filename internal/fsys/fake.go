@@ -0,0 +1,171 @@
+package fsys
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A Fake is an in-memory FS that maps file names, which must be absolute
+// and are cleaned with filepath.Clean, to files. Parent directories are
+// created implicitly.
+type Fake map[string]FakeFile
+
+// A FakeFile is a file in a Fake FS. The zero value is a regular file.
+type FakeFile struct {
+	Dir  bool   // file is a directory
+	Link string // target of a symbolic link (may be relative)
+}
+
+// MaxLinks is the maximum number of symbolic links followed when resolving
+// a name.
+const MaxLinks = 40
+
+// ErrTooManyLinks is the error returned when resolving a name requires
+// following more than MaxLinks symbolic links.
+var ErrTooManyLinks = errors.New("too many levels of symbolic links")
+
+// Resolve returns the slash-separated absolute name with its symbolic links
+// resolved. Each element of name is resolved in turn, so that links to
+// directories are followed. The readlink function returns the target of the
+// symbolic link at the resolved name of an element, which may be relative to
+// its directory, an empty target if it is not a link, and false if it does not
+// exist. If followLast is false, a symbolic link in the last element of name
+// is not followed. Errors are of type *fs.PathError with the operation op.
+//
+// Resolve is shared by the in-memory file systems of this module.
+func Resolve(op, name string, followLast bool, readlink func(name string) (target string, ok bool)) (string, error) {
+	name = path.Clean("/" + name)
+	links := 0
+	for {
+		resolved := "/"
+		rest := strings.TrimPrefix(name, "/")
+		restarted := false
+		for rest != "" {
+			var elem string
+			if i := strings.IndexByte(rest, '/'); i >= 0 {
+				elem, rest = rest[:i], rest[i+1:]
+			} else {
+				elem, rest = rest, ""
+			}
+			next := path.Join(resolved, elem)
+			target, ok := readlink(next)
+			if !ok {
+				return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+			}
+			if target != "" && (rest != "" || followLast) {
+				if links++; links > MaxLinks {
+					return "", &fs.PathError{Op: op, Path: name, Err: ErrTooManyLinks}
+				}
+				if !strings.HasPrefix(target, "/") {
+					target = path.Join(resolved, target)
+				}
+				name = path.Join(target, rest)
+				restarted = true
+				break
+			}
+			resolved = next
+		}
+		if !restarted {
+			return resolved, nil
+		}
+	}
+}
+
+// lookup returns the file at the cleaned name, which may be an implicit
+// directory, or nil if it does not exist.
+func (f Fake) lookup(name string) *FakeFile {
+	if file, ok := f[name]; ok {
+		return &file
+	}
+	prefix := name
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	for k := range f {
+		if strings.HasPrefix(filepath.Clean(k), prefix) {
+			return &FakeFile{Dir: true}
+		}
+	}
+	return nil
+}
+
+// resolve returns the resolved name of the file at name and the file.
+// If followLast is false, a symbolic link in the last element of name
+// is not followed.
+func (f Fake) resolve(op, name string, followLast bool) (string, *FakeFile, error) {
+	name = filepath.Clean(name)
+	vol := filepath.VolumeName(name)
+	resolved, err := Resolve(op, filepath.ToSlash(name[len(vol):]), followLast, func(name string) (string, bool) {
+		file := f.lookup(vol + filepath.FromSlash(name))
+		if file == nil {
+			return "", false
+		}
+		target := file.Link
+		return filepath.ToSlash(target[len(filepath.VolumeName(target)):]), true
+	})
+	if err != nil {
+		if pe, ok := err.(*fs.PathError); ok {
+			pe.Path = vol + filepath.FromSlash(pe.Path)
+		}
+		return "", nil, err
+	}
+	resolved = vol + filepath.FromSlash(resolved)
+	file := f.lookup(resolved)
+	if file == nil {
+		file = &FakeFile{Dir: true} // root
+	}
+	return resolved, file, nil
+}
+
+func (f Fake) stat(op, name string, followLast bool) (fs.FileInfo, error) {
+	path, file, err := f.resolve(op, name, followLast)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeInfo{path: path, file: file}, nil
+}
+
+// Stat returns the FileInfo of the file at name following symbolic links.
+func (f Fake) Stat(name string) (fs.FileInfo, error) { return f.stat("stat", name, true) }
+
+// Lstat returns the FileInfo of the file at name without following a
+// symbolic link in the last element of name.
+func (f Fake) Lstat(name string) (fs.FileInfo, error) { return f.stat("lstat", name, false) }
+
+// EvalSymlinks returns name after resolving any symbolic links.
+func (f Fake) EvalSymlinks(name string) (string, error) {
+	path, _, err := f.resolve("lstat", name, true)
+	return path, err
+}
+
+// SameFile reports if fi1 and fi2 describe the same file. Both must have
+// been returned by Stat or Lstat of the Fake.
+func (f Fake) SameFile(fi1, fi2 fs.FileInfo) bool {
+	a, ok1 := fi1.(*fakeInfo)
+	b, ok2 := fi2.(*fakeInfo)
+	return ok1 && ok2 && a.path == b.path
+}
+
+type fakeInfo struct {
+	path string // resolved path
+	file *FakeFile
+}
+
+func (fi *fakeInfo) Name() string { return filepath.Base(fi.path) }
+func (fi *fakeInfo) Size() int64  { return 0 }
+func (fi *fakeInfo) Mode() fs.FileMode {
+	switch {
+	case fi.file.Link != "":
+		return fs.ModeSymlink | 0777
+	case fi.file.Dir:
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+func (fi *fakeInfo) ModTime() time.Time { return time.Time{} }
+func (fi *fakeInfo) IsDir() bool        { return fi.Mode().IsDir() }
+func (fi *fakeInfo) Sys() interface{}   { return nil }
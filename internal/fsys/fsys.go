@@ -0,0 +1,69 @@
+// Package fsys provides the file system operations used by buildutil and
+// contextutil when a build.Context does not provide its own. The operations
+// may be replaced in tests, which allows symlink-heavy logic to be tested
+// without creating real symlinks (which is not always possible on Windows).
+package fsys
+
+import (
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/charlievieth/buildutil/internal/util"
+)
+
+// An FS provides the file system operations used by this module.
+type FS interface {
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	EvalSymlinks(path string) (string, error)
+	SameFile(fi1, fi2 fs.FileInfo) bool
+}
+
+// OS is the FS of the local file system.
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Stat(name string) (fs.FileInfo, error)    { return os.Stat(name) }
+func (osFS) Lstat(name string) (fs.FileInfo, error)   { return os.Lstat(name) }
+func (osFS) EvalSymlinks(path string) (string, error) { return util.EvalSymlinks(path) }
+func (osFS) SameFile(fi1, fi2 fs.FileInfo) bool       { return os.SameFile(fi1, fi2) }
+
+var (
+	mu      sync.RWMutex
+	current = OS
+)
+
+func get() FS {
+	mu.RLock()
+	f := current
+	mu.RUnlock()
+	return f
+}
+
+// Set replaces the FS used by the functions of this package and returns a
+// function that restores the previous FS. It is intended for tests.
+func Set(f FS) (restore func()) {
+	mu.Lock()
+	prev := current
+	current = f
+	mu.Unlock()
+	return func() {
+		mu.Lock()
+		current = prev
+		mu.Unlock()
+	}
+}
+
+// Stat is like os.Stat, but uses the current FS.
+func Stat(name string) (fs.FileInfo, error) { return get().Stat(name) }
+
+// Lstat is like os.Lstat, but uses the current FS.
+func Lstat(name string) (fs.FileInfo, error) { return get().Lstat(name) }
+
+// EvalSymlinks is like filepath.EvalSymlinks, but uses the current FS.
+func EvalSymlinks(path string) (string, error) { return get().EvalSymlinks(path) }
+
+// SameFile is like os.SameFile, but uses the current FS.
+func SameFile(fi1, fi2 fs.FileInfo) bool { return get().SameFile(fi1, fi2) }
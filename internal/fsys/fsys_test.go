@@ -0,0 +1,105 @@
+package fsys
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFake(t *testing.T) {
+	p := filepath.FromSlash
+	f := Fake{
+		p("/a/b/file.go"): {},
+		p("/a/empty"):     {Dir: true},
+		p("/a/abs"):       {Link: p("/a/b")},
+		p("/a/rel"):       {Link: "b"},
+		p("/a/b/up"):      {Link: ".."},
+		p("/loop1"):       {Link: p("/loop2")},
+		p("/loop2"):       {Link: p("/loop1")},
+	}
+
+	tests := []struct {
+		name  string
+		path  string // resolved path
+		isDir bool
+	}{
+		{"/a", "/a", true},
+		{"/a/empty", "/a/empty", true},
+		{"/a/b/file.go", "/a/b/file.go", false},
+		{"/a/abs/file.go", "/a/b/file.go", false},
+		{"/a/rel/file.go", "/a/b/file.go", false},
+		{"/a/abs", "/a/b", true},
+		{"/a/b/up/b/up/rel", "/a/b", true},
+	}
+	for _, x := range tests {
+		fi, err := f.Stat(p(x.name))
+		if err != nil {
+			t.Errorf("Stat(%q): %v", x.name, err)
+			continue
+		}
+		if fi.IsDir() != x.isDir {
+			t.Errorf("Stat(%q).IsDir() = %t; want: %t", x.name, fi.IsDir(), x.isDir)
+		}
+		path, err := f.EvalSymlinks(p(x.name))
+		if err != nil || path != p(x.path) {
+			t.Errorf("EvalSymlinks(%q) = %q, %v; want: %q, nil", x.name, path, err, p(x.path))
+		}
+		want, err := f.Stat(p(x.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !f.SameFile(fi, want) {
+			t.Errorf("SameFile(%q, %q) = false; want: true", x.name, x.path)
+		}
+	}
+
+	fi, err := f.Lstat(p("/a/abs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("Lstat(%q).Mode() = %s; want symlink", "/a/abs", fi.Mode())
+	}
+	dir, _ := f.Stat(p("/a/b"))
+	if f.SameFile(fi, dir) {
+		t.Errorf("SameFile(Lstat(%q), Stat(%q)) = true; want: false", "/a/abs", "/a/b")
+	}
+
+	if _, err := f.Stat(p("/a/missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat(%q) = %v; want: %v", "/a/missing", err, fs.ErrNotExist)
+	}
+	if _, err := f.Stat(p("/loop1")); !errors.Is(err, ErrTooManyLinks) {
+		t.Errorf("Stat(%q) = %v; want: %v", "/loop1", err, ErrTooManyLinks)
+	}
+}
+
+func TestSet(t *testing.T) {
+	name := filepath.FromSlash("/fake/dir")
+	restore := Set(Fake{name: {Dir: true}})
+	fi, err := Stat(name)
+	if err != nil || !fi.IsDir() {
+		t.Errorf("Stat(%q) = %v, %v; want directory", name, fi, err)
+	}
+	restore()
+
+	if _, err := Stat(name); !os.IsNotExist(err) {
+		t.Errorf("Stat(%q) after restore = %v; want: %v", name, err, fs.ErrNotExist)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi1, err := Stat(wd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi2, err := Lstat(wd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !SameFile(fi1, fi2) {
+		t.Errorf("SameFile(%q, %q) = false; want: true", wd, wd)
+	}
+}
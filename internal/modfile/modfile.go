@@ -0,0 +1,148 @@
+// Package modfile implements a minimal parser for go.mod and go.work files.
+//
+// Only the directives used by buildutil are parsed (module, go, toolchain,
+// use and replace), all others are ignored. See golang.org/x/mod/modfile
+// for a complete parser.
+package modfile
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A Replace is a replace directive.
+type Replace struct {
+	Old        string // module path being replaced
+	OldVersion string // optional
+	New        string // replacement module path or directory
+	NewVersion string // empty if New is a directory
+}
+
+// A File is a parsed go.mod or go.work file.
+type File struct {
+	Module    string
+	Go        string
+	Toolchain string
+	Use       []string // go.work only
+	Replace   []Replace
+}
+
+// Parse parses the go.mod or go.work file data.
+func Parse(data []byte) (*File, error) {
+	var f File
+	block := "" // verb of the current block, if any
+	scan := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; scan.Scan(); lineno++ {
+		line := scan.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		verb, arg := block, line
+		if block != "" {
+			if line == ")" {
+				block = ""
+				continue
+			}
+		} else {
+			verb = line
+			if i := strings.IndexAny(line, " \t"); i >= 0 {
+				verb = line[:i]
+			}
+			arg = strings.TrimSpace(line[len(verb):])
+			if arg == "(" {
+				block = verb
+				continue
+			}
+		}
+		if err := f.parseDirective(verb, arg); err != nil {
+			return nil, fmt.Errorf("%d: invalid %s directive: %w", lineno, verb, err)
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (f *File) parseDirective(verb, arg string) error {
+	switch verb {
+	case "module":
+		path, err := parseString(arg)
+		if err != nil {
+			return err
+		}
+		f.Module = path
+	case "go":
+		f.Go = arg
+	case "toolchain":
+		f.Toolchain = arg
+	case "use":
+		path, err := parseString(arg)
+		if err != nil {
+			return err
+		}
+		f.Use = append(f.Use, path)
+	case "replace":
+		r, err := parseReplace(arg)
+		if err != nil {
+			return err
+		}
+		f.Replace = append(f.Replace, r)
+	}
+	return nil
+}
+
+func parseReplace(arg string) (Replace, error) {
+	var r Replace
+	i := strings.Index(arg, "=>")
+	if i == -1 {
+		return r, errors.New("missing =>")
+	}
+	old := strings.Fields(arg[:i])
+	new := strings.Fields(arg[i+len("=>"):])
+	if len(old) == 0 || len(old) > 2 || len(new) == 0 || len(new) > 2 {
+		return r, errors.New("usage: replace module/path [v1.2.3] => other/module v1.4 or dir")
+	}
+	var err error
+	if r.Old, err = parseString(old[0]); err != nil {
+		return r, err
+	}
+	if len(old) == 2 {
+		r.OldVersion = old[1]
+	}
+	if r.New, err = parseString(new[0]); err != nil {
+		return r, err
+	}
+	if len(new) == 2 {
+		r.NewVersion = new[1]
+	}
+	return r, nil
+}
+
+func parseString(s string) (string, error) {
+	if s == "" {
+		return "", errors.New("empty string")
+	}
+	if s[0] == '"' || s[0] == '`' {
+		return strconv.Unquote(s)
+	}
+	return s, nil
+}
+
+// IsDirectoryPath reports whether the replacement path is a local directory
+// (as opposed to a module path).
+func IsDirectoryPath(path string) bool {
+	return path == "." || path == ".." ||
+		strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") ||
+		strings.HasPrefix(path, `.\`) || strings.HasPrefix(path, `..\`) ||
+		strings.HasPrefix(path, "/") || filepath.IsAbs(path)
+}
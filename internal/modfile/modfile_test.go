@@ -0,0 +1,73 @@
+package modfile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	const data = `// go.work
+go 1.21
+
+toolchain go1.21.3
+
+use (
+	./a // comment
+	"./b"
+)
+use ../c
+
+replace example.com/x v1.0.0 => ./x
+replace (
+	example.com/y => example.com/z v1.2.3
+	example.com/w => ../w
+)
+
+require example.com/q v1.0.0
+`
+	want := &File{
+		Go:        "1.21",
+		Toolchain: "go1.21.3",
+		Use:       []string{"./a", "./b", "../c"},
+		Replace: []Replace{
+			{Old: "example.com/x", OldVersion: "v1.0.0", New: "./x"},
+			{Old: "example.com/y", New: "example.com/z", NewVersion: "v1.2.3"},
+			{Old: "example.com/w", New: "../w"},
+		},
+	}
+	f, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("Parse() = %+v; want: %+v", f, want)
+	}
+
+	for _, s := range []string{
+		"module \"a\n",
+		"replace a b\n",
+		"replace a => b c d\n",
+	} {
+		if _, err := Parse([]byte(s)); err == nil {
+			t.Errorf("Parse(%q): expected error", s)
+		}
+	}
+}
+
+func TestIsDirectoryPath(t *testing.T) {
+	tests := map[string]bool{
+		".":              true,
+		"..":             true,
+		"./a":            true,
+		"../a":           true,
+		"/abs/path":      true,
+		"example.com/a":  false,
+		"a":              false,
+		".hidden/module": false,
+	}
+	for path, want := range tests {
+		if got := IsDirectoryPath(path); got != want {
+			t.Errorf("IsDirectoryPath(%q) = %t; want: %t", path, got, want)
+		}
+	}
+}
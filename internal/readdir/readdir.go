@@ -6,6 +6,7 @@ package readdir
 import (
 	"io/fs"
 	"os"
+	"sort"
 	"sync"
 	"time"
 )
@@ -50,15 +51,38 @@ func (f *fileInfo) Sys() interface{} {
 }
 
 // ReadDir is a faster version of ioutil.ReadDir that uses os.ReadDir
-// and returns a wrapper around fs.FileInfo.
+// and returns a wrapper around fs.FileInfo. The entries are sorted by
+// filename on every platform.
 //
 // This is roughly 3.5-4x faster than ioutil.ReadDir and is used heavily
 // by the build.Context when importing packages.
 func ReadDir(dirname string) ([]fs.FileInfo, error) {
-	des, err := os.ReadDir(dirname)
+	return readDir(dirname, true)
+}
+
+// ReadDirUnsorted is like ReadDir, but returns the entries in directory
+// order, which is platform and file system dependent. It is for callers
+// that do not depend on the order, or sort the entries themselves, and
+// want to avoid the cost of sorting large directories.
+func ReadDirUnsorted(dirname string) ([]fs.FileInfo, error) {
+	return readDir(dirname, false)
+}
+
+func readDir(dirname string, sorted bool) ([]fs.FileInfo, error) {
+	f, err := os.Open(dirname)
 	if err != nil {
 		return nil, err
 	}
+	des, err := f.ReadDir(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if sorted {
+		sort.Slice(des, func(i, j int) bool {
+			return des[i].Name() < des[j].Name()
+		})
+	}
 	fis := make([]fs.FileInfo, len(des))
 	for i, d := range des {
 		fis[i] = &fileInfo{DirEntry: d}
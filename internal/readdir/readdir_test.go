@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"sort"
 	"testing"
 	"time"
 )
@@ -85,6 +86,41 @@ func TestReadDirError(t *testing.T) {
 	}
 }
 
+func TestReadDirUnsorted(t *testing.T) {
+	dir := t.TempDir()
+	var want []string
+	for _, name := range []string{"c", "a", "B", "b", "_d", "10", "9"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, name)
+	}
+	sort.Strings(want)
+
+	names := func(fis []os.FileInfo, err error) []string {
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := make([]string, len(fis))
+		for i, fi := range fis {
+			a[i] = fi.Name()
+		}
+		return a
+	}
+	if got := names(ReadDir(dir)); !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadDir() = %q; want: %q", got, want)
+	}
+	got := names(ReadDirUnsorted(dir))
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadDirUnsorted() = %q; want: %q", got, want)
+	}
+
+	if _, err := ReadDirUnsorted(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("ReadDirUnsorted(missing) error = %v; want: %v", err, os.ErrNotExist)
+	}
+}
+
 func BenchmarkReadDir(b *testing.B) {
 	benchdir := filepath.Join(runtime.GOROOT(), "src")
 	if _, err := os.Stat(benchdir); err != nil {
@@ -97,6 +133,13 @@ func BenchmarkReadDir(b *testing.B) {
 			}
 		}
 	})
+	b.Run("ReadDirUnsorted", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := ReadDirUnsorted(benchdir); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
 	b.Run("ioutil.ReadDir", func(b *testing.B) {
 		for i := 0; i < b.N; i++ {
 			if _, err := ioutil.ReadDir(benchdir); err != nil {
@@ -5,11 +5,38 @@ package readdir
 
 import (
 	"io/fs"
-	"io/ioutil"
+	"os"
+	"sort"
 )
 
+// ReadDir is like ioutil.ReadDir. The entries are sorted by filename on
+// every platform.
 func ReadDir(dirname string) ([]fs.FileInfo, error) {
+	return readDir(dirname, true)
+}
+
+// ReadDirUnsorted is like ReadDir, but returns the entries in directory
+// order, which is platform and file system dependent.
+func ReadDirUnsorted(dirname string) ([]fs.FileInfo, error) {
+	return readDir(dirname, false)
+}
+
+func readDir(dirname string, sorted bool) ([]fs.FileInfo, error) {
 	// No performance advantage on Windows since ioutil.ReadDir
 	// and os.ReadDir use the same functionality.
-	return ioutil.ReadDir(dirname)
+	f, err := os.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	fis, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if sorted {
+		sort.Slice(fis, func(i, j int) bool {
+			return fis[i].Name() < fis[j].Name()
+		})
+	}
+	return fis, nil
 }
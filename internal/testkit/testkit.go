@@ -0,0 +1,23 @@
+// Package testkit provides helpers for running regression tests against the
+// Go source trees bundled in the testdata directory of the buildutil package.
+package testkit
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/charlievieth/buildutil/buildutiltest"
+)
+
+// Corpora are the names of the bundled Go source trees. The tarball of each
+// is "testdata/NAME.tgz" and contains the tree at "NAME/src".
+var Corpora = []string{"go1.17.9", "go1.18.1"}
+
+// ExtractCorpus extracts the bundled Go source tree name from the testdata
+// directory to a temporary directory and returns the path of its "src"
+// directory. The temporary directory is removed when the test completes.
+func ExtractCorpus(tb testing.TB, testdata, name string) string {
+	tb.Helper()
+	dir := buildutiltest.ExtractTarball(tb, filepath.Join(testdata, name+".tgz"))
+	return filepath.Join(dir, name, "src")
+}
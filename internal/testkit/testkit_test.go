@@ -0,0 +1,32 @@
+package testkit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/charlievieth/buildutil/buildutiltest"
+)
+
+func TestExtractCorpus(t *testing.T) {
+	testdata := t.TempDir()
+	buildutiltest.WriteTarball(t, filepath.Join(testdata, "go1.0.tgz"), map[string]string{
+		"go1.0/src/fmt/print.go":            "package fmt\n",
+		"go1.0/src/fmt/README":              "readme\n",
+		"go1.0/src/os/file_unix.go":         "package os\n",
+		"go1.0/src/os/testdata/data.go":     "package data\n",
+		"go1.0/src/internal/abi/abi.go":     "package abi\n",
+		"go1.0/src/cmd/go/testdata/main.go": "package main\n",
+	})
+
+	root := ExtractCorpus(t, testdata, "go1.0")
+	if fi, err := os.Stat(root); err != nil || !fi.IsDir() {
+		t.Fatalf("ExtractCorpus() = %q: not a directory: %v", root, err)
+	}
+
+	for _, name := range []string{"fmt/print.go", "os/file_unix.go", "os/testdata/data.go"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package util
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isWindows is true if paths are Windows paths.
+const isWindows = os.PathSeparator == '\\'
+
+// IsSubdir reports if dir is within root by performing lexical analysis only.
+// Both paths should be clean. On Windows the volume names (drive letters and
+// UNC shares) are compared case-insensitively.
+func IsSubdir(root, dir string) bool {
+	_, ok := subdirIndex(root, dir, isWindows)
+	return ok
+}
+
+// HasSubdir is like IsSubdir, but also returns the slash separated path of
+// dir relative to root.
+func HasSubdir(root, dir string) (rel string, ok bool) {
+	if i, ok := subdirIndex(root, dir, isWindows); ok {
+		return filepath.ToSlash(dir[i:]), true
+	}
+	return "", false
+}
+
+// subdirIndex returns the index of the path of dir relative to root, if dir
+// is within root. If windows is true, the paths are Windows paths.
+func subdirIndex(root, dir string, windows bool) (int, bool) {
+	off := 0
+	if windows {
+		rv := volumeNameWindows(root)
+		dv := volumeNameWindows(dir)
+		if !equalVolumeWindows(rv, dv) {
+			return 0, false
+		}
+		root = root[len(rv):]
+		dir = dir[len(dv):]
+		off = len(dv)
+		// Volume only root: `\\server\share`
+		if root == "" && rv != "" {
+			if len(dir) > 1 && isPathSeparator(dir[0], windows) {
+				return off + 1, true
+			}
+			return 0, false
+		}
+	}
+	n := len(root)
+	if n == 0 || n >= len(dir) || dir[:n] != root {
+		return 0, false
+	}
+	if isPathSeparator(root[n-1], windows) {
+		return off + n, true // root is a file system root: "/" or `C:\`
+	}
+	if isPathSeparator(dir[n], windows) {
+		return off + n + 1, true
+	}
+	return 0, false
+}
+
+func isPathSeparator(c byte, windows bool) bool {
+	return c == '/' || windows && c == '\\'
+}
+
+// volumeNameWindows returns the leading volume name of the Windows path,
+// which is either a drive letter ("C:") or a UNC share (`\\server\share`).
+func volumeNameWindows(path string) string {
+	if len(path) >= 2 && path[1] == ':' && ('a' <= path[0]|0x20 && path[0]|0x20 <= 'z') {
+		return path[:2]
+	}
+	// UNC path: `\\server\share`. Paths beginning with `\\.\` or `\\?\` are
+	// device paths, which are not handled.
+	if len(path) < 5 || !isPathSeparator(path[0], true) || !isPathSeparator(path[1], true) ||
+		isPathSeparator(path[2], true) || path[2] == '.' || path[2] == '?' {
+		return ""
+	}
+	n := 3
+	for n < len(path) && !isPathSeparator(path[n], true) {
+		n++ // server
+	}
+	n++
+	if n >= len(path) || isPathSeparator(path[n], true) {
+		return "" // missing share
+	}
+	for n < len(path) && !isPathSeparator(path[n], true) {
+		n++ // share
+	}
+	return path[:n]
+}
+
+// equalVolumeWindows reports if the Windows volume names v1 and v2 are
+// equal, ignoring case and the type of path separator.
+func equalVolumeWindows(v1, v2 string) bool {
+	if len(v1) != len(v2) {
+		return false
+	}
+	if v1 == v2 {
+		return true
+	}
+	return strings.EqualFold(strings.ReplaceAll(v1, "/", `\`), strings.ReplaceAll(v2, "/", `\`))
+}
+
+// maxShortPath is the length at which Windows paths are given the long path
+// prefix. It is less than MAX_PATH (260) since the limit for directories is
+// 248 characters (MAX_PATH minus room for an 8.3 file name).
+const maxShortPath = 248
+
+// EvalSymlinks is like filepath.EvalSymlinks, but supports Windows paths that
+// are too long to be used with the Windows API. The os package handles long
+// paths itself, but filepath.EvalSymlinks does not on Windows.
+func EvalSymlinks(path string) (string, error) {
+	if !isWindows {
+		return filepath.EvalSymlinks(path)
+	}
+	long := fixLongPath(path)
+	if long == path {
+		return filepath.EvalSymlinks(path)
+	}
+	real, err := filepath.EvalSymlinks(long)
+	if err != nil {
+		if pe, ok := err.(*fs.PathError); ok {
+			pe.Path = trimLongPath(pe.Path)
+		}
+		return "", err
+	}
+	return trimLongPath(real), nil
+}
+
+// fixLongPath returns the extended-length form (`\\?\C:\...`) of the absolute
+// Windows path if it is too long to be used with the Windows API, otherwise
+// path is returned unchanged. Like the os package, the path is cleaned since
+// extended-length paths are not processed by Windows (e.g. "." and ".."
+// elements and forward slashes are not allowed).
+func fixLongPath(path string) string {
+	if len(path) < maxShortPath {
+		return path
+	}
+	var prefix string
+	var min int // number of elements of the volume name, which are not removed by ".."
+	vol := volumeNameWindows(path)
+	switch {
+	case len(vol) == 2 && len(path) > 2 && isPathSeparator(path[2], true):
+		prefix, min = `\\?\`, 1
+	case len(vol) > 2:
+		prefix, min = `\\?\UNC\`, 2
+	default:
+		return path // relative, device or already extended-length path
+	}
+	elems := strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '\\' })
+	stack := make([]string, 0, len(elems))
+	for _, e := range elems {
+		switch e {
+		case ".":
+		case "..":
+			if len(stack) > min {
+				stack = stack[:len(stack)-1]
+			}
+		default:
+			stack = append(stack, e)
+		}
+	}
+	return prefix + strings.Join(stack, `\`)
+}
+
+// trimLongPath removes the extended-length prefix added by fixLongPath.
+func trimLongPath(path string) string {
+	if strings.HasPrefix(path, `\\?\UNC\`) {
+		return `\\` + path[len(`\\?\UNC\`):]
+	}
+	return strings.TrimPrefix(path, `\\?\`)
+}
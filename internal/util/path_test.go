@@ -0,0 +1,108 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSubdirIndex(t *testing.T) {
+	tests := []struct {
+		root, dir string
+		windows   bool
+		rel       string
+		ok        bool
+	}{
+		{"/a", "/a/b", false, "b", true},
+		{"/a", "/a/b/c", false, "b/c", true},
+		{"/a", "/a", false, "", false},
+		{"/a", "/ab", false, "", false},
+		{"/a/", "/a/b", false, "b", true},
+		{"/", "/a", false, "a", true},
+		{"", "/a", false, "", false},
+		{"/A", "/a/b", false, "", false},
+
+		{`C:\a`, `C:\a\b`, true, `b`, true},
+		{`C:\a`, `c:\a\b\c`, true, `b\c`, true},
+		{`c:\a`, `C:\a\b`, true, `b`, true},
+		{`C:\a`, `D:\a\b`, true, ``, false},
+		{`C:\a`, `C:\ab`, true, ``, false},
+		{`C:\`, `C:\a`, true, `a`, true},
+		{`C:\`, `c:\a\b`, true, `a\b`, true},
+		{`\\server\share`, `\\server\share\a`, true, `a`, true},
+		{`\\server\share\`, `\\SERVER\Share\a\b`, true, `a\b`, true},
+		{`\\server\share\a`, `\\server\share\a\b`, true, `b`, true},
+		{`\\server\share`, `\\server\other\a`, true, ``, false},
+		{`\\server\share`, `\\server\share`, true, ``, false},
+		{`\\server\share\a`, `C:\a\b`, true, ``, false},
+		{`//server/share`, `\\server\share\a`, true, `a`, true},
+	}
+	for _, x := range tests {
+		i, ok := subdirIndex(x.root, x.dir, x.windows)
+		rel := ""
+		if ok {
+			rel = x.dir[i:]
+		}
+		if rel != x.rel || ok != x.ok {
+			t.Errorf("subdirIndex(%q, %q, %t) = %q, %t; want: %q, %t",
+				x.root, x.dir, x.windows, rel, ok, x.rel, x.ok)
+		}
+	}
+}
+
+func TestVolumeNameWindows(t *testing.T) {
+	tests := map[string]string{
+		`C:\a`:             `C:`,
+		`c:`:               `c:`,
+		`\\server\share\a`: `\\server\share`,
+		`//server/share`:   `//server/share`,
+		`\\server`:         ``,
+		`\\server\`:        ``,
+		`\\?\C:\a`:         ``,
+		`\\.\pipe\name`:    ``,
+		`\a\b`:             ``,
+		`1:\a`:             ``,
+		``:                 ``,
+	}
+	for path, want := range tests {
+		if got := volumeNameWindows(path); got != want {
+			t.Errorf("volumeNameWindows(%q) = %q; want: %q", path, got, want)
+		}
+	}
+}
+
+func TestFixLongPath(t *testing.T) {
+	long := strings.Repeat("a", 100)
+	long3 := long + `\` + long + `\` + long
+	tests := []struct {
+		path, want string
+	}{
+		{`C:\a\b`, `C:\a\b`},
+		{`C:\` + long3, `\\?\C:\` + long3},
+		{`C:/` + long + `/./x/../` + long + `/` + long, `\\?\C:\` + long3},
+		{`C:\..\` + long3, `\\?\C:\` + long3},
+		{`\\server\share\` + long3, `\\?\UNC\server\share\` + long3},
+		{`\\server\share\..\` + long3, `\\?\UNC\server\share\` + long3},
+		{`\\?\C:\` + long3, `\\?\C:\` + long3},
+		{long3, long3},
+		{`C:` + long3, `C:` + long3},
+	}
+	for _, x := range tests {
+		if got := fixLongPath(x.path); got != x.want {
+			t.Errorf("fixLongPath(%q) = %q; want: %q", x.path, got, x.want)
+		}
+	}
+}
+
+func TestTrimLongPath(t *testing.T) {
+	tests := map[string]string{
+		`\\?\C:\a\b`:             `C:\a\b`,
+		`\\?\UNC\server\share\a`: `\\server\share\a`,
+		`C:\a\b`:                 `C:\a\b`,
+		`\\server\share\a`:       `\\server\share\a`,
+	}
+	for path, want := range tests {
+		if got := trimLongPath(path); got != want {
+			t.Errorf("trimLongPath(%q) = %q; want: %q", path, got, want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package util
 import (
 	"go/build"
 	"os"
+	"sort"
 	"strings"
 )
 
@@ -75,6 +76,23 @@ func StringsSame(a1, a2 []string) bool {
 	return true
 }
 
+// SortUniqueStrings sorts list in place and removes any duplicates.
+func SortUniqueStrings(list []string) []string {
+	if len(list) <= 1 {
+		return list
+	}
+	sort.Strings(list)
+	a := list[:1]
+	k := a[0]
+	for i := 1; i < len(list); i++ {
+		if v := list[i]; v != k {
+			a = append(a, v)
+			k = v
+		}
+	}
+	return a
+}
+
 func TagsIntersect(m1, m2 map[string]bool) bool {
 	for k := range m1 {
 		if _, ok := m2[k]; ok {
@@ -90,6 +108,14 @@ type Environ struct {
 
 func NewEnviron() *Environ { return &Environ{env: os.Environ()} }
 
+// NewEnvironFrom returns a new Environ that is initialized with a copy of env.
+func NewEnvironFrom(env []string) *Environ {
+	return &Environ{env: DuplicateStrings(env)}
+}
+
+// Clone returns a copy of e that can be modified independently of e.
+func (e *Environ) Clone() *Environ { return NewEnvironFrom(e.env) }
+
 func (e *Environ) Environ() []string { return e.env }
 
 func (e *Environ) Index(key string) int {
@@ -129,6 +155,17 @@ func (e *Environ) Set(key, value string) {
 	}
 }
 
+// Unset removes all occurrences of key from the environment.
+func (e *Environ) Unset(key string) {
+	for {
+		i := e.Index(key)
+		if i == -1 {
+			break
+		}
+		e.env = append(e.env[:i], e.env[i+1:]...)
+	}
+}
+
 func CopyContext(orig *build.Context) *build.Context {
 	tmp := *orig // make a copy
 	ctxt := &tmp
@@ -5,6 +5,7 @@ import (
 	"go/build"
 	"math/rand"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -67,6 +68,38 @@ func TestStringsSame(t *testing.T) {
 	}
 }
 
+func TestSortUniqueStrings(t *testing.T) {
+	tests := []struct {
+		in, exp []string
+	}{
+		{nil, nil},
+		{[]string{}, []string{}},
+		{[]string{"a"}, []string{"a"}},
+		{[]string{"a", "a", "z"}, []string{"a", "z"}},
+		{[]string{"b", "a", "a", "b"}, []string{"a", "b"}},
+	}
+	equal := func(s1, s2 []string) bool {
+		if len(s1) != len(s2) {
+			return false
+		}
+		for i := range s1 {
+			if s1[i] != s2[i] {
+				return false
+			}
+		}
+		return true
+	}
+	for _, tt := range tests {
+		got := SortUniqueStrings(append([]string(nil), tt.in...))
+		if !equal(got, tt.exp) {
+			t.Errorf("SortUniqueStrings(%q) = %q; want %q", tt.in, got, tt.exp)
+		}
+		if !sort.StringsAreSorted(got) {
+			t.Errorf("result %q is not sorted!", got)
+		}
+	}
+}
+
 func TestEnviron(t *testing.T) {
 	env := []string{
 		"AAA1=1",
@@ -96,6 +129,40 @@ func TestEnviron(t *testing.T) {
 	}
 }
 
+func TestEnvironUnset(t *testing.T) {
+	e := NewEnvironFrom([]string{"A=1", "AA=2", "B=3", "A=4"})
+	e.Unset("A")
+	if v, ok := e.Lookup("A"); ok {
+		t.Errorf("Lookup(%q) = %q, %t; want: %q, %t", "A", v, ok, "", false)
+	}
+	want := []string{"AA=2", "B=3"}
+	if got := e.Environ(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Environ() = %q; want: %q", got, want)
+	}
+	e.Unset("missing")
+	if got := e.Environ(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Environ() = %q; want: %q", got, want)
+	}
+}
+
+func TestEnvironClone(t *testing.T) {
+	env := []string{"A=1", "B=2"}
+	e1 := NewEnvironFrom(env)
+	e1.Set("A", "3")
+	if env[0] != "A=1" {
+		t.Errorf("NewEnvironFrom: modified the original slice: %q", env)
+	}
+	e2 := e1.Clone()
+	e2.Set("B", "4")
+	e2.Unset("A")
+	if want := []string{"A=3", "B=2"}; !reflect.DeepEqual(e1.Environ(), want) {
+		t.Errorf("Clone: modified the original Environ: %q; want: %q", e1.Environ(), want)
+	}
+	if want := []string{"B=4"}; !reflect.DeepEqual(e2.Environ(), want) {
+		t.Errorf("Environ() = %q; want: %q", e2.Environ(), want)
+	}
+}
+
 func TestCopyContext(t *testing.T) {
 	orig := build.Default
 	orig.BuildTags = []string{"test"}
@@ -1,21 +1,27 @@
 package buildutil
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"go/build"
 	"go/build/constraint"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/charlievieth/buildutil/internal/fsys"
 	"github.com/charlievieth/buildutil/internal/util"
-	"github.com/charlievieth/reonce"
 )
 
-// PreferredArchList is used to pick an OS (GOOS) when matching a build.Context
-// to a file.
-var PreferredOSList = createPreferredList([]string{
+// The default preference lists start with the most common platforms and end
+// with every other OS or Arch in the order they first appear in
+// DefaultGoPlatforms, so that niche OSes like aix, plan9 and solaris are
+// also tried.
+var defaultPreferredOSList = createPreferredList([]string{
 	runtime.GOOS, // deduped in init()
 	"darwin",
 	"linux",
@@ -25,9 +31,7 @@ var PreferredOSList = createPreferredList([]string{
 	"netbsd",
 }, func(p *GoPlatform) string { return p.GOOS })
 
-// PreferredArchList is used to pick an Arch (GOARCH) when matching a build.Context
-// to a file.
-var PreferredArchList = createPreferredList([]string{
+var defaultPreferredArchList = createPreferredList([]string{
 	runtime.GOARCH,
 	"amd64",
 	"arm64",
@@ -36,6 +40,71 @@ var PreferredArchList = createPreferredList([]string{
 	"ppc64",
 }, func(p *GoPlatform) string { return p.GOARCH })
 
+// PreferredOSList is used to pick an OS (GOOS) when matching a build.Context
+// to a file.
+//
+// Deprecated: assigning to PreferredOSList is not safe for concurrent use
+// with MatchContext. Use SetPreferredOSList or MatchContextOptions instead.
+var PreferredOSList = util.DuplicateStrings(defaultPreferredOSList)
+
+// PreferredArchList is used to pick an Arch (GOARCH) when matching a
+// build.Context to a file.
+//
+// Deprecated: assigning to PreferredArchList is not safe for concurrent use
+// with MatchContext. Use SetPreferredArchList or MatchContextOptions instead.
+var PreferredArchList = util.DuplicateStrings(defaultPreferredArchList)
+
+// preferredMu guards PreferredOSList and PreferredArchList.
+var preferredMu sync.RWMutex
+
+// changedPreferredLists returns the PreferredOSList and PreferredArchList
+// if they were changed from the defaults, otherwise nil.
+func changedPreferredLists() (osList, archList []string) {
+	preferredMu.RLock()
+	defer preferredMu.RUnlock()
+	if !stringsEqual(PreferredOSList, defaultPreferredOSList) {
+		osList = PreferredOSList
+	}
+	if !stringsEqual(PreferredArchList, defaultPreferredArchList) {
+		archList = PreferredArchList
+	}
+	return osList, archList
+}
+
+func stringsEqual(a1, a2 []string) bool {
+	if len(a1) != len(a2) {
+		return false
+	}
+	for i := range a1 {
+		if a1[i] != a2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SetPreferredOSList sets the OSes that MatchContext tries first when it
+// has to change the GOOS of a Context. Like MatchOptions, list may contain
+// patterns and any OS not in list is tried after those in list.
+// It is safe to call SetPreferredOSList concurrently with MatchContext.
+func SetPreferredOSList(list []string) {
+	a := expandPreferredList(list, defaultPreferredOSList)
+	preferredMu.Lock()
+	PreferredOSList = a
+	preferredMu.Unlock()
+}
+
+// SetPreferredArchList sets the arches that MatchContext tries first when
+// it has to change the GOARCH of a Context. Like MatchOptions, list may
+// contain patterns and any Arch not in list is tried after those in list.
+// It is safe to call SetPreferredArchList concurrently with MatchContext.
+func SetPreferredArchList(list []string) {
+	a := expandPreferredList(list, defaultPreferredArchList)
+	preferredMu.Lock()
+	PreferredArchList = a
+	preferredMu.Unlock()
+}
+
 func createPreferredList(orig []string, fn func(p *GoPlatform) string) []string {
 	seen := make(map[string]bool)
 	var a []string
@@ -45,7 +114,7 @@ func createPreferredList(orig []string, fn func(p *GoPlatform) string) []string
 			a = append(a, s)
 		}
 	}
-	for _, p := range DefaultGoPlatforms {
+	for _, p := range knownPlatforms {
 		s := fn(&p)
 		if !seen[s] {
 			seen[s] = true
@@ -59,6 +128,21 @@ var (
 	ErrImpossibleGoVersion = errors.New("cannot satisfy go version")
 	ErrMatchContext        = errors.New("cannot match context to file")
 
+	// ErrFilenameConflict is returned when the build constraints of a file
+	// cannot be satisfied by the GOOS/GOARCH required by its file name
+	// (e.g. "x_linux.go" with the constraint "//go:build !linux"). The file
+	// should be renamed or its build constraints fixed.
+	ErrFilenameConflict = errors.New("file name conflicts with build constraint")
+
+	// ErrForbiddenTag is returned when the build constraints of a file
+	// cannot be satisfied without setting one of the ForbiddenTags of the
+	// MatchOptions.
+	ErrForbiddenTag = errors.New("build constraint requires a forbidden tag")
+
+	// ErrInvalidConstraint is returned when the build constraints of a file
+	// cannot be parsed.
+	ErrInvalidConstraint = errors.New("invalid build constraint")
+
 	// declared here to make testing easier
 	errCompilerMismatchGc    = errors.New("compiler mismatch: gc")
 	errCompilerMismatchGccGo = errors.New("compiler mismatch: gccgo")
@@ -79,34 +163,105 @@ func (e *MatchError) Error() string {
 
 func (e *MatchError) Unwrap() error { return e.Err }
 
-// NB: will need to be updated for go2
-var goVersionTagRe = reonce.New(`^go1\.\d+$`)
+// Kind returns a stable name for the kind of the error, which is one of:
+//
+//	"invalid-constraint"    ErrInvalidConstraint
+//	"impossible-go-version" ErrImpossibleGoVersion
+//	"filename-conflict"     ErrFilenameConflict
+//	"forbidden-tag"         ErrForbiddenTag
+//	"compiler-mismatch"     the file requires a different compiler
+//	"no-match"              ErrMatchContext
+//	"other"                 any other error
+func (e *MatchError) Kind() string {
+	switch err := e.Err; {
+	case errors.Is(err, ErrInvalidConstraint):
+		return "invalid-constraint"
+	case errors.Is(err, ErrImpossibleGoVersion):
+		return "impossible-go-version"
+	case errors.Is(err, ErrFilenameConflict):
+		return "filename-conflict"
+	case errors.Is(err, ErrForbiddenTag):
+		return "forbidden-tag"
+	case errors.Is(err, errCompilerMismatchGc), errors.Is(err, errCompilerMismatchGccGo),
+		errors.Is(err, errCompilerNegatedGc), errors.Is(err, errCompilerNegatedGccGo):
+		return "compiler-mismatch"
+	case errors.Is(err, ErrMatchContext):
+		return "no-match"
+	}
+	return "other"
+}
+
+// MarshalJSON encodes the MatchError as a JSON object with the stable fields
+// "path", "permanent", "kind" (see Kind) and "message", which is the message
+// of Err, so that editors can render it as a structured diagnostic.
+func (e *MatchError) MarshalJSON() ([]byte, error) {
+	var msg string
+	if e.Err != nil {
+		msg = e.Err.Error()
+	}
+	return json.Marshal(&struct {
+		Path      string `json:"path"`
+		Permanent bool   `json:"permanent"`
+		Kind      string `json:"kind"`
+		Message   string `json:"message"`
+	}{
+		Path:      e.Path,
+		Permanent: e.Permanent,
+		Kind:      e.Kind(),
+		Message:   msg,
+	})
+}
 
 func isGoReleaseTag(s string) bool {
-	return knownReleaseTag[s] ||
-		strings.HasPrefix(s, "go1.") && goVersionTagRe.MatchString(s)
+	return knownReleaseTag[s] || isReleaseTagForm(s)
 }
 
-func isGoExperimentTag(name string) bool {
-	return strings.HasPrefix(name, "goexperiment.")
+// sanitizerTags are the tool tags set by the -race, -msan and -asan flags of
+// the go command. They must not be added to the BuildTags of a Context since
+// the files they guard expect the code to be built with the sanitizer.
+var sanitizerTags = map[string]bool{
+	"race": true,
+	"msan": true,
+	"asan": true,
 }
 
-func isInternalTag(ctxt *build.Context, name string) bool {
-	if name == "gc" || name == "gccgo" || knownOS[name] || knownArch[name] ||
-		isGoExperimentTag(name) || isGoReleaseTag(name) {
-		return true
-	}
-	for _, tag := range ctxt.ToolTags {
-		if tag == name {
-			return true
+// setBuildTag adds (or removes) tag to the BuildTags of ctxt. Sanitizer tags
+// are added to the ToolTags instead, and since msan and asan require cgo it
+// is enabled for them, if supported.
+func setBuildTag(ctxt *build.Context, tag string, add bool) {
+	if !sanitizerTags[tag] {
+		if add {
+			ctxt.BuildTags = util.StringsAppend(ctxt.BuildTags, tag)
+		} else {
+			ctxt.BuildTags = util.StringsRemoveAll(ctxt.BuildTags, tag)
 		}
+		return
 	}
-	for _, tag := range ctxt.ReleaseTags {
-		if tag == name {
-			return true
+	if add {
+		ctxt.ToolTags = util.StringsAppend(ctxt.ToolTags, tag)
+		if tag != "race" && cgoEnabled[ctxt.GOOS+"/"+ctxt.GOARCH] {
+			ctxt.CgoEnabled = true
 		}
+	} else {
+		ctxt.ToolTags = util.StringsRemoveAll(ctxt.ToolTags, tag)
+		ctxt.BuildTags = util.StringsRemoveAll(ctxt.BuildTags, tag)
 	}
-	return false
+}
+
+func isGoExperimentTag(name string) bool {
+	return strings.HasPrefix(name, "goexperiment.")
+}
+
+// isInternalTag reports if name is not a user defined tag (see ClassifyTag).
+// Sanitizer tags are only internal if they are tool tags of ctxt.
+func isInternalTag(ctxt *build.Context, name string) bool {
+	switch ClassifyTag(ctxt, name) {
+	case TagUserDefined:
+		return false
+	case TagSanitizer:
+		return util.StringsContains(ctxt.ToolTags, name)
+	}
+	return true
 }
 
 func lookupTag(x constraint.Expr, tag string) (found, negated bool) {
@@ -143,6 +298,112 @@ func lookupTag(x constraint.Expr, tag string) (found, negated bool) {
 	return false, false
 }
 
+// walkTags calls fn for each tag in x.
+func walkTags(x constraint.Expr, fn func(tag string)) {
+	switch v := x.(type) {
+	case *constraint.TagExpr:
+		fn(v.Tag)
+	case *constraint.NotExpr:
+		walkTags(v.X, fn)
+	case *constraint.AndExpr:
+		walkTags(v.X, fn)
+		walkTags(v.Y, fn)
+	case *constraint.OrExpr:
+		walkTags(v.X, fn)
+		walkTags(v.Y, fn)
+	default:
+		panic(fmt.Sprintf("invalid type: %T", x))
+	}
+}
+
+// maxConflictTags is the maximum number of non-platform tags in a build
+// constraint that filenameConflict will check all combinations of.
+const maxConflictTags = 12
+
+// filenameConflict reports if the build constraint x cannot be satisfied by
+// any platform allowed by the GOOS/GOARCH suffix of filename, regardless of
+// the value of any non-platform tags.
+func filenameConflict(ctxt *build.Context, filename string, x constraint.Expr) bool {
+	var free []string
+	seen := make(map[string]bool)
+	walkTags(x, func(tag string) {
+		if !seen[tag] && !knownOS[tag] && !knownArch[tag] && tag != "unix" {
+			free = append(free, tag)
+		}
+		seen[tag] = true
+	})
+	if len(free) > maxConflictTags {
+		return false // too expensive to check
+	}
+	values := make(map[string]bool, len(free))
+
+	name := filepath.Base(filename)
+	pctxt := *ctxt // copy
+	pctxt.BuildTags = nil
+	pctxt.ToolTags = nil
+	pctxt.ReleaseTags = nil
+	for _, p := range knownPlatforms {
+		pctxt.GOOS = p.GOOS
+		pctxt.GOARCH = p.GOARCH
+		if !goodOSArchFile(&pctxt, name, nil) {
+			continue
+		}
+		for n := 0; n < 1<<len(free); n++ {
+			for i, tag := range free {
+				values[tag] = n&(1<<i) != 0
+			}
+			ok := x.Eval(func(tag string) bool {
+				if v, ok := values[tag]; ok {
+					return v
+				}
+				return matchTag(&pctxt, tag, nil)
+			})
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// requiresForbiddenTag reports if the build constraint x cannot be satisfied
+// unless one of the forbidden tags is set, regardless of the value of any
+// other tag, and returns the first forbidden tag in x.
+func requiresForbiddenTag(x constraint.Expr, forbidden []string) (string, bool) {
+	if len(forbidden) == 0 {
+		return "", false
+	}
+	var free []string
+	var first string
+	seen := make(map[string]bool)
+	walkTags(x, func(tag string) {
+		if !seen[tag] {
+			if util.StringsContains(forbidden, tag) {
+				if first == "" {
+					first = tag
+				}
+			} else {
+				free = append(free, tag)
+			}
+		}
+		seen[tag] = true
+	})
+	if first == "" || len(free) > maxConflictTags {
+		return "", false
+	}
+	values := make(map[string]bool, len(free))
+	for n := 0; n < 1<<len(free); n++ {
+		for i, tag := range free {
+			values[tag] = n&(1<<i) != 0
+		}
+		// Forbidden tags are not in values and are never set
+		if x.Eval(func(tag string) bool { return values[tag] }) {
+			return "", false
+		}
+	}
+	return first, true
+}
+
 func checkCompiler(ctxt *build.Context, x constraint.Expr) error {
 	switch ctxt.Compiler {
 	case "gc":
@@ -169,15 +430,15 @@ func checkCompiler(ctxt *build.Context, x constraint.Expr) error {
 
 // findSupportedArch returns an Arch that is valid for the
 // Context's GOOS, if any.
-func findSupportedArch(ctxt *build.Context) (string, bool) {
+func findSupportedArch(ctxt *build.Context, prefs *matchPrefs) (string, bool) {
 	arches, ok := supportedPlatformsOsArch[ctxt.GOOS]
 	if !ok || arches[ctxt.GOARCH] {
 		// No mapping for the OS or the OS/Arch combo is valid
 		return ctxt.GOARCH, true
 	}
 	// Try preferred list first
-	for _, arch := range PreferredArchList {
-		if arches[arch] {
+	for _, arch := range prefs.archList {
+		if arches[arch] && prefs.allowed(ctxt.GOOS, arch) {
 			return arch, true
 		}
 	}
@@ -190,15 +451,15 @@ func findSupportedArch(ctxt *build.Context) (string, bool) {
 
 // findSupportedOS returns an OS that is valid for the
 // Context's GOARCH, if any.
-func findSupportedOS(ctxt *build.Context) (string, bool) {
+func findSupportedOS(ctxt *build.Context, prefs *matchPrefs) (string, bool) {
 	oses, ok := supportedPlatformsArchOs[ctxt.GOARCH]
 	if !ok || oses[ctxt.GOOS] {
 		// No mapping for the Arch or the OS/Arch combo is valid
 		return ctxt.GOOS, true
 	}
 	// Try preferred list first
-	for _, os := range PreferredOSList {
-		if oses[os] {
+	for _, os := range prefs.osList {
+		if oses[os] && prefs.allowed(os, ctxt.GOARCH) {
 			return os, true
 		}
 	}
@@ -209,81 +470,124 @@ func findSupportedOS(ctxt *build.Context) (string, bool) {
 	return "", false
 }
 
+// evalPlatform evaluates expr after updating the cgo support of ctxt for its
+// current platform. The wasm ports (js/wasm and wasip1/wasm) do not support
+// cgo.
+func evalPlatform(ctxt *build.Context, expr constraint.Expr, cgo bool) bool {
+	ctxt.CgoEnabled = cgo && ctxt.GOARCH != "wasm"
+	return eval(ctxt, expr, nil)
+}
+
 // matchGOARCH attempts to find an Arch that is valid for the Context's OS and
 // satisfies the build constraint expr.
-func matchGOARCH(ctxt *build.Context, expr constraint.Expr) bool {
+func matchGOARCH(ctxt *build.Context, expr constraint.Expr, prefs *matchPrefs) bool {
 	arches, ok := supportedPlatformsOsArch[ctxt.GOOS]
-	if !ok || arches[ctxt.GOARCH] {
+	if !ok || arches[ctxt.GOARCH] && prefs.allowed(ctxt.GOOS, ctxt.GOARCH) {
 		return eval(ctxt, expr, nil)
 	}
 	origArch := ctxt.GOARCH
+	origCgo := ctxt.CgoEnabled
 	// Try the preferred list first
-	for _, arch := range PreferredArchList {
-		if arches[arch] {
+	for _, arch := range prefs.archList {
+		if arches[arch] && prefs.allowed(ctxt.GOOS, arch) {
 			ctxt.GOARCH = arch
-			if eval(ctxt, expr, nil) {
+			if evalPlatform(ctxt, expr, origCgo) {
 				return true
 			}
 		}
 	}
 	// Try all supported arches
 	for arch := range arches {
+		if !prefs.allowed(ctxt.GOOS, arch) {
+			continue
+		}
 		ctxt.GOARCH = arch
-		if eval(ctxt, expr, nil) {
+		if evalPlatform(ctxt, expr, origCgo) {
 			return true
 		}
 	}
 	ctxt.GOARCH = origArch
+	ctxt.CgoEnabled = origCgo
 	return false
 }
 
 // matchGOOS attempts to find an OS that is valid for the Context's Arch and
 // satisfies the build constraint expr.
-func matchGOOS(ctxt *build.Context, expr constraint.Expr) bool {
+func matchGOOS(ctxt *build.Context, expr constraint.Expr, prefs *matchPrefs) bool {
 	oses, ok := supportedPlatformsArchOs[ctxt.GOARCH]
-	if !ok || oses[ctxt.GOOS] {
+	if !ok || oses[ctxt.GOOS] && prefs.allowed(ctxt.GOOS, ctxt.GOARCH) {
 		return eval(ctxt, expr, nil)
 	}
 	origOs := ctxt.GOOS
+	origCgo := ctxt.CgoEnabled
 	// Try the preferred list first
-	for _, os := range PreferredOSList {
-		if oses[os] {
+	for _, os := range prefs.osList {
+		if oses[os] && prefs.allowed(os, ctxt.GOARCH) {
 			ctxt.GOOS = os
-			if eval(ctxt, expr, nil) {
+			if evalPlatform(ctxt, expr, origCgo) {
 				return true
 			}
 		}
 	}
 	// Try all supported OSes
 	for os := range oses {
+		if !prefs.allowed(os, ctxt.GOARCH) {
+			continue
+		}
 		ctxt.GOOS = os
-		if eval(ctxt, expr, nil) {
+		if evalPlatform(ctxt, expr, origCgo) {
 			return true
 		}
 	}
 	ctxt.GOOS = origOs
+	ctxt.CgoEnabled = origCgo
 	return false
 }
 
 // TODO: make sure CGO support is correct for the selected platform.
 //
 // MatchContext returns a build.Context that would include filename in a build.
+// The GOOS/GOARCH suffix of filename, or if it has none, the platform
+// specific packages imported by the file (e.g. "syscall/js" or
+// "golang.org/x/sys/windows") restrict the platforms that are considered.
+// MatchContext is safe for concurrent use.
 func MatchContext(orig *build.Context, filename string, src interface{}) (*build.Context, error) {
+	return MatchContextOptions(orig, filename, src, nil)
+}
+
+// MatchContextOptions is like MatchContext, but uses opts to configure the
+// order in which platforms are tried. If opts is nil the PreferredOSList
+// and PreferredArchList are used.
+//
+// The ProjectConfigFile is not read by MatchContextOptions, to use it load
+// it with FindProjectConfig and set the Project of opts.
+func MatchContextOptions(orig *build.Context, filename string, src interface{}, opts *MatchOptions) (*build.Context, error) {
 	if orig == nil {
 		orig = &build.Default
 	}
+	if opts != nil && opts.Project != nil {
+		orig = opts.Project.context(orig)
+		opts = opts.Project.matchOptions(opts)
+	}
 	rc, err := openReader(orig, filename, src)
 	if err != nil {
 		return nil, err
 	}
-	data, err := readImportsFast(rc)
+	data, err := readImportsMatch(rc)
 	rc.Close()
 	if err != nil {
 		return nil, err
 	}
 
+	// Permanent errors cannot be resolved until either the file or
+	// Context changes so check if we've seen this file before.
+	cacheKey := matchContextKey(orig, filename, data, opts)
+	if err, ok := matchErrCache.Load(cacheKey); ok {
+		return nil, &MatchError{Path: filename, Permanent: true, Err: err}
+	}
+
 	// copy
-	ctxt := util.CopyContext(orig)
+	ctxt := CopyContext(orig)
 
 	// init
 	if ctxt.GOARCH == "" {
@@ -292,17 +596,30 @@ func MatchContext(orig *build.Context, filename string, src interface{}) (*build
 	if ctxt.GOOS == "" {
 		ctxt.GOOS = runtime.GOOS
 	}
-	// WARN: we might not want to set this
-	if ctxt.GOROOT == "" {
+	if opts != nil && opts.NoGOROOT {
+		ctxt.GOROOT = ""
+	} else if ctxt.GOROOT == "" {
 		ctxt.GOROOT = runtime.GOROOT()
 	}
 	if ctxt.Compiler == "" {
 		ctxt.Compiler = runtime.Compiler
 	}
 
+	prefs := newMatchPrefs(ctxt, opts)
+	for _, tag := range prefs.requiredTags {
+		if strings.HasPrefix(tag, "!") {
+			ctxt.BuildTags = util.StringsRemoveAll(ctxt.BuildTags, tag[1:])
+		} else {
+			ctxt.BuildTags = util.StringsAppend(ctxt.BuildTags, tag)
+		}
+	}
+	for _, tag := range prefs.forbidden {
+		ctxt.BuildTags = util.StringsRemoveAll(ctxt.BuildTags, tag)
+	}
+
 	// We ignore the error here since it's too hard to determine
 	// if it matters.
-	if gopath, ok := fixGOPATH(ctxt, filename); ok {
+	if gopath, ok := inferGOPATH(ctxt, filename, true); ok {
 		ctxt.GOPATH = gopath
 	}
 
@@ -312,22 +629,44 @@ func MatchContext(orig *build.Context, filename string, src interface{}) (*build
 		requiredOS   map[string]bool
 		requiredArch string
 	)
+	//
+	// The os/arch is recorded even if the filename matches the Context so
+	// that we don't later switch to a platform that excludes the file.
 	tags := make(map[string]bool)
-	if !goodOSArchFile(ctxt, filepath.Base(filename), tags) {
-		for tag := range tags {
-			switch {
-			case knownOS[tag]:
+	nameOK := goodOSArchFile(ctxt, filepath.Base(filename), tags)
+	for tag := range tags {
+		switch {
+		case knownOS[tag]:
+			requiredOS = map[string]bool{tag: true}
+			if nameOK {
+				// The OS may be compatible with tag (e.g. android and linux)
+				requiredOS[ctxt.GOOS] = true
+			} else {
 				ctxt.GOOS = tag
-				requiredOS = map[string]bool{tag: true}
-				// WARN WARN WARN
-				// WARN: we might want to keep these because it's used below
-				delete(tags, tag) // remove so that we don't attempt to match it again
-			case knownArch[tag]:
+			}
+			// WARN WARN WARN
+			// WARN: we might want to keep these because it's used below
+			delete(tags, tag) // remove so that we don't attempt to match it again
+		case knownArch[tag]:
+			if !nameOK {
 				ctxt.GOARCH = tag
-				requiredArch = tag
-				// WARN WARN WARN
-				// WARN: we might want to keep these because it's used below
-				delete(tags, tag) // remove so that we don't attempt to match it again
+			}
+			requiredArch = tag
+			// WARN WARN WARN
+			// WARN: we might want to keep these because it's used below
+			delete(tags, tag) // remove so that we don't attempt to match it again
+		}
+	}
+
+	// If the file name does not specify an OS, and the Context does not
+	// already match the file, use the platform specific packages imported
+	// by the file, if any, as the required OS (e.g. a file that imports
+	// "syscall/js" can only be built for js).
+	if requiredOS == nil && !(nameOK && shouldBuildOnly(ctxt, data, nil)) {
+		if hint := importOSHint(data); hint != nil {
+			requiredOS = hint
+			if !hint[ctxt.GOOS] {
+				ctxt.GOOS = preferredOS(prefs, hint)
 			}
 		}
 	}
@@ -343,18 +682,23 @@ func MatchContext(orig *build.Context, filename string, src interface{}) (*build
 	// the OS/Arch is valid.
 	switch {
 	case requiredOS != nil && requiredArch == "":
-		if arch, ok := findSupportedArch(ctxt); ok {
+		if arch, ok := findSupportedArch(ctxt, prefs); ok {
 			ctxt.GOARCH = arch
 		}
 	case requiredArch != "" && requiredOS == nil:
-		if os, ok := findSupportedOS(ctxt); ok {
+		if os, ok := findSupportedOS(ctxt, prefs); ok {
 			ctxt.GOOS = os
 		}
 	}
 
+	// The wasm ports (js/wasm and wasip1/wasm) do not support cgo.
+	if ctxt.GOARCH == "wasm" && (requiredOS != nil || requiredArch != "") {
+		ctxt.CgoEnabled = false
+	}
+
 	ok, _, err := shouldBuild(ctxt, data, tags)
 	if err != nil {
-		return nil, &MatchError{Path: filename, Err: err}
+		return nil, &MatchError{Path: filename, Err: fmt.Errorf("%w: %v", ErrInvalidConstraint, err)}
 	}
 	if ok {
 		// Updating the OS/Arch from the filename fixed the Context
@@ -363,7 +707,7 @@ func MatchContext(orig *build.Context, filename string, src interface{}) (*build
 
 	expr, err := parseBuildConstraint(data)
 	if err != nil {
-		return nil, &MatchError{Path: filename, Err: err}
+		return nil, &MatchError{Path: filename, Err: fmt.Errorf("%w: %v", ErrInvalidConstraint, err)}
 	}
 
 	// CEV: Is this possible and if so how?
@@ -374,6 +718,12 @@ func MatchContext(orig *build.Context, filename string, src interface{}) (*build
 		return nil, &MatchError{Path: filename, Err: errors.New("no build tags")}
 	}
 
+	// The error is not cached since it is cheap to compute.
+	if tag, ok := requiresForbiddenTag(expr, prefs.forbidden); ok {
+		return nil, &MatchError{Path: filename, Permanent: true,
+			Err: fmt.Errorf("%w: %s", ErrForbiddenTag, tag)}
+	}
+
 	// GOEXPERIMENT tags
 	for name := range tags {
 		if isGoExperimentTag(name) {
@@ -392,89 +742,146 @@ func MatchContext(orig *build.Context, filename string, src interface{}) (*build
 		return ctxt, nil
 	}
 
-	// Quickly try to find a build tag that works
+	// Try each resolution strategy in order. Changes made by a strategy that
+	// fails to match are kept if they may be needed by a later strategy
+	// (e.g. the build tags are kept when trying other platforms).
+	//
+	// Delay checking for the compiler and go version until after all of
+	// the strategies have been tried since some things, like the "purego"
+	// tag, get around this.
+	for _, strategy := range prefs.strategies {
+		var ok bool
+		switch strategy {
+		case StrategyBuildTags:
+			ok = matchBuildTags(ctxt, expr, tags, prefs)
+		case StrategyCgo:
+			ok = matchCgo(ctxt, expr, tags)
+		case StrategyPlatform:
+			ok = matchPlatform(ctxt, expr, tags, prefs, requiredOS, requiredArch)
+		case StrategyGoVersion:
+			var err error
+			if ok, err = matchGoVersion(ctxt, expr, tags); err != nil {
+				matchErrCache.Store(cacheKey, filename, err)
+				return nil, &MatchError{Path: filename, Permanent: true, Err: err}
+			}
+		}
+		if ok {
+			return ctxt, nil
+		}
+	}
+	if err := checkPermanentMismatch(ctxt, expr, tags, prefs); err != nil {
+		matchErrCache.Store(cacheKey, filename, err)
+		return nil, &MatchError{Path: filename, Permanent: true, Err: err}
+	}
+
+	// Check if the file name and build constraints conflict, which is
+	// a permanent error since the file can never be built.
+	if (requiredOS != nil || requiredArch != "") && filenameConflict(ctxt, filename, expr) {
+		err := fmt.Errorf("%w: %s", ErrFilenameConflict, expr)
+		matchErrCache.Store(cacheKey, filename, err)
+		return nil, &MatchError{Path: filename, Permanent: true, Err: err}
+	}
+
+	// TODO: add additional context to the error (such as
+	// the "//go:build" directive).
+	return nil, &MatchError{Path: filename, Err: ErrMatchContext}
+}
+
+// matchBuildTags attempts to satisfy expr by setting the user defined and
+// sanitizer tags of tags that are not required by prefs. If no single tag
+// satisfies expr all of them are set, and left set, since they may be needed
+// by the other strategies.
+func matchBuildTags(ctxt *build.Context, expr constraint.Expr, tags map[string]bool, prefs *matchPrefs) bool {
 	var buildTags []string
 	for name := range tags {
-		if !isInternalTag(ctxt, name) {
+		// Required tags are already set and must not be changed. Sanitizer
+		// tags may be tool tags, but can be toggled like build tags.
+		kind := ClassifyTag(ctxt, name)
+		if (kind == TagUserDefined || kind == TagSanitizer) && !prefs.required(name) {
 			buildTags = append(buildTags, name)
 		}
 	}
-	if len(buildTags) != 0 {
-		origBuildTags := util.DuplicateStrings(ctxt.BuildTags)
-		orig := ctxt.BuildTags
-		for _, tag := range buildTags {
-			ok, negated := lookupTag(expr, tag)
-			if !ok {
-				continue // this should not happen
-			}
-			if negated {
-				ctxt.BuildTags = util.StringsRemoveAll(ctxt.BuildTags, tag)
-			} else {
-				ctxt.BuildTags = util.StringsAppend(ctxt.BuildTags, tag)
-			}
-			if eval(ctxt, expr, nil) {
-				return ctxt, nil
-			}
-			ctxt.BuildTags = orig
-		}
-
-		// Apply all build tags
-		// NB: there are likely situations where this will not work
-		ctxt.BuildTags = origBuildTags
-		for _, tag := range buildTags {
-			if ok, negated := lookupTag(expr, tag); ok {
-				if negated {
-					ctxt.BuildTags = util.StringsRemoveAll(ctxt.BuildTags, tag)
-				} else {
-					ctxt.BuildTags = util.StringsAppend(ctxt.BuildTags, tag)
-				}
-			}
+	if len(buildTags) == 0 {
+		return false
+	}
+	// Sort so that the result does not depend on map iteration order.
+	sort.Strings(buildTags)
+
+	origBuildTags := util.DuplicateStrings(ctxt.BuildTags)
+	origToolTags := util.DuplicateStrings(ctxt.ToolTags)
+	origCgo := ctxt.CgoEnabled
+	orig := ctxt.BuildTags
+	for _, tag := range buildTags {
+		ok, negated := lookupTag(expr, tag)
+		if !ok {
+			continue // this should not happen
 		}
+		setBuildTag(ctxt, tag, !negated)
 		if eval(ctxt, expr, nil) {
-			return ctxt, nil
+			return true
 		}
+		ctxt.BuildTags = orig
+		ctxt.ToolTags = util.DuplicateStrings(origToolTags)
+		ctxt.CgoEnabled = origCgo
 	}
 
+	// Apply all build tags
+	// NB: there are likely situations where this will not work
+	ctxt.BuildTags = origBuildTags
+	ctxt.ToolTags = origToolTags
+	for _, tag := range buildTags {
+		if ok, negated := lookupTag(expr, tag); ok {
+			setBuildTag(ctxt, tag, !negated)
+		}
+	}
+	return eval(ctxt, expr, nil)
+}
+
+// checkPermanentMismatch returns an error if expr requires a compiler or,
+// unless the Go version may be changed, a Go version that ctxt does not
+// have since the Context cannot be adapted to handle that.
+func checkPermanentMismatch(ctxt *build.Context, expr constraint.Expr, tags map[string]bool, prefs *matchPrefs) error {
 	// Check for release tag constraints since there is nothing we
 	// can do to resolve them.
-	for name := range tags {
-		if isGoReleaseTag(name) {
-			ok, negated := lookupTag(expr, name)
-			if !ok {
-				continue
-			}
-			hasRelease := util.StringsContains(ctxt.ReleaseTags, name)
-			if negated && hasRelease || !negated && !hasRelease {
-				return nil, &MatchError{Path: filename, Permanent: true,
-					Err: ErrImpossibleGoVersion}
+	if !prefs.changeVersion() {
+		for name := range tags {
+			if ClassifyTag(ctxt, name) == TagRelease {
+				ok, negated := lookupTag(expr, name)
+				if !ok {
+					continue
+				}
+				hasRelease := IsReleaseTag(ctxt, name)
+				if negated && hasRelease || !negated && !hasRelease {
+					return ErrImpossibleGoVersion
+				}
 			}
 		}
 	}
-
-	// Delay checking for the compiler and go version until after trying
-	// build and tool tags since some things like the "purego" tag get
-	// around this.
-
-	// Check for a compiler mismatch since we cannot adapt the Context
-	// to handle that.
 	if tags["gc"] || tags["gccgo"] {
-		if err := checkCompiler(ctxt, expr); err != nil {
-			return nil, &MatchError{Path: filename, Permanent: true, Err: err}
-		}
+		return checkCompiler(ctxt, expr)
 	}
+	return nil
+}
 
-	// Try toggling cgo
+// matchCgo attempts to satisfy expr by toggling cgo, if it is supported.
+func matchCgo(ctxt *build.Context, expr constraint.Expr, tags map[string]bool) bool {
 	if tags["cgo"] {
 		if ctxt.CgoEnabled || cgoEnabled[ctxt.GOOS+"/"+ctxt.GOARCH] {
 			ctxt.CgoEnabled = !ctxt.CgoEnabled
 			if eval(ctxt, expr, nil) {
-				return ctxt, nil
+				return true
 			}
 			ctxt.CgoEnabled = !ctxt.CgoEnabled // undo our change
 		}
 	}
+	return false
+}
+
+// matchPlatform attempts to satisfy expr by changing the GOOS and/or GOARCH
+// of ctxt to a platform allowed by the OS and Arch required by the file name.
+func matchPlatform(ctxt *build.Context, expr constraint.Expr, tags map[string]bool,
+	prefs *matchPrefs, requiredOS map[string]bool, requiredArch string) bool {
 
-	// Try differet OS/Arch combinations
 	hasOS := util.TagsIntersect(tags, knownOS)
 	hasArch := util.TagsIntersect(tags, knownArch)
 	switch {
@@ -482,7 +889,7 @@ func MatchContext(orig *build.Context, filename string, src interface{}) (*build
 		oldOS := ctxt.GOOS
 		oldArch := ctxt.GOARCH
 		oldCgo := ctxt.CgoEnabled
-		for _, p := range DefaultGoPlatforms {
+		for _, p := range prefs.platforms {
 			if p.GOOS == oldOS && p.GOARCH == oldArch {
 				continue
 			}
@@ -496,13 +903,13 @@ func MatchContext(orig *build.Context, filename string, src interface{}) (*build
 			ctxt.GOARCH = p.GOARCH
 			ctxt.CgoEnabled = p.CgoSupported
 			if eval(ctxt, expr, nil) {
-				return ctxt, nil
+				return true
 			}
 			// Try again without cgo
 			if ctxt.CgoEnabled {
 				ctxt.CgoEnabled = false
 				if eval(ctxt, expr, nil) {
-					return ctxt, nil
+					return true
 				}
 			}
 		}
@@ -511,7 +918,7 @@ func MatchContext(orig *build.Context, filename string, src interface{}) (*build
 		ctxt.CgoEnabled = oldCgo
 	case hasOS:
 		oldOS := ctxt.GOOS
-		for _, os := range PreferredOSList {
+		for _, os := range prefs.osList {
 			if os == oldOS {
 				continue
 			}
@@ -520,14 +927,14 @@ func MatchContext(orig *build.Context, filename string, src interface{}) (*build
 			}
 			ctxt.GOOS = os
 			// Change GOARCH to one that is supported
-			if matchGOARCH(ctxt, expr) {
-				return ctxt, nil
+			if matchGOARCH(ctxt, expr, prefs) {
+				return true
 			}
 		}
 		ctxt.GOOS = oldOS
 	case hasArch:
 		oldArch := ctxt.GOARCH
-		for _, arch := range PreferredArchList {
+		for _, arch := range prefs.archList {
 			if arch == oldArch {
 				continue
 			}
@@ -535,16 +942,64 @@ func MatchContext(orig *build.Context, filename string, src interface{}) (*build
 				continue
 			}
 			ctxt.GOARCH = arch
-			if matchGOOS(ctxt, expr) {
-				return ctxt, nil
+			if matchGOOS(ctxt, expr, prefs) {
+				return true
 			}
 		}
 		ctxt.GOARCH = oldArch
 	}
+	return false
+}
 
-	// TODO: add additional context to the error (such as
-	// the "//go:build" directive).
-	return nil, &MatchError{Path: filename, Err: ErrMatchContext}
+// matchGoVersion attempts to satisfy expr by changing the ReleaseTags of
+// ctxt to those of the Go version nearest to the version of ctxt that
+// satisfies the Go 1 release tags of expr. The ReleaseTags are left changed
+// since the new version may be needed by the other strategies.
+// ErrImpossibleGoVersion is returned if no Go version satisfies expr (e.g.
+// "go1.21 && !go1.20").
+func matchGoVersion(ctxt *build.Context, expr constraint.Expr, tags map[string]bool) (bool, error) {
+	current := 0
+	for _, tag := range ctxt.ReleaseTags {
+		if v, ok := ParseGoVersionTag(tag); ok && v.Major == 1 && v.Minor > current {
+			current = v.Minor
+		}
+	}
+	// The version must be in the range [min, max). Since min is at least
+	// go1.1, a constraint that excludes it (e.g. "!go1.1") is impossible
+	// instead of producing an empty list of release tags.
+	min, max := 1, -1
+	for name := range tags {
+		v, ok := ParseGoVersionTag(name)
+		if !ok || v.Major != 1 || ClassifyTag(ctxt, name) != TagRelease {
+			continue
+		}
+		found, negated := lookupTag(expr, name)
+		switch {
+		case !found:
+		case negated && (max == -1 || v.Minor < max):
+			max = v.Minor
+		case !negated && v.Minor > min:
+			min = v.Minor
+		}
+	}
+	if max != -1 && min >= max {
+		return false, ErrImpossibleGoVersion
+	}
+	version := current
+	switch {
+	case current < min:
+		version = min // assume a newer Go version
+	case max != -1 && current >= max:
+		version = max - 1 // assume an older Go version
+	}
+	if version != current {
+		tags := make([]string, version)
+		for i := range tags {
+			tags[i] = "go1." + strconv.Itoa(i+1)
+		}
+		ctxt.ReleaseTags = tags
+	}
+	return eval(ctxt, expr, nil), nil
 }
 
 func pathContainsSrcDir(s string) bool {
@@ -558,7 +1013,7 @@ func resolveGOPATH(dir string) (string, bool) {
 	origDir := dir
 
 	if !pathContainsSrcDir(dir) {
-		dir, _ = filepath.EvalSymlinks(dir)
+		dir, _ = fsys.EvalSymlinks(dir)
 		if !pathContainsSrcDir(dir) {
 			return origDir, false
 		}
@@ -581,8 +1036,25 @@ func resolveGOPATH(dir string) (string, bool) {
 	return origDir, false
 }
 
-// TODO: use or remove
-func fixGOPATH(ctxt *build.Context, filename string) (string, bool) {
+// InferGOPATH returns the GOPATH that should be used when building the Go
+// source file filename. This is useful for editors when a file outside of
+// the configured GOPATH is opened.
+//
+// If filename is within the GOROOT or one of the Context's GOPATH entries the
+// GOPATH (or build.Default.GOPATH, if ctxt.GOPATH is empty) is returned.
+// Otherwise, if srcHeuristic is true, the parent of the nearest ancestor
+// directory named "src" (resolving symlinks if needed) is prepended to the
+// Context's GOPATH and returned.
+//
+// The returned bool reports if a GOPATH was found.
+func InferGOPATH(ctxt *build.Context, filename string, srcHeuristic bool) (string, bool) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	return inferGOPATH(ctxt, filename, srcHeuristic)
+}
+
+func inferGOPATH(ctxt *build.Context, filename string, srcHeuristic bool) (string, bool) {
 	dir := filepath.Dir(filename)
 
 	// fast check for GOROOT/GOPATH
@@ -601,6 +1073,9 @@ func fixGOPATH(ctxt *build.Context, filename string) (string, bool) {
 		}
 	}
 
+	if !srcHeuristic {
+		return "", false
+	}
 	if path, ok := resolveGOPATH(dir); ok {
 		if ctxt.SplitPathList == nil && ctxt.GOPATH != "" {
 			path = path + string(filepath.ListSeparator) + ctxt.GOPATH
@@ -4,13 +4,9 @@
 package buildutil
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"errors"
 	"flag"
 	"go/build"
-	"io"
-	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -19,6 +15,7 @@ import (
 	"sync"
 	"testing"
 
+	"github.com/charlievieth/buildutil/internal/testkit"
 	"github.com/charlievieth/buildutil/internal/util"
 )
 
@@ -45,11 +42,11 @@ func TestMatchContextWalkStdLib(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		expectedErrors["os/user/lookup_unix_test.go"] = ErrMatchContext
 	}
-	for _, name := range []string{"go1.17.9", "go1.18.1"} {
+	for _, name := range testkit.Corpora {
+		name := name
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
-			tarball := filepath.Join("./testdata", name+".tgz")
-			root := filepath.Join(extractTarball(t, tarball), name, "src")
+			root := testkit.ExtractCorpus(t, "./testdata", name)
 			testMatchContextWalkDirectory(t, root, expectedErrors)
 		})
 	}
@@ -183,19 +180,10 @@ func testMatchContextWalkDirectory(t *testing.T, root string, expectedErrors map
 		}()
 	}
 
-	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		name := d.Name()
-		if d.IsDir() && (name == "" || name[0] == '.' || name[0] == '_' ||
-			name == "vendor" || name == "testdata" || name == "internal" ||
-			name == "node_modules") {
-			return filepath.SkipDir
-		}
-		if d.Type().IsRegular() && filepath.Ext(name) == ".go" {
-			ch <- path
-		}
+	policy := DefaultWalkPolicy()
+	policy.SkipDirs = []string{"internal"}
+	err := policy.Walk(root, func(path string) error {
+		ch <- path
 		return nil
 	})
 	close(ch)
@@ -220,67 +208,3 @@ func testMatchContextWalkDirectory(t *testing.T, root string, expectedErrors map
 		t.Errorf("MatchContext: failed to visit the provided invalid files:\n%+v\n", expectedErrors)
 	}
 }
-
-func extractTarball(t *testing.T, tarball string) string {
-	tempdir := t.TempDir()
-
-	fi, err := os.Open(tarball)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer fi.Close()
-	gr, err := gzip.NewReader(fi)
-	if err != nil {
-		t.Fatal(err)
-	}
-	tr := tar.NewReader(gr)
-
-	seen := make(map[string]bool)
-	mkdir := func(dir string) error {
-		if seen[dir] {
-			return nil
-		}
-		seen[dir] = true
-		return os.MkdirAll(dir, 0755)
-	}
-
-	buf := make([]byte, 32*1024)
-	for {
-		hdr, err := tr.Next()
-		if err != nil {
-			if err != io.EOF {
-				t.Fatal(err)
-			}
-			break
-		}
-		path := filepath.Join(tempdir, hdr.Name)
-		if hdr.Typeflag == tar.TypeDir {
-			if err := mkdir(path); err != nil {
-				t.Fatal(err)
-			}
-			continue
-		}
-		if hdr.Typeflag != tar.TypeReg {
-			t.Logf("%s: unsupported type flag: %d", hdr.Name, hdr.Typeflag)
-			continue
-		}
-		if err := mkdir(filepath.Dir(path)); err != nil {
-			t.Fatal(err)
-		}
-		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, hdr.FileInfo().Mode())
-		if err != nil {
-			t.Fatal(err)
-		}
-		if _, err := io.CopyBuffer(f, tr, buf); err != nil {
-			t.Fatal(err)
-		}
-		if err := f.Close(); err != nil {
-			t.Fatal(err)
-		}
-	}
-
-	if err := gr.Close(); err != nil {
-		t.Fatal(err)
-	}
-	return tempdir
-}
@@ -2,15 +2,20 @@ package buildutil
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go/build"
+	"go/build/constraint"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/charlievieth/buildutil/internal/util"
@@ -225,7 +230,987 @@ func TestMatchContext(t *testing.T) {
 	}
 }
 
-func TestFixGOPATH(t *testing.T) {
+func TestMatchContextWasm(t *testing.T) {
+	tests := []struct {
+		filename, build string
+		goos            string
+	}{
+		{filename: "foo_wasip1.go", goos: "wasip1"},
+		{filename: "foo_js.go", goos: "js"},
+		{filename: "foo_wasm.go", goos: "js"},
+		{filename: "foo_wasm.go", build: "//go:build wasip1", goos: "wasip1"},
+		{filename: "foo.go", build: "//go:build js && wasm", goos: "js"},
+		{filename: "foo.go", build: "//go:build wasip1 && wasm", goos: "wasip1"},
+		{filename: "foo.go", build: "//go:build wasip1", goos: "wasip1"},
+	}
+	orig := build.Default
+	orig.GOOS = "linux"
+	orig.GOARCH = "amd64"
+	orig.CgoEnabled = true
+	for _, x := range tests {
+		src := "package p\n"
+		if x.build != "" {
+			src = x.build + "\n\n" + src
+		}
+		ctxt, err := MatchContext(&orig, x.filename, src)
+		if err != nil {
+			t.Errorf("%s: %q: %v", x.filename, x.build, err)
+			continue
+		}
+		if ctxt.GOOS != x.goos || ctxt.GOARCH != "wasm" || ctxt.CgoEnabled {
+			t.Errorf("%s: %q: got: %s/%s cgo=%t want: %s/wasm cgo=false", x.filename,
+				x.build, ctxt.GOOS, ctxt.GOARCH, ctxt.CgoEnabled, x.goos)
+		}
+	}
+}
+
+// Test files for OSes that are not in the head of the preference lists.
+func TestMatchContextSanitizerTags(t *testing.T) {
+	tests := []struct {
+		build     string
+		toolTags  []string
+		wantTools []string
+		cgo       bool
+	}{
+		{"//go:build race", nil, []string{"race"}, false},
+		{"//go:build msan", nil, []string{"msan"}, true},
+		{"//go:build asan && foo", nil, []string{"asan"}, true},
+		{"//go:build !race", []string{"race"}, nil, false},
+	}
+	for _, x := range tests {
+		orig := build.Default
+		orig.GOOS = "linux"
+		orig.GOARCH = "amd64"
+		orig.CgoEnabled = false
+		orig.BuildTags = nil
+		orig.ToolTags = x.toolTags
+		src := x.build + "\n\npackage p\n"
+		ctxt, err := MatchContext(&orig, "foo.go", src)
+		if err != nil {
+			t.Errorf("%q: %v", x.build, err)
+			continue
+		}
+		for _, tag := range ctxt.BuildTags {
+			if sanitizerTags[tag] {
+				t.Errorf("%q: sanitizer tag %q added to BuildTags: %q", x.build, tag, ctxt.BuildTags)
+			}
+		}
+		if (len(ctxt.ToolTags) != 0 || len(x.wantTools) != 0) && !reflect.DeepEqual(ctxt.ToolTags, x.wantTools) {
+			t.Errorf("%q: ToolTags = %q; want: %q", x.build, ctxt.ToolTags, x.wantTools)
+		}
+		if ctxt.CgoEnabled != x.cgo {
+			t.Errorf("%q: CgoEnabled = %t; want: %t", x.build, ctxt.CgoEnabled, x.cgo)
+		}
+		if len(orig.ToolTags) != len(x.toolTags) {
+			t.Errorf("%q: MatchContext modified the original ToolTags: %q", x.build, orig.ToolTags)
+		}
+	}
+}
+
+func TestMatchContextNicheOS(t *testing.T) {
+	tests := []struct {
+		filename, build string
+		goos            string
+	}{
+		{filename: "foo_plan9.go", goos: "plan9"},
+		{filename: "foo_aix.go", goos: "aix"},
+		{filename: "foo_solaris.go", goos: "solaris"},
+		{filename: "foo_illumos.go", goos: "illumos"},
+		{filename: "foo_dragonfly.go", goos: "dragonfly"},
+		{filename: "foo_plan9_arm.go", goos: "plan9"},
+		{filename: "foo.go", build: "//go:build plan9", goos: "plan9"},
+		{filename: "foo.go", build: "//go:build aix && ppc64", goos: "aix"},
+		{filename: "foo.go", build: "//go:build solaris && !illumos", goos: "solaris"},
+		{filename: "foo.go", build: "//go:build dragonfly || plan9", goos: "dragonfly"},
+		{filename: "foo_ppc64.go", build: "//go:build aix", goos: "aix"},
+	}
+	orig := build.Default
+	orig.GOOS = "linux"
+	orig.GOARCH = "amd64"
+	orig.CgoEnabled = true
+	for _, x := range tests {
+		src := "package p\n"
+		if x.build != "" {
+			src = x.build + "\n\n" + src
+		}
+		ctxt, err := MatchContext(&orig, x.filename, src)
+		if err != nil {
+			t.Errorf("%s: %q: %v", x.filename, x.build, err)
+			continue
+		}
+		if ctxt.GOOS != x.goos {
+			t.Errorf("%s: %q: GOOS = %q; want: %q", x.filename, x.build, ctxt.GOOS, x.goos)
+		}
+		if arches := supportedPlatformsOsArch[ctxt.GOOS]; arches != nil && !arches[ctxt.GOARCH] {
+			t.Errorf("%s: %q: unsupported platform: %s/%s", x.filename, x.build,
+				ctxt.GOOS, ctxt.GOARCH)
+		}
+		if !goodOSArchFile(ctxt, x.filename, nil) || !shouldBuildOnly(ctxt, []byte(src), nil) {
+			t.Errorf("%s: %q: Context %s/%s does not match the file", x.filename,
+				x.build, ctxt.GOOS, ctxt.GOARCH)
+		}
+	}
+}
+
+func TestMatchContextErrorCache(t *testing.T) {
+	matchErrCache.Reset()
+	t.Cleanup(matchErrCache.Reset)
+
+	src := "//go:build !" + latestReleaseTag + "\n\npackage main\n"
+	for i := 0; i < 2; i++ {
+		_, err := MatchContext(nil, "main.go", src)
+		var me *MatchError
+		if !errors.As(err, &me) {
+			t.Fatalf("%d: want error type %T got: %#v", i, me, err)
+		}
+		if !me.Permanent || me.Err != ErrImpossibleGoVersion {
+			t.Errorf("%d: got: %#v want: %v", i, me, ErrImpossibleGoVersion)
+		}
+		if n := matchErrCache.Len(); n != 1 {
+			t.Errorf("%d: cache size: got: %d want: %d", i, n, 1)
+		}
+	}
+
+	// Changing the Context must not use the cached error
+	ctxt := build.Default
+	ctxt.ReleaseTags = ctxt.ReleaseTags[:len(ctxt.ReleaseTags)-1]
+	if _, err := MatchContext(&ctxt, "main.go", src); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing the file must not use the cached error
+	src = "//go:build " + latestReleaseTag + "\n\npackage main\n"
+	if _, err := MatchContext(nil, "main.go", src); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Errors caused by the MatchOptions must not be returned for other options.
+func TestMatchContextErrorCacheOptions(t *testing.T) {
+	matchErrCache.Reset()
+	t.Cleanup(matchErrCache.Reset)
+
+	orig := build.Default
+	orig.Compiler = "gc"
+	orig.BuildTags = nil
+	const src = "//go:build gccgo || purego\n\npackage p\n"
+	for _, opts := range []*MatchOptions{
+		{Strategies: []MatchStrategy{StrategyCgo}},
+		{RequiredTags: []string{"!purego"}},
+		{ForbiddenTags: []string{"purego"}},
+	} {
+		if _, err := MatchContextOptions(&orig, "p.go", src, opts); !errors.Is(err, errCompilerMismatchGccGo) {
+			t.Errorf("%+v: error = %v; want: %v", opts, err, errCompilerMismatchGccGo)
+		}
+		ctxt, err := MatchContext(&orig, "p.go", src)
+		if err != nil {
+			t.Errorf("%+v: MatchContext: %v", opts, err)
+			continue
+		}
+		if !reflect.DeepEqual(ctxt.BuildTags, []string{"purego"}) {
+			t.Errorf("%+v: MatchContext: BuildTags = %q; want: %q", opts, ctxt.BuildTags, []string{"purego"})
+		}
+	}
+}
+
+// The compiler must not be checked before the build tags are tried.
+func TestMatchContextStrategyOrderCompiler(t *testing.T) {
+	matchErrCache.Reset()
+	t.Cleanup(matchErrCache.Reset)
+
+	orig := build.Default
+	orig.Compiler = "gc"
+	orig.BuildTags = nil
+	const src = "//go:build gccgo || purego\n\npackage p\n"
+	opts := &MatchOptions{Strategies: []MatchStrategy{StrategyCgo, StrategyBuildTags}}
+	ctxt, err := MatchContextOptions(&orig, "p.go", src, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ctxt.BuildTags, []string{"purego"}) {
+		t.Errorf("BuildTags = %q; want: %q", ctxt.BuildTags, []string{"purego"})
+	}
+}
+
+func TestMatchContextFilenameConflict(t *testing.T) {
+	matchErrCache.Reset()
+	t.Cleanup(matchErrCache.Reset)
+
+	orig := build.Default
+	orig.GOOS = "linux"
+	orig.GOARCH = "amd64"
+
+	conflicts := []struct {
+		filename, build string
+	}{
+		{"x_linux.go", "//go:build !linux"},
+		{"x_linux.go", "//go:build !unix"},
+		{"x_windows.go", "//go:build !windows && tag1"},
+		{"x_arm64.go", "//go:build amd64"},
+		{"x_linux_arm64.go", "//go:build darwin || !arm64"},
+		{"x_android.go", "//go:build !linux"},
+	}
+	for _, x := range conflicts {
+		src := x.build + "\n\npackage main\n"
+		_, err := MatchContext(&orig, x.filename, src)
+		var me *MatchError
+		if !errors.As(err, &me) {
+			t.Errorf("%s: %q: want error type %T got: %#v", x.filename, x.build, me, err)
+			continue
+		}
+		if !me.Permanent || !errors.Is(err, ErrFilenameConflict) {
+			t.Errorf("%s: %q: got: %#v want: %v", x.filename, x.build, me, ErrFilenameConflict)
+		}
+	}
+
+	// Constraints that can be satisfied must not be reported as a conflict.
+	valid := []struct {
+		filename, build string
+	}{
+		{"x_linux.go", "//go:build !linux || tag1"},
+		{"x_linux.go", "//go:build !darwin"},
+		{"x_arm64.go", "//go:build !amd64 && cgo"},
+		{"x_windows.go", "//go:build !unix"},
+	}
+	for _, x := range valid {
+		src := x.build + "\n\npackage main\n"
+		if _, err := MatchContext(&orig, x.filename, src); err != nil {
+			t.Errorf("%s: %q: unexpected error: %v", x.filename, x.build, err)
+		}
+	}
+}
+
+func TestMatchContextOptions(t *testing.T) {
+	orig := build.Default
+	orig.GOOS = "linux"
+	orig.GOARCH = "amd64"
+	orig.CgoEnabled = false
+
+	tests := []struct {
+		build string
+		opts  *MatchOptions
+		goos  string
+		arch  string
+		err   bool
+	}{
+		{
+			build: "freebsd || netbsd",
+			opts:  &MatchOptions{PreferredOS: []string{"net*"}},
+			goos:  "netbsd",
+		},
+		{
+			build: "freebsd || netbsd",
+			opts:  &MatchOptions{PreferredOS: []string{"freebsd"}},
+			goos:  "freebsd",
+		},
+		{
+			build: "freebsd || netbsd",
+			opts:  &MatchOptions{Policy: PolicyFirstClassOnly},
+			err:   true,
+		},
+		{
+			build: "windows || freebsd",
+			opts:  &MatchOptions{PreferredOS: []string{"*bsd"}, Policy: PolicyFirstClassOnly},
+			goos:  "windows",
+		},
+		{
+			build: "mips64 || riscv64",
+			opts:  &MatchOptions{PreferredArch: []string{"riscv*"}},
+			arch:  "riscv64",
+		},
+		{
+			build: "mips64 || riscv64",
+			opts:  &MatchOptions{PreferredArch: []string{"[invalid", "mips64"}},
+			arch:  "mips64",
+		},
+		{
+			build: "windows || freebsd",
+			opts:  &MatchOptions{PreferredOS: []string{"windows"}},
+			goos:  "windows",
+		},
+		{
+			build: "windows || freebsd",
+			opts:  &MatchOptions{PreferredOS: []string{"windows"}, Policy: PolicySameFamily},
+			goos:  "freebsd",
+		},
+		{
+			build: "arm64 || 386",
+			opts:  &MatchOptions{},
+			arch:  "arm64",
+		},
+		{
+			build: "arm64 || 386",
+			opts:  &MatchOptions{Policy: PolicySameFamily},
+			arch:  "386",
+		},
+		{
+			build: "ppc64le || mips64le",
+			opts:  &MatchOptions{PreferredArch: []string{"ppc64le"}},
+			arch:  "ppc64le",
+		},
+		{
+			build: "freebsd || netbsd",
+			opts: &MatchOptions{
+				PreferredOS: []string{"freebsd"},
+				Targets:     []GoPlatform{{GOOS: "netbsd"}},
+			},
+			goos: "netbsd",
+		},
+		{
+			build: "(darwin || windows) && (arm64 || 386)",
+			opts: &MatchOptions{
+				Targets: []GoPlatform{{GOOS: "openbsd", GOARCH: "amd64"}, {GOOS: "windows", GOARCH: "386"}},
+			},
+			goos: "windows",
+			arch: "386",
+		},
+		{
+			build: "mips64 || riscv64",
+			opts: &MatchOptions{
+				PreferredArch: []string{"mips64"},
+				Targets:       []GoPlatform{{GOARCH: "riscv64"}},
+			},
+			arch: "riscv64",
+		},
+		{
+			// Targets excluded by the policy are ignored
+			build: "freebsd || netbsd",
+			opts: &MatchOptions{
+				Targets: []GoPlatform{{GOOS: "netbsd", GOARCH: "amd64"}},
+				Policy:  PolicyFirstClassOnly,
+			},
+			err: true,
+		},
+	}
+	for _, x := range tests {
+		src := "//go:build " + x.build + "\n\npackage p\n"
+		ctxt, err := MatchContextOptions(&orig, "p.go", src, x.opts)
+		if x.err {
+			if err == nil {
+				t.Errorf("%q: %+v: expected error got: %s/%s", x.build, x.opts,
+					ctxt.GOOS, ctxt.GOARCH)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %+v: %v", x.build, x.opts, err)
+			continue
+		}
+		if x.goos != "" && ctxt.GOOS != x.goos {
+			t.Errorf("%q: %+v: GOOS got: %q want: %q", x.build, x.opts, ctxt.GOOS, x.goos)
+		}
+		if x.arch != "" && ctxt.GOARCH != x.arch {
+			t.Errorf("%q: %+v: GOARCH got: %q want: %q", x.build, x.opts, ctxt.GOARCH, x.arch)
+		}
+	}
+}
+
+func TestMatchContextRequiredTags(t *testing.T) {
+	orig := build.Default
+	orig.GOOS = "linux"
+	orig.GOARCH = "amd64"
+	orig.BuildTags = []string{"debug"}
+
+	tests := []struct {
+		build    string
+		required []string
+		goos     string
+		tags     []string
+		err      bool
+	}{
+		{
+			build:    "linux",
+			required: []string{"integration"},
+			goos:     "linux",
+			tags:     []string{"debug", "integration"},
+		},
+		{
+			build:    "integration && darwin",
+			required: []string{"integration"},
+			goos:     "darwin",
+			tags:     []string{"debug", "integration"},
+		},
+		{
+			// The required tag cannot be removed to match the file
+			build:    "!integration",
+			required: []string{"integration"},
+			err:      true,
+		},
+		{
+			// A negated required tag is removed and never added
+			build:    "debug",
+			required: []string{"!debug"},
+			err:      true,
+		},
+		{
+			build:    "!debug || windows",
+			required: []string{"!debug"},
+			goos:     "linux",
+			tags:     []string{},
+		},
+	}
+	for _, x := range tests {
+		src := "//go:build " + x.build + "\n\npackage p\n"
+		opts := &MatchOptions{RequiredTags: x.required}
+		ctxt, err := MatchContextOptions(&orig, "p.go", src, opts)
+		if x.err {
+			if err == nil {
+				t.Errorf("%q: %q: expected error got: %s/%s %q", x.build, x.required,
+					ctxt.GOOS, ctxt.GOARCH, ctxt.BuildTags)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %q: %v", x.build, x.required, err)
+			continue
+		}
+		if ctxt.GOOS != x.goos {
+			t.Errorf("%q: %q: GOOS got: %q want: %q", x.build, x.required, ctxt.GOOS, x.goos)
+		}
+		tags := append([]string{}, ctxt.BuildTags...)
+		sort.Strings(tags)
+		if !reflect.DeepEqual(tags, x.tags) {
+			t.Errorf("%q: %q: BuildTags got: %q want: %q", x.build, x.required, tags, x.tags)
+		}
+	}
+	if !reflect.DeepEqual(orig.BuildTags, []string{"debug"}) {
+		t.Errorf("MatchContextOptions modified the original Context: %q", orig.BuildTags)
+	}
+}
+
+func TestMatchContextForbiddenTags(t *testing.T) {
+	orig := build.Default
+	orig.GOOS = "linux"
+	orig.GOARCH = "amd64"
+	orig.BuildTags = []string{"purego"}
+
+	tests := []struct {
+		build string
+		goos  string
+		err   bool
+	}{
+		{build: "!purego", goos: "linux"},
+		{build: "purego || windows", goos: "windows"},
+		{build: "!purego && (foo || windows)", goos: "linux"},
+		{build: "purego", err: true},
+		{build: "purego && windows", err: true},
+		{build: "(purego || foo) && (purego || !foo)", err: true},
+	}
+	opts := &MatchOptions{ForbiddenTags: []string{"purego"}}
+	for _, x := range tests {
+		src := "//go:build " + x.build + "\n\npackage p\n"
+		ctxt, err := MatchContextOptions(&orig, "p.go", src, opts)
+		if x.err {
+			var me *MatchError
+			if !errors.As(err, &me) || !me.Permanent || !errors.Is(err, ErrForbiddenTag) {
+				t.Errorf("%q: error = %v; want: %v", x.build, err, ErrForbiddenTag)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", x.build, err)
+			continue
+		}
+		if ctxt.GOOS != x.goos {
+			t.Errorf("%q: GOOS got: %q want: %q", x.build, ctxt.GOOS, x.goos)
+		}
+		if util.StringsContains(ctxt.BuildTags, "purego") {
+			t.Errorf("%q: BuildTags contains forbidden tag: %q", x.build, ctxt.BuildTags)
+		}
+	}
+
+	// Without the option the tag is set
+	src := "//go:build purego && windows\n\npackage p\n"
+	if _, err := MatchContext(&orig, "p.go", src); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMatchContextPlatforms(t *testing.T) {
+	orig := build.Default
+	orig.GOOS = "linux"
+	orig.GOARCH = "amd64"
+
+	tests := []struct {
+		version string
+		build   string
+		want    string // GOOS/GOARCH, empty if no platform matches
+	}{
+		{"go1.18", "loong64", ""},
+		{"go1.19", "loong64", "linux/loong64"},
+		{"go1.20", "wasip1", ""},
+		{"go1.21", "wasip1", "wasip1/wasm"},
+	}
+	for _, x := range tests {
+		platforms, err := GoVersionPlatforms(x.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := "//go:build " + x.build + "\n\npackage p\n"
+		ctxt, err := MatchContextOptions(&orig, "p.go", src, &MatchOptions{Platforms: platforms})
+		if x.want == "" {
+			if err == nil {
+				t.Errorf("%s: %q: expected an error got: %s/%s", x.version, x.build,
+					ctxt.GOOS, ctxt.GOARCH)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %q: %v", x.version, x.build, err)
+			continue
+		}
+		if got := ctxt.GOOS + "/" + ctxt.GOARCH; got != x.want {
+			t.Errorf("%s: %q: got: %s want: %s", x.version, x.build, got, x.want)
+		}
+	}
+}
+
+func TestMatchContextNoGOROOT(t *testing.T) {
+	orig := build.Default
+	orig.GOROOT = ""
+	src := "//go:build linux\n\npackage p\n"
+
+	// By default the GOROOT is set from the runtime
+	ctxt, err := MatchContext(&orig, "p.go", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctxt.GOROOT != runtime.GOROOT() {
+		t.Errorf("GOROOT = %q; want: %q", ctxt.GOROOT, runtime.GOROOT())
+	}
+
+	for _, goroot := range []string{"", "/does/not/exist"} {
+		orig.GOROOT = goroot
+		ctxt, err := MatchContextOptions(&orig, "p.go", src, &MatchOptions{NoGOROOT: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ctxt.GOROOT != "" {
+			t.Errorf("NoGOROOT: GOROOT = %q; want: %q", ctxt.GOROOT, "")
+		}
+		if ctxt.GOOS != "linux" {
+			t.Errorf("NoGOROOT: GOOS = %q; want: %q", ctxt.GOOS, "linux")
+		}
+	}
+}
+
+func TestMatchContextSymlinkToAncestor(t *testing.T) {
+	switch runtime.GOOS {
+	case "windows", "plan9":
+		t.Skip("skipping: test requires symlinks")
+	}
+	dir := filepath.Join(t.TempDir(), "a")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "x_linux.go"), []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dir, filepath.Join(dir, "l")); err != nil {
+		t.Fatal(err)
+	}
+	orig := build.Default
+	orig.GOOS = "darwin"
+	filename := filepath.Join(dir, "l", "x_linux.go")
+	ctxt, err := MatchContext(&orig, filename, nil)
+	if err != nil {
+		t.Fatalf("MatchContext(%q): %v", filename, err)
+	}
+	if ctxt.GOOS != "linux" {
+		t.Errorf("MatchContext(%q): GOOS = %q; want: %q", filename, ctxt.GOOS, "linux")
+	}
+}
+
+func TestMatchErrorKind(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("%w: missing close paren", ErrInvalidConstraint), "invalid-constraint"},
+		{ErrImpossibleGoVersion, "impossible-go-version"},
+		{ErrFilenameConflict, "filename-conflict"},
+		{fmt.Errorf("%w: purego", ErrForbiddenTag), "forbidden-tag"},
+		{errCompilerMismatchGccGo, "compiler-mismatch"},
+		{errCompilerNegatedGc, "compiler-mismatch"},
+		{ErrMatchContext, "no-match"},
+		{errors.New("no build tags"), "other"},
+		{nil, "other"},
+	}
+	for _, x := range tests {
+		e := &MatchError{Path: "a.go", Err: x.err}
+		if got := e.Kind(); got != x.want {
+			t.Errorf("MatchError{Err: %v}.Kind() = %q; want: %q", x.err, got, x.want)
+		}
+	}
+}
+
+func TestMatchErrorJSON(t *testing.T) {
+	me := &MatchError{Path: "a.go", Permanent: true, Err: ErrImpossibleGoVersion}
+	data, err := json.Marshal(me)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"path":"a.go","permanent":true,"kind":"impossible-go-version",` +
+		`"message":"cannot satisfy go version"}`
+	if string(data) != want {
+		t.Errorf("json.Marshal(%v) = %s; want: %s", me, data, want)
+	}
+}
+
+func TestMatchContextInvalidConstraint(t *testing.T) {
+	for _, src := range []string{
+		"//go:build (linux\n\npackage p\n",
+		"//go:build linux &&\n\npackage p\n",
+	} {
+		_, err := MatchContext(nil, "p.go", src)
+		var me *MatchError
+		if !errors.As(err, &me) || me.Permanent || !errors.Is(err, ErrInvalidConstraint) {
+			t.Errorf("%q: error = %v; want: %v", src, err, ErrInvalidConstraint)
+		}
+	}
+}
+
+func TestRequiresForbiddenTag(t *testing.T) {
+	tests := []struct {
+		expr string
+		tag  string
+		ok   bool
+	}{
+		{"purego", "purego", true},
+		{"purego && foo", "purego", true},
+		{"!purego", "", false},
+		{"purego || foo", "", false},
+		{"foo", "", false},
+		{"(purego || !foo) && (purego || foo)", "purego", true},
+		{"noasm || purego", "noasm", true},
+	}
+	forbidden := []string{"purego", "noasm"}
+	for _, x := range tests {
+		expr, err := constraint.Parse("//go:build " + x.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tag, ok := requiresForbiddenTag(expr, forbidden)
+		if tag != x.tag || ok != x.ok {
+			t.Errorf("requiresForbiddenTag(%q) = %q, %t; want: %q, %t", x.expr, tag, ok, x.tag, x.ok)
+		}
+	}
+}
+
+func TestMatchContextStrategies(t *testing.T) {
+	orig := build.Default
+	orig.GOOS = "linux"
+	orig.GOARCH = "amd64"
+	orig.CgoEnabled = false
+	orig.BuildTags = nil
+	orig.ReleaseTags = nil
+	for i := 1; i <= 20; i++ {
+		orig.ReleaseTags = append(orig.ReleaseTags, "go1."+strconv.Itoa(i))
+	}
+	withVersion := []MatchStrategy{StrategyBuildTags, StrategyGoVersion, StrategyCgo, StrategyPlatform}
+
+	tests := []struct {
+		build      string
+		strategies []MatchStrategy
+		goos       string
+		cgo        bool
+		tags       []string
+		version    string // last release tag
+		err        error
+	}{
+		{build: "go1.99", err: ErrImpossibleGoVersion},
+		{build: "go1.22", strategies: withVersion, goos: "linux", version: "go1.22"},
+		{build: "!go1.18", strategies: withVersion, goos: "linux", version: "go1.17"},
+		{build: "go1.15 && !go1.18", strategies: withVersion, goos: "linux", version: "go1.17"},
+		{build: "go1.21 && !go1.20", strategies: withVersion, err: ErrImpossibleGoVersion},
+		{build: "!go1.1", strategies: withVersion, err: ErrImpossibleGoVersion},
+		{
+			// Prefer the tag to assuming a newer Go version
+			build:      "go1.22 || purego",
+			strategies: withVersion,
+			goos:       "linux",
+			tags:       []string{"purego"},
+			version:    "go1.20",
+		},
+		{
+			build:      "go1.22 || purego",
+			strategies: []MatchStrategy{StrategyGoVersion, StrategyBuildTags},
+			goos:       "linux",
+			version:    "go1.22",
+		},
+		{
+			// The Go version is kept when changing the platform
+			build:      "go1.22 && windows",
+			strategies: withVersion,
+			goos:       "windows",
+			version:    "go1.22",
+		},
+		{build: "cgo || windows", goos: "linux", cgo: true, version: "go1.20"},
+		{
+			build:      "cgo || windows",
+			strategies: []MatchStrategy{StrategyPlatform, StrategyCgo},
+			goos:       "windows",
+			version:    "go1.20",
+		},
+		{build: "windows", strategies: []MatchStrategy{StrategyBuildTags}, err: ErrMatchContext},
+		{build: "go1.99", strategies: []MatchStrategy{StrategyBuildTags}, err: ErrImpossibleGoVersion},
+	}
+	for _, x := range tests {
+		src := "//go:build " + x.build + "\n\npackage p\n"
+		opts := &MatchOptions{Strategies: x.strategies}
+		ctxt, err := MatchContextOptions(&orig, "p.go", src, opts)
+		if x.err != nil {
+			if !errors.Is(err, x.err) {
+				t.Errorf("%q: %v: error = %v; want: %v", x.build, x.strategies, err, x.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v: %v", x.build, x.strategies, err)
+			continue
+		}
+		version := ctxt.ReleaseTags[len(ctxt.ReleaseTags)-1]
+		if ctxt.GOOS != x.goos || ctxt.CgoEnabled != x.cgo || version != x.version ||
+			!reflect.DeepEqual(ctxt.BuildTags, x.tags) {
+			t.Errorf("%q: %v: got: {%s, cgo: %t, %q, %s} want: {%s, cgo: %t, %q, %s}",
+				x.build, x.strategies, ctxt.GOOS, ctxt.CgoEnabled, ctxt.BuildTags, version,
+				x.goos, x.cgo, x.tags, x.version)
+		}
+	}
+	if len(orig.ReleaseTags) != 20 {
+		t.Errorf("MatchContextOptions modified the original Context: %q", orig.ReleaseTags)
+	}
+}
+
+func TestMatchContextImportHints(t *testing.T) {
+	orig := build.Default
+	orig.GOOS = "linux"
+	orig.GOARCH = "amd64"
+	orig.CgoEnabled = true
+
+	tests := []struct {
+		name, src  string
+		goos       string
+		goarch     string
+		cgoEnabled bool
+	}{
+		{
+			name:   "p.go",
+			src:    "//go:build !linux\n\npackage p\n\nimport \"syscall/js\"\n",
+			goos:   "js",
+			goarch: "wasm",
+		},
+		{
+			name:       "p.go",
+			src:        "//go:build !linux\n\npackage p\n\nimport (\n\t\"fmt\"\n\t\"golang.org/x/sys/windows/registry\"\n)\n",
+			goos:       "windows",
+			goarch:     "amd64",
+			cgoEnabled: true,
+		},
+		{
+			// Already a unix OS
+			name:       "p.go",
+			src:        "//go:build !darwin\n\npackage p\n\nimport \"golang.org/x/sys/unix\"\n",
+			goos:       "linux",
+			goarch:     "amd64",
+			cgoEnabled: true,
+		},
+		{
+			// The hint is only used if the Context does not match
+			name:       "p.go",
+			src:        "package p\n\nimport \"syscall/js\"\n",
+			goos:       "linux",
+			goarch:     "amd64",
+			cgoEnabled: true,
+		},
+		{
+			// The file name takes precedence
+			name:       "p_linux.go",
+			src:        "package p\n\nimport \"syscall/js\"\n",
+			goos:       "linux",
+			goarch:     "amd64",
+			cgoEnabled: true,
+		},
+		{
+			// Conflicting imports are ignored
+			name:       "p.go",
+			src:        "//go:build !linux\n\npackage p\n\nimport (\n\t\"syscall/js\"\n\t\"golang.org/x/sys/windows\"\n)\n",
+			goos:       "darwin",
+			goarch:     "amd64",
+			cgoEnabled: true,
+		},
+		{
+			name:       "p.go",
+			src:        "package p\n\nimport \"golang.org/x/sys/windowsx\"\n",
+			goos:       "linux",
+			goarch:     "amd64",
+			cgoEnabled: true,
+		},
+	}
+	for _, x := range tests {
+		ctxt, err := MatchContext(&orig, x.name, x.src)
+		if err != nil {
+			t.Errorf("%s: %q: %v", x.name, x.src, err)
+			continue
+		}
+		if ctxt.GOOS != x.goos || ctxt.GOARCH != x.goarch || ctxt.CgoEnabled != x.cgoEnabled {
+			t.Errorf("%s: %q: got: %s/%s cgo: %t want: %s/%s cgo: %t", x.name, x.src,
+				ctxt.GOOS, ctxt.GOARCH, ctxt.CgoEnabled, x.goos, x.goarch, x.cgoEnabled)
+		}
+	}
+
+	// The hint is not used if the Context already matches the file
+	wasip1 := orig
+	wasip1.GOOS = "wasip1"
+	wasip1.GOARCH = "wasm"
+	wasip1.CgoEnabled = false
+	src := "//go:build unix || (js && wasm) || wasip1\n\npackage os\n\nimport \"internal/syscall/unix\"\n"
+	ctxt, err := MatchContext(&wasip1, "file_unix.go", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctxt.GOOS != "wasip1" || ctxt.GOARCH != "wasm" {
+		t.Errorf("%q: got: %s/%s want: %s/%s", src, ctxt.GOOS, ctxt.GOARCH, "wasip1", "wasm")
+	}
+
+	// The unix system call packages can be built for js and wasip1
+	for _, goos := range []string{"js", "wasip1"} {
+		src := "//go:build " + goos + "\n\npackage p\n\nimport \"golang.org/x/sys/unix\"\n"
+		ctxt, err := MatchContext(&orig, "p.go", src)
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+		if ctxt.GOOS != goos || ctxt.GOARCH != "wasm" {
+			t.Errorf("%q: got: %s/%s want: %s/%s", src, ctxt.GOOS, ctxt.GOARCH, goos, "wasm")
+		}
+	}
+
+	// Unix packages select a unix OS
+	orig.GOOS = "windows"
+	src = "//go:build !windows\n\npackage p\n\nimport \"golang.org/x/sys/unix\"\n"
+	ctxt, err = MatchContext(&orig, "p.go", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !unixOS[ctxt.GOOS] {
+		t.Errorf("%q: GOOS = %q; want a unix OS", src, ctxt.GOOS)
+	}
+}
+
+func TestMatchStrategyString(t *testing.T) {
+	for _, s := range []MatchStrategy{StrategyBuildTags, StrategyCgo, StrategyPlatform, StrategyGoVersion} {
+		if name := s.String(); name == "" || strings.HasPrefix(name, "MatchStrategy(") {
+			t.Errorf("%d: missing name: %q", s, name)
+		}
+	}
+	if s := MatchStrategy(100).String(); s != "MatchStrategy(100)" {
+		t.Errorf("String() = %q; want: %q", s, "MatchStrategy(100)")
+	}
+}
+
+// Test that MatchContext is safe for concurrent use while the preferred
+// lists are being updated (run with -race).
+func TestMatchContextConcurrent(t *testing.T) {
+	t.Cleanup(func() {
+		SetPreferredOSList(nil)
+		SetPreferredArchList(nil)
+	})
+	orig := build.Default
+	orig.GOOS = "linux"
+	orig.GOARCH = "amd64"
+
+	srcs := []string{
+		"//go:build freebsd || netbsd\n\npackage p\n",
+		"//go:build mips64 || riscv64\n\npackage p\n",
+		"//go:build !" + latestReleaseTag + "\n\npackage p\n",
+	}
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			for j := 0; j < 50; j++ {
+				switch i {
+				case 0:
+					SetPreferredOSList([]string{"*bsd"})
+				case 1:
+					SetPreferredArchList([]string{"riscv*"})
+				default:
+					MatchContext(&orig, "p.go", srcs[j%len(srcs)])
+				}
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+}
+
+func TestSetPreferredOSList(t *testing.T) {
+	t.Cleanup(func() { SetPreferredOSList(nil) })
+	SetPreferredOSList([]string{"net*"})
+	if PreferredOSList[0] != "netbsd" {
+		t.Errorf("PreferredOSList[0] = %q; want: %q", PreferredOSList[0], "netbsd")
+	}
+	if len(PreferredOSList) != len(defaultPreferredOSList) {
+		t.Errorf("len(PreferredOSList) = %d; want: %d", len(PreferredOSList),
+			len(defaultPreferredOSList))
+	}
+}
+
+func TestDefaultPreferredListTail(t *testing.T) {
+	test := func(t *testing.T, list, head []string, fn func(p *GoPlatform) string) {
+		seen := make(map[string]bool)
+		for _, s := range head {
+			seen[s] = true
+		}
+		var want []string
+		for _, p := range knownPlatforms {
+			if s := fn(&p); !seen[s] {
+				seen[s] = true
+				want = append(want, s)
+			}
+		}
+		if got := list[len(list)-len(want):]; !reflect.DeepEqual(got, want) {
+			t.Errorf("tail = %q; want: %q", got, want)
+		}
+	}
+	t.Run("OS", func(t *testing.T) {
+		head := []string{runtime.GOOS, "darwin", "linux", "windows", "openbsd", "freebsd", "netbsd"}
+		test(t, defaultPreferredOSList, head, func(p *GoPlatform) string { return p.GOOS })
+		for _, os := range []string{"aix", "dragonfly", "illumos", "plan9", "solaris"} {
+			if !util.StringsContains(defaultPreferredOSList, os) {
+				t.Errorf("missing OS: %q", os)
+			}
+		}
+	})
+	t.Run("Arch", func(t *testing.T) {
+		head := []string{runtime.GOARCH, "amd64", "arm64", "arm", "386", "ppc64"}
+		test(t, defaultPreferredArchList, head, func(p *GoPlatform) string { return p.GOARCH })
+	})
+}
+
+func TestExpandPreferredList(t *testing.T) {
+	list := []string{"linux", "darwin", "freebsd", "netbsd", "openbsd"}
+	tests := []struct {
+		patterns, want []string
+	}{
+		{nil, list},
+		{[]string{"*bsd"}, []string{"freebsd", "netbsd", "openbsd", "linux", "darwin"}},
+		{[]string{"openbsd", "*bsd"}, []string{"openbsd", "freebsd", "netbsd", "linux", "darwin"}},
+		{[]string{"plan9", "darwin"}, []string{"darwin", "linux", "freebsd", "netbsd", "openbsd"}},
+	}
+	for _, x := range tests {
+		got := expandPreferredList(x.patterns, list)
+		if !reflect.DeepEqual(got, x.want) {
+			t.Errorf("expandPreferredList(%q) = %q; want: %q", x.patterns, got, x.want)
+		}
+	}
+}
+
+func TestInferGOPATH(t *testing.T) {
 	type gopathTest struct {
 		dir, exp string
 		ok       bool
@@ -279,9 +1264,90 @@ func TestFixGOPATH(t *testing.T) {
 	}
 	for _, x := range tests {
 		ctxt.GOPATH = filepath.Clean("/go")
-		got, ok := fixGOPATH(&ctxt, x.dir)
+		got, ok := InferGOPATH(&ctxt, x.dir, true)
+		if got != x.exp || ok != x.ok {
+			t.Errorf("InferGOPATH(%q) = %q, %t; want: %q, %t", x.dir, got, ok, x.exp, x.ok)
+		}
+	}
+}
+
+func TestInferGOPATH_NoSrcHeuristic(t *testing.T) {
+	ctxt := build.Default
+	ctxt.GOROOT = filepath.Clean("/goroot")
+	ctxt.GOPATH = filepath.Clean("/go")
+	tests := []struct {
+		dir, exp string
+		ok       bool
+	}{
+		{"/go/src/p", "/go", true},
+		{"/goroot/src/p", "/go", true},
+		{"/xgo/src/p", "", false},
+	}
+	for _, x := range tests {
+		dir := filepath.Clean(x.dir)
+		exp := x.exp
+		if exp != "" {
+			exp = filepath.Clean(exp)
+		}
+		got, ok := InferGOPATH(&ctxt, filepath.Join(dir, "p.go"), false)
+		if got != exp || ok != x.ok {
+			t.Errorf("InferGOPATH(%q) = %q, %t; want: %q, %t", dir, got, ok, exp, x.ok)
+		}
+	}
+}
+
+func TestInferGOPATH_Symlink(t *testing.T) {
+	tmp, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pathContainsSrcDir(tmp) {
+		t.Skipf("skipping: temp dir contains a src directory: %s", tmp)
+	}
+	gopath := filepath.Join(tmp, "gopath")
+	pkgDir := filepath.Join(gopath, "src", "p")
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// The path of the link does not contain a "src" directory
+	link := filepath.Join(tmp, "link")
+	if err := os.Symlink(pkgDir, link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	filename := filepath.Join(link, "p.go")
+
+	ctxt := build.Default
+	ctxt.GOROOT = filepath.Join(tmp, "goroot")
+	ctxt.GOPATH = filepath.Join(tmp, "other")
+	want := gopath + string(filepath.ListSeparator) + ctxt.GOPATH
+	if got, ok := InferGOPATH(&ctxt, filename, true); got != want || !ok {
+		t.Errorf("InferGOPATH(%q) = %q, %t; want: %q, %t", filename, got, ok, want, true)
+	}
+	if got, ok := InferGOPATH(&ctxt, filename, false); got != "" || ok {
+		t.Errorf("InferGOPATH(%q, false) = %q, %t; want: %q, %t", filename, got, ok, "", false)
+	}
+}
+
+func TestInferGOPATH_Windows(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("skipping: Windows only test")
+	}
+	ctxt := build.Default
+	ctxt.GOROOT = `C:\Go`
+	tests := []struct {
+		filename, gopath, exp string
+		ok                    bool
+	}{
+		{`C:\Users\me\go\src\p\p.go`, `C:\Users\me\go`, `C:\Users\me\go`, true},
+		{`C:\Go\src\fmt\print.go`, `C:\Users\me\go`, `C:\Users\me\go`, true},
+		{`D:\work\src\p\p.go`, `C:\Users\me\go`, `D:\work;C:\Users\me\go`, true},
+		{`D:\work\p\p.go`, `C:\Users\me\go`, "", false},
+	}
+	for _, x := range tests {
+		ctxt.GOPATH = x.gopath
+		got, ok := InferGOPATH(&ctxt, x.filename, true)
 		if got != x.exp || ok != x.ok {
-			t.Errorf("fixGOPATH(%q) = %q, %t; want: %q, %t", x.dir, got, ok, x.exp, x.ok)
+			t.Errorf("InferGOPATH(%q) = %q, %t; want: %q, %t", x.filename, got, ok, x.exp, x.ok)
 		}
 	}
 }
@@ -341,7 +1407,7 @@ const FooBar = 1
 	}
 }
 
-func BenchmarkFixGOPATH(b *testing.B) {
+func BenchmarkInferGOPATH(b *testing.B) {
 	wd, err := os.Getwd()
 	if err != nil {
 		b.Fatal(err)
@@ -354,7 +1420,7 @@ func BenchmarkFixGOPATH(b *testing.B) {
 		gopath := ctxt.GOPATH
 		for i := 0; i < b.N; i++ {
 			ctxt.GOPATH = gopath
-			fixGOPATH(&ctxt, filename)
+			InferGOPATH(&ctxt, filename, true)
 		}
 	})
 
@@ -367,7 +1433,7 @@ func BenchmarkFixGOPATH(b *testing.B) {
 		gopath := ctxt.GOPATH
 		for i := 0; i < b.N; i++ {
 			ctxt.GOPATH = gopath
-			fixGOPATH(&ctxt, filename)
+			InferGOPATH(&ctxt, filename, true)
 		}
 	})
 }
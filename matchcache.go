@@ -0,0 +1,123 @@
+package buildutil
+
+import (
+	"crypto/sha256"
+	"go/build"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// maxMatchErrorCacheSize is the maximum number of permanent errors cached
+// by MatchContext. The cache is cleared once it reaches this size.
+const maxMatchErrorCacheSize = 1024
+
+type matchCacheKey FileHash
+
+// matchErrorCache caches permanent MatchErrors (e.g. compiler mismatch or
+// impossible Go version), which will never change until either the file or
+// Context changes, so that repeated requests for the same file can be
+// short-circuited.
+type matchErrorCache struct {
+	mu    sync.Mutex
+	m     map[matchCacheKey]error
+	paths map[string][]matchCacheKey // keys stored for each file
+}
+
+var matchErrCache matchErrorCache
+
+func (c *matchErrorCache) Load(key matchCacheKey) (error, bool) {
+	c.mu.Lock()
+	err, ok := c.m[key]
+	c.mu.Unlock()
+	return err, ok
+}
+
+func (c *matchErrorCache) Store(key matchCacheKey, filename string, err error) {
+	filename = filepath.Clean(filename)
+	c.mu.Lock()
+	if c.m == nil || len(c.m) >= maxMatchErrorCacheSize {
+		c.m = make(map[matchCacheKey]error)
+		c.paths = make(map[string][]matchCacheKey)
+	}
+	c.m[key] = err
+	c.paths[filename] = append(c.paths[filename], key)
+	c.mu.Unlock()
+}
+
+// Invalidate removes the errors stored for the file filename.
+func (c *matchErrorCache) Invalidate(filename string) {
+	filename = filepath.Clean(filename)
+	c.mu.Lock()
+	for _, key := range c.paths[filename] {
+		delete(c.m, key)
+	}
+	delete(c.paths, filename)
+	c.mu.Unlock()
+}
+
+func (c *matchErrorCache) Len() int {
+	c.mu.Lock()
+	n := len(c.m)
+	c.mu.Unlock()
+	return n
+}
+
+func (c *matchErrorCache) Reset() {
+	c.mu.Lock()
+	c.m = nil
+	c.paths = nil
+	c.mu.Unlock()
+}
+
+// matchContextKey returns the cache key for the file header, the fields of
+// build.Context ctxt that are used when evaluating build constraints and
+// the MatchOptions opts, which may be nil. The options are part of the key
+// since they determine whether an error is permanent (e.g. an impossible Go
+// version is only an error if the Go version cannot be changed and the
+// required or forbidden tags may prevent the build tags from satisfying a
+// compiler constraint).
+func matchContextKey(ctxt *build.Context, filename string, header []byte, opts *MatchOptions) matchCacheKey {
+	h := sha256.New()
+	write := func(s string) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	writeList := func(a []string) {
+		write(strconv.Itoa(len(a)))
+		for _, s := range a {
+			write(s)
+		}
+	}
+	write(filepath.Base(filename))
+	write(ctxt.GOOS)
+	write(ctxt.GOARCH)
+	write(ctxt.Compiler)
+	write(strconv.FormatBool(ctxt.CgoEnabled))
+	writeList(ctxt.BuildTags)
+	writeList(ctxt.ToolTags)
+	writeList(ctxt.ReleaseTags)
+	strategies := opts.strategies()
+	write(strconv.Itoa(len(strategies)))
+	for _, s := range strategies {
+		write(strconv.Itoa(int(s)))
+	}
+	if opts != nil {
+		writeList(opts.PreferredOS)
+		writeList(opts.PreferredArch)
+		write(strconv.Itoa(int(opts.Policy)))
+		writeList(opts.RequiredTags)
+		writeList(opts.ForbiddenTags)
+		for _, a := range [][]GoPlatform{opts.Targets, opts.Platforms} {
+			write(strconv.Itoa(len(a)))
+			for _, p := range a {
+				write(p.String())
+			}
+		}
+	}
+	h.Write(header)
+
+	var key matchCacheKey
+	h.Sum(key[:0])
+	return key
+}
@@ -0,0 +1,240 @@
+package buildutil
+
+import (
+	"go/build"
+	"path/filepath"
+	"strings"
+
+	"github.com/charlievieth/buildutil/internal/util"
+)
+
+// A ContextConflictError is returned by MatchContextFiles when no single
+// Context matches all of the files. It matches ErrMatchContext with errors.Is.
+type ContextConflictError struct {
+	Context   *build.Context // Context that matches all but the Conflicts
+	Conflicts []string       // files and directories not matched by Context
+}
+
+func (e *ContextConflictError) Error() string {
+	return "buildutil: " + e.Context.GOOS + "/" + e.Context.GOARCH +
+		" context does not match: " + strings.Join(e.Conflicts, ", ")
+}
+
+func (e *ContextConflictError) Is(target error) bool { return target == ErrMatchContext }
+
+// MatchContextFiles returns a single Context, derived from orig, that
+// matches all of paths. This is useful for editors that use one Context
+// for all of the open files.
+//
+// Each path may be a Go source file or a package directory, which matches
+// if any of its Go files match. Paths are matched in order, so if a path
+// cannot be matched without excluding an earlier path it is a conflict.
+// If there are conflicts a *ContextConflictError is returned, which holds
+// the Context that matches the rest of the paths.
+//
+// The opts are passed to MatchContextOptions and may be nil.
+func MatchContextFiles(orig *build.Context, paths []string, opts *MatchOptions) (*build.Context, error) {
+	if orig == nil {
+		orig = &build.Default
+	}
+	ctxt := CopyContext(orig)
+	var matched, conflicts []string
+	for _, path := range paths {
+		files, err := matchPathFiles(ctxt, path)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := matchesAnyFile(ctxt, files)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, path)
+			continue
+		}
+		if c := matchPathContext(ctxt, files, opts); c != nil {
+			if ok, err := matchesAllPaths(c, matched); err != nil {
+				return nil, err
+			} else if ok {
+				ctxt = c
+				matched = append(matched, path)
+				continue
+			}
+		}
+		conflicts = append(conflicts, path)
+	}
+	if len(conflicts) != 0 {
+		return nil, &ContextConflictError{Context: ctxt, Conflicts: conflicts}
+	}
+	return ctxt, nil
+}
+
+// matchPathFiles returns the Go files of path, which is either a file or
+// a directory.
+func matchPathFiles(ctxt *build.Context, path string) ([]string, error) {
+	if !isDir(ctxt, path) {
+		return []string{path}, nil
+	}
+	fis, err := readSourceDir(ctxt, path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, fi := range fis {
+		if !fi.IsDir() && filepath.Ext(fi.Name()) == ".go" {
+			files = append(files, joinPath(ctxt, path, fi.Name()))
+		}
+	}
+	return files, nil
+}
+
+// matchesAnyFile reports if any of files is included by ctxt.
+func matchesAnyFile(ctxt *build.Context, files []string) (bool, error) {
+	ev := newTagEvaluator(ctxt)
+	for _, file := range files {
+		dir, name := filepath.Split(file)
+		reason, _, err := classifyFile(ev, dir, name, true, nil)
+		if err != nil {
+			return false, err
+		}
+		if reason == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matchesAllPaths reports if all of paths are matched by ctxt.
+func matchesAllPaths(ctxt *build.Context, paths []string) (bool, error) {
+	for _, path := range paths {
+		files, err := matchPathFiles(ctxt, path)
+		if err != nil {
+			return false, err
+		}
+		if ok, err := matchesAnyFile(ctxt, files); !ok || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchPathContext returns the Context matched to the first of files that
+// can be matched, or nil if none can be.
+func matchPathContext(ctxt *build.Context, files []string, opts *MatchOptions) *build.Context {
+	for _, file := range files {
+		if c, err := MatchContextOptions(ctxt, file, nil, opts); err == nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// MatchPackageContext returns a single Context, derived from orig, under
+// which the Go files of the package directory dir build together. This is
+// useful for editors that use one Context per package instead of one per
+// file (see MatchContext).
+//
+// If targets is empty, the returned Context is the one that includes the
+// most files of dir, which is orig if no other Context includes more.
+// Otherwise, the Context must include all of targets, which are files of
+// dir, and then as many of the other files as possible. If targets cannot
+// be matched by a single Context a *ContextConflictError is returned (see
+// MatchContextFiles). A *build.NoGoError is returned if no Context includes
+// any of the files of dir.
+//
+// The Context is found greedily: starting from orig, and the Contexts
+// matched to each file, the files of dir are added in order if they can be
+// matched without excluding a file already included. Test files are
+// considered part of the package. The opts are passed to
+// MatchContextOptions and may be nil.
+func MatchPackageContext(orig *build.Context, dir string, targets []string, opts *MatchOptions) (*build.Context, error) {
+	if orig == nil {
+		orig = &build.Default
+	}
+	all, err := matchPathFiles(orig, dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, file := range all {
+		if name := filepath.Base(file); !strings.HasPrefix(name, "_") && !strings.HasPrefix(name, ".") {
+			files = append(files, file)
+		}
+	}
+
+	var required []string
+	seeds := []*build.Context{CopyContext(orig)}
+	if len(targets) != 0 {
+		for _, name := range targets {
+			if !isAbsPath(orig, name) {
+				name = joinPath(orig, dir, name)
+			}
+			required = append(required, name)
+		}
+		ctxt, err := MatchContextFiles(orig, required, opts)
+		if err != nil {
+			return nil, err
+		}
+		seeds[0] = ctxt
+	} else {
+		seen := map[matchCacheKey]bool{matchContextKey(orig, "", nil, nil): true}
+		for _, file := range files {
+			c, err := MatchContextOptions(orig, file, nil, opts)
+			if err != nil {
+				continue
+			}
+			if key := matchContextKey(c, "", nil, nil); !seen[key] {
+				seen[key] = true
+				seeds = append(seeds, c)
+			}
+		}
+	}
+
+	var best *build.Context
+	bestCount := 0
+	for _, seed := range seeds {
+		ctxt, matched, err := extendPackageContext(seed, files, required, opts)
+		if err != nil {
+			return nil, err
+		}
+		if best == nil || len(matched) > bestCount {
+			best, bestCount = ctxt, len(matched)
+		}
+	}
+	if bestCount == 0 {
+		return nil, &build.NoGoError{Dir: dir}
+	}
+	return best, nil
+}
+
+// extendPackageContext adds each of files, in order, to the files matched
+// by ctxt, which must include required, if the file is included by ctxt or
+// by a Context matched to the file that still includes the files already
+// matched. It returns the resulting Context and the matched files.
+func extendPackageContext(ctxt *build.Context, files, required []string, opts *MatchOptions) (*build.Context, []string, error) {
+	matched := append([]string(nil), required...)
+	for _, file := range files {
+		if util.StringsContains(required, file) {
+			continue
+		}
+		ok, err := matchesAnyFile(ctxt, []string{file})
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			matched = append(matched, file)
+			continue
+		}
+		c, err := MatchContextOptions(ctxt, file, nil, opts)
+		if err != nil {
+			continue
+		}
+		if ok, err := matchesAllPaths(c, matched); err != nil {
+			return nil, nil, err
+		} else if ok {
+			ctxt = c
+			matched = append(matched, file)
+		}
+	}
+	return ctxt, matched, nil
+}
@@ -0,0 +1,113 @@
+package buildutil
+
+import (
+	"errors"
+	"go/build"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/charlievieth/buildutil/buildutiltest"
+)
+
+func TestMatchContextFiles(t *testing.T) {
+	dir := t.TempDir()
+	buildutiltest.WriteFiles(t, dir, map[string]string{
+		"a_linux.go":          "package p\n",
+		"b.go":                "//go:build unix\n\npackage p\n",
+		"c.go":                "//go:build foo\n\npackage p\n",
+		"d_windows.go":        "package p\n",
+		"pkg/x_linux.go":      "package pkg\n",
+		"pkg/x_windows.go":    "package pkg\n",
+		"winpkg/w_windows.go": "package winpkg\n",
+	})
+	path := func(name string) string { return filepath.Join(dir, filepath.FromSlash(name)) }
+
+	orig := build.Default
+	orig.GOOS = "darwin"
+	orig.GOARCH = "amd64"
+	orig.BuildTags = nil
+
+	ctxt, err := MatchContextFiles(&orig, []string{path("a_linux.go"), path("b.go"), path("c.go"), path("pkg")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctxt.GOOS != "linux" || !reflect.DeepEqual(ctxt.BuildTags, []string{"foo"}) {
+		t.Errorf("MatchContextFiles: GOOS = %q BuildTags = %q; want: %q %q",
+			ctxt.GOOS, ctxt.BuildTags, "linux", []string{"foo"})
+	}
+
+	_, err = MatchContextFiles(&orig, []string{path("a_linux.go"), path("d_windows.go"), path("pkg"), path("winpkg")}, nil)
+	var cerr *ContextConflictError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("MatchContextFiles: error = %v; want: %T", err, cerr)
+	}
+	want := []string{path("d_windows.go"), path("winpkg")}
+	if !reflect.DeepEqual(cerr.Conflicts, want) {
+		t.Errorf("Conflicts = %q; want: %q", cerr.Conflicts, want)
+	}
+	if cerr.Context.GOOS != "linux" {
+		t.Errorf("Context.GOOS = %q; want: %q", cerr.Context.GOOS, "linux")
+	}
+	if !errors.Is(err, ErrMatchContext) {
+		t.Errorf("errors.Is(%v, ErrMatchContext) = false; want: true", err)
+	}
+}
+
+func TestMatchPackageContext(t *testing.T) {
+	dir := t.TempDir()
+	buildutiltest.WriteFiles(t, dir, map[string]string{
+		"a.go":              "package p\n",
+		"b_linux.go":        "package p\n",
+		"c_linux.go":        "//go:build foo\n\npackage p\n",
+		"d_linux_test.go":   "package p\n",
+		"e_windows.go":      "package p\n",
+		"_ignored_linux.go": "package p\n",
+		"empty/README":      "",
+		"never/x.go":        "//go:build linux && !linux\n\npackage x\n",
+	})
+	path := func(name string) string { return filepath.Join(dir, filepath.FromSlash(name)) }
+
+	orig := build.Default
+	orig.GOOS = "darwin"
+	orig.GOARCH = "amd64"
+	orig.BuildTags = nil
+
+	ctxt, err := MatchPackageContext(&orig, dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctxt.GOOS != "linux" || !reflect.DeepEqual(ctxt.BuildTags, []string{"foo"}) {
+		t.Errorf("MatchPackageContext: GOOS = %q BuildTags = %q; want: %q %q",
+			ctxt.GOOS, ctxt.BuildTags, "linux", []string{"foo"})
+	}
+	if orig.GOOS != "darwin" || orig.BuildTags != nil {
+		t.Errorf("MatchPackageContext: modified orig: GOOS = %q BuildTags = %q", orig.GOOS, orig.BuildTags)
+	}
+
+	// The targets must be included even though fewer files match
+	ctxt, err = MatchPackageContext(&orig, dir, []string{"e_windows.go"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctxt.GOOS != "windows" {
+		t.Errorf("MatchPackageContext(%q): GOOS = %q; want: %q", "e_windows.go", ctxt.GOOS, "windows")
+	}
+
+	_, err = MatchPackageContext(&orig, dir, []string{"b_linux.go", path("e_windows.go")}, nil)
+	var cerr *ContextConflictError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("MatchPackageContext: error = %v; want: %T", err, cerr)
+	}
+	if want := []string{path("e_windows.go")}; !reflect.DeepEqual(cerr.Conflicts, want) {
+		t.Errorf("Conflicts = %q; want: %q", cerr.Conflicts, want)
+	}
+
+	for _, name := range []string{"empty", "never"} {
+		_, err = MatchPackageContext(&orig, path(name), nil, nil)
+		var nerr *build.NoGoError
+		if !errors.As(err, &nerr) {
+			t.Errorf("MatchPackageContext(%q): error = %v; want: %T", name, err, nerr)
+		}
+	}
+}
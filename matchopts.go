@@ -0,0 +1,394 @@
+package buildutil
+
+import (
+	"go/build"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charlievieth/buildutil/internal/util"
+)
+
+// A PlatformPolicy controls which platforms MatchContextOptions considers,
+// and in what order, when it has to change the GOOS or GOARCH of a Context.
+type PlatformPolicy int
+
+const (
+	// PolicyDefault tries the preferred OSes and arches first and then
+	// falls back to all supported platforms.
+	PolicyDefault PlatformPolicy = iota
+
+	// PolicyFirstClassOnly only considers first-class ports
+	// (see GoPlatform.FirstClass) when searching for a matching platform.
+	// A GOOS or GOARCH required by the filename is always respected.
+	PolicyFirstClassOnly
+
+	// PolicySameFamily prefers platforms in the same family as the original
+	// Context (e.g. another unix OS for linux or arm for arm64) before
+	// trying other platforms.
+	PolicySameFamily
+)
+
+// A MatchStrategy is a way in which MatchContextOptions may change a Context
+// so that it matches a file. The strategies of MatchOptions are tried in
+// order, so earlier strategies are preferred.
+type MatchStrategy int
+
+const (
+	// StrategyBuildTags adds or removes user defined build tags, and the
+	// sanitizer tags (e.g. "race"), that are not RequiredTags.
+	StrategyBuildTags MatchStrategy = iota
+
+	// StrategyCgo enables or disables cgo, if the platform supports it.
+	StrategyCgo
+
+	// StrategyPlatform changes the GOOS and/or GOARCH.
+	StrategyPlatform
+
+	// StrategyGoVersion changes the ReleaseTags to those of the nearest Go
+	// version that satisfies the release tags of the file (e.g. "go1.21"),
+	// which assumes that a newer (or older) Go toolchain is used. It is not
+	// one of the DefaultMatchStrategies.
+	StrategyGoVersion
+)
+
+var matchStrategyNames = [...]string{
+	StrategyBuildTags: "build-tags",
+	StrategyCgo:       "cgo",
+	StrategyPlatform:  "platform",
+	StrategyGoVersion: "go-version",
+}
+
+func (s MatchStrategy) String() string {
+	if 0 <= s && int(s) < len(matchStrategyNames) {
+		return matchStrategyNames[s]
+	}
+	return "MatchStrategy(" + strconv.Itoa(int(s)) + ")"
+}
+
+// DefaultMatchStrategies are the strategies used when the Strategies of
+// MatchOptions is nil. They never change the ReleaseTags or Compiler of the
+// Context, so a file that requires a different Go version or compiler is an
+// error. Adding or removing a build tag is preferred to toggling cgo, which
+// is preferred to changing the platform.
+var DefaultMatchStrategies = []MatchStrategy{
+	StrategyBuildTags,
+	StrategyCgo,
+	StrategyPlatform,
+}
+
+// MatchOptions configures how MatchContextOptions searches for a Context
+// that matches a file.
+//
+// The PreferredOS and PreferredArch lists may contain patterns, using the
+// syntax of path.Match, such as "*bsd" or "mips*". Any OS or Arch not
+// matched by the list is tried after the matched values in the order of
+// the default preferences.
+//
+// RequiredTags are build tags that any matched Context must also satisfy,
+// such as tags already required by the other files in the package
+// (e.g. "integration"). They are added to the BuildTags of the Context and
+// are never removed when searching for a match. A negated tag ("!tag") is
+// removed from the BuildTags and is never added.
+//
+// ForbiddenTags are build tags that are never set, such as tags that the
+// policy of a project forbids building with (e.g. "purego"). They are
+// removed from the BuildTags of the Context and a file whose build
+// constraint cannot be satisfied without one of them is a permanent
+// ErrForbiddenTag error, instead of being matched by setting the tag.
+//
+// Targets are hints of the platforms the caller actually builds for, such as
+// those parsed from a CI configuration or Dockerfile. They are tried, in
+// order, before the preferred lists. Only the GOOS and GOARCH of each target
+// are used and either may be empty, in which case the target only hints at
+// an OS or Arch. Targets excluded by the Policy are ignored.
+//
+// Strategies are the ways, in order of preference, that the Context may be
+// changed to match a file. If nil, DefaultMatchStrategies is used. For
+// example, to also allow assuming a different Go version, but only if no
+// build tag satisfies the file:
+//
+//	Strategies: []MatchStrategy{StrategyBuildTags, StrategyGoVersion,
+//		StrategyCgo, StrategyPlatform}
+//
+// Platforms, if not nil, are the only platforms that may be used when the
+// GOOS or GOARCH is changed, such as the platforms supported by a specific
+// version of Go (see GoVersionPlatforms). They replace the default list of
+// platforms, but are still ordered by the preferences and limited by the
+// Policy.
+//
+// NoGOROOT is for environments without a GOROOT, such as containers that
+// only have source trees. The GOROOT of the Context is cleared, instead of
+// defaulting to runtime.GOROOT() (which may not exist), and steps that
+// require the GOROOT are skipped. See also the GOROOT-less mode of the
+// contextutil package, which is used when the GOROOT of a Context is empty.
+//
+// Project, if not nil, is the ProjectConfig of the project containing the
+// file (see FindProjectConfig). Its BuildTags are added to the Context, its
+// ForbiddenTags are added to ForbiddenTags and its preferences are used for
+// any preference that is neither specified by the MatchOptions nor set with
+// SetPreferredOSList or SetPreferredArchList.
+//
+// The Compiler is never changed.
+type MatchOptions struct {
+	PreferredOS   []string
+	PreferredArch []string
+	Policy        PlatformPolicy
+	RequiredTags  []string
+	ForbiddenTags []string
+	Targets       []GoPlatform
+	Strategies    []MatchStrategy
+	Platforms     []GoPlatform
+	NoGOROOT      bool
+	Project       *ProjectConfig
+}
+
+// strategies returns the Strategies of opts, which may be nil, or the
+// DefaultMatchStrategies.
+func (opts *MatchOptions) strategies() []MatchStrategy {
+	if opts == nil || opts.Strategies == nil {
+		return DefaultMatchStrategies
+	}
+	return opts.Strategies
+}
+
+// matchPrefs are the preferences of a single call to MatchContextOptions.
+type matchPrefs struct {
+	osList       []string
+	archList     []string
+	platforms    []GoPlatform
+	firstClass   bool
+	supported    map[string]bool // "GOOS/GOARCH" of the allowed platforms, nil if all
+	requiredTags []string
+	forbidden    []string
+	strategies   []MatchStrategy
+}
+
+// required reports if the build tag, or its negation, is required or if
+// the tag is forbidden.
+func (p *matchPrefs) required(tag string) bool {
+	for _, s := range p.requiredTags {
+		if strings.TrimPrefix(s, "!") == tag {
+			return true
+		}
+	}
+	return util.StringsContains(p.forbidden, tag)
+}
+
+// changeVersion reports if the Go version of the Context may be changed.
+func (p *matchPrefs) changeVersion() bool {
+	return hasStrategy(p.strategies, StrategyGoVersion)
+}
+
+func hasStrategy(strategies []MatchStrategy, s MatchStrategy) bool {
+	for _, x := range strategies {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports if the platform goos/goarch may be used.
+func (p *matchPrefs) allowed(goos, goarch string) bool {
+	if p.supported != nil && !p.supported[goos+"/"+goarch] {
+		return false
+	}
+	return !p.firstClass || isFirstClassPlatform(goos, goarch)
+}
+
+func newMatchPrefs(ctxt *build.Context, opts *MatchOptions) *matchPrefs {
+	// The global lists are read on each call for compatibility
+	// with callers that modify them.
+	if opts == nil {
+		preferredMu.RLock()
+		p := &matchPrefs{
+			osList:     PreferredOSList,
+			archList:   PreferredArchList,
+			platforms:  knownPlatforms,
+			strategies: DefaultMatchStrategies,
+		}
+		preferredMu.RUnlock()
+		return p
+	}
+	p := &matchPrefs{
+		osList:       expandPreferredList(opts.PreferredOS, defaultPreferredOSList),
+		archList:     expandPreferredList(opts.PreferredArch, defaultPreferredArchList),
+		requiredTags: opts.RequiredTags,
+		forbidden:    opts.ForbiddenTags,
+		strategies:   opts.strategies(),
+	}
+	all := knownPlatforms
+	if opts.Platforms != nil {
+		all = opts.Platforms
+		p.supported = make(map[string]bool, len(all))
+		for _, pp := range all {
+			p.supported[pp.String()] = true
+		}
+	}
+	platforms := make([]GoPlatform, 0, len(all))
+	for _, pp := range all {
+		if opts.Policy != PolicyFirstClassOnly || pp.FirstClass {
+			platforms = append(platforms, pp)
+		}
+	}
+	switch opts.Policy {
+	case PolicyFirstClassOnly:
+		p.firstClass = true
+		p.osList = filterList(p.osList, func(s string) bool {
+			for _, pp := range platforms {
+				if pp.GOOS == s {
+					return true
+				}
+			}
+			return false
+		})
+		p.archList = filterList(p.archList, func(s string) bool {
+			for _, pp := range platforms {
+				if pp.GOARCH == s {
+					return true
+				}
+			}
+			return false
+		})
+	case PolicySameFamily:
+		osFamily := isUnixOS(ctxt.GOOS)
+		archFamily := archFamilyOf(ctxt.GOARCH)
+		sort.SliceStable(p.osList, func(i, j int) bool {
+			return isUnixOS(p.osList[i]) == osFamily && isUnixOS(p.osList[j]) != osFamily
+		})
+		sort.SliceStable(p.archList, func(i, j int) bool {
+			return archFamilyOf(p.archList[i]) == archFamily &&
+				archFamilyOf(p.archList[j]) != archFamily
+		})
+		sort.SliceStable(platforms, func(i, j int) bool {
+			return platformFamilyScore(&platforms[i], osFamily, archFamily) >
+				platformFamilyScore(&platforms[j], osFamily, archFamily)
+		})
+	}
+	p.platforms = platforms
+	if len(opts.Targets) != 0 {
+		p.applyTargets(opts.Targets)
+	}
+	return p
+}
+
+// applyTargets moves the target platforms to the front of the preferences.
+func (p *matchPrefs) applyTargets(targets []GoPlatform) {
+	var oses, arches []string
+	for _, t := range targets {
+		if t.GOOS != "" {
+			oses = append(oses, t.GOOS)
+		}
+		if t.GOARCH != "" {
+			arches = append(arches, t.GOARCH)
+		}
+	}
+	p.osList = expandPreferredList(oses, p.osList)
+	p.archList = expandPreferredList(arches, p.archList)
+
+	rank := func(pp *GoPlatform) int {
+		for i, t := range targets {
+			if (t.GOOS == "" || t.GOOS == pp.GOOS) && (t.GOARCH == "" || t.GOARCH == pp.GOARCH) {
+				return i
+			}
+		}
+		return len(targets)
+	}
+	// Copy since p.platforms may be knownPlatforms
+	platforms := make([]GoPlatform, len(p.platforms))
+	copy(platforms, p.platforms)
+	sort.SliceStable(platforms, func(i, j int) bool {
+		return rank(&platforms[i]) < rank(&platforms[j])
+	})
+	p.platforms = platforms
+}
+
+// expandPreferredList returns all the values in list ordered by the patterns.
+// Values not matched by any pattern are appended in their original order.
+func expandPreferredList(patterns, list []string) []string {
+	seen := make(map[string]bool, len(list))
+	a := make([]string, 0, len(list))
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, `*?[\`) {
+			if !seen[pattern] && util.StringsContains(list, pattern) {
+				seen[pattern] = true
+				a = append(a, pattern)
+			}
+			continue
+		}
+		for _, s := range list {
+			if seen[s] {
+				continue
+			}
+			// Invalid patterns are ignored
+			if ok, _ := path.Match(pattern, s); ok {
+				seen[s] = true
+				a = append(a, s)
+			}
+		}
+	}
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			a = append(a, s)
+		}
+	}
+	return a
+}
+
+func filterList(a []string, keep func(s string) bool) []string {
+	var b []string
+	for _, s := range a {
+		if keep(s) {
+			b = append(b, s)
+		}
+	}
+	return b
+}
+
+func isFirstClassPlatform(goos, goarch string) bool {
+	for _, p := range knownPlatforms {
+		if p.GOOS == goos && p.GOARCH == goarch {
+			return p.FirstClass
+		}
+	}
+	return false
+}
+
+func isUnixOS(goos string) bool { return unixOS[goos] }
+
+// archFamilyOf returns the family of GOARCH arch.
+func archFamilyOf(arch string) string {
+	switch arch {
+	case "386", "amd64", "amd64p32":
+		return "x86"
+	case "arm", "armbe", "arm64", "arm64be":
+		return "arm"
+	case "ppc", "ppc64", "ppc64le":
+		return "ppc"
+	case "riscv", "riscv64":
+		return "riscv"
+	case "s390", "s390x":
+		return "s390"
+	}
+	if strings.HasPrefix(arch, "mips") {
+		return "mips"
+	}
+	if strings.HasPrefix(arch, "sparc") {
+		return "sparc"
+	}
+	return arch
+}
+
+func platformFamilyScore(p *GoPlatform, unix bool, archFamily string) int {
+	n := 0
+	if isUnixOS(p.GOOS) == unix {
+		n++
+	}
+	if archFamilyOf(p.GOARCH) == archFamily {
+		n++
+	}
+	return n
+}
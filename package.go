@@ -0,0 +1,236 @@
+package buildutil
+
+import (
+	"errors"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A Package is a trimmed down build.Package with the information needed by
+// most editor features. It is returned by LoadDirPackage.
+type Package struct {
+	Dir            string   // directory containing the package sources
+	Name           string   // package name
+	ImportPath     string   // import path of the package ("." if unknown)
+	GoFiles        []string // .go source files (excluding TestGoFiles, XTestGoFiles, IgnoredGoFiles)
+	TestGoFiles    []string // _test.go files in the package
+	XTestGoFiles   []string // _test.go files outside the package
+	IgnoredGoFiles []string // .go source files ignored for this build
+	Imports        []string // sorted import paths of GoFiles
+	EmbedPatterns  []string // sorted //go:embed patterns of GoFiles
+}
+
+// LoadDirPackage returns the Package in directory dir for the build.Context
+// ctxt. It is a faster alternative to build.ImportDir for tools that do not
+// need all of the information in a build.Package: only the header of each
+// Go file is read, unless it imports "embed", and the Context's ReadDir,
+// OpenFile and JoinPath functions, if set, are used.
+//
+// Files are classified the same as BuildableFiles. Unlike build.ImportDir,
+// files that import "C" are included in GoFiles if cgo is enabled and files
+// that cannot be read or parsed are ignored. The ImportPath is found using
+// the GOPATH and GOROOT of ctxt (see ImportPath) or, if dir is not in either,
+// the go.mod file of the module containing dir.
+//
+// If dir contains no buildable Go files the Package is returned with a
+// *build.NoGoError. If the files are from more than one package the Package
+// is returned with a *build.MultiplePackageError.
+func LoadDirPackage(ctxt *build.Context, dir string) (*Package, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	fis, err := readSourceDir(ctxt, dir)
+	if err != nil {
+		return nil, err
+	}
+	p := &Package{Dir: dir}
+	if p.ImportPath, err = dirImportPath(ctxt, dir); err != nil {
+		return nil, err
+	}
+
+	var badGoFile bool
+	var multiErr build.MultiplePackageError
+	var firstFile string
+	imports := make(map[string]bool)
+	embeds := make(map[string]bool)
+	ev := newTagEvaluator(ctxt)
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		reason, header, err := classifyFile(ev, dir, name, true, nil)
+		if reason == ExcludeIgnored {
+			continue
+		}
+		if reason != 0 || err != nil {
+			p.IgnoredGoFiles = append(p.IgnoredGoFiles, name)
+			badGoFile = badGoFile || err != nil
+			continue
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), name, header, parser.ImportsOnly)
+		if err != nil {
+			p.IgnoredGoFiles = append(p.IgnoredGoFiles, name)
+			badGoFile = true
+			continue
+		}
+		pkg := f.Name.Name
+		if pkg == "documentation" {
+			p.IgnoredGoFiles = append(p.IgnoredGoFiles, name)
+			continue
+		}
+		isTest := strings.HasSuffix(name, "_test.go")
+		isXTest := false
+		if isTest && strings.HasSuffix(pkg, "_test") && p.Name != pkg {
+			isXTest = true
+			pkg = pkg[:len(pkg)-len("_test")]
+		}
+		if p.Name == "" {
+			p.Name = pkg
+			firstFile = name
+		} else if pkg != p.Name {
+			if len(multiErr.Packages) == 0 {
+				multiErr = build.MultiplePackageError{
+					Dir:      dir,
+					Packages: []string{p.Name},
+					Files:    []string{firstFile},
+				}
+			}
+			multiErr.Packages = append(multiErr.Packages, pkg)
+			multiErr.Files = append(multiErr.Files, name)
+		}
+
+		switch {
+		case isXTest:
+			p.XTestGoFiles = append(p.XTestGoFiles, name)
+		case isTest:
+			p.TestGoFiles = append(p.TestGoFiles, name)
+		default:
+			p.GoFiles = append(p.GoFiles, name)
+			importsEmbed := false
+			for _, spec := range f.Imports {
+				if imp, err := strconv.Unquote(spec.Path.Value); err == nil {
+					imports[imp] = true
+					importsEmbed = importsEmbed || imp == "embed"
+				}
+			}
+			if importsEmbed {
+				// Embed patterns are only valid after the imports, so the
+				// whole file must be read.
+				if err := readEmbedPatterns(ctxt, dir, name, embeds); err != nil {
+					badGoFile = true
+				}
+			}
+		}
+	}
+	if len(multiErr.Packages) != 0 {
+		return p, &multiErr
+	}
+	if len(imports) != 0 {
+		p.Imports = mapKeys(imports)
+	}
+	if len(embeds) != 0 {
+		p.EmbedPatterns = mapKeys(embeds)
+	}
+	if len(p.GoFiles)+len(p.TestGoFiles)+len(p.XTestGoFiles) == 0 && !badGoFile {
+		return p, &build.NoGoError{Dir: dir}
+	}
+	return p, nil
+}
+
+// dirImportPath returns the import path of dir using the GOPATH and GOROOT
+// of ctxt or the go.mod file of the module containing dir.
+func dirImportPath(ctxt *build.Context, dir string) (string, error) {
+	importPath, err := ImportPath(ctxt, dir)
+	if err != nil || importPath != "." {
+		return importPath, err
+	}
+	for root, rel := dir, ""; ; {
+		if fileExists(ctxt, joinPath(ctxt, root, "go.mod")) {
+			modPath, err := ModulePath(ctxt, root)
+			if err != nil || modPath == "" {
+				return ".", nil
+			}
+			return path.Join(modPath, rel), nil
+		}
+		parent := filepath.Dir(root)
+		if parent == root {
+			return ".", nil
+		}
+		rel = path.Join(filepath.Base(root), rel)
+		root = parent
+	}
+}
+
+// readEmbedPatterns adds the patterns of the //go:embed directives of the Go
+// file name to embeds.
+func readEmbedPatterns(ctxt *build.Context, dir, name string, embeds map[string]bool) error {
+	rc, err := openReaderDirName(ctxt, dir, name, nil)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), name, data, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	for _, g := range f.Comments {
+		for _, c := range g.List {
+			args := strings.TrimPrefix(c.Text, "//go:embed")
+			if args == c.Text || args == "" || (args[0] != ' ' && args[0] != '\t') {
+				continue
+			}
+			patterns, err := parseGoEmbed(args)
+			if err != nil {
+				return err
+			}
+			for _, pattern := range patterns {
+				embeds[pattern] = true
+			}
+		}
+	}
+	return nil
+}
+
+var errInvalidEmbed = errors.New("invalid quoted string in //go:embed")
+
+// parseGoEmbed parses the space separated, and optionally quoted, patterns
+// of a //go:embed directive.
+func parseGoEmbed(args string) ([]string, error) {
+	var patterns []string
+	for args = strings.TrimLeft(args, " \t"); args != ""; args = strings.TrimLeft(args, " \t") {
+		var pattern string
+		switch args[0] {
+		case '"', '`':
+			quoted, err := strconv.QuotedPrefix(args)
+			if err != nil {
+				return nil, errInvalidEmbed
+			}
+			if pattern, err = strconv.Unquote(quoted); err != nil {
+				return nil, errInvalidEmbed
+			}
+			args = args[len(quoted):]
+			if args != "" && args[0] != ' ' && args[0] != '\t' {
+				return nil, errInvalidEmbed
+			}
+		default:
+			i := strings.IndexAny(args, " \t")
+			if i == -1 {
+				i = len(args)
+			}
+			pattern, args = args[:i], args[i:]
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
@@ -0,0 +1,129 @@
+package buildutil
+
+import (
+	"errors"
+	"go/build"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/charlievieth/buildutil/buildutiltest"
+)
+
+func TestLoadDirPackage(t *testing.T) {
+	gopath := buildutiltest.NewGOPATH(t, map[string]string{
+		"example.com/p/p.go":         "package p\n\nimport (\n\t\"fmt\"\n\t_ \"embed\"\n)\n\n//go:embed a.txt \"b c.txt\"\nvar s string\n\n//go:embed `d/*`\nvar t string\n",
+		"example.com/p/p_linux.go":   "package p\n\nimport \"os\"\n",
+		"example.com/p/p_windows.go": "package p\n\nimport \"syscall\"\n",
+		"example.com/p/tag.go":       "//go:build foo\n\npackage p\n\nimport \"strings\"\n",
+		"example.com/p/cgo.go":       "package p\n\nimport \"C\"\n",
+		"example.com/p/doc.go":       "package documentation\n",
+		"example.com/p/_ignored.go":  "package p\n",
+		"example.com/p/p_test.go":    "package p\n\nimport \"testing\"\n",
+		"example.com/p/x_test.go":    "package p_test\n\nimport \"testing\"\n",
+		"example.com/p/a.txt":        "a\n",
+		"example.com/m/m1.go":        "package m1\n",
+		"example.com/m/m2.go":        "package m2\n",
+		"example.com/e/README":       "readme\n",
+	})
+	ctxt := buildutiltest.GOPATHContext(nil, gopath)
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "amd64"
+	ctxt.CgoEnabled = false
+
+	dir := filepath.Join(gopath, "src", "example.com", "p")
+	p, err := LoadDirPackage(ctxt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Package{
+		Dir:            dir,
+		Name:           "p",
+		ImportPath:     "example.com/p",
+		GoFiles:        []string{"p.go", "p_linux.go"},
+		TestGoFiles:    []string{"p_test.go"},
+		XTestGoFiles:   []string{"x_test.go"},
+		IgnoredGoFiles: []string{"cgo.go", "doc.go", "p_windows.go", "tag.go"},
+		Imports:        []string{"embed", "fmt", "os"},
+		EmbedPatterns:  []string{"a.txt", "b c.txt", "d/*"},
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("LoadDirPackage() = %+v; want: %+v", p, want)
+	}
+
+	// Compare with go/build
+	bp, err := ctxt.ImportDir(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.GoFiles, bp.GoFiles) || !reflect.DeepEqual(p.Imports, bp.Imports) ||
+		!reflect.DeepEqual(p.EmbedPatterns, bp.EmbedPatterns) ||
+		!reflect.DeepEqual(p.IgnoredGoFiles, bp.IgnoredGoFiles) {
+		t.Errorf("LoadDirPackage() = %+v; build.ImportDir: %+v", p, bp)
+	}
+
+	// Cgo files are included in GoFiles
+	cgoCtxt := *ctxt
+	cgoCtxt.CgoEnabled = true
+	p, err = LoadDirPackage(&cgoCtxt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"cgo.go", "p.go", "p_linux.go"}; !reflect.DeepEqual(p.GoFiles, want) {
+		t.Errorf("LoadDirPackage(cgo) GoFiles = %q; want: %q", p.GoFiles, want)
+	}
+
+	_, err = LoadDirPackage(ctxt, filepath.Join(gopath, "src", "example.com", "m"))
+	var multiErr *build.MultiplePackageError
+	if !errors.As(err, &multiErr) || !reflect.DeepEqual(multiErr.Packages, []string{"m1", "m2"}) {
+		t.Errorf("LoadDirPackage() error = %v; want: %T", err, multiErr)
+	}
+
+	_, err = LoadDirPackage(ctxt, filepath.Join(gopath, "src", "example.com", "e"))
+	var noGoErr *build.NoGoError
+	if !errors.As(err, &noGoErr) {
+		t.Errorf("LoadDirPackage() error = %v; want: %T", err, noGoErr)
+	}
+}
+
+func TestLoadDirPackageModule(t *testing.T) {
+	root := buildutiltest.NewModule(t, "example.com/mod", map[string]string{
+		"mod.go":     "package mod\n",
+		"sub/sub.go": "package sub\n",
+	})
+	for dir, want := range map[string]string{
+		root:                       "example.com/mod",
+		filepath.Join(root, "sub"): "example.com/mod/sub",
+	} {
+		p, err := LoadDirPackage(buildutiltest.GOPATHContext(nil, t.TempDir()), dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.ImportPath != want {
+			t.Errorf("LoadDirPackage(%q).ImportPath = %q; want: %q", dir, p.ImportPath, want)
+		}
+	}
+}
+
+func TestParseGoEmbed(t *testing.T) {
+	tests := []struct {
+		args string
+		want []string
+		err  bool
+	}{
+		{" a.txt", []string{"a.txt"}, false},
+		{"\ta.txt  b/*.txt ", []string{"a.txt", "b/*.txt"}, false},
+		{` "a b.txt" ` + "`c d.txt`", []string{"a b.txt", "c d.txt"}, false},
+		{` "a.txt`, nil, true},
+		{` "a.txt"b`, nil, true},
+	}
+	for _, x := range tests {
+		got, err := parseGoEmbed(x.args)
+		if (err != nil) != x.err {
+			t.Errorf("parseGoEmbed(%q) error = %v; want error: %t", x.args, err, x.err)
+		}
+		if !reflect.DeepEqual(got, x.want) {
+			t.Errorf("parseGoEmbed(%q) = %q; want: %q", x.args, got, x.want)
+		}
+	}
+}
@@ -4,8 +4,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 //go:generate go run -tags gen_platform_list genplatforms.go
@@ -19,6 +27,176 @@ type GoPlatform struct {
 	FirstClass   bool   `json:"FirstClass"`
 }
 
+// String returns the platform formatted as "$GOOS/$GOARCH".
+func (p GoPlatform) String() string { return p.GOOS + "/" + p.GOARCH }
+
+// additionalPlatforms are platforms supported by newer versions of Go that
+// may be missing from the generated DefaultGoPlatforms.
+var additionalPlatforms = []GoPlatform{
+	{"wasip1", "wasm", false, false}, // go1.21
+}
+
+// platformVersions are the minor versions of the Go 1 releases that added,
+// or removed, the knownPlatforms that are not supported by every release
+// since go1.17 (see GoVersionPlatforms). Zero means never.
+var platformVersions = map[string]struct{ added, removed int }{
+	"linux/loong64": {added: 19},
+	"wasip1/wasm":   {added: 21},
+	"windows/arm":   {removed: 26},
+}
+
+// knownPlatforms is DefaultGoPlatforms plus any missing additionalPlatforms,
+// which are also added to the supported platform maps.
+var knownPlatforms = func() []GoPlatform {
+	a := DefaultGoPlatforms[:len(DefaultGoPlatforms):len(DefaultGoPlatforms)]
+	for _, p := range additionalPlatforms {
+		if supportedPlatformsOsArch[p.GOOS][p.GOARCH] {
+			continue
+		}
+		a = append(a, p)
+		if supportedPlatformsOsArch[p.GOOS] == nil {
+			supportedPlatformsOsArch[p.GOOS] = make(map[string]bool)
+		}
+		supportedPlatformsOsArch[p.GOOS][p.GOARCH] = true
+		if supportedPlatformsArchOs[p.GOARCH] == nil {
+			supportedPlatformsArchOs[p.GOARCH] = make(map[string]bool)
+		}
+		supportedPlatformsArchOs[p.GOARCH][p.GOOS] = true
+	}
+	return a
+}()
+
+// defaultArchFeatureTags are the architecture feature tool tags (e.g.
+// "amd64.v1") set by the go command for each GOARCH when the GOAMD64, GOARM,
+// etc. environment variables are not set.
+var defaultArchFeatureTags = map[string][]string{
+	"386":      {"386.sse2"},
+	"amd64":    {"amd64.v1"},
+	"arm":      {"arm.5", "arm.6", "arm.7"},
+	"arm64":    {"arm64.v8.0"},
+	"mips":     {"mips.hardfloat"},
+	"mipsle":   {"mipsle.hardfloat"},
+	"mips64":   {"mips64.hardfloat"},
+	"mips64le": {"mips64le.hardfloat"},
+	"ppc64":    {"ppc64.power8"},
+	"ppc64le":  {"ppc64le.power8"},
+	"riscv64":  {"riscv64.rva20u64"},
+	"wasm":     {"wasm.satconv", "wasm.signext"},
+}
+
+// ContextFor returns a copy of build.Default for the platform goos/goarch.
+// Cgo is enabled if cgo is true and the platform supports cgo. If goarch is
+// not the GOARCH of build.Default its architecture feature tool tags (e.g.
+// "amd64.v1") are replaced with the default ones of goarch.
+//
+// The returned Context is safe to modify.
+func ContextFor(goos, goarch string, cgo bool) *build.Context {
+	return contextFor(&build.Default, goos, goarch, cgo)
+}
+
+func contextFor(base *build.Context, goos, goarch string, cgo bool) *build.Context {
+	ctxt := CopyContext(base)
+	if goarch != ctxt.GOARCH {
+		tags := make([]string, 0, len(ctxt.ToolTags)+len(defaultArchFeatureTags[goarch]))
+		for _, tag := range ctxt.ToolTags {
+			if !isArchFeatureTag(tag) {
+				tags = append(tags, tag)
+			}
+		}
+		ctxt.ToolTags = append(tags, defaultArchFeatureTags[goarch]...)
+	}
+	ctxt.GOOS = goos
+	ctxt.GOARCH = goarch
+	ctxt.CgoEnabled = cgo && cgoEnabled[goos+"/"+goarch]
+	return ctxt
+}
+
+// ContextForPlatform returns a copy of build.Default for platform p with
+// cgo enabled if it is supported by p.
+func ContextForPlatform(p GoPlatform) *build.Context {
+	return ContextFor(p.GOOS, p.GOARCH, p.CgoSupported)
+}
+
+// A PlatformError records an error returned by the ForEachPlatform callback
+// for a platform.
+type PlatformError struct {
+	Platform GoPlatform
+	Err      error
+}
+
+func (e *PlatformError) Error() string { return e.Platform.String() + ": " + e.Err.Error() }
+
+func (e *PlatformError) Unwrap() error { return e.Err }
+
+// PlatformErrors is a list of *PlatformError ordered by platform.
+type PlatformErrors []*PlatformError
+
+// Error returns the first error and the number of remaining errors, if any.
+func (p PlatformErrors) Error() string {
+	switch len(p) {
+	case 0:
+		return "no errors"
+	case 1:
+		return p[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", p[0], len(p)-1)
+}
+
+// ForEachPlatform calls fn, in parallel, with a copy of Context base (or
+// build.Default if nil) for each of the platforms. Cgo is enabled for a
+// platform if it is enabled by base and supported by the platform. Each call
+// to fn receives its own Context which it may modify.
+//
+// If platforms is nil DefaultGoPlatforms is used. It is a table generated
+// from `go tool dist list` by go:generate, so the go command is not run, but
+// platforms added by newer releases of Go may be missing. Use LoadGoPlatforms for the platforms
+// of the installed go command, or GoVersionPlatforms for those of a specific
+// version of Go. If platforms is empty, but not nil, fn is not called.
+//
+// All platforms are visited and any errors returned by fn are returned as
+// PlatformErrors in the order of platforms.
+func ForEachPlatform(base *build.Context, platforms []GoPlatform, fn func(*build.Context) error) error {
+	if base == nil {
+		base = &build.Default
+	}
+	if platforms == nil {
+		platforms = DefaultGoPlatforms
+	}
+	numWorkers := runtime.GOMAXPROCS(0)
+	if numWorkers > len(platforms) {
+		numWorkers = len(platforms)
+	}
+	errs := make([]error, len(platforms))
+	ch := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				p := platforms[i]
+				errs[i] = fn(contextFor(base, p.GOOS, p.GOARCH, base.CgoEnabled && p.CgoSupported))
+			}
+		}()
+	}
+	for i := range platforms {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+
+	var perrs PlatformErrors
+	for i, err := range errs {
+		if err != nil {
+			perrs = append(perrs, &PlatformError{Platform: platforms[i], Err: err})
+		}
+	}
+	if len(perrs) != 0 {
+		return perrs
+	}
+	return nil
+}
+
 // LoadGoPlatforms loads the supported platforms supported by the
 // go executable found on the PATH.
 func LoadGoPlatforms() ([]GoPlatform, error) {
@@ -41,3 +219,179 @@ func LoadGoPlatforms() ([]GoPlatform, error) {
 	}
 	return ps, err
 }
+
+// MatchFileAllPlatforms reports, for each of the DefaultGoPlatforms, whether
+// the file with the given name would be included in a build for the platform.
+// The file's header is only read and parsed once and the build constraints
+// are then evaluated against each platform.
+//
+// The build.Context ctxt (or build.Default, if nil) is used to read the file
+// and provides the build, tool and release tags used when evaluating the
+// build constraints. Cgo is considered enabled for a platform if it is
+// enabled by ctxt and supported by the platform.
+//
+// If src is not nil it will be used as the content of the file.
+func MatchFileAllPlatforms(ctxt *build.Context, dir, name string, src interface{}) (map[GoPlatform]bool, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	rc, err := openReaderDirName(ctxt, dir, name, src)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readImportsFast(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	expr, err := parseBuildConstraint(data)
+	if err != nil {
+		return nil, err
+	}
+
+	base := filepath.Base(name)
+	tmp := *ctxt
+	m := make(map[GoPlatform]bool, len(DefaultGoPlatforms))
+	for _, p := range DefaultGoPlatforms {
+		tmp.GOOS = p.GOOS
+		tmp.GOARCH = p.GOARCH
+		tmp.CgoEnabled = ctxt.CgoEnabled && p.CgoSupported
+		m[p] = goodOSArchFile(&tmp, base, nil) && (expr == nil || eval(&tmp, expr, nil))
+	}
+	return m, nil
+}
+
+// PlatformFileSets returns the BuildableFiles of directory dir for each of the
+// platforms (or DefaultGoPlatforms if nil). The build.Context ctxt (or
+// build.Default, if nil) is used to read the directory and provides the
+// build, tool and release tags. Cgo is enabled for a platform if it is
+// enabled by ctxt and supported by the platform. Use DiffFileSets to compare
+// the files of two platforms.
+//
+// Only the headers of the files are read and no type checking is performed,
+// which makes this suitable for "compare the linux and windows builds" views.
+func PlatformFileSets(ctxt *build.Context, dir string, platforms []GoPlatform) (map[GoPlatform]*BuildableFileSet, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	if platforms == nil {
+		platforms = DefaultGoPlatforms
+	}
+	sets := make([]*BuildableFileSet, len(platforms))
+	index := make(map[string]int, len(platforms))
+	for i, p := range platforms {
+		index[p.String()] = i
+	}
+	err := ForEachPlatform(ctxt, platforms, func(pctxt *build.Context) error {
+		set, err := BuildableFiles(pctxt, dir)
+		if err != nil {
+			return err
+		}
+		sets[index[pctxt.GOOS+"/"+pctxt.GOARCH]] = set
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[GoPlatform]*BuildableFileSet, len(platforms))
+	for i, p := range platforms {
+		m[p] = sets[i]
+	}
+	return m, nil
+}
+
+// A ConditionalImport is an import of a package and the platforms for which
+// it is active, which are those that include at least one of the files that
+// import it.
+type ConditionalImport struct {
+	Path      string       // import path
+	Platforms []GoPlatform // in the order passed to PlatformImports
+}
+
+// PlatformImports returns the imports of the non-test Go files of directory
+// dir, sorted by import path, and the platforms (or DefaultGoPlatforms if
+// nil) that they are active for, which allows auditing the dependencies of
+// specific platforms (e.g. "is a cgo-only package imported by windows
+// builds?"). Imports that are not active for any of the platforms are
+// omitted.
+//
+// The build.Context ctxt (or build.Default, if nil) is used to read the
+// directory and provides the build, tool and release tags. Cgo is enabled
+// for a platform if it is enabled by ctxt and supported by the platform, so
+// files that import "C" are only included for those platforms. Each file is
+// only read and parsed once and files that cannot be read or parsed are
+// ignored.
+func PlatformImports(ctxt *build.Context, dir string, platforms []GoPlatform) ([]ConditionalImport, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	if platforms == nil {
+		platforms = DefaultGoPlatforms
+	}
+	fis, err := readSourceDir(ctxt, dir)
+	if err != nil {
+		return nil, err
+	}
+	evs := make([]*tagEvaluator, len(platforms))
+	for i, p := range platforms {
+		evs[i] = newTagEvaluator(contextFor(ctxt, p.GOOS, p.GOARCH,
+			ctxt.CgoEnabled && p.CgoSupported))
+	}
+
+	active := make(map[string][]bool) // import path => platform index => active
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") ||
+			strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		header, err := readFileHeader(ctxt, dir, name, true)
+		if err != nil {
+			continue
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), name, header, parser.ImportsOnly)
+		if err != nil || len(f.Imports) == 0 {
+			continue
+		}
+		var imports []string
+		cgo := false
+		for _, spec := range f.Imports {
+			if path, err := strconv.Unquote(spec.Path.Value); err == nil {
+				imports = append(imports, path)
+				cgo = cgo || path == "C"
+			}
+		}
+		for i, ev := range evs {
+			if !goodOSArchFile(ev.ctxt, name, nil) || (cgo && !ev.ctxt.CgoEnabled) {
+				continue
+			}
+			if ok, err := ev.shouldBuild(header, nil); err != nil || !ok {
+				continue
+			}
+			for _, path := range imports {
+				a := active[path]
+				if a == nil {
+					a = make([]bool, len(platforms))
+					active[path] = a
+				}
+				a[i] = true
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(active))
+	for path := range active {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	imports := make([]ConditionalImport, len(paths))
+	for i, path := range paths {
+		imports[i].Path = path
+		for j, ok := range active[path] {
+			if ok {
+				imports[i].Platforms = append(imports[i].Platforms, platforms[j])
+			}
+		}
+	}
+	return imports, nil
+}
@@ -1,8 +1,14 @@
 package buildutil
 
 import (
+	"errors"
+	"go/build"
 	"reflect"
+	"sync"
 	"testing"
+
+	"github.com/charlievieth/buildutil/buildutiltest"
+	"github.com/charlievieth/buildutil/internal/util"
 )
 
 func TestDefaultGoPlatforms(t *testing.T) {
@@ -39,3 +45,275 @@ func TestCgoEnabledMap(t *testing.T) {
 		t.Errorf("cgoEnabled got: %+v want: %+v", cgoEnabled, want)
 	}
 }
+
+func TestContextFor(t *testing.T) {
+	for _, p := range DefaultGoPlatforms {
+		for _, cgo := range []bool{true, false} {
+			ctxt := ContextFor(p.GOOS, p.GOARCH, cgo)
+			if ctxt.GOOS != p.GOOS || ctxt.GOARCH != p.GOARCH {
+				t.Errorf("ContextFor(%q, %q, %t): got: %s/%s", p.GOOS, p.GOARCH, cgo,
+					ctxt.GOOS, ctxt.GOARCH)
+			}
+			if want := cgo && p.CgoSupported; ctxt.CgoEnabled != want {
+				t.Errorf("ContextFor(%q, %q, %t): CgoEnabled = %t; want: %t",
+					p.GOOS, p.GOARCH, cgo, ctxt.CgoEnabled, want)
+			}
+			if !reflect.DeepEqual(ctxt.ReleaseTags, build.Default.ReleaseTags) {
+				t.Errorf("ContextFor(%q, %q, %t): ReleaseTags = %q; want: %q",
+					p.GOOS, p.GOARCH, cgo, ctxt.ReleaseTags, build.Default.ReleaseTags)
+			}
+			var archTags []string
+			for _, tag := range ctxt.ToolTags {
+				if isArchFeatureTag(tag) {
+					archTags = append(archTags, tag)
+				}
+			}
+			want := defaultArchFeatureTags[p.GOARCH]
+			if p.GOARCH == build.Default.GOARCH {
+				want = nil
+				for _, tag := range build.Default.ToolTags {
+					if isArchFeatureTag(tag) {
+						want = append(want, tag)
+					}
+				}
+			}
+			if !reflect.DeepEqual(archTags, want) {
+				t.Errorf("ContextFor(%q, %q, %t): architecture tool tags = %q; want: %q",
+					p.GOOS, p.GOARCH, cgo, archTags, want)
+			}
+		}
+	}
+
+	// Make sure build.Default is not modified
+	orig := util.CopyContext(&build.Default)
+	ctxt := ContextForPlatform(GoPlatform{GOOS: "plan9", GOARCH: "386"})
+	ctxt.BuildTags = append(ctxt.BuildTags, "tag")
+	if !reflect.DeepEqual(orig, &build.Default) {
+		t.Errorf("ContextForPlatform modified build.Default")
+	}
+	if ctxt.CgoEnabled {
+		t.Errorf("ContextForPlatform(plan9/386): CgoEnabled = %t; want: %t", true, false)
+	}
+}
+
+func TestForEachPlatform(t *testing.T) {
+	base := build.Default
+	base.CgoEnabled = true
+	base.BuildTags = []string{"tag1"}
+
+	var mu sync.Mutex
+	seen := make(map[GoPlatform]bool)
+	err := ForEachPlatform(&base, nil, func(ctxt *build.Context) error {
+		p := GoPlatform{GOOS: ctxt.GOOS, GOARCH: ctxt.GOARCH}
+		ctxt.BuildTags = append(ctxt.BuildTags[:0], "modified")
+		mu.Lock()
+		seen[p] = ctxt.CgoEnabled
+		mu.Unlock()
+		if p.GOOS == "plan9" {
+			return errors.New("plan9 error")
+		}
+		return nil
+	})
+	if !reflect.DeepEqual(base.BuildTags, []string{"tag1"}) {
+		t.Errorf("ForEachPlatform modified base Context: %q", base.BuildTags)
+	}
+	for _, p := range DefaultGoPlatforms {
+		cgo, ok := seen[GoPlatform{GOOS: p.GOOS, GOARCH: p.GOARCH}]
+		if !ok {
+			t.Errorf("ForEachPlatform: missing platform: %s", p)
+		}
+		if cgo != p.CgoSupported {
+			t.Errorf("ForEachPlatform: %s: CgoEnabled = %t; want: %t", p, cgo, p.CgoSupported)
+		}
+	}
+
+	var perrs PlatformErrors
+	if !errors.As(err, &perrs) {
+		t.Fatalf("ForEachPlatform: want error type %T got: %#v", perrs, err)
+	}
+	var want, got []GoPlatform
+	for _, p := range DefaultGoPlatforms {
+		if p.GOOS == "plan9" {
+			want = append(want, p)
+		}
+	}
+	for _, e := range perrs {
+		got = append(got, e.Platform)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ForEachPlatform: error platforms = %v; want: %v", got, want)
+	}
+
+	// Explicit platforms
+	platforms := []GoPlatform{{GOOS: "linux", GOARCH: "amd64"}}
+	n := 0
+	err = ForEachPlatform(nil, platforms, func(ctxt *build.Context) error {
+		n++
+		if ctxt.CgoEnabled {
+			t.Errorf("ForEachPlatform: CgoEnabled should be false")
+		}
+		return nil
+	})
+	if err != nil || n != 1 {
+		t.Errorf("ForEachPlatform = %v; called %d times", err, n)
+	}
+
+	// Only nil platforms are replaced with DefaultGoPlatforms
+	n = 0
+	err = ForEachPlatform(nil, []GoPlatform{}, func(*build.Context) error {
+		n++
+		return nil
+	})
+	if err != nil || n != 0 {
+		t.Errorf("ForEachPlatform(empty) = %v; called %d times", err, n)
+	}
+}
+
+func TestMatchFileAllPlatforms(t *testing.T) {
+	ctxt := build.Default
+	ctxt.CgoEnabled = true
+	ctxt.BuildTags = nil
+
+	tests := []struct {
+		name, build string
+		want        func(p GoPlatform) bool
+	}{
+		{
+			name: "main.go",
+			want: func(p GoPlatform) bool { return true },
+		},
+		{
+			name: "x_linux.go",
+			want: func(p GoPlatform) bool { return p.GOOS == "linux" || p.GOOS == "android" },
+		},
+		{
+			name:  "x_linux.go",
+			build: "//go:build arm64 || amd64",
+			want: func(p GoPlatform) bool {
+				return (p.GOOS == "linux" || p.GOOS == "android") &&
+					(p.GOARCH == "arm64" || p.GOARCH == "amd64")
+			},
+		},
+		{
+			name:  "cgo.go",
+			build: "//go:build cgo && !windows",
+			want:  func(p GoPlatform) bool { return p.CgoSupported && p.GOOS != "windows" },
+		},
+		{
+			name:  "tag.go",
+			build: "// +build sometag",
+			want:  func(p GoPlatform) bool { return false },
+		},
+	}
+	for _, x := range tests {
+		src := "package p\n"
+		if x.build != "" {
+			src = x.build + "\n\n" + src
+		}
+		m, err := MatchFileAllPlatforms(&ctxt, "", x.name, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m) != len(DefaultGoPlatforms) {
+			t.Errorf("%s: got %d platforms want: %d", x.name, len(m), len(DefaultGoPlatforms))
+		}
+		for _, p := range DefaultGoPlatforms {
+			if want := x.want(p); m[p] != want {
+				t.Errorf("%s: %q: %s: got: %t want: %t", x.name, x.build, p, m[p], want)
+			}
+		}
+	}
+}
+
+func TestPlatformFileSets(t *testing.T) {
+	dir := t.TempDir()
+	buildutiltest.WriteFiles(t, dir, map[string]string{
+		"main.go":        "package main\n",
+		"sys_linux.go":   "package main\n",
+		"sys_windows.go": "package main\n",
+		"cgo.go":         "package main\n\nimport \"C\"\n",
+		"asm_arm64.s":    "#include \"textflag.h\"\n",
+	})
+	ctxt := build.Default
+	ctxt.CgoEnabled = true
+
+	linux := GoPlatform{GOOS: "linux", GOARCH: "amd64", CgoSupported: true}
+	windows := GoPlatform{GOOS: "windows", GOARCH: "arm64", CgoSupported: false}
+	sets, err := PlatformFileSets(&ctxt, dir, []GoPlatform{linux, windows})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 2 {
+		t.Fatalf("PlatformFileSets() returned %d sets; want: %d", len(sets), 2)
+	}
+	want := map[GoPlatform][]string{
+		linux:   {"cgo.go", "main.go", "sys_linux.go"},
+		windows: {"main.go", "sys_windows.go"},
+	}
+	for p, files := range want {
+		if got := sets[p].GoFiles; !reflect.DeepEqual(got, files) {
+			t.Errorf("%s: GoFiles = %q; want: %q", p, got, files)
+		}
+	}
+	d := DiffFileSets(sets[linux], sets[windows])
+	wantDiff := &FileSetDiff{
+		Added:   []string{"asm_arm64.s", "sys_windows.go"},
+		Removed: []string{"cgo.go", "sys_linux.go"},
+	}
+	if !reflect.DeepEqual(d, wantDiff) {
+		t.Errorf("DiffFileSets(linux, windows) = %+v; want: %+v", d, wantDiff)
+	}
+
+	if _, err := PlatformFileSets(&ctxt, dir+"-missing", []GoPlatform{linux}); err == nil {
+		t.Error("PlatformFileSets: expected an error for a missing directory")
+	}
+}
+
+func TestPlatformImports(t *testing.T) {
+	dir := t.TempDir()
+	buildutiltest.WriteFiles(t, dir, map[string]string{
+		"main.go":        "package main\n\nimport \"fmt\"\n",
+		"sys_linux.go":   "package main\n\nimport (\n\t\"fmt\"\n\t\"golang.org/x/sys/unix\"\n)\n",
+		"sys_windows.go": "package main\n\nimport \"golang.org/x/sys/windows\"\n",
+		"cgo.go":         "package main\n\n// #include <stdio.h>\nimport \"C\"\n\nimport \"example.com/cgodep\"\n",
+		"tag.go":         "//go:build foo\n\npackage main\n\nimport \"example.com/foo\"\n",
+		"main_test.go":   "package main\n\nimport \"testing\"\n",
+		"_ignored.go":    "package main\n\nimport \"example.com/ignored\"\n",
+	})
+	ctxt := build.Default
+	ctxt.CgoEnabled = true
+	ctxt.BuildTags = nil
+
+	linux := GoPlatform{GOOS: "linux", GOARCH: "amd64", CgoSupported: true}
+	windows := GoPlatform{GOOS: "windows", GOARCH: "arm64", CgoSupported: false}
+	imports, err := PlatformImports(&ctxt, dir, []GoPlatform{linux, windows})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ConditionalImport{
+		{"C", []GoPlatform{linux}},
+		{"example.com/cgodep", []GoPlatform{linux}},
+		{"fmt", []GoPlatform{linux, windows}},
+		{"golang.org/x/sys/unix", []GoPlatform{linux}},
+		{"golang.org/x/sys/windows", []GoPlatform{windows}},
+	}
+	if !reflect.DeepEqual(imports, want) {
+		t.Errorf("PlatformImports() = %+v; want: %+v", imports, want)
+	}
+
+	// Cgo disabled
+	ctxt.CgoEnabled = false
+	imports, err = PlatformImports(&ctxt, dir, []GoPlatform{linux})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, imp := range imports {
+		if imp.Path == "C" || imp.Path == "example.com/cgodep" {
+			t.Errorf("PlatformImports(CgoEnabled=false): unexpected import: %q", imp.Path)
+		}
+	}
+
+	if _, err := PlatformImports(&ctxt, dir+"-missing", nil); err == nil {
+		t.Error("PlatformImports: expected an error for a missing directory")
+	}
+}
@@ -0,0 +1,288 @@
+package buildutil
+
+import (
+	"errors"
+	"go/build/constraint"
+	"sort"
+	"strings"
+
+	"github.com/charlievieth/buildutil/internal/util"
+)
+
+// BuildConstraintForPlatformSet returns a build constraint that is satisfied
+// by exactly the platforms of the known platforms (DefaultGoPlatforms and
+// any newer platforms known to this package) that are in platforms. It is
+// intended for code generators that emit per-platform files:
+//
+//	x, _ := BuildConstraintForPlatformSet(platforms)
+//	fmt.Fprintf(w, "//go:build %s\n", x)
+//
+// The constraint only uses GOOS, GOARCH and "unix" tags. Several equivalent
+// forms are generated, such as grouping the platforms by GOOS or by GOARCH,
+// and the one with the fewest tags is returned. Tags implied by GOOS (e.g.
+// "linux" is satisfied by android) are accounted for.
+//
+// If platforms includes every known platform a nil constraint is returned
+// since no constraint is required. The CgoSupported and FirstClass fields
+// of the platforms are ignored.
+func BuildConstraintForPlatformSet(platforms []GoPlatform) (constraint.Expr, error) {
+	if len(platforms) == 0 {
+		return nil, errors.New("buildutil: empty platform set")
+	}
+	set := make(map[string]bool, len(platforms))
+	for _, p := range platforms {
+		if !supportedPlatformsOsArch[p.GOOS][p.GOARCH] {
+			return nil, errors.New("buildutil: unknown platform: " + p.String())
+		}
+		set[p.String()] = true
+	}
+	if len(set) == len(knownPlatforms) {
+		return nil, nil
+	}
+
+	var best constraint.Expr
+	bestTags := 0
+	for _, x := range []constraint.Expr{
+		platformExprByOS(set, false),
+		platformExprByOS(set, true),
+		platformExprByArch(set),
+	} {
+		x = fixPlatformExpr(x, set)
+		if x == nil {
+			continue
+		}
+		if n := countTags(x); best == nil || n < bestTags {
+			best, bestTags = x, n
+		}
+	}
+	if best == nil {
+		return nil, errors.New("buildutil: cannot create build constraint for platforms")
+	}
+	return best, nil
+}
+
+// platformExprByOS returns an expression for set that groups the platforms
+// by GOOS. If unix is true the unix OSes are grouped with the "unix" tag,
+// if possible.
+func platformExprByOS(set map[string]bool, unix bool) constraint.Expr {
+	byOS := make(map[string][]string) // GOOS => GOARCHes in set
+	for _, p := range knownPlatforms {
+		if set[p.String()] {
+			byOS[p.GOOS] = append(byOS[p.GOOS], p.GOARCH)
+		}
+	}
+	var terms []constraint.Expr
+	if unix {
+		// The unix OSes can be replaced with "unix" if each has exactly the
+		// GOARCHes in arches (or all of its GOARCHes).
+		arches := make(map[string]bool)
+		for goos, a := range byOS {
+			if unixOS[goos] {
+				for _, arch := range a {
+					arches[arch] = true
+				}
+			}
+		}
+		for _, p := range knownPlatforms {
+			if unixOS[p.GOOS] && arches[p.GOARCH] != set[p.String()] {
+				return nil
+			}
+		}
+		if len(arches) == 0 {
+			return nil
+		}
+		for goos := range byOS {
+			if unixOS[goos] {
+				delete(byOS, goos)
+			}
+		}
+		unixArches := mapKeys(arches)
+		x := constraint.Expr(tagExpr("unix"))
+		if len(unixArches) != len(platformArches(func(p GoPlatform) bool { return unixOS[p.GOOS] })) {
+			x = andExpr(x, orTags(unixArches))
+		}
+		terms = append(terms, x)
+	}
+	terms = append(terms, groupPlatformExprs(byOS, func(goos string) []string {
+		return platformArches(func(p GoPlatform) bool { return p.GOOS == goos })
+	}, false)...)
+	return orExprs(terms)
+}
+
+// platformExprByArch returns an expression for set that groups the
+// platforms by GOARCH.
+func platformExprByArch(set map[string]bool) constraint.Expr {
+	byArch := make(map[string][]string) // GOARCH => GOOSes in set
+	for _, p := range knownPlatforms {
+		if set[p.String()] {
+			byArch[p.GOARCH] = append(byArch[p.GOARCH], p.GOOS)
+		}
+	}
+	return orExprs(groupPlatformExprs(byArch, func(goarch string) []string {
+		return platformOSes(func(p GoPlatform) bool { return p.GOARCH == goarch })
+	}, true))
+}
+
+// groupPlatformExprs returns the expressions for m, which maps a GOOS (or
+// GOARCH) to the GOARCHes (or GOOSes) in the set. Keys with all of their
+// values, according to all, are grouped into one term and keys with the same
+// values are grouped together: "(linux || darwin) && (amd64 || arm64)".
+func groupPlatformExprs(m map[string][]string, all func(key string) []string, byArch bool) []constraint.Expr {
+	var full []string
+	groups := make(map[string][]string) // values => keys
+	for key, values := range m {
+		sort.Strings(values)
+		if len(values) == len(all(key)) {
+			full = append(full, key)
+			continue
+		}
+		k := strings.Join(values, ",")
+		groups[k] = append(groups[k], key)
+	}
+	var terms []constraint.Expr
+	if len(full) != 0 {
+		sort.Strings(full)
+		terms = append(terms, orTags(full))
+	}
+	for _, values := range sortedKeys(groups) {
+		keys := groups[values]
+		sort.Strings(keys)
+		x, y := orTags(keys), orTags(strings.Split(values, ","))
+		if byArch {
+			x, y = y, x // GOOS first
+		}
+		terms = append(terms, andExpr(x, y))
+	}
+	return terms
+}
+
+// fixPlatformExpr returns x, modified to exclude any platforms it matches
+// that are not in set (e.g. "linux" matches android), or nil if x does not
+// match exactly the platforms in set.
+func fixPlatformExpr(x constraint.Expr, set map[string]bool) constraint.Expr {
+	if x == nil {
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		extra := make(map[string]bool)
+		for _, p := range knownPlatforms {
+			match := evalPlatformExpr(x, p)
+			if match && !set[p.String()] {
+				extra[p.String()] = true
+			} else if !match && set[p.String()] {
+				return nil
+			}
+		}
+		if len(extra) == 0 {
+			return x
+		}
+		// Exclude the extra platforms by their GOOS, which is never implied
+		// by another GOOS. If no platforms of the GOOS are in the set the
+		// GOARCH is not needed.
+		inSet := make(map[string]bool)
+		for _, p := range knownPlatforms {
+			if set[p.String()] {
+				inSet[p.GOOS] = true
+			}
+		}
+		excludeOS := make(map[string]bool)
+		for _, p := range knownPlatforms {
+			if extra[p.String()] && !inSet[p.GOOS] {
+				excludeOS[p.GOOS] = true
+			}
+		}
+		for _, p := range knownPlatforms {
+			if excludeOS[p.GOOS] {
+				extra[p.String()] = true
+			}
+		}
+		y := platformExprByOS(extra, false)
+		if y == nil {
+			return nil
+		}
+		x = andExpr(x, &constraint.NotExpr{X: y})
+	}
+	return nil
+}
+
+// evalPlatformExpr reports if x is satisfied by platform p.
+func evalPlatformExpr(x constraint.Expr, p GoPlatform) bool {
+	ctxt := ContextFor(p.GOOS, p.GOARCH, false)
+	ctxt.BuildTags = nil
+	ctxt.ToolTags = nil
+	return eval(ctxt, x, nil)
+}
+
+// platformArches returns the sorted GOARCHes of the known platforms for
+// which fn returns true.
+func platformArches(fn func(p GoPlatform) bool) []string {
+	var a []string
+	for _, p := range knownPlatforms {
+		if fn(p) {
+			a = append(a, p.GOARCH)
+		}
+	}
+	return util.SortUniqueStrings(a)
+}
+
+// platformOSes returns the sorted GOOSes of the known platforms for which
+// fn returns true.
+func platformOSes(fn func(p GoPlatform) bool) []string {
+	var a []string
+	for _, p := range knownPlatforms {
+		if fn(p) {
+			a = append(a, p.GOOS)
+		}
+	}
+	return util.SortUniqueStrings(a)
+}
+
+func mapKeys(m map[string]bool) []string {
+	a := make([]string, 0, len(m))
+	for k := range m {
+		a = append(a, k)
+	}
+	sort.Strings(a)
+	return a
+}
+
+func sortedKeys(m map[string][]string) []string {
+	a := make([]string, 0, len(m))
+	for k := range m {
+		a = append(a, k)
+	}
+	sort.Strings(a)
+	return a
+}
+
+func tagExpr(tag string) *constraint.TagExpr { return &constraint.TagExpr{Tag: tag} }
+
+func andExpr(x, y constraint.Expr) constraint.Expr { return &constraint.AndExpr{X: x, Y: y} }
+
+// orTags returns the OR of tags, which must not be empty.
+func orTags(tags []string) constraint.Expr {
+	exprs := make([]constraint.Expr, len(tags))
+	for i, tag := range tags {
+		exprs[i] = tagExpr(tag)
+	}
+	return orExprs(exprs)
+}
+
+// orExprs returns the OR of exprs or nil if exprs is empty.
+func orExprs(exprs []constraint.Expr) constraint.Expr {
+	if len(exprs) == 0 {
+		return nil
+	}
+	x := exprs[0]
+	for _, y := range exprs[1:] {
+		x = &constraint.OrExpr{X: x, Y: y}
+	}
+	return x
+}
+
+// countTags returns the number of tags in x.
+func countTags(x constraint.Expr) int {
+	n := 0
+	walkTags(x, func(string) { n++ })
+	return n
+}
@@ -0,0 +1,96 @@
+package buildutil
+
+import "testing"
+
+func TestBuildConstraintForPlatformSet(t *testing.T) {
+	filter := func(fn func(p GoPlatform) bool) []GoPlatform {
+		var a []GoPlatform
+		for _, p := range knownPlatforms {
+			if fn(p) {
+				a = append(a, p)
+			}
+		}
+		return a
+	}
+	tests := []struct {
+		name      string
+		platforms []GoPlatform
+		want      string // expected constraint, if not empty
+	}{
+		{
+			name:      "linux/amd64",
+			platforms: []GoPlatform{{GOOS: "linux", GOARCH: "amd64"}},
+			want:      "linux && amd64 && !android",
+		},
+		{
+			name:      "windows",
+			platforms: filter(func(p GoPlatform) bool { return p.GOOS == "windows" }),
+			want:      "windows",
+		},
+		{
+			name:      "unix",
+			platforms: filter(func(p GoPlatform) bool { return unixOS[p.GOOS] }),
+			want:      "unix",
+		},
+		{
+			name: "unix amd64 and arm64",
+			platforms: filter(func(p GoPlatform) bool {
+				return unixOS[p.GOOS] && (p.GOARCH == "amd64" || p.GOARCH == "arm64")
+			}),
+			want: "unix && (amd64 || arm64)",
+		},
+		{
+			name: "first class unix",
+			platforms: filter(func(p GoPlatform) bool {
+				return p.FirstClass && unixOS[p.GOOS]
+			}),
+		},
+		{
+			name:      "amd64",
+			platforms: filter(func(p GoPlatform) bool { return p.GOARCH == "amd64" }),
+			want:      "amd64",
+		},
+		{
+			name: "not windows",
+			platforms: filter(func(p GoPlatform) bool {
+				return p.GOOS != "windows" && p.GOOS != "plan9"
+			}),
+		},
+		{
+			name: "android and darwin",
+			platforms: filter(func(p GoPlatform) bool {
+				return p.GOOS == "android" || p.GOOS == "darwin"
+			}),
+			want: "(android || darwin) && !ios",
+		},
+	}
+	for _, x := range tests {
+		expr, err := BuildConstraintForPlatformSet(x.platforms)
+		if err != nil {
+			t.Errorf("%s: %v", x.name, err)
+			continue
+		}
+		if x.want != "" && expr.String() != x.want {
+			t.Errorf("%s: BuildConstraintForPlatformSet() = %q; want: %q", x.name, expr, x.want)
+		}
+		want := make(map[string]bool)
+		for _, p := range x.platforms {
+			want[p.String()] = true
+		}
+		for _, p := range knownPlatforms {
+			if got := evalPlatformExpr(expr, p); got != want[p.String()] {
+				t.Errorf("%s: %q: eval(%s) = %t; want: %t", x.name, expr, p, got, want[p.String()])
+			}
+		}
+	}
+
+	if x, err := BuildConstraintForPlatformSet(knownPlatforms); x != nil || err != nil {
+		t.Errorf("BuildConstraintForPlatformSet(all) = %v, %v; want: %v, %v", x, err, nil, nil)
+	}
+	if _, err := BuildConstraintForPlatformSet(nil); err == nil {
+		t.Error("BuildConstraintForPlatformSet(nil): expected an error")
+	}
+	if _, err := BuildConstraintForPlatformSet([]GoPlatform{{GOOS: "linux", GOARCH: "foo"}}); err == nil {
+		t.Error("BuildConstraintForPlatformSet(linux/foo): expected an error")
+	}
+}
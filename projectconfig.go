@@ -0,0 +1,185 @@
+package buildutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"io/fs"
+	"io/ioutil"
+	"time"
+
+	"github.com/charlievieth/buildutil/contextutil"
+	"github.com/charlievieth/buildutil/internal/util"
+)
+
+// ProjectConfigFile is the name of the optional project configuration file,
+// which is located at the root of a project (typically the module root).
+const ProjectConfigFile = ".buildutil.json"
+
+// A ProjectConfig is the project configuration read from a ProjectConfigFile.
+// It allows a team to share the defaults used by GoCommand and, if provided
+// as the Project of the MatchOptions, MatchContextOptions without requiring
+// each contributor to configure their editor.
+//
+//	{
+//	    "build_tags": ["integration"],
+//	    "preferred_os": ["linux", "darwin"],
+//	    "preferred_arch": ["amd64", "arm64"],
+//	    "forbidden_tags": ["purego"]
+//	}
+type ProjectConfig struct {
+	// BuildTags are added to the BuildTags of the Context.
+	BuildTags []string `json:"build_tags,omitempty"`
+
+	// PreferredOS and PreferredArch are used as the preferences of the
+	// MatchOptions, if not otherwise specified.
+	PreferredOS   []string `json:"preferred_os,omitempty"`
+	PreferredArch []string `json:"preferred_arch,omitempty"`
+
+	// ForbiddenTags are added to the ForbiddenTags of the MatchOptions.
+	ForbiddenTags []string `json:"forbidden_tags,omitempty"`
+}
+
+// ParseProjectConfig parses the ProjectConfigFile data. Unknown fields are
+// an error to catch misspellings.
+func ParseProjectConfig(data []byte) (*ProjectConfig, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var conf ProjectConfig
+	if err := dec.Decode(&conf); err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
+
+// projectRootCacheTTL is how long the project roots found by
+// FindProjectConfig are cached. Workspace.Notify invalidates the cache
+// when tombstone files are created or deleted.
+const projectRootCacheTTL = 5 * time.Second
+
+// projectRootCache caches the project roots found by FindProjectConfig,
+// which is called by every GoCommand call.
+var projectRootCache = contextutil.NewProjectRootCache(projectRootCacheTTL)
+
+// findProjectRoot is contextutil.FindProjectRoot with ProjectConfigFile as
+// an additional tombstone. The result is cached if ctxt uses the local file
+// system since the cache is keyed by directory.
+func findProjectRoot(ctxt *build.Context, path string) (string, error) {
+	if ctxt.OpenFile == nil && ctxt.ReadDir == nil && ctxt.IsDir == nil {
+		return projectRootCache.FindProjectRoot(ctxt, path, ProjectConfigFile)
+	}
+	return contextutil.FindProjectRoot(ctxt, path, ProjectConfigFile)
+}
+
+// FindProjectConfig reads the ProjectConfigFile at the root of the project
+// containing path, which is found with contextutil.FindProjectRoot (with
+// ProjectConfigFile as an additional tombstone), and returns it along with
+// the name of the file. If the project does not have a ProjectConfigFile
+// a nil config and error are returned.
+//
+// The Context is used for file system access. When the Context uses the
+// local file system the project root is cached for a few seconds, or until
+// Workspace.Notify reports that a file was created or deleted.
+func FindProjectConfig(ctxt *build.Context, path string) (*ProjectConfig, string, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	root, err := findProjectRoot(ctxt, path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	name := joinPath(ctxt, root, ProjectConfigFile)
+	rc, err := openReader(ctxt, name, nil)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	conf, err := ParseProjectConfig(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", name, err)
+	}
+	return conf, name, nil
+}
+
+// context returns a copy of ctxt with the BuildTags of the config added
+// or ctxt if there are no tags to add.
+func (c *ProjectConfig) context(ctxt *build.Context) *build.Context {
+	if len(c.BuildTags) == 0 {
+		return ctxt
+	}
+	ctxt = CopyContext(ctxt)
+	for _, tag := range c.BuildTags {
+		ctxt.BuildTags = util.StringsAppend(ctxt.BuildTags, tag)
+	}
+	return ctxt
+}
+
+// matchOptions returns opts with the preferences of the config used for
+// any preference that neither opts nor the global preference lists specify.
+// The ForbiddenTags of the config are added to those of opts.
+func (c *ProjectConfig) matchOptions(opts *MatchOptions) *MatchOptions {
+	if len(c.PreferredOS) == 0 && len(c.PreferredArch) == 0 && len(c.ForbiddenTags) == 0 {
+		return opts
+	}
+	var o MatchOptions
+	if opts != nil {
+		o = *opts
+	}
+	osList, archList := changedPreferredLists()
+	if len(o.PreferredOS) == 0 {
+		if osList != nil {
+			o.PreferredOS = osList
+		} else {
+			o.PreferredOS = c.PreferredOS
+		}
+	}
+	if len(o.PreferredArch) == 0 {
+		if archList != nil {
+			o.PreferredArch = archList
+		} else {
+			o.PreferredArch = c.PreferredArch
+		}
+	}
+	if len(c.ForbiddenTags) != 0 {
+		forbidden := util.DuplicateStrings(o.ForbiddenTags)
+		for _, tag := range c.ForbiddenTags {
+			forbidden = util.StringsAppend(forbidden, tag)
+		}
+		o.ForbiddenTags = forbidden
+	}
+	return &o
+}
+
+// projectContext returns ctxt with the BuildTags of the ProjectConfigFile of
+// the project containing ctxt.Dir, if any. Errors reading the config are
+// ignored.
+func projectContext(ctxt *build.Context) *build.Context {
+	if conf := projectConfig(ctxt); conf != nil {
+		return conf.context(ctxt)
+	}
+	return ctxt
+}
+
+// projectConfig returns the ProjectConfigFile of the project containing
+// ctxt.Dir, or nil if there is none. Errors reading the config are ignored.
+func projectConfig(ctxt *build.Context) *ProjectConfig {
+	if ctxt == nil || ctxt.Dir == "" {
+		return nil
+	}
+	if conf, _, err := FindProjectConfig(ctxt, ctxt.Dir); err == nil {
+		return conf
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package buildutil
+
+import (
+	"go/build"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/charlievieth/buildutil/buildutiltest"
+)
+
+func TestParseProjectConfig(t *testing.T) {
+	conf, err := ParseProjectConfig([]byte(`{
+		"build_tags": ["integration"],
+		"preferred_os": ["linux", "*bsd"],
+		"preferred_arch": ["arm64"],
+		"forbidden_tags": ["purego"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ProjectConfig{
+		BuildTags:     []string{"integration"},
+		PreferredOS:   []string{"linux", "*bsd"},
+		PreferredArch: []string{"arm64"},
+		ForbiddenTags: []string{"purego"},
+	}
+	if !reflect.DeepEqual(conf, want) {
+		t.Errorf("ParseProjectConfig() = %+v; want: %+v", conf, want)
+	}
+
+	for _, data := range []string{`{"build_tag": ["x"]}`, `{"build_tags": "x"}`, `[`} {
+		if _, err := ParseProjectConfig([]byte(data)); err == nil {
+			t.Errorf("ParseProjectConfig(%q): expected error", data)
+		}
+	}
+}
+
+func TestFindProjectConfig(t *testing.T) {
+	dir := t.TempDir()
+	buildutiltest.WriteFiles(t, dir, map[string]string{
+		"proj/go.mod":               "module proj\n",
+		"proj/" + ProjectConfigFile: `{"build_tags": ["integration"]}`,
+		"proj/pkg/pkg.go":           "package pkg\n",
+		"other/go.mod":              "module other\n",
+		"other/other.go":            "package other\n",
+		"bad/go.mod":                "module bad\n",
+		"bad/" + ProjectConfigFile:  `{"tags": ["x"]}`,
+	})
+
+	conf, name, err := FindProjectConfig(nil, filepath.Join(dir, "proj/pkg/pkg.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "proj", ProjectConfigFile); name != want {
+		t.Errorf("FindProjectConfig: name = %q; want: %q", name, want)
+	}
+	if conf == nil || !reflect.DeepEqual(conf.BuildTags, []string{"integration"}) {
+		t.Errorf("FindProjectConfig: config = %+v; want: %q", conf, "integration")
+	}
+
+	conf, name, err = FindProjectConfig(nil, filepath.Join(dir, "other/other.go"))
+	if conf != nil || name != "" || err != nil {
+		t.Errorf("FindProjectConfig: no config = %+v, %q, %v; want: nil, \"\", nil", conf, name, err)
+	}
+
+	if _, _, err := FindProjectConfig(nil, filepath.Join(dir, "bad")); err == nil {
+		t.Error("FindProjectConfig: expected error for invalid config")
+	}
+}
+
+func TestMatchContextProjectConfig(t *testing.T) {
+	dir := t.TempDir()
+	buildutiltest.WriteFiles(t, dir, map[string]string{
+		"go.mod":          "module proj\n",
+		ProjectConfigFile: `{"build_tags": ["integration"], "preferred_os": ["freebsd"]}`,
+		"x/x.go":          "//go:build integration && (windows || freebsd)\n\npackage x\n",
+	})
+	filename := filepath.Join(dir, "x/x.go")
+	orig := build.Default
+	orig.GOOS = "linux"
+	orig.GOARCH = "amd64"
+	orig.BuildTags = nil
+
+	// The config is not read unless provided
+	ctxt, err := MatchContext(&orig, filename, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctxt.GOOS != "windows" {
+		t.Errorf("MatchContext: GOOS = %q; want: %q", ctxt.GOOS, "windows")
+	}
+
+	conf, _, err := FindProjectConfig(&orig, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctxt, err = MatchContextOptions(&orig, filename, nil, &MatchOptions{Project: conf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctxt.GOOS != "freebsd" {
+		t.Errorf("GOOS = %q; want: %q", ctxt.GOOS, "freebsd")
+	}
+	if !reflect.DeepEqual(ctxt.BuildTags, []string{"integration"}) {
+		t.Errorf("BuildTags = %q; want: %q", ctxt.BuildTags, []string{"integration"})
+	}
+
+	// Explicit preferences take precedence
+	ctxt, err = MatchContextOptions(&orig, filename, nil,
+		&MatchOptions{PreferredOS: []string{"windows"}, Project: conf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctxt.GOOS != "windows" {
+		t.Errorf("PreferredOS: GOOS = %q; want: %q", ctxt.GOOS, "windows")
+	}
+
+	// As do the global preferences
+	SetPreferredOSList([]string{"windows"})
+	t.Cleanup(func() { SetPreferredOSList(nil) })
+	ctxt, err = MatchContextOptions(&orig, filename, nil, &MatchOptions{Project: conf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctxt.GOOS != "windows" {
+		t.Errorf("SetPreferredOSList: GOOS = %q; want: %q", ctxt.GOOS, "windows")
+	}
+}
+
+func TestFindProjectConfigCache(t *testing.T) {
+	projectRootCache.Reset()
+	t.Cleanup(projectRootCache.Reset)
+
+	dir := t.TempDir()
+	buildutiltest.WriteFiles(t, dir, map[string]string{
+		"go.mod":   "module proj\n",
+		"p/p.go":   "package p\n",
+		"p/q/q.go": "package q\n",
+	})
+	if conf, _, err := FindProjectConfig(nil, filepath.Join(dir, "p/p.go")); conf != nil || err != nil {
+		t.Fatalf("FindProjectConfig = %+v, %v; want: nil, nil", conf, err)
+	}
+	if n := projectRootCache.Len(); n != 1 {
+		t.Errorf("projectRootCache.Len() = %d; want: %d", n, 1)
+	}
+
+	// Creating the config must be seen once the Workspace is notified
+	name := filepath.Join(dir, ProjectConfigFile)
+	buildutiltest.WriteFiles(t, dir, map[string]string{
+		ProjectConfigFile: `{"build_tags": ["integration"]}`,
+	})
+	var w Workspace
+	w.Notify(Event{Path: name, Kind: EventCreated})
+	conf, got, err := FindProjectConfig(nil, filepath.Join(dir, "p/p.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf == nil || got != name {
+		t.Errorf("FindProjectConfig = %+v, %q; want: %q", conf, got, name)
+	}
+}
+
+func TestGoCommandProjectConfig(t *testing.T) {
+	t.Setenv("GOFLAGS", "")
+	dir := t.TempDir()
+	buildutiltest.WriteFiles(t, dir, map[string]string{
+		"go.mod":          "module proj\n",
+		ProjectConfigFile: `{"build_tags": ["integration"]}`,
+		"x/x.go":          "package x\n",
+	})
+	ctxt := build.Default
+	ctxt.BuildTags = []string{"foo"}
+	ctxt.Dir = filepath.Join(dir, "x")
+
+	cmd := GoCommand(&ctxt, "go", "list")
+	var goflags string
+	for _, s := range cmd.Env {
+		if strings.HasPrefix(s, "GOFLAGS=") {
+			goflags = strings.TrimPrefix(s, "GOFLAGS=")
+		}
+	}
+	if want := "-tags=foo,integration"; goflags != want {
+		t.Errorf("GOFLAGS = %q; want: %q", goflags, want)
+	}
+	if !reflect.DeepEqual(ctxt.BuildTags, []string{"foo"}) {
+		t.Errorf("GoCommand modified the Context: BuildTags = %q", ctxt.BuildTags)
+	}
+}
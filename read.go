@@ -10,8 +10,10 @@ import (
 	"errors"
 	"go/token"
 	"io"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"unicode/utf8"
 )
 
@@ -22,13 +24,34 @@ type importReader struct {
 	err  error
 	eof  bool
 	nerr int
+	max  int64 // maximum size of buf, if > 0
 	pos  token.Position
+
+	// Directives of the comments before the package clause
+	leading bool // reading the comments before the package clause
+	debug   []GoDebug
+	lines   []LineDirective
 }
 
 var bom = []byte{0xef, 0xbb, 0xbf}
 
+// DefaultReaderPoolMaxBuffer is the default maximum capacity of a buffer
+// retained by the pool of readers used to read file headers.
+const DefaultReaderPoolMaxBuffer = 64 * 1024
+
+// Reader pool metrics, updated atomically.
+var (
+	readerPoolGets        uint64
+	readerPoolMisses      uint64
+	readerPoolDropped     uint64
+	readerPoolMaxRetained int64
+	readerPoolMaxBuffer   int64 = DefaultReaderPoolMaxBuffer
+	maxHeaderSize         int64
+)
+
 var importReaderPool = sync.Pool{
 	New: func() interface{} {
+		atomic.AddUint64(&readerPoolMisses, 1)
 		return &importReader{
 			b:   bufio.NewReader(nil),
 			buf: make([]byte, 0, 512),
@@ -36,15 +59,85 @@ var importReaderPool = sync.Pool{
 	},
 }
 
+// ReaderPoolStats are the metrics of the pool of readers used to read
+// file headers (see ReaderPoolMetrics).
+type ReaderPoolStats struct {
+	Hits        uint64 // readers reused from the pool
+	Misses      uint64 // readers allocated because the pool was empty
+	Dropped     uint64 // buffers discarded since they exceeded the maximum capacity
+	MaxRetained int64  // capacity of the largest buffer returned to the pool
+}
+
+// ReaderPoolMetrics returns the metrics of the pool of readers used to read
+// file headers. It is safe for concurrent use.
+func ReaderPoolMetrics() ReaderPoolStats {
+	gets := atomic.LoadUint64(&readerPoolGets)
+	misses := atomic.LoadUint64(&readerPoolMisses)
+	var hits uint64
+	if gets > misses {
+		hits = gets - misses
+	}
+	return ReaderPoolStats{
+		Hits:        hits,
+		Misses:      misses,
+		Dropped:     atomic.LoadUint64(&readerPoolDropped),
+		MaxRetained: atomic.LoadInt64(&readerPoolMaxRetained),
+	}
+}
+
+// SetReaderPoolMaxBuffer sets the maximum capacity of a buffer retained by
+// the pool of readers used to read file headers and returns the previous
+// value. Buffers that grow larger than n, which happens when reading very
+// large (typically generated) files, are discarded instead of being returned
+// to the pool. If n <= 0 there is no limit. The default is
+// DefaultReaderPoolMaxBuffer. It is safe for concurrent use.
+func SetReaderPoolMaxBuffer(n int) int {
+	return int(atomic.SwapInt64(&readerPoolMaxBuffer, int64(n)))
+}
+
+// A HeaderTooLargeError is returned when the header of a file, which is what
+// is read to evaluate its build constraints, exceeds the maximum size set
+// with SetMaxHeaderSize.
+type HeaderTooLargeError struct {
+	Limit int64 // maximum header size in bytes
+}
+
+func (e *HeaderTooLargeError) Error() string {
+	return "buildutil: file header exceeds maximum size of " +
+		strconv.FormatInt(e.Limit, 10) + " bytes"
+}
+
+// SetMaxHeaderSize sets the maximum number of bytes read from a Go source
+// file when reading its header (its leading comments, package clause and,
+// for some functions, imports) and returns the previous value. A file whose
+// header is larger, such as a hostile input with megabytes of leading
+// comments, is rejected with a *HeaderTooLargeError instead of being
+// buffered in memory. If n <= 0, which is the default, there is no limit.
+// It is safe for concurrent use.
+func SetMaxHeaderSize(n int) int {
+	return int(atomic.SwapInt64(&maxHeaderSize, int64(n)))
+}
+
 func putImportReader(r *importReader) {
 	b := r.b
 	buf := r.buf[:0]
+	if max := atomic.LoadInt64(&readerPoolMaxBuffer); max > 0 && int64(cap(buf)) > max {
+		atomic.AddUint64(&readerPoolDropped, 1)
+		buf = make([]byte, 0, 512)
+	}
+	for n := int64(cap(buf)); ; {
+		old := atomic.LoadInt64(&readerPoolMaxRetained)
+		if n <= old || atomic.CompareAndSwapInt64(&readerPoolMaxRetained, old, n) {
+			break
+		}
+	}
 	b.Reset(nil) // remove reference
 	*r = importReader{b: b, buf: buf}
 	importReaderPool.Put(r)
 }
 
 func newImportReader(name string, rd io.Reader) *importReader {
+	atomic.AddUint64(&readerPoolGets, 1)
 	r := importReaderPool.Get().(*importReader)
 	r.b.Reset(rd)
 
@@ -56,6 +149,8 @@ func newImportReader(name string, rd io.Reader) *importReader {
 		r.b.Discard(3)
 	}
 	r.buf = r.buf[:0]
+	r.max = atomic.LoadInt64(&maxHeaderSize)
+	r.leading = true
 	r.pos = token.Position{
 		Filename: name,
 		Line:     1,
@@ -83,6 +178,12 @@ func (r *importReader) syntaxError() {
 // readByte reads the next byte from the input, saves it in buf, and returns it.
 // If an error occurs, readByte records the error in r.err and returns 0.
 func (r *importReader) readByte() byte {
+	if r.max > 0 && int64(len(r.buf)) >= r.max {
+		if r.err == nil || r.err == errSyntax {
+			r.err = &HeaderTooLargeError{Limit: r.max}
+		}
+		return 0
+	}
 	c, err := r.b.ReadByte()
 	if err == nil {
 		r.buf = append(r.buf, c)
@@ -130,9 +231,13 @@ func (r *importReader) peekByte(skipSpace bool) byte {
 			case '/':
 				c = r.readByte()
 				if c == '/' {
+					start := len(r.buf) - 2
 					for c != '\n' && r.err == nil && !r.eof {
 						c = r.readByte()
 					}
+					if r.leading && r.err == nil {
+						r.readDirective(start)
+					}
 				} else if c == '*' {
 					var c1 byte
 					for (c != '*' || c1 != '/') && r.err == nil {
@@ -161,10 +266,32 @@ func (r *importReader) nextByte(skipSpace bool) byte {
 	return c
 }
 
+// readDirective records the //go:debug or //line directive, if any, of the
+// line comment that starts at buf[start].
+func (r *importReader) readDirective(start int) {
+	line := bytes.TrimRight(r.buf[start:], "\r\n")
+	switch {
+	case bytes.HasPrefix(line, goDebugPrefix):
+		if d, ok := parseGoDebug(line[len(goDebugPrefix):]); ok {
+			d.Offset = start
+			r.debug = append(r.debug, d)
+		}
+	case bytes.HasPrefix(line, linePrefix):
+		// The //line directive must start at the beginning of the line.
+		if start == 0 || r.buf[start-1] == '\n' {
+			if d, ok := parseLineDirective(string(line[len(linePrefix):])); ok {
+				d.Offset = start
+				r.lines = append(r.lines, d)
+			}
+		}
+	}
+}
+
 // readKeyword reads the given keyword from the input.
 // If the keyword is not present, readKeyword records a syntax error.
 func (r *importReader) readKeyword(kw string) {
 	r.peekByte(true)
+	r.leading = false
 	for i := 0; i < len(kw); i++ {
 		if r.nextByte(false) != kw[i] {
 			r.syntaxError()
@@ -232,8 +359,36 @@ func (r *importReader) readImport() {
 	r.readString()
 }
 
-// TODO: remove ??
+// ReadComments reads the leading block of comments, and any blank lines
+// between them, from r without reading or parsing the rest of the input.
+// This is useful for scanning license headers and build constraints.
+// Trailing white space is removed from the returned comments.
 //
+// The returned offset is the byte offset of the first byte of the input that
+// is not white space or part of a comment (where the code starts), or -1 if
+// the input contains only comments. If the comments are malformed (e.g. an
+// unterminated "/*" comment), the bytes read are returned with an offset of
+// -1 and a nil error. An error is only returned if reading from r fails,
+// the input contains a NUL byte or the comments exceed the maximum header
+// size (see SetMaxHeaderSize).
+func ReadComments(r io.Reader) (comments []byte, offset int, err error) {
+	ir := newImportReader("", r)
+	defer putImportReader(ir)
+	ir.peekByte(true)
+	buf := ir.buf
+	offset = -1
+	if ir.err == nil && !ir.eof {
+		// Didn't reach EOF, so must have found a non-space byte. Remove it.
+		buf = buf[:len(buf)-1]
+		offset = len(buf)
+	}
+	err = ir.err
+	if err == errSyntax {
+		err = nil
+	}
+	return append([]byte(nil), bytes.TrimRight(buf, " \f\t\r\n")...), offset, err
+}
+
 // readComments is like io.ReadAll, except that it only reads the leading
 // block of comments in the file.
 func readComments(f io.Reader) ([]byte, error) {
@@ -251,6 +406,28 @@ func readComments(f io.Reader) ([]byte, error) {
 type fileInfo struct {
 	name   string // full name including dir
 	header []byte
+	debug  []GoDebug       // //go:debug directives before the package clause
+	lines  []LineDirective // //line directives before the package clause
+}
+
+// ReadImportsFast reads the header of the Go source file read from r: the
+// leading comments, which contain any build constraints, and the package
+// clause. Reading stops after the package clause so the (possibly very large)
+// remainder of the file is never read. The returned header is suitable for
+// evaluating build constraints with ShouldBuild and is what MatchContext uses.
+//
+// ReadImportsFast is tolerant of syntax errors: if the package clause is
+// malformed or missing, the bytes read so far are returned with a nil error.
+// An error is only returned if reading from r fails, the input contains a
+// NUL byte or the header exceeds the maximum header size (see
+// SetMaxHeaderSize), in which case the bytes read before the error are also
+// returned.
+func ReadImportsFast(r io.Reader) ([]byte, error) {
+	data, err := readImportsFast(r)
+	if err == errSyntax {
+		err = nil
+	}
+	return data, err
 }
 
 // TODO: rename to "readPackageName" or something
@@ -258,27 +435,72 @@ type fileInfo struct {
 // readImportsFast is like readImports, except that it stops reading after the
 // package clause.
 func readImportsFast(f io.Reader) ([]byte, error) {
-	r := newImportReader("dummy.go", f)
+	info := fileInfo{name: "dummy.go"}
+	err := readPackageClause(f, &info)
+	return info.header, err
+}
+
+// readPackageClause is like readGoInfo, except that it stops reading after
+// the package clause. Unlike readGoInfo the header is returned, instead of
+// the whole file, if there is a syntax error.
+func readPackageClause(f io.Reader, info *fileInfo) error {
+	r := newImportReader(info.name, f)
 	defer putImportReader(r)
 	r.readKeyword("package")
 	r.readIdent()
 	r.readByte()
+	info.debug = r.debug
+	info.lines = r.lines
 
 	// If we stopped successfully before EOF, we read a byte that told us we were done.
 	// Return all but that last byte, which would cause a syntax error if we let it through.
 	if r.err == nil && !r.eof {
-		buf := append([]byte(nil), r.buf[:len(r.buf)-1]...)
-		return buf, nil
+		info.header = append([]byte(nil), r.buf[:len(r.buf)-1]...)
+		return nil
+	}
+	info.header = append([]byte(nil), r.buf...)
+	return r.err
+}
+
+// readImportsMatch is like readImportsFast, except that it also reads the
+// imports so that they can be used as hints when matching a Context to the
+// file. If the imports are malformed only the header through the package
+// clause is returned.
+func readImportsMatch(f io.Reader) ([]byte, error) {
+	r := newImportReader("dummy.go", f)
+	defer putImportReader(r)
+	r.readKeyword("package")
+	r.readIdent()
+	if r.err != nil || r.eof {
+		return append([]byte(nil), r.buf...), r.err
+	}
+	n := len(r.buf) // package clause and the byte following it
+	for r.peekByte(true) == 'i' {
+		r.readKeyword("import")
+		if r.peekByte(true) == '(' {
+			r.nextByte(false)
+			for r.peekByte(true) != ')' && r.err == nil {
+				r.readImport()
+			}
+			r.nextByte(false)
+		} else {
+			r.readImport()
+		}
+	}
+	switch {
+	case r.err == errSyntax:
+		return append([]byte(nil), r.buf[:n]...), nil
+	case r.err != nil:
+		return append([]byte(nil), r.buf...), r.err
+	case !r.eof:
+		return append([]byte(nil), r.buf[:len(r.buf)-1]...), nil
 	}
-	buf := append([]byte(nil), r.buf...)
-	err := r.err
-	return buf, err
+	return append([]byte(nil), r.buf...), nil
 }
 
 // readGoInfo expects a Go file as input and reads the file up to and including the import section.
-// It records what it learned in *info.
-// If info.fset is non-nil, readGoInfo parses the file and sets info.parsed, info.parseErr,
-// info.imports, info.embeds, and info.embedErr.
+// It records what it learned in *info: the header and the //go:debug and //line
+// directives that precede the package clause.
 //
 // It only returns an error if there are problems reading the file,
 // not for syntax errors in the file itself.
@@ -302,6 +524,8 @@ func readGoInfo(f io.Reader, info *fileInfo) error {
 	}
 
 	info.header = r.buf
+	info.debug = r.debug
+	info.lines = r.lines
 
 	// If we stopped successfully before EOF, we read a byte that told us we were done.
 	// Return all but that last byte, which would cause a syntax error if we let it through.
@@ -6,8 +6,10 @@ package buildutil
 
 import (
 	"bytes"
+	"errors"
 	"go/build"
 	"io"
+	"reflect"
 	"runtime"
 	"strings"
 	"sync"
@@ -162,6 +164,29 @@ func TestReadGoInfo(t *testing.T) {
 	})
 }
 
+func TestReadGoInfoDirectives(t *testing.T) {
+	const src = "//go:debug panicnil=1\n" +
+		"//line gen.go:10:2\n" +
+		"\n" +
+		"// Package main.\n" +
+		"package main\n" +
+		"\n" +
+		"//go:debug after=1\n" +
+		"import \"fmt\"\n"
+	info := fileInfo{name: "main.go"}
+	if err := readGoInfo(strings.NewReader(src), &info); err != nil {
+		t.Fatal(err)
+	}
+	wantDebug := []GoDebug{{Key: "panicnil", Value: "1", Offset: 0}}
+	if !reflect.DeepEqual(info.debug, wantDebug) {
+		t.Errorf("debug = %+v; want: %+v", info.debug, wantDebug)
+	}
+	wantLines := []LineDirective{{Filename: "gen.go", Line: 10, Col: 2, Offset: strings.Index(src, "//line")}}
+	if !reflect.DeepEqual(info.lines, wantLines) {
+		t.Errorf("lines = %+v; want: %+v", info.lines, wantLines)
+	}
+}
+
 func TestReadGoInfo_Parallel(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping: short test")
@@ -449,3 +474,171 @@ func BenchmarkReadGoInfo(b *testing.B) {
 		readGoInfo(rc, &info)
 	}
 }
+
+func TestReaderPoolMetrics(t *testing.T) {
+	prev := SetReaderPoolMaxBuffer(1024)
+	t.Cleanup(func() { SetReaderPoolMaxBuffer(prev) })
+	if prev != DefaultReaderPoolMaxBuffer {
+		t.Errorf("SetReaderPoolMaxBuffer = %d; want: %d", prev, DefaultReaderPoolMaxBuffer)
+	}
+
+	before := ReaderPoolMetrics()
+
+	// Small files must not be dropped
+	if _, err := readComments(strings.NewReader("// small\n\npackage p\n")); err != nil {
+		t.Fatal(err)
+	}
+	stats := ReaderPoolMetrics()
+	if n := (stats.Hits + stats.Misses) - (before.Hits + before.Misses); n < 1 {
+		t.Errorf("Hits + Misses increased by %d; want: >= 1", n)
+	}
+
+	// Buffers larger than the max must be dropped
+	large := strings.Repeat("// large comment\n", 1024) + "\npackage p\n"
+	if _, err := readComments(strings.NewReader(large)); err != nil {
+		t.Fatal(err)
+	}
+	after := ReaderPoolMetrics()
+	if after.Dropped <= stats.Dropped {
+		t.Errorf("Dropped = %d; want: > %d", after.Dropped, stats.Dropped)
+	}
+	if after.MaxRetained < before.MaxRetained {
+		t.Errorf("MaxRetained decreased: %d < %d", after.MaxRetained, before.MaxRetained)
+	}
+}
+
+func TestMaxHeaderSize(t *testing.T) {
+	const limit = 256
+	prev := SetMaxHeaderSize(limit)
+	t.Cleanup(func() { SetMaxHeaderSize(prev) })
+	if prev != 0 {
+		t.Errorf("SetMaxHeaderSize = %d; want: %d", prev, 0)
+	}
+
+	small := "// small\n\npackage p\n\nimport \"fmt\"\n"
+	large := strings.Repeat("// large comment\n", 1024) + "\npackage p\n"
+	readers := map[string]func(io.Reader) error{
+		"ReadImportsFast": func(r io.Reader) error {
+			_, err := ReadImportsFast(r)
+			return err
+		},
+		"ReadComments": func(r io.Reader) error {
+			_, _, err := ReadComments(r)
+			return err
+		},
+		"readImportsMatch": func(r io.Reader) error {
+			_, err := readImportsMatch(r)
+			return err
+		},
+		"readGoInfo": func(r io.Reader) error {
+			return readGoInfo(r, &fileInfo{name: "dummy.go"})
+		},
+	}
+	for name, fn := range readers {
+		if err := fn(strings.NewReader(small)); err != nil {
+			t.Errorf("%s(small): unexpected error: %v", name, err)
+		}
+		err := fn(strings.NewReader(large))
+		var herr *HeaderTooLargeError
+		if !errors.As(err, &herr) || herr.Limit != limit {
+			t.Errorf("%s(large) = %v; want: %T with Limit %d", name, err, herr, limit)
+		}
+	}
+
+	// Files larger than the limit are fine if the header is small
+	src := small + strings.Repeat("// trailing comment\n", 1024)
+	if _, err := ReadImportsFast(strings.NewReader(src)); err != nil {
+		t.Errorf("ReadImportsFast: unexpected error: %v", err)
+	}
+	if _, err := MatchContext(nil, "/p/large.go", []byte(large)); err == nil {
+		t.Error("MatchContext: expected an error for a header larger than the limit")
+	}
+}
+
+func TestReadImportsFast(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		{"package p\n\nimport \"fmt\"\n", "package p\n", false},
+		{"//go:build linux\n\npackage p\nfunc main() {}\n", "//go:build linux\n\npackage p\n", false},
+		{"// comment\n\npackage p", "// comment\n\npackage p", false},
+		{"", "", false},
+		{"// no package clause\n\nfunc main() {}\n", "// no package clause\n\nfu", false},
+		{"package p\x00", "package p\x00", true},
+	}
+	for _, test := range tests {
+		data, err := ReadImportsFast(strings.NewReader(test.in))
+		if (err != nil) != test.err {
+			t.Errorf("ReadImportsFast(%q): error = %v; want error: %t", test.in, err, test.err)
+		}
+		if string(data) != test.want {
+			t.Errorf("ReadImportsFast(%q) = %q; want: %q", test.in, data, test.want)
+		}
+	}
+
+	// Read errors must be returned
+	if _, err := ReadImportsFast(io.MultiReader(strings.NewReader("// x\n"),
+		errReader{io.ErrClosedPipe})); err != io.ErrClosedPipe {
+		t.Errorf("ReadImportsFast: error = %v; want: %v", err, io.ErrClosedPipe)
+	}
+}
+
+func TestReadImportsMatch(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		{"package p\n\nimport \"fmt\"\n\nfunc F() {}\n", "package p\n\nimport \"fmt\"\n\n", false},
+		{"package p\n\nimport (\n\t\"fmt\"\n\tjs \"syscall/js\"\n)\n", "package p\n\nimport (\n\t\"fmt\"\n\tjs \"syscall/js\"\n)\n", false},
+		{"//go:build linux\n\npackage p\nfunc main() {}\n", "//go:build linux\n\npackage p\n", false},
+		{"package p\n\nimport (\n\t\"fmt\"\n", "package p\n", false},
+		{"package p", "package p", false},
+		{"", "", true},
+		{"package p\nimport \"a\x00\"\n", "package p\nimport \"a\x00", true},
+	}
+	for _, test := range tests {
+		data, err := readImportsMatch(strings.NewReader(test.in))
+		if (err != nil) != test.err {
+			t.Errorf("readImportsMatch(%q): error = %v; want error: %t", test.in, err, test.err)
+		}
+		if string(data) != test.want {
+			t.Errorf("readImportsMatch(%q) = %q; want: %q", test.in, data, test.want)
+		}
+	}
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestReadCommentsOffset(t *testing.T) {
+	tests := []struct {
+		in, want string
+		offset   int
+		err      bool
+	}{
+		{"package p\n", "", 0, false},
+		{"// Copyright\n\n// License\n\npackage p\n", "// Copyright\n\n// License", 26, false},
+		{"/* block\n * comment */\npackage p", "/* block\n * comment */", 23, false},
+		{"\n\n  // indented\n\t\nfunc main() {}\n", "\n\n  // indented", 18, false},
+		{"// only comments\n\n", "// only comments", -1, false},
+		{"", "", -1, false},
+		{"/* unterminated", "/* unterminated", -1, false},
+		{"// x\n\x00", "// x\n\x00", -1, true},
+	}
+	for _, test := range tests {
+		data, offset, err := ReadComments(strings.NewReader(test.in))
+		if (err != nil) != test.err {
+			t.Errorf("ReadComments(%q): error = %v; want error: %t", test.in, err, test.err)
+		}
+		if string(data) != test.want || offset != test.offset {
+			t.Errorf("ReadComments(%q) = %q, %d; want: %q, %d", test.in, data, offset,
+				test.want, test.offset)
+		}
+		if offset >= 0 && !strings.HasPrefix(test.in[offset:], "package") &&
+			!strings.HasPrefix(test.in[offset:], "func") {
+			t.Errorf("ReadComments(%q): invalid offset: %d", test.in, offset)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package buildutil
+
+import (
+	"bytes"
+	"go/ast"
+	"go/build/constraint"
+	"go/format"
+	"go/parser"
+	"go/token"
+)
+
+// StripToConstraints returns the package clause of the Go source file src
+// and its build constraints ("//go:build" and "// +build" lines). All other
+// comments and everything after the package clause are removed. The result
+// is formatted with gofmt, which adds a "//go:build" line if the file only
+// has "// +build" lines.
+//
+// StripToConstraints is useful for generating corpora and test fixtures of
+// build constraints from real source files without copying their contents.
+func StripToConstraints(src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	af, err := parser.ParseFile(fset, "", src, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	// Remove non-build directive comments
+	if len(af.Comments) != 0 {
+		a := af.Comments[:0]
+		for _, g := range af.Comments {
+			if hasBuildDirective(g) {
+				a = append(a, g)
+			}
+		}
+		af.Comments = a
+	}
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, af); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func hasBuildDirective(g *ast.CommentGroup) bool {
+	if g == nil {
+		return false
+	}
+	for _, c := range g.List {
+		if constraint.IsGoBuild(c.Text) || constraint.IsPlusBuild(c.Text) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package buildutil
+
+import (
+	"testing"
+)
+
+func TestStripToConstraints(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{
+			src:  "package p\n",
+			want: "package p\n",
+		},
+		{
+			src: `// Copyright 2022 The Go Authors.
+
+//go:build linux && !cgo
+// +build linux,!cgo
+
+// Package p does things.
+package p
+
+import "fmt"
+
+func main() { fmt.Println("hello") }
+`,
+			want: "//go:build linux && !cgo\n// +build linux,!cgo\n\npackage p\n",
+		},
+		{
+			// gofmt adds the missing "//go:build" line
+			src:  "// +build ignore\n\n/* block */\npackage main // comment\n\nvar x = 1\n",
+			want: "//go:build ignore\n// +build ignore\n\npackage main\n",
+		},
+	}
+	for _, x := range tests {
+		got, err := StripToConstraints([]byte(x.src))
+		if err != nil {
+			t.Errorf("StripToConstraints(%q): %v", x.src, err)
+			continue
+		}
+		if string(got) != x.want {
+			t.Errorf("StripToConstraints(%q) = %q; want: %q", x.src, got, x.want)
+		}
+	}
+
+	if _, err := StripToConstraints([]byte("//go:build linux\n\nfunc main() {}\n")); err == nil {
+		t.Error("StripToConstraints: expected error for missing package clause")
+	}
+}
@@ -23,6 +23,7 @@ var knownOS = map[string]bool{
 	"openbsd":   true,
 	"plan9":     true,
 	"solaris":   true,
+	"wasip1":    true,
 	"windows":   true,
 	"zos":       true,
 }
@@ -90,6 +91,7 @@ var knownOSList = []string{
 	"openbsd",
 	"plan9",
 	"solaris",
+	"wasip1",
 	"windows",
 	"zos",
 }
@@ -1,6 +1,7 @@
 package buildutil
 
 import (
+	"go/build"
 	"reflect"
 	"sort"
 	"testing"
@@ -33,3 +34,54 @@ func TestKnownArchList(t *testing.T) {
 		t.Errorf("knownArchList = %q; want: %q", knownArchList, want)
 	}
 }
+
+func TestKnownReleaseTags(t *testing.T) {
+	tags := KnownReleaseTags(nil)
+	if !reflect.DeepEqual(tags, build.Default.ReleaseTags) {
+		t.Errorf("KnownReleaseTags(nil) = %q; want: %q", tags, build.Default.ReleaseTags)
+	}
+	tags[0] = "modified"
+	if build.Default.ReleaseTags[0] == "modified" {
+		t.Fatal("KnownReleaseTags must return a copy")
+	}
+	ctxt := build.Default
+	ctxt.ReleaseTags = []string{"go1.1", "go1.2"}
+	if tags := KnownReleaseTags(&ctxt); !reflect.DeepEqual(tags, ctxt.ReleaseTags) {
+		t.Errorf("KnownReleaseTags(ctxt) = %q; want: %q", tags, ctxt.ReleaseTags)
+	}
+}
+
+func TestIsReleaseTag(t *testing.T) {
+	std := &build.Context{ReleaseTags: []string{"go1.1", "go1.2", "go1.3"}}
+	odd := &build.Context{ReleaseTags: []string{"go1.3", "go1.1"}}
+	tests := []struct {
+		ctxt *build.Context
+		tag  string
+		want bool
+	}{
+		{nil, "go1.1", true},
+		{nil, build.Default.ReleaseTags[len(build.Default.ReleaseTags)-1], true},
+		{nil, "go1.1000", false},
+		{nil, "go1", false},
+		{std, "go1.1", true},
+		{std, "go1.3", true},
+		{std, "go1.4", false},
+		{std, "go1.0", false},
+		{std, "go1.01", false},
+		{std, "go2.1", false},
+		{std, "linux", false},
+		{odd, "go1.3", true},
+		{odd, "go1.1", true},
+		{odd, "go1.2", false},
+		{&build.Context{}, "go1.1", false},
+	}
+	for _, test := range tests {
+		var tags []string
+		if test.ctxt != nil {
+			tags = test.ctxt.ReleaseTags
+		}
+		if got := IsReleaseTag(test.ctxt, test.tag); got != test.want {
+			t.Errorf("IsReleaseTag(%q, %q) = %t; want: %t", tags, test.tag, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package buildutil
+
+import (
+	"go/build"
+	"strconv"
+
+	"github.com/charlievieth/buildutil/internal/util"
+)
+
+// A TagKind classifies a build tag by what sets it.
+type TagKind int
+
+const (
+	// TagUserDefined is a tag that is only set by the BuildTags of a
+	// Context (the -tags flag of the go command).
+	TagUserDefined TagKind = iota
+
+	// TagOS is a GOOS value or the "unix" tag.
+	TagOS
+
+	// TagArch is a GOARCH value or an architecture feature level
+	// (e.g. "amd64.v2").
+	TagArch
+
+	// TagCompiler is the "gc" or "gccgo" tag or the Compiler of the Context.
+	TagCompiler
+
+	// TagRelease is a Go release tag (e.g. "go1.21").
+	TagRelease
+
+	// TagGoExperiment is a GOEXPERIMENT tag (e.g. "goexperiment.arenas") or
+	// "boringcrypto", which is an old name for "goexperiment.boringcrypto".
+	TagGoExperiment
+
+	// TagCgo is the "cgo" tag.
+	TagCgo
+
+	// TagSanitizer is one of the "race", "msan" or "asan" tags, which are set
+	// by the sanitizer flags of the go command.
+	TagSanitizer
+
+	// TagTool is any other tool tag of the Context.
+	TagTool
+)
+
+var tagKindNames = [...]string{
+	TagUserDefined:  "user",
+	TagOS:           "os",
+	TagArch:         "arch",
+	TagCompiler:     "compiler",
+	TagRelease:      "release",
+	TagGoExperiment: "goexperiment",
+	TagCgo:          "cgo",
+	TagSanitizer:    "sanitizer",
+	TagTool:         "tool",
+}
+
+func (k TagKind) String() string {
+	if 0 <= k && int(k) < len(tagKindNames) {
+		return tagKindNames[k]
+	}
+	return "TagKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// ClassifyTag returns the kind of the build tag. Tags that are not set by the
+// platform, toolchain or release of ctxt are TagUserDefined, these are the
+// only tags that MatchContext adds to the BuildTags of a Context. A nil ctxt
+// only classifies the tags known to this package.
+func ClassifyTag(ctxt *build.Context, tag string) TagKind {
+	switch {
+	case tag == "cgo":
+		return TagCgo
+	case tag == "gc" || tag == "gccgo" || ctxt != nil && tag == ctxt.Compiler:
+		return TagCompiler
+	case knownOS[tag] || tag == "unix":
+		return TagOS
+	case knownArch[tag] || isArchFeatureTag(tag):
+		return TagArch
+	case sanitizerTags[tag]:
+		return TagSanitizer
+	case isGoExperimentTag(tag) || tag == "boringcrypto":
+		return TagGoExperiment
+	case isGoReleaseTag(tag) || IsReleaseTag(ctxt, tag):
+		return TagRelease
+	case ctxt != nil && util.StringsContains(ctxt.ToolTags, tag):
+		return TagTool
+	}
+	return TagUserDefined
+}
+
+// isArchFeatureTag reports if tag is an architecture feature level tag such
+// as "amd64.v2" or "arm.7".
+func isArchFeatureTag(tag string) bool {
+	arch, level, ok := cut(tag, ".")
+	return ok && knownArch[arch] && level != ""
+}
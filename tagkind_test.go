@@ -0,0 +1,42 @@
+package buildutil
+
+import (
+	"go/build"
+	"testing"
+)
+
+func TestClassifyTag(t *testing.T) {
+	ctxt := build.Default
+	ctxt.ToolTags = []string{"amd64.v1", "mytool"}
+	tests := []struct {
+		tag  string
+		want TagKind
+	}{
+		{"linux", TagOS},
+		{"unix", TagOS},
+		{"amd64", TagArch},
+		{"amd64.v2", TagArch},
+		{"gc", TagCompiler},
+		{"gccgo", TagCompiler},
+		{"go1.1", TagRelease},
+		{"go1.21", TagRelease},
+		{"go1.21.3", TagUserDefined},
+		{"goexperiment.arenas", TagGoExperiment},
+		{"boringcrypto", TagGoExperiment},
+		{"cgo", TagCgo},
+		{"race", TagSanitizer},
+		{"msan", TagSanitizer},
+		{"asan", TagSanitizer},
+		{"mytool", TagTool},
+		{"integration", TagUserDefined},
+		{"purego", TagUserDefined},
+	}
+	for _, x := range tests {
+		if got := ClassifyTag(&ctxt, x.tag); got != x.want {
+			t.Errorf("ClassifyTag(%q) = %s; want: %s", x.tag, got, x.want)
+		}
+	}
+	if got := ClassifyTag(nil, "mytool"); got != TagUserDefined {
+		t.Errorf("ClassifyTag(nil, %q) = %s; want: %s", "mytool", got, TagUserDefined)
+	}
+}
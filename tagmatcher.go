@@ -0,0 +1,80 @@
+package buildutil
+
+import (
+	"go/build"
+	"go/build/constraint"
+	"strings"
+)
+
+// A TagMatcher matches build tags against a build.Context using the same
+// semantics as go/build and the rest of this package. This includes the
+// cgo and compiler tags, the BuildTags, ToolTags and ReleaseTags of the
+// Context and tags implied by the OS (e.g. "linux" for android and "unix"
+// for unix-like OSes).
+//
+// A TagMatcher is safe for concurrent use if AllTags is nil.
+type TagMatcher struct {
+	// AllTags, if non-nil, records all the tags consulted by Match
+	// and Eval.
+	AllTags map[string]bool
+
+	ctxt *build.Context
+}
+
+// NewTagMatcher returns a TagMatcher for ctxt. The TagMatcher uses a copy of
+// ctxt so subsequent changes to ctxt are not reflected by the TagMatcher.
+// If ctxt is nil build.Default is used.
+func NewTagMatcher(ctxt *build.Context) *TagMatcher {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	return &TagMatcher{ctxt: CopyContext(ctxt)}
+}
+
+// Match reports if the build tag is satisfied by the Context.
+func (m *TagMatcher) Match(tag string) bool {
+	return matchTag(m.ctxt, tag, m.AllTags)
+}
+
+// Eval reports if the build constraint x is satisfied by the Context.
+func (m *TagMatcher) Eval(x constraint.Expr) bool {
+	return eval(m.ctxt, x, m.AllTags)
+}
+
+// MatchTagFor reports if the build tag is satisfied by ctxt using the tag
+// matching rules of Go release version (e.g. "go1.18" or "1.18.2"), which
+// allows tools analyzing code for older toolchains to get historically
+// correct answers. For example, the "unix" tag is only matched by go1.19
+// and later. If version is empty, the release is the newest of the
+// ReleaseTags of ctxt. If the release cannot be determined, the rules of
+// the Go toolchain this package was built with are used.
+func MatchTagFor(version string, ctxt *build.Context, tag string) bool {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	go119 := matchUnixAndBoringCrypto
+	if v, ok := releaseVersion(version, ctxt); ok {
+		go119 = !v.Less(GoRelease{Major: 1, Minor: 19})
+	}
+	return matchTagRules(ctxt, tag, nil, go119)
+}
+
+// releaseVersion returns the Go release version (with or without the "go"
+// prefix), or the newest release tag of ctxt if version is empty.
+func releaseVersion(version string, ctxt *build.Context) (GoRelease, bool) {
+	if version == "" {
+		var newest GoRelease
+		found := false
+		for _, tag := range ctxt.ReleaseTags {
+			if v, ok := ParseGoVersionTag(tag); ok && (!found || newest.Less(v)) {
+				newest = v
+				found = true
+			}
+		}
+		return newest, found
+	}
+	if !strings.HasPrefix(version, "go") {
+		version = "go" + version
+	}
+	return ParseGoVersionTag(version)
+}
@@ -0,0 +1,100 @@
+package buildutil
+
+import (
+	"go/build"
+	"go/build/constraint"
+	"reflect"
+	"testing"
+)
+
+func TestTagMatcher(t *testing.T) {
+	ctxt := build.Default
+	ctxt.GOOS = "android"
+	ctxt.GOARCH = "arm64"
+	ctxt.CgoEnabled = true
+	ctxt.Compiler = "gc"
+	ctxt.BuildTags = []string{"tag1"}
+	ctxt.ToolTags = []string{"goexperiment.foo"}
+	ctxt.ReleaseTags = []string{"go1.1", "go1.2"}
+
+	m := NewTagMatcher(&ctxt)
+
+	// Changes to the Context must not affect the TagMatcher
+	ctxt.BuildTags[0] = "changed"
+	ctxt.GOOS = "windows"
+
+	tests := map[string]bool{
+		"android":          true,
+		"linux":            true,
+		"unix":             matchUnixAndBoringCrypto,
+		"windows":          false,
+		"arm64":            true,
+		"amd64":            false,
+		"cgo":              true,
+		"gc":               true,
+		"gccgo":            false,
+		"tag1":             true,
+		"changed":          false,
+		"goexperiment.foo": true,
+		"go1.2":            true,
+		"go1.3":            false,
+	}
+	for tag, want := range tests {
+		if got := m.Match(tag); got != want {
+			t.Errorf("Match(%q) = %t; want: %t", tag, got, want)
+		}
+	}
+
+	m.AllTags = make(map[string]bool)
+	x, err := constraint.Parse("//go:build linux && (tag1 || tag2) && !cgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Eval(x) {
+		t.Errorf("Eval(%q) = %t; want: %t", x, true, false)
+	}
+	want := map[string]bool{"linux": true, "tag1": true, "tag2": true, "cgo": true}
+	if !reflect.DeepEqual(m.AllTags, want) {
+		t.Errorf("AllTags = %v; want: %v", m.AllTags, want)
+	}
+
+	if m := NewTagMatcher(nil); m.Match(build.Default.GOOS) != true {
+		t.Errorf("NewTagMatcher(nil).Match(%q) = %t; want: %t", build.Default.GOOS, false, true)
+	}
+}
+
+func TestMatchTagFor(t *testing.T) {
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "amd64"
+	ctxt.ToolTags = []string{"goexperiment.boringcrypto"}
+	ctxt.ReleaseTags = []string{"go1.1", "go1.17", "go1.18"}
+
+	tests := []struct {
+		version string
+		tag     string
+		want    bool
+	}{
+		{"go1.18", "unix", false},
+		{"go1.19", "unix", true},
+		{"1.19.3", "unix", true},
+		{"go1.20", "unix", true},
+		{"go2.0", "unix", true},
+		{"go1.18", "boringcrypto", false},
+		{"go1.19", "boringcrypto", true},
+		{"", "unix", false}, // go1.18 from ReleaseTags
+		{"go1.18", "linux", true},
+		{"go1.18", "goexperiment.boringcrypto", true},
+		{"invalid", "unix", matchUnixAndBoringCrypto},
+	}
+	for _, x := range tests {
+		if got := MatchTagFor(x.version, &ctxt, x.tag); got != x.want {
+			t.Errorf("MatchTagFor(%q, ctxt, %q) = %t; want: %t", x.version, x.tag, got, x.want)
+		}
+	}
+
+	ctxt.ReleaseTags = append(ctxt.ReleaseTags, "go1.19")
+	if !MatchTagFor("", &ctxt, "unix") {
+		t.Errorf("MatchTagFor(%q, ctxt, %q) = %t; want: %t", "", "unix", false, true)
+	}
+}
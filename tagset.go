@@ -0,0 +1,181 @@
+package buildutil
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A TagSource identifies where a build tag came from. When sources disagree
+// about a tag, the source with the greater value takes precedence.
+type TagSource int
+
+const (
+	// TagSourceEnv is the "-tags" flag of the GOFLAGS environment variable.
+	TagSourceEnv TagSource = iota
+
+	// TagSourceArgs is the "-tags" flag of the command-line arguments.
+	TagSourceArgs
+
+	// TagSourceEditor is the BuildTags of the build.Context, which are
+	// typically from the settings of an editor.
+	TagSourceEditor
+
+	// TagSourceProject is the BuildTags of the ProjectConfigFile.
+	TagSourceProject
+
+	// TagSourceMatch is the tags added or removed by MatchContext.
+	TagSourceMatch
+)
+
+var tagSourceNames = [...]string{
+	TagSourceEnv:     "GOFLAGS",
+	TagSourceArgs:    "arguments",
+	TagSourceEditor:  "editor",
+	TagSourceProject: "project config",
+	TagSourceMatch:   "MatchContext",
+}
+
+func (s TagSource) String() string {
+	if 0 <= s && int(s) < len(tagSourceNames) {
+		return tagSourceNames[s]
+	}
+	return "TagSource(" + strconv.Itoa(int(s)) + ")"
+}
+
+// A TagOrigin records that a build tag was added, or removed, by a source.
+type TagOrigin struct {
+	Tag     string
+	Removed bool // the tag was negated ("!tag")
+	Source  TagSource
+}
+
+// A TagConflict is a build tag that is added by one source and removed by
+// another. The tag is set if Added takes precedence over Removed.
+type TagConflict struct {
+	Tag     string
+	Added   TagSource // highest precedence source that added the tag
+	Removed TagSource // highest precedence source that removed the tag
+}
+
+func (c TagConflict) String() string {
+	if c.Added == c.Removed {
+		return "build tag " + strconv.Quote(c.Tag) + " is both added and removed by " +
+			c.Added.String()
+	}
+	winner := c.Added
+	if c.Removed > c.Added {
+		winner = c.Removed
+	}
+	return "build tag " + strconv.Quote(c.Tag) + " is added by " + c.Added.String() +
+		" and removed by " + c.Removed.String() + ": using " + winner.String()
+}
+
+// A TagSet accumulates build tags from several sources, records where each
+// tag came from and renders the final list of tags. Instead of the last
+// source silently overriding the others, sources are ranked by precedence
+// (see TagSource) and a tag that one source adds and another removes is
+// reported by Conflicts.
+//
+// The zero value is an empty set ready to use.
+type TagSet struct {
+	origins []TagOrigin
+}
+
+// Add adds tags from source src to the set. A negated tag ("!tag") records
+// that src removes the tag. Empty tags are ignored.
+func (s *TagSet) Add(src TagSource, tags ...string) {
+	for _, tag := range tags {
+		removed := strings.HasPrefix(tag, "!")
+		if removed {
+			tag = tag[1:]
+		}
+		if tag != "" {
+			s.origins = append(s.origins, TagOrigin{Tag: tag, Removed: removed, Source: src})
+		}
+	}
+}
+
+// AddGoFlags adds the tags of the "-tags" flag in the value of a GOFLAGS
+// environment variable, if any, from source src.
+func (s *TagSet) AddGoFlags(src TagSource, goflags string) {
+	s.Add(src, ExtractTagArgs(strings.Fields(goflags))...)
+}
+
+// AddDiff adds the tags added by diff, and records the tags removed by it,
+// from source src (e.g. the changes made by MatchContext).
+func (s *TagSet) AddDiff(src TagSource, diff *ContextDiff) {
+	if diff == nil {
+		return
+	}
+	s.Add(src, diff.TagsAdded...)
+	for _, tag := range diff.TagsRemoved {
+		s.Add(src, "!"+tag)
+	}
+}
+
+// Origins returns where tag was added or removed, in the order the sources
+// were added to the set.
+func (s *TagSet) Origins(tag string) []TagOrigin {
+	var a []TagOrigin
+	for _, o := range s.origins {
+		if o.Tag == tag {
+			a = append(a, o)
+		}
+	}
+	return a
+}
+
+// resolve returns the origin with the highest precedence for each tag,
+// ordered by when they were added. Ties are won by the origin that was
+// added last.
+func (s *TagSet) resolve() []TagOrigin {
+	winners := make(map[string]int, len(s.origins))
+	for i, o := range s.origins {
+		if j, ok := winners[o.Tag]; !ok || o.Source >= s.origins[j].Source {
+			winners[o.Tag] = i
+		}
+	}
+	var a []TagOrigin
+	for i, o := range s.origins {
+		if winners[o.Tag] == i {
+			a = append(a, o)
+		}
+	}
+	return a
+}
+
+// Tags returns the build tags that are set, or nil if there are none. The
+// tags are ordered by when the source that set them was added, so merging
+// the tags of a lower precedence source with those of a higher one is the
+// same as MergeTagArgs.
+func (s *TagSet) Tags() []string {
+	var tags []string
+	for _, o := range s.resolve() {
+		if !o.Removed {
+			tags = append(tags, o.Tag)
+		}
+	}
+	return tags
+}
+
+// Conflicts returns the tags that are both added and removed, in the same
+// order as Tags.
+func (s *TagSet) Conflicts() []TagConflict {
+	var conflicts []TagConflict
+	for _, r := range s.resolve() {
+		c := TagConflict{Tag: r.Tag, Added: -1, Removed: -1}
+		for _, o := range s.origins {
+			switch {
+			case o.Tag != r.Tag:
+			case o.Removed && o.Source > c.Removed:
+				c.Removed = o.Source
+			case !o.Removed && o.Source > c.Added:
+				c.Added = o.Source
+			}
+		}
+		if c.Added != -1 && c.Removed != -1 {
+			conflicts = append(conflicts, c)
+		}
+	}
+	return conflicts
+}
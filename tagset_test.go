@@ -0,0 +1,88 @@
+package buildutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTagSet(t *testing.T) {
+	var ts TagSet
+	ts.AddGoFlags(TagSourceEnv, "-mod=mod -tags=a,purego,b")
+	ts.Add(TagSourceArgs, "c", "!b", "")
+	ts.Add(TagSourceEditor, "a", "d")
+	ts.Add(TagSourceProject, "!purego")
+	ts.AddDiff(TagSourceMatch, &ContextDiff{TagsAdded: []string{"b"}, TagsRemoved: []string{"d"}})
+
+	if got, want := ts.Tags(), []string{"c", "a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Tags() = %q; want: %q", got, want)
+	}
+
+	wantConflicts := []TagConflict{
+		{Tag: "purego", Added: TagSourceEnv, Removed: TagSourceProject},
+		{Tag: "b", Added: TagSourceMatch, Removed: TagSourceArgs},
+		{Tag: "d", Added: TagSourceEditor, Removed: TagSourceMatch},
+	}
+	if got := ts.Conflicts(); !reflect.DeepEqual(got, wantConflicts) {
+		t.Errorf("Conflicts() = %+v; want: %+v", got, wantConflicts)
+	}
+
+	wantOrigins := []TagOrigin{
+		{Tag: "b", Source: TagSourceEnv},
+		{Tag: "b", Removed: true, Source: TagSourceArgs},
+		{Tag: "b", Source: TagSourceMatch},
+	}
+	if got := ts.Origins("b"); !reflect.DeepEqual(got, wantOrigins) {
+		t.Errorf("Origins(%q) = %+v; want: %+v", "b", got, wantOrigins)
+	}
+
+	var empty TagSet
+	if tags := empty.Tags(); tags != nil {
+		t.Errorf("Tags() = %q; want: nil", tags)
+	}
+}
+
+func TestTagSetMergeTagArgs(t *testing.T) {
+	tests := []struct {
+		old, new []string
+	}{
+		{[]string{"a", "b"}, []string{"b", "c"}},
+		{[]string{"tag3", "!tag2"}, []string{"tag1", "tag2"}},
+		{nil, []string{"a"}},
+		{[]string{"a"}, nil},
+	}
+	for _, x := range tests {
+		var ts TagSet
+		ts.Add(TagSourceArgs, x.old...)
+		ts.Add(TagSourceEditor, x.new...)
+		got := ts.Tags()
+		want := MergeTagArgs(x.old, x.new)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("TagSet(%q, %q) = %q; MergeTagArgs: %q", x.old, x.new, got, want)
+		}
+	}
+}
+
+func TestTagConflictString(t *testing.T) {
+	tests := []struct {
+		c    TagConflict
+		want string
+	}{
+		{
+			TagConflict{Tag: "purego", Added: TagSourceEnv, Removed: TagSourceProject},
+			`build tag "purego" is added by GOFLAGS and removed by project config: using project config`,
+		},
+		{
+			TagConflict{Tag: "foo", Added: TagSourceMatch, Removed: TagSourceArgs},
+			`build tag "foo" is added by MatchContext and removed by arguments: using MatchContext`,
+		},
+		{
+			TagConflict{Tag: "foo", Added: TagSourceArgs, Removed: TagSourceArgs},
+			`build tag "foo" is both added and removed by arguments`,
+		},
+	}
+	for _, x := range tests {
+		if got := x.c.String(); got != x.want {
+			t.Errorf("%+v.String() = %q; want: %q", x.c, got, x.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package buildutil
+
+import (
+	"fmt"
+	"go/build"
+	"path/filepath"
+	"strings"
+)
+
+// A Problem describes an impossible or suspicious build.Context
+// configuration found by ValidateContext.
+type Problem struct {
+	Field   string // build.Context field the problem relates to (e.g. "GOOS")
+	Message string // description of the problem
+}
+
+func (p Problem) String() string { return p.Field + ": " + p.Message }
+
+// ValidateContext reports any impossible configurations of build.Context
+// ctxt, such as an unsupported GOOS/GOARCH combination or cgo being enabled
+// on a platform that does not support it. MatchContext and GoCommand will
+// silently produce incorrect results for Contexts that have problems.
+//
+// A nil or empty result means that no problems were found.
+func ValidateContext(ctxt *build.Context) []Problem {
+	var problems []Problem
+	add := func(field, format string, args ...interface{}) {
+		problems = append(problems, Problem{
+			Field:   field,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if ctxt.GOOS != "" && !knownOS[ctxt.GOOS] {
+		add("GOOS", "unknown operating system: %q", ctxt.GOOS)
+	}
+	if ctxt.GOARCH != "" && !knownArch[ctxt.GOARCH] {
+		add("GOARCH", "unknown architecture: %q", ctxt.GOARCH)
+	}
+	platform := ctxt.GOOS + "/" + ctxt.GOARCH
+	if arches, ok := supportedPlatformsOsArch[ctxt.GOOS]; ok && ctxt.GOARCH != "" && !arches[ctxt.GOARCH] {
+		add("GOARCH", "unsupported GOOS/GOARCH pair: %s", platform)
+	} else if ctxt.CgoEnabled && ok && !cgoEnabled[platform] {
+		add("CgoEnabled", "cgo is not supported on: %s", platform)
+	}
+
+	if ctxt.GOPATH != "" {
+		goroot := filepath.Clean(ctxt.GOROOT)
+		for _, p := range splitPathList(ctxt, ctxt.GOPATH) {
+			if p == "" {
+				continue
+			}
+			if strings.HasPrefix(p, "~") {
+				add("GOPATH", "entry %q starts with an unexpanded '~'", p)
+				continue
+			}
+			if ctxt.GOROOT == "" {
+				continue
+			}
+			p = filepath.Clean(p)
+			if p == goroot || isSubdir(p, goroot) {
+				add("GOPATH", "entry %q contains GOROOT %q", p, ctxt.GOROOT)
+			}
+		}
+	}
+
+	var prev GoRelease
+	for _, tag := range ctxt.ReleaseTags {
+		v, hasPatch, ok := parseGoVersion(tag)
+		if !ok || hasPatch {
+			add("ReleaseTags", "invalid release tag: %q", tag)
+			continue
+		}
+		if !prev.Less(v) {
+			add("ReleaseTags", "release tag %q is out of order", tag)
+			continue
+		}
+		prev = v
+	}
+
+	return problems
+}
+
+// parseReleaseTagMinor returns the minor version of the release tag
+// "go1.N" (N).
+func parseReleaseTagMinor(tag string) (int, bool) {
+	v, hasPatch, ok := parseGoVersion(tag)
+	if !ok || hasPatch || v.Major != 1 {
+		return 0, false
+	}
+	return v.Minor, true
+}
@@ -0,0 +1,113 @@
+package buildutil
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateContext(t *testing.T) {
+	if problems := ValidateContext(&build.Default); len(problems) != 0 {
+		t.Errorf("build.Default: unexpected problems: %q", problems)
+	}
+
+	goroot := filepath.Clean("/usr/local/go")
+	sep := string(os.PathListSeparator)
+	tests := []struct {
+		name   string
+		update func(ctxt *build.Context)
+		fields []string
+	}{
+		{
+			name:   "Valid",
+			update: func(ctxt *build.Context) {},
+		},
+		{
+			name: "UnknownOS",
+			update: func(ctxt *build.Context) {
+				ctxt.GOOS = "not-an-os"
+			},
+			fields: []string{"GOOS"},
+		},
+		{
+			name: "UnsupportedPair",
+			update: func(ctxt *build.Context) {
+				ctxt.GOOS = "darwin"
+				ctxt.GOARCH = "386"
+			},
+			fields: []string{"GOARCH"},
+		},
+		{
+			name: "CgoNotSupported",
+			update: func(ctxt *build.Context) {
+				ctxt.GOOS = "js"
+				ctxt.GOARCH = "wasm"
+				ctxt.CgoEnabled = true
+			},
+			fields: []string{"CgoEnabled"},
+		},
+		{
+			name: "GopathContainsGoroot",
+			update: func(ctxt *build.Context) {
+				ctxt.GOPATH = filepath.Clean("/go") + sep + goroot
+			},
+			fields: []string{"GOPATH"},
+		},
+		{
+			name: "GopathParentOfGoroot",
+			update: func(ctxt *build.Context) {
+				ctxt.GOPATH = filepath.Dir(goroot)
+			},
+			fields: []string{"GOPATH"},
+		},
+		{
+			name: "GopathTilde",
+			update: func(ctxt *build.Context) {
+				ctxt.GOPATH = "~/go"
+			},
+			fields: []string{"GOPATH"},
+		},
+		{
+			name: "ReleaseTagsOrder",
+			update: func(ctxt *build.Context) {
+				ctxt.ReleaseTags = []string{"go1.1", "go1.3", "go1.2"}
+			},
+			fields: []string{"ReleaseTags"},
+		},
+		{
+			name: "ReleaseTagsInvalid",
+			update: func(ctxt *build.Context) {
+				ctxt.ReleaseTags = []string{"go1.1", "foo"}
+			},
+			fields: []string{"ReleaseTags"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctxt := build.Context{
+				GOOS:        "linux",
+				GOARCH:      "amd64",
+				GOROOT:      goroot,
+				GOPATH:      filepath.Clean("/go"),
+				CgoEnabled:  true,
+				Compiler:    "gc",
+				ReleaseTags: []string{"go1.1", "go1.2", "go1.3"},
+			}
+			test.update(&ctxt)
+			problems := ValidateContext(&ctxt)
+			var fields []string
+			for _, p := range problems {
+				fields = append(fields, p.Field)
+			}
+			if len(fields) != len(test.fields) {
+				t.Fatalf("got: %q want fields: %q", problems, test.fields)
+			}
+			for i := range fields {
+				if fields[i] != test.fields[i] {
+					t.Errorf("got: %q want fields: %q", problems, test.fields)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package buildutil
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/build"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/charlievieth/buildutil/internal/fsys"
+	"github.com/charlievieth/buildutil/internal/util"
+)
+
+// ErrMaxFiles is returned when a walk is stopped because the MaxFiles limit
+// of its WalkPolicy was reached.
+var ErrMaxFiles = errors.New("buildutil: walk stopped: too many files")
+
+// A WalkPolicy configures how a source tree is walked. It is shared by the
+// functions and commands that walk source trees so that they skip the same
+// directories and files.
+type WalkPolicy struct {
+	// IgnoreDirs are the directories that are skipped.
+	IgnoreDirs IgnoreDirPolicy
+
+	// SkipDirs are the base names of additional directories to skip.
+	SkipDirs []string
+
+	// FollowSymlinks follows symbolic links to directories. Directories
+	// that were already visited are not walked again.
+	FollowSymlinks bool
+
+	// SkipTests skips "_test.go" files.
+	SkipTests bool
+
+	// MaxFiles is the maximum number of Go files visited. If the limit is
+	// exceeded the walk is stopped with ErrMaxFiles. Zero means no limit.
+	MaxFiles int
+}
+
+// DefaultWalkPolicy returns the default WalkPolicy, which skips the
+// directories ignored by IsIgnoredDir.
+func DefaultWalkPolicy() *WalkPolicy {
+	return &WalkPolicy{IgnoreDirs: DefaultIgnoreDirPolicy}
+}
+
+// SkipDir reports if the directory with base name name is skipped.
+func (p *WalkPolicy) SkipDir(name string) bool {
+	return p.IgnoreDirs.IsIgnored(name) || util.StringsContains(p.SkipDirs, name)
+}
+
+// SkipFile reports if the file with base name name is skipped. Only Go files
+// are visited.
+func (p *WalkPolicy) SkipFile(name string) bool {
+	if !strings.HasSuffix(name, ".go") {
+		return true
+	}
+	return p.SkipTests && strings.HasSuffix(name, "_test.go")
+}
+
+// AddFlags defines flags for the fields of the policy in flags so that
+// commands accept the same options.
+func (p *WalkPolicy) AddFlags(flags *flag.FlagSet) {
+	flags.Func("skip-dir", "Skip directories named `NAME` (may be repeated)", func(s string) error {
+		for _, name := range strings.Split(s, ",") {
+			if name != "" {
+				p.SkipDirs = append(p.SkipDirs, name)
+			}
+		}
+		return nil
+	})
+	flags.BoolVar(&p.FollowSymlinks, "follow-symlinks", p.FollowSymlinks,
+		"Follow symbolic links to directories")
+	flags.BoolVar(&p.SkipTests, "skip-tests", p.SkipTests, "Skip _test.go files")
+	flags.IntVar(&p.MaxFiles, "max-files", p.MaxFiles,
+		"Stop after visiting `N` Go files (0 means no limit)")
+}
+
+// Walk calls fn with the path of each Go file in the tree rooted at root,
+// which is walked in lexical order. Directories, other than root, skipped by
+// the policy are not walked. Symbolic links to files are visited, but links
+// to directories are only followed if FollowSymlinks is set. Walking stops
+// at the first error returned by fn.
+func (p *WalkPolicy) Walk(root string, fn func(path string) error) error {
+	w := newPolicyWalker(nil, p)
+	w.file = func(dir, name string) error {
+		return fn(filepath.Join(dir, name))
+	}
+	return w.walk(root)
+}
+
+// WalkErrors is a list of errors reading the files that were skipped by
+// WalkGoFiles, in the order the files were visited.
+type WalkErrors []error
+
+// Error returns the first error and the number of remaining errors, if any.
+func (e WalkErrors) Error() string {
+	switch len(e) {
+	case 0:
+		return "no errors"
+	case 1:
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e[0], len(e)-1)
+}
+
+// WalkGoFiles calls fn with the path of each Go file in the tree rooted at
+// root that is included in a build for ctxt (see Include). Only the header
+// of each file is read to evaluate its build constraints. Files with names
+// beginning with "_" or "." are ignored, as they are by the go command.
+//
+// The tree is walked according to opts, or DefaultWalkPolicy if opts is nil;
+// the MaxFiles limit applies to all of the Go files visited, not only those
+// passed to fn. The Context's ReadDir, IsDir and OpenFile functions are used,
+// if set. Walking stops at the first error returned by fn. Files that cannot
+// be read, such as those with a header larger than the limit set with
+// SetMaxHeaderSize, are skipped and, once the walk is complete, returned as
+// WalkErrors.
+func WalkGoFiles(ctxt *build.Context, root string, opts *WalkPolicy, fn func(path string) error) error {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	if opts == nil {
+		opts = DefaultWalkPolicy()
+	}
+	var errs WalkErrors
+	w := newPolicyWalker(ctxt, opts)
+	w.file = func(dir, name string) error {
+		if strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+			return nil
+		}
+		path := joinPath(ctxt, dir, name)
+		ok, err := includeSrc(ctxt, path, nil)
+		if err != nil && err != errSyntax {
+			if _, isPath := err.(*fs.PathError); !isPath {
+				err = &fs.PathError{Op: "read", Path: path, Err: err}
+			}
+			errs = append(errs, err)
+			return nil
+		}
+		if !ok {
+			return nil
+		}
+		return fn(path)
+	}
+	if err := w.walk(root); err != nil {
+		return err
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// A policyWalker walks a source tree according to a WalkPolicy using the
+// file system functions of a Context. It is shared by all of the functions
+// that walk source trees.
+type policyWalker struct {
+	ctxt   *build.Context
+	policy *WalkPolicy
+	files  int
+	seen   map[string]bool // real path of walked directories
+
+	// skipDir, if not nil, reports if the directory at path is skipped
+	// in addition to those skipped by the policy.
+	skipDir func(path string) bool
+
+	// file is called with the directory and name of each Go file that is
+	// not skipped by the policy.
+	file func(dir, name string) error
+}
+
+func newPolicyWalker(ctxt *build.Context, policy *WalkPolicy) *policyWalker {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	w := &policyWalker{ctxt: ctxt, policy: policy}
+	if policy.FollowSymlinks {
+		w.seen = make(map[string]bool)
+	}
+	return w
+}
+
+func (w *policyWalker) walk(dir string) error {
+	if w.seen != nil {
+		// The root may not exist on the local file system if the
+		// Context provides its own, in which case it is used as is.
+		real, err := fsys.EvalSymlinks(dir)
+		if err != nil {
+			real = dir
+		}
+		if w.seen[real] {
+			return nil
+		}
+		w.seen[real] = true
+	}
+	fis, err := readSourceDir(w.ctxt, dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		name := fi.Name()
+		path := joinPath(w.ctxt, dir, name)
+		dirEntry, regular := fi.IsDir(), fi.Mode().IsRegular()
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			dirEntry = isDir(w.ctxt, path)
+			if dirEntry {
+				// Links to directories can only be followed if they
+				// can be resolved, which is required to detect cycles.
+				if !w.policy.FollowSymlinks {
+					continue
+				}
+				if _, err := fsys.EvalSymlinks(path); err != nil {
+					continue
+				}
+			} else {
+				regular = fileExists(w.ctxt, path) // false if broken
+			}
+		}
+		if dirEntry {
+			if w.policy.SkipDir(name) || (w.skipDir != nil && w.skipDir(path)) {
+				continue
+			}
+			if err := w.walk(path); err != nil {
+				return err
+			}
+			continue
+		}
+		if !regular || w.policy.SkipFile(name) {
+			continue
+		}
+		w.files++
+		if w.policy.MaxFiles > 0 && w.files > w.policy.MaxFiles {
+			return ErrMaxFiles
+		}
+		if err := w.file(dir, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package buildutil
+
+import (
+	"errors"
+	"go/build"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/charlievieth/buildutil/buildutiltest"
+	"github.com/charlievieth/buildutil/contextutil"
+)
+
+func TestWalkPolicy(t *testing.T) {
+	root := t.TempDir()
+	buildutiltest.WriteFiles(t, root, map[string]string{
+		"a.go":            "package a\n",
+		"a_test.go":       "package a\n",
+		"README.md":       "readme\n",
+		".git/x.go":       "package x\n",
+		"vendor/v/v.go":   "package v\n",
+		"skip/s.go":       "package s\n",
+		"sub/b.go":        "package sub\n",
+		"other/linked.go": "package other\n",
+	})
+	if err := os.Symlink(filepath.Join(root, "other"), filepath.Join(root, "sub", "link")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	// Create a cycle
+	if err := os.Symlink(root, filepath.Join(root, "other", "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	walk := func(p *WalkPolicy) ([]string, error) {
+		var files []string
+		err := p.Walk(root, func(path string) error {
+			rel, err := filepath.Rel(root, path)
+			files = append(files, filepath.ToSlash(rel))
+			return err
+		})
+		return files, err
+	}
+	tests := []struct {
+		policy *WalkPolicy
+		want   []string
+		err    error
+	}{
+		{
+			DefaultWalkPolicy(),
+			[]string{"a.go", "a_test.go", "other/linked.go", "skip/s.go", "sub/b.go"},
+			nil,
+		},
+		{
+			&WalkPolicy{IgnoreDirs: DefaultIgnoreDirPolicy, SkipDirs: []string{"skip"}, SkipTests: true},
+			[]string{"a.go", "other/linked.go", "sub/b.go"},
+			nil,
+		},
+		{
+			&WalkPolicy{IgnoreDirs: DefaultIgnoreDirPolicy, SkipDirs: []string{"other"}, FollowSymlinks: true},
+			[]string{"a.go", "a_test.go", "skip/s.go", "sub/b.go", "sub/link/linked.go"},
+			nil,
+		},
+		{
+			&WalkPolicy{IgnoreDirs: DefaultIgnoreDirPolicy, MaxFiles: 2},
+			[]string{"a.go", "a_test.go"},
+			ErrMaxFiles,
+		},
+	}
+	for i, x := range tests {
+		files, err := walk(x.policy)
+		if !errors.Is(err, x.err) {
+			t.Errorf("%d: Walk() error = %v; want: %v", i, err, x.err)
+		}
+		if !reflect.DeepEqual(files, x.want) {
+			t.Errorf("%d: Walk() = %q; want: %q", i, files, x.want)
+		}
+	}
+
+	// Following symlinks must not walk the cycle forever
+	p := DefaultWalkPolicy()
+	p.FollowSymlinks = true
+	files, err := walk(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.go", "a_test.go", "other/linked.go", "skip/s.go", "sub/b.go"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("Walk(FollowSymlinks) = %q; want: %q", files, want)
+	}
+}
+
+func TestWalkGoFiles(t *testing.T) {
+	root := t.TempDir()
+	buildutiltest.WriteFiles(t, root, map[string]string{
+		"a.go":             "package a\n",
+		"a_linux.go":       "package a\n",
+		"a_windows.go":     "package a\n",
+		"a_test.go":        "package a\n",
+		"tag.go":           "//go:build foo\n\npackage a\n",
+		"notag.go":         "//go:build !foo\n\npackage a\n",
+		"_ignored.go":      "package a\n",
+		"syntax.go":        "//go:build linux\n\npackage\n",
+		"sub/b.go":         "//go:build linux && amd64\n\npackage b\n",
+		"sub/b_arm64.go":   "package b\n",
+		"vendor/v/v.go":    "package v\n",
+		"testdata/t/t.go":  "package t\n",
+		"sub/c/c_linux.go": "//go:build ignore\n\npackage c\n",
+	})
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "amd64"
+	ctxt.BuildTags = []string{"foo"}
+
+	walk := func(opts *WalkPolicy) ([]string, error) {
+		var files []string
+		err := WalkGoFiles(&ctxt, root, opts, func(path string) error {
+			rel, err := filepath.Rel(root, path)
+			files = append(files, filepath.ToSlash(rel))
+			return err
+		})
+		return files, err
+	}
+	files, err := walk(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.go", "a_linux.go", "a_test.go", "sub/b.go", "tag.go"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("WalkGoFiles() = %q; want: %q", files, want)
+	}
+
+	files, err = walk(&WalkPolicy{IgnoreDirs: DefaultIgnoreDirPolicy, SkipDirs: []string{"sub"}, SkipTests: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"a.go", "a_linux.go", "tag.go"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("WalkGoFiles() = %q; want: %q", files, want)
+	}
+
+	errStop := errors.New("stop")
+	err = WalkGoFiles(&ctxt, root, nil, func(path string) error { return errStop })
+	if err != errStop {
+		t.Errorf("WalkGoFiles() error = %v; want: %v", err, errStop)
+	}
+
+	// Files that cannot be read are reported and skipped
+	buildutiltest.WriteFiles(t, root, map[string]string{
+		"big.go": "// " + strings.Repeat("x", 1024) + "\n\npackage a\n",
+	})
+	defer SetMaxHeaderSize(SetMaxHeaderSize(512))
+	files, err = walk(nil)
+	var errs WalkErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("WalkGoFiles() error = %v; want: %T with 1 error", err, errs)
+	}
+	var herr *HeaderTooLargeError
+	var perr *fs.PathError
+	if !errors.As(errs[0], &herr) || !errors.As(errs[0], &perr) || perr.Path != filepath.Join(root, "big.go") {
+		t.Errorf("WalkGoFiles() error = %#v; want: %T of %q", errs[0], herr, "big.go")
+	}
+	want = []string{"a.go", "a_linux.go", "a_test.go", "sub/b.go", "tag.go"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("WalkGoFiles() = %q; want: %q", files, want)
+	}
+}
+
+func TestWalkGoFilesContext(t *testing.T) {
+	// The file system of the Context is walked
+	ctxt := contextutil.NewFakeContext(map[string]contextutil.FakeFile{
+		"/src/a.go":         {Data: "package a\n"},
+		"/src/a_windows.go": {Data: "package a\n"},
+		"/src/sub/b.go":     {Data: "//go:build ignore\n\npackage b\n"},
+		"/src/sub/c.go":     {Data: "package c\n"},
+		"/src/link.go":      {Data: "/src/sub/c.go", Mode: fs.ModeSymlink},
+		"/src/broken.go":    {Data: "/src/missing.go", Mode: fs.ModeSymlink},
+	})
+	ctxt.GOOS = "linux"
+	var files []string
+	err := WalkGoFiles(ctxt, "/src", nil, func(path string) error {
+		files = append(files, filepath.ToSlash(path))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/src/a.go", "/src/link.go", "/src/sub/c.go"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("WalkGoFiles() = %q; want: %q", files, want)
+	}
+}
+
+func TestBuildImportGraphPolicy(t *testing.T) {
+	root := t.TempDir()
+	buildutiltest.WriteFiles(t, root, map[string]string{
+		"go.mod":      "module example.com/m\n",
+		"a/a.go":      "package a\n",
+		"b/b.go":      "package b\n\nimport _ \"example.com/m/a\"\n",
+		"gen/gen.go":  "package gen\n\nimport _ \"example.com/m/a\"\n",
+		"c/c_test.go": "package c\n\nimport _ \"example.com/m/a\"\n",
+	})
+	policy := DefaultWalkPolicy()
+	policy.SkipDirs = []string{"gen"}
+	policy.SkipTests = true
+	g, err := BuildImportGraphPolicy(nil, policy, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"example.com/m/b"}
+	if got := g.Importers("example.com/m/a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Importers() = %q; want: %q", got, want)
+	}
+}
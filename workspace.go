@@ -0,0 +1,152 @@
+package buildutil
+
+import (
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/charlievieth/buildutil/contextutil"
+)
+
+// An EventKind is the kind of change made to a file or directory.
+type EventKind int
+
+const (
+	// EventCreated means the file or directory was created.
+	EventCreated EventKind = iota + 1
+
+	// EventModified means the content of the file was changed.
+	EventModified
+
+	// EventDeleted means the file or directory was deleted.
+	EventDeleted
+)
+
+var eventKindNames = [...]string{
+	EventCreated:  "created",
+	EventModified: "modified",
+	EventDeleted:  "deleted",
+}
+
+func (k EventKind) String() string {
+	if 0 < k && int(k) < len(eventKindNames) {
+		return eventKindNames[k]
+	}
+	return "EventKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// An Event is a change to a file or directory, such as one reported by a
+// file system watcher or the didChangeWatchedFiles notification of a
+// language server.
+type Event struct {
+	Path string // absolute path of the file or directory
+	Kind EventKind
+}
+
+func (e Event) String() string { return e.Kind.String() + ": " + e.Path }
+
+// An EventHandler is notified of the changes to files by a Workspace so that
+// it can invalidate any state derived from them (e.g. an index of packages).
+type EventHandler interface {
+	HandleEvent(ev Event)
+}
+
+// The EventHandlerFunc type is an adapter to allow the use of ordinary
+// functions as EventHandlers.
+type EventHandlerFunc func(ev Event)
+
+// HandleEvent calls f(ev).
+func (f EventHandlerFunc) HandleEvent(ev Event) { f(ev) }
+
+// A Workspace invalidates the caches used by an editor, or other long
+// running tool, when files change. Instead of each cache having its own
+// invalidation method that must be called for the right changes, the tool
+// reports every change with Notify and the Workspace invalidates only the
+// affected caches:
+//
+//   - The permanent MatchContext errors of a Go file are removed when the
+//     file is modified or deleted.
+//   - The cached header and build constraints of a Go file are removed from
+//     the ConstraintCache, if set, when the file changes.
+//   - The GoLister, if set, is invalidated when a Go source file, go.mod,
+//     go.sum, go.work or vendor/modules.txt file changes, or when any file
+//     or directory is deleted (since it may be a directory of packages).
+//   - The ProjectRootCache, if set, and the cache of project roots used to
+//     find the ProjectConfigFile are invalidated for the directories that
+//     may contain the file when a file or directory is created or deleted.
+//   - The symbolic links resolved by scoped Contexts are discarded (see
+//     contextutil.InvalidateSymlinks) when a file that is not a Go file,
+//     and so may be a directory or link, is created or deleted.
+//   - Every registered EventHandler is notified of every event.
+//
+// The zero value is ready to use. A Workspace is safe for concurrent use,
+// but the GoLister, Constraints and Roots fields must not be changed after
+// first use.
+type Workspace struct {
+	// GoLister is invalidated when a file that can change the output of
+	// "go list" changes. If nil, no GoLister is invalidated.
+	GoLister *GoLister
+
+	// Constraints is invalidated for a Go file when it changes. If nil,
+	// no ConstraintCache is invalidated.
+	Constraints *ConstraintCache
+
+	// Roots is invalidated when a file that may be a project tombstone is
+	// created or deleted. If nil, no ProjectRootCache is invalidated.
+	Roots *contextutil.ProjectRootCache
+
+	mu       sync.Mutex
+	handlers []EventHandler
+}
+
+// Register adds h to the EventHandlers notified by w.
+func (w *Workspace) Register(h EventHandler) {
+	w.mu.Lock()
+	w.handlers = append(w.handlers, h)
+	w.mu.Unlock()
+}
+
+// Notify invalidates the caches affected by ev and notifies the registered
+// EventHandlers, in the order they were registered.
+func (w *Workspace) Notify(ev Event) {
+	name := filepath.Base(ev.Path)
+	isGo := filepath.Ext(name) == ".go"
+
+	if isGo && ev.Kind != EventCreated {
+		matchErrCache.Invalidate(ev.Path)
+	}
+	if isGo && w.Constraints != nil {
+		w.Constraints.Invalidate(ev.Path)
+	}
+	if w.GoLister != nil && (isGo || isModuleFile(ev.Path) || ev.Kind == EventDeleted) {
+		w.GoLister.Invalidate()
+	}
+	if ev.Kind != EventModified {
+		projectRootCache.Invalidate(ev.Path)
+		if w.Roots != nil {
+			w.Roots.Invalidate(ev.Path)
+		}
+		if !isGo {
+			contextutil.InvalidateSymlinks()
+		}
+	}
+
+	w.mu.Lock()
+	handlers := w.handlers
+	w.mu.Unlock()
+	for _, h := range handlers {
+		h.HandleEvent(ev)
+	}
+}
+
+// isModuleFile reports if path is a file that defines the modules or the
+// dependencies of a module.
+func isModuleFile(path string) bool {
+	switch filepath.Base(path) {
+	case "go.mod", "go.sum", "go.work", "go.work.sum":
+		return true
+	case "modules.txt":
+		return filepath.Base(filepath.Dir(path)) == "vendor"
+	}
+	return false
+}
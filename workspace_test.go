@@ -0,0 +1,218 @@
+package buildutil
+
+import (
+	"context"
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/charlievieth/buildutil/buildutiltest"
+	"github.com/charlievieth/buildutil/contextutil"
+)
+
+func TestEventKindString(t *testing.T) {
+	tests := map[EventKind]string{
+		EventCreated:  "created",
+		EventModified: "modified",
+		EventDeleted:  "deleted",
+		0:             "EventKind(0)",
+		EventKind(9):  "EventKind(9)",
+	}
+	for k, want := range tests {
+		if got := k.String(); got != want {
+			t.Errorf("EventKind(%d).String() = %q; want: %q", int(k), got, want)
+		}
+	}
+}
+
+func TestWorkspaceMatchCache(t *testing.T) {
+	matchErrCache.Reset()
+	t.Cleanup(matchErrCache.Reset)
+
+	dir := t.TempDir()
+	src := "//go:build !" + latestReleaseTag + "\n\npackage main\n"
+	names := []string{filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")}
+	for _, name := range names {
+		if _, err := MatchContext(nil, name, src); err == nil {
+			t.Fatalf("MatchContext(%q): expected an error", name)
+		}
+	}
+	if n := matchErrCache.Len(); n != 2 {
+		t.Fatalf("cache size: got: %d want: %d", n, 2)
+	}
+
+	var w Workspace
+	w.Notify(Event{Path: names[0], Kind: EventCreated})
+	if n := matchErrCache.Len(); n != 2 {
+		t.Errorf("%s: cache size: got: %d want: %d", EventCreated, n, 2)
+	}
+	// Only the errors of the modified file are removed
+	w.Notify(Event{Path: names[0], Kind: EventModified})
+	if n := matchErrCache.Len(); n != 1 {
+		t.Errorf("%s: cache size: got: %d want: %d", EventModified, n, 1)
+	}
+	w.Notify(Event{Path: names[1], Kind: EventDeleted})
+	if n := matchErrCache.Len(); n != 0 {
+		t.Errorf("%s: cache size: got: %d want: %d", EventDeleted, n, 0)
+	}
+}
+
+func TestWorkspaceConstraints(t *testing.T) {
+	c := NewConstraintCache(0)
+	c.now = func() time.Time { return time.Now().Add(time.Hour) } // trust mtimes
+	w := Workspace{Constraints: c}
+
+	name := filepath.Join(t.TempDir(), "a.go")
+	writeFile := func(src string, modTime time.Time) {
+		if err := os.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	modTime := time.Now().Add(-time.Hour)
+	writeFile("//go:build linux\n\npackage a\n", modTime)
+	if ok, err := c.MatchFile(&ctxt, name); !ok || err != nil {
+		t.Fatalf("MatchFile = %t, %v; want: true, nil", ok, err)
+	}
+
+	// Edit the build constraint without changing the size or modification
+	// time of the file, which the cache cannot detect by itself.
+	writeFile("//go:build plan9\n\npackage a\n", modTime)
+	w.Notify(Event{Path: name, Kind: EventModified})
+	if ok, err := c.MatchFile(&ctxt, name); ok || err != nil {
+		t.Errorf("MatchFile after Notify = %t, %v; want: false, nil", ok, err)
+	}
+}
+
+func TestWorkspaceGoLister(t *testing.T) {
+	l := &GoLister{
+		Cache: true,
+		run: func(cmd *exec.Cmd) ([]byte, error) {
+			return []byte("ok"), nil
+		},
+	}
+	w := Workspace{GoLister: l}
+	ctxt := build.Default
+	ctxt.Dir = t.TempDir()
+
+	tests := []struct {
+		ev         Event
+		invalidate bool
+	}{
+		{Event{filepath.Join(ctxt.Dir, "a.go"), EventModified}, true},
+		{Event{filepath.Join(ctxt.Dir, "a.go"), EventCreated}, true},
+		{Event{filepath.Join(ctxt.Dir, "go.mod"), EventModified}, true},
+		{Event{filepath.Join(ctxt.Dir, "go.work"), EventCreated}, true},
+		{Event{filepath.Join(ctxt.Dir, "vendor", "modules.txt"), EventModified}, true},
+		{Event{filepath.Join(ctxt.Dir, "pkg"), EventDeleted}, true},
+		{Event{filepath.Join(ctxt.Dir, "modules.txt"), EventModified}, false},
+		{Event{filepath.Join(ctxt.Dir, "README.md"), EventModified}, false},
+		{Event{filepath.Join(ctxt.Dir, "pkg"), EventCreated}, false},
+	}
+	for _, x := range tests {
+		if _, err := l.GoList(context.Background(), &ctxt, "p"); err != nil {
+			t.Fatal(err)
+		}
+		w.Notify(x.ev)
+		l.mu.Lock()
+		invalidated := len(l.cache) == 0
+		l.mu.Unlock()
+		if invalidated != x.invalidate {
+			t.Errorf("Notify(%s): invalidated = %t; want: %t", x.ev, invalidated, x.invalidate)
+		}
+	}
+}
+
+func TestWorkspaceRoots(t *testing.T) {
+	root := buildutiltest.NewModule(t, "example.com/mod", map[string]string{
+		"sub/sub.go": "package sub\n",
+	})
+	ctxt := buildutiltest.GOPATHContext(nil, t.TempDir())
+	roots := contextutil.NewProjectRootCache(0)
+	w := Workspace{Roots: roots}
+
+	sub := filepath.Join(root, "sub")
+	if _, err := roots.FindProjectRoot(ctxt, sub); err != nil {
+		t.Fatal(err)
+	}
+	w.Notify(Event{Path: filepath.Join(sub, "sub.go"), Kind: EventModified})
+	if n := roots.Len(); n != 1 {
+		t.Errorf("%s: roots.Len() = %d; want: %d", EventModified, n, 1)
+	}
+	w.Notify(Event{Path: filepath.Join(sub, "go.mod"), Kind: EventCreated})
+	if n := roots.Len(); n != 0 {
+		t.Errorf("%s: roots.Len() = %d; want: %d", EventCreated, n, 0)
+	}
+}
+
+func TestWorkspaceScopedContext(t *testing.T) {
+	tempdir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopath := filepath.Join(tempdir, "go")
+	buildutiltest.WriteFiles(t, filepath.Join(gopath, "src"), map[string]string{
+		"a/pkg/pkg.go":      "package pkg\n",
+		"other/other.go":    "package other\n",
+		"links/placeholder": "",
+	})
+	link := filepath.Join(gopath, "src", "links", "link")
+	if err := os.Symlink(filepath.Join(gopath, "src", "other"), link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	ctxt, err := contextutil.ScopedContext(buildutiltest.GOPATHContext(nil, gopath),
+		filepath.Join(gopath, "src", "a", "pkg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctxt.ReadDir(link); err == nil {
+		t.Fatalf("ReadDir(%q): expected an error for a directory that is not in scope", link)
+	}
+
+	// Rename a link to the scoped directory over the link
+	tmp := filepath.Join(gopath, "src", "links", "tmp")
+	if err := os.Symlink(filepath.Join(gopath, "src", "a"), tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		t.Fatal(err)
+	}
+	var w Workspace
+	w.Notify(Event{Path: tmp, Kind: EventDeleted})
+	w.Notify(Event{Path: link, Kind: EventCreated})
+
+	fis, err := ctxt.ReadDir(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 || fis[0].Name() != "pkg" {
+		t.Errorf("ReadDir(%q) = %v; want: [pkg]", link, fis)
+	}
+}
+
+func TestWorkspaceHandlers(t *testing.T) {
+	var w Workspace
+	var got1, got2 []Event
+	w.Register(EventHandlerFunc(func(ev Event) { got1 = append(got1, ev) }))
+	w.Register(EventHandlerFunc(func(ev Event) { got2 = append(got2, ev) }))
+
+	want := []Event{
+		{"/a/b.go", EventCreated},
+		{"/a/README", EventModified},
+		{"/a", EventDeleted},
+	}
+	for _, ev := range want {
+		w.Notify(ev)
+	}
+	if !reflect.DeepEqual(got1, want) || !reflect.DeepEqual(got2, want) {
+		t.Errorf("handled events = %v, %v; want: %v", got1, got2, want)
+	}
+}